@@ -13,8 +13,8 @@ import "myp2p/client"
 // - log : instance de Logger pour afficher des messages d'information, d'avertissement ou d'erreur.
 func RegisterCallbacks(log *Logger) {
 
-	// On assigne une fonction anonyme à client.OnPeerEvent
-	client.OnPeerEvent = func(peer *client.Peer, event client.PeerEventType, details string) {
+	// On assigne une fonction anonyme à OnPeerEvent du nœud par défaut
+	client.Default().OnPeerEvent = func(peer *client.Peer, event client.PeerEventType, details string) {
 		switch event {
 
 		// -----------------------------
@@ -64,6 +64,24 @@ func RegisterCallbacks(log *Logger) {
 		// -----------------------------
 		case client.EventMerkleDownloadLocal:
 			log.Info("Merkle téléchargé depuis le système " + details)
+
+		// -----------------------------
+		// Ban automatique pour mauvais comportement
+		// -----------------------------
+		case client.EventBanned:
+			log.Error("Peer " + peer.Name + " banni automatiquement : " + details)
+
+		// -----------------------------
+		// Erreur renvoyée par le peer
+		// -----------------------------
+		case client.EventErrorReceived:
+			log.Warn("Erreur reçue de " + peer.Name + " : " + details)
+
+		// -----------------------------
+		// Extensions négociées avec le peer
+		// -----------------------------
+		case client.EventCapabilities:
+			log.Info("Extensions négociées avec " + peer.Name + " : " + details)
 		}
 
 	}
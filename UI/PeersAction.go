@@ -2,8 +2,8 @@ package UI
 
 import (
 	"crypto/ecdsa"
+	"fmt"
 	"myp2p/client"
-	"net"
 
 	"fyne.io/fyne/v2/widget"
 )
@@ -23,7 +23,7 @@ func BanSelectedPeers(peerChecks *widget.CheckGroup, logger *Logger) {
 	for _, name := range peerChecks.Selected {
 		peer, exist := client.FindPeer(name)
 		if exist {
-			client.AddBan(peer)
+			client.AddBan(peer, "manuel (GUI)", 0)
 			logger.Info("→ ban  " + name)
 		}
 	}
@@ -56,7 +56,7 @@ func UnbanSelectedPeers(peerChecks *widget.CheckGroup, logger *Logger) {
 // - Vérifie que la sélection n’est pas vide
 // - Pour chaque peer, vérifie qu’il existe et n’est pas déjà connecté
 // - Lance le handshake en goroutine pour ne pas bloquer l’UI
-func HandshakeSelectedPeers(peerChecks *widget.CheckGroup, conn *net.UDPConn, priv *ecdsa.PrivateKey, logger *Logger) {
+func HandshakeSelectedPeers(peerChecks *widget.CheckGroup, conn client.Transport, priv *ecdsa.PrivateKey, logger *Logger) {
 	if len(peerChecks.Selected) == 0 {
 		logger.Warn("Sélectionnez au moins un peer")
 		return
@@ -86,9 +86,10 @@ func HandshakeSelectedPeers(peerChecks *widget.CheckGroup, conn *net.UDPConn, pr
 // - Ignore les peers déjà connectés
 // - Lance le handshake pour chaque peer non connecté
 // - Log un avertissement si aucun peer n’est à connecter
-func HandshakeAllPeers(conn *net.UDPConn, priv *ecdsa.PrivateKey, logger *Logger) {
+func HandshakeAllPeers(conn client.Transport, priv *ecdsa.PrivateKey, logger *Logger) {
 	count := 0
-	for name, peer := range client.Peers {
+	for _, peer := range client.ListPeers() {
+		name := peer.Name
 		if peer.State == client.PeerAssociated {
 			continue
 		}
@@ -100,3 +101,61 @@ func HandshakeAllPeers(conn *net.UDPConn, priv *ecdsa.PrivateKey, logger *Logger
 		logger.Warn("Aucun peer non connecté")
 	}
 }
+
+// -----------------------------
+// ShowPeersInfo
+// -----------------------------
+// Affiche dans les logs l'état de chaque peer connu
+// - connecté ou non
+// - version du protocole et extensions négociées (annoncées des deux côtés)
+// - chiffrement effectif de l'association et politique appliquée
+func ShowPeersInfo(logger *Logger) {
+	peers := client.ListPeers()
+	if len(peers) == 0 {
+		logger.Warn("Aucun peer connu")
+		return
+	}
+	for _, peer := range peers {
+		peer.Mupeer.RLock()
+		status := "déconnecté"
+		if peer.State == client.PeerAssociated {
+			status = "connecté"
+		}
+		line := fmt.Sprintf("%s [%s] v%d extensions : %s", peer.Name, status, peer.Version, client.ExtensionNames(peer.Capabilities))
+		peer.Mupeer.RUnlock()
+		logger.Info(line + " - " + client.EncryptionState(peer))
+	}
+}
+
+// -----------------------------
+// SetEncryptionSelectedPeers
+// -----------------------------
+// Applique une politique de chiffrement (never, opportunistic, required ou
+// default = politique globale) aux peers sélectionnés, ou à tous les peers
+// (politique globale) si aucun n'est sélectionné
+func SetEncryptionSelectedPeers(peerChecks *widget.CheckGroup, choice string, logger *Logger) {
+	if len(peerChecks.Selected) == 0 {
+		policy, err := client.ParseEncryptionPolicy(choice)
+		if err != nil {
+			logger.Warn("Choisissez une politique pour l'ensemble des peers")
+			return
+		}
+		client.SetEncryptionPolicy("", policy)
+		logger.Info("Politique de chiffrement globale : " + policy.String())
+		return
+	}
+	for _, name := range peerChecks.Selected {
+		if choice == "default" {
+			client.ClearEncryptionPolicy(name)
+			logger.Info("Chiffrement " + name + " : politique globale")
+			continue
+		}
+		policy, err := client.ParseEncryptionPolicy(choice)
+		if err != nil {
+			logger.Error(err.Error())
+			return
+		}
+		client.SetEncryptionPolicy(name, policy)
+		logger.Info("Chiffrement " + name + " : " + policy.String())
+	}
+}
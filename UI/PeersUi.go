@@ -9,11 +9,6 @@ import (
 	"fyne.io/fyne/v2/widget"
 )
 
-// ------------------------------------------------------
-// Debug mode pour afficher des logs supplémentaires
-// ------------------------------------------------------
-var Debug = false
-
 // ------------------------------------------------------
 // buildPeerSelector
 // ------------------------------------------------------
@@ -23,20 +18,14 @@ var Debug = false
 // - lance un goroutine autoRefreshPeers pour mettre à jour périodiquement la liste des peers
 func buildPeerSelector(log Logger) *widget.CheckGroup {
 	update := func() []string {
-		client.PeersMu.Lock()
-		names := make([]string, 0, len(client.Peers))
-		for name := range client.Peers {
-			names = append(names, name)
-		}
-		client.PeersMu.Unlock()
-		return names
+		return client.PeerNames()
 	}
 
 	checks := widget.NewCheckGroup(update(), func([]string) {})
 	checks.Horizontal = true
 
 	// rafraîchissement automatique toutes les 20 secondes
-	go autoRefreshPeers(checks, update, log)
+	go autoRefreshPeers(client.Default().Done(), checks, update, log)
 
 	return checks
 }
@@ -78,7 +67,9 @@ func getSinglePeer(
 // - client.RefreshPeers(): met à jour la map des peers connus côté client
 // - Met à jour le CheckGroup (Options et Selected)
 // - Fait tout dans fyne.Do pour exécuter dans le thread UI
+// - S'arrête quand done est fermé (arrêt du nœud)
 func autoRefreshPeers(
+	done <-chan struct{},
 	checks *widget.CheckGroup,
 	update func() []string,
 	log Logger,
@@ -86,7 +77,13 @@ func autoRefreshPeers(
 	ticker := time.NewTicker(30 * time.Second)
 	defer ticker.Stop()
 
-	for range ticker.C {
+	for {
+		select {
+		case <-ticker.C:
+		case <-done:
+			// le nœud est arrêté
+			return
+		}
 		fyne.Do(func() {
 			names, changed, err := client.GetPeerListIfChanged()
 			if err != nil {
@@ -95,9 +92,7 @@ func autoRefreshPeers(
 			}
 			if changed {
 				client.RefreshPeers(names)
-				if Debug {
-					log.Info("Peers mis à jour")
-				}
+				uiLog.Debug("Peers mis à jour")
 			}
 			// Met à jour la liste visible dans le CheckGroup
 			checks.Options = update()
@@ -0,0 +1,68 @@
+package UI
+
+import (
+	"encoding/json"
+	"myp2p/client"
+	"strings"
+
+	"fyne.io/fyne/v2/widget"
+)
+
+// -----------------------------
+// splitPaths
+// -----------------------------
+// Découpe une liste de chemins séparés par des virgules en ignorant les vides
+func splitPaths(text string) []string {
+	var paths []string
+	for _, p := range strings.Split(text, ",") {
+		p = strings.TrimSpace(p)
+		if p != "" {
+			paths = append(paths, p)
+		}
+	}
+	return paths
+}
+
+// -----------------------------
+// SetACLSelectedPeers
+// -----------------------------
+// Définit les sous-arbres visibles par les peers sélectionnés
+// - Vérifie que la sélection n’est pas vide
+// - Une liste vide retire la règle du peer (il retombe sur la règle par défaut)
+// - Enregistre les ACL dans client.ACLFile
+func SetACLSelectedPeers(peerChecks *widget.CheckGroup, pathsText string, logger *Logger) {
+	if len(peerChecks.Selected) == 0 {
+		logger.Warn("Sélectionnez au moins un peer")
+		return
+	}
+	paths := splitPaths(pathsText)
+	for _, name := range peerChecks.Selected {
+		client.SetGrant(name, paths)
+		if len(paths) == 0 {
+			logger.Info("→ ACL retirée pour " + name)
+		} else {
+			logger.Info("→ ACL " + name + " : " + strings.Join(paths, ", "))
+		}
+	}
+	if err := client.SaveACL(client.ACLFile); err != nil {
+		logger.Error("Erreur sauvegarde ACL : " + err.Error())
+	}
+}
+
+// -----------------------------
+// ShowACL
+// -----------------------------
+// Affiche la configuration ACL courante dans le journal
+func ShowACL(logger *Logger) {
+	a := client.GetACL()
+	if a == nil {
+		logger.Info("ACL désactivées : tout est partagé")
+		return
+	}
+	data, err := json.MarshalIndent(a, "", "  ")
+	if err != nil {
+		logger.Error(err.Error())
+		return
+	}
+	logger.Info(string(data))
+}
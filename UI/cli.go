@@ -3,13 +3,14 @@ package UI
 import (
 	"bufio"
 	"crypto/ecdsa"
-	"encoding/hex"
 	"fmt"
 	"myp2p/client"
 	"myp2p/clientStorage"
-	"net"
+	"myp2p/logging"
 	"os"
+	"sort"
 	"strings"
+	"time"
 )
 
 /* -------------------------------------------------------------------------
@@ -21,6 +22,14 @@ const (
 	CMD_SHOW      = "SHOW"
 	CMD_ASK       = "ASK"
 	CMD_MERKLE    = "MERKLE"
+	CMD_ACL       = "ACL"
+	CMD_BAN       = "BAN"
+	CMD_UNBAN     = "UNBAN"
+	CMD_BANS      = "BANS"
+	CMD_STATS     = "STATS"
+	CMD_LOG       = "LOG"
+	CMD_ENCRYPT   = "ENCRYPT"
+	CMD_DEDUP     = "DEDUP"
 )
 
 /* -------------------------------------------------------------------------
@@ -29,12 +38,16 @@ const (
 
 func ShowPeers() {
 	fmt.Println("|-------------------- PEERS --------------------|")
-	for name, p := range client.Peers {
+	for _, p := range client.ListPeers() {
+		name := p.Name
 		status := "disconnected"
 		if p.State == client.PeerAssociated {
 			status = "connected"
 		}
-		fmt.Printf("- %s [%s]\n", name, status)
+		p.Mupeer.RLock()
+		caps, version := p.Capabilities, p.Version
+		p.Mupeer.RUnlock()
+		fmt.Printf("- %s [%s] v%d extensions=%s %s\n", name, status, version, client.ExtensionNames(caps), client.EncryptionState(p))
 	}
 	fmt.Println("|------------------------------------------------|")
 }
@@ -43,7 +56,7 @@ func ShowPeers() {
    ASK COMMAND
    ------------------------------------------------------------------------- */
 
-func ProcessAskCommand(conn *net.UDPConn, priv *ecdsa.PrivateKey, parts []string) {
+func ProcessAskCommand(conn client.Transport, priv *ecdsa.PrivateKey, parts []string) {
 
 	if len(parts) < 3 {
 		fmt.Println("Usage:")
@@ -111,10 +124,11 @@ func ProcessAskCommand(conn *net.UDPConn, priv *ecdsa.PrivateKey, parts []string
 		client.StartAskMerkle(peer)
 
 		// si le root du peer n'a pas changé on dit directement : " C'est bon téléchargé ! "
+		peer.Mupeer.Lock()
 		if peer.RootChanged == false {
 			peer.MerkleDone = true
-
 		}
+		peer.Mupeer.Unlock()
 
 		// création de la requête DatumRequest
 		id := client.GenerateId()
@@ -130,7 +144,9 @@ func ProcessAskCommand(conn *net.UDPConn, priv *ecdsa.PrivateKey, parts []string
 		// envoi de la requête
 		client.SendMessage(conn, peer.ActiveAddr, msg)
 
+		peer.Mupeer.Lock()
 		peer.RootChanged = false // On a récupéré les changements
+		peer.Mupeer.Unlock()
 	/* -------------------- ASK DATA -------------------- */
 	case "DATA":
 
@@ -162,7 +178,7 @@ func ProcessAskCommand(conn *net.UDPConn, priv *ecdsa.PrivateKey, parts []string
 			}
 
 			fmt.Println("→ Téléchargement du fichier", target)
-			err := clientStorage.RebuildNode(hash, "OUTPUT/"+peer.Name+"/"+target)
+			err := client.RebuildFromPeer(peer, hash, "OUTPUT/"+peer.Name+"/"+target)
 			if err != nil {
 				fmt.Println("Erreur téléchargement :", err)
 			}
@@ -198,7 +214,7 @@ func ProcessAskCommand(conn *net.UDPConn, priv *ecdsa.PrivateKey, parts []string
 		}
 
 		fmt.Println("→ Téléchargement complet du peer", peer.Name)
-		err := clientStorage.RebuildNode(root, "OUTPUT/"+peer.Name)
+		err := client.RebuildFromPeer(peer, root, "OUTPUT/"+peer.Name)
 		if err != nil {
 			fmt.Println("Erreur téléchargement :", err)
 		}
@@ -229,14 +245,284 @@ func ProcessMerkle(parts []string) {
 	}
 
 	fmt.Println("Merkle tree de", peer.Name)
-	clientStorage.PrintTree(clientStorage.MerkleMap[hex.EncodeToString(peer.Root)], 0)
+	node, _ := clientStorage.FindHash(peer.Root)
+	clientStorage.PrintTree(node, 0)
+}
+
+/* -------------------------------------------------------------------------
+   ACL
+   ------------------------------------------------------------------------- */
+
+func ProcessACL(parts []string) {
+	usage := func() {
+		fmt.Println("Usage:")
+		fmt.Println("  ACL SHOW")
+		fmt.Println("  ACL SET <peer|key:<empreinte>|group:<nom>> <chemin,...>")
+		fmt.Println("  ACL DEL <sujet>")
+		fmt.Println("  ACL GROUP <nom> [membre ...]")
+		fmt.Println("  ACL RELOAD")
+	}
+	if len(parts) < 2 {
+		usage()
+		return
+	}
+
+	switch strings.ToUpper(parts[1]) {
+	case "SHOW":
+		a := client.GetACL()
+		if a == nil {
+			fmt.Println("ACL désactivées : tout est partagé")
+			return
+		}
+		fmt.Println("Par défaut :", a.Default)
+		for g, members := range a.Groups {
+			fmt.Printf("Groupe %s : %v\n", g, members)
+		}
+		for subject, paths := range a.Grants {
+			fmt.Printf("- %s → %v\n", subject, paths)
+		}
+		return
+	case "SET":
+		if len(parts) < 4 {
+			usage()
+			return
+		}
+		client.SetGrant(parts[2], splitPaths(strings.Join(parts[3:], " ")))
+	case "DEL":
+		if len(parts) < 3 {
+			usage()
+			return
+		}
+		client.SetGrant(parts[2], nil)
+	case "GROUP":
+		if len(parts) < 3 {
+			usage()
+			return
+		}
+		client.SetGroup(parts[2], parts[3:])
+	case "RELOAD":
+		if err := client.LoadACL(client.ACLFile); err != nil {
+			fmt.Println("Erreur chargement ACL :", err)
+		}
+		return
+	default:
+		usage()
+		return
+	}
+
+	if err := client.SaveACL(client.ACLFile); err != nil {
+		fmt.Println("Erreur sauvegarde ACL :", err)
+	}
+}
+
+/* -------------------------------------------------------------------------
+   BAN / UNBAN / BANS
+   ------------------------------------------------------------------------- */
+
+// BAN <peer> [durée] [raison...] ; durée au format Go (10m, 2h), absente ou 0 = permanent
+func ProcessBan(parts []string) {
+	if len(parts) < 2 {
+		fmt.Println("Usage: BAN <peer> [durée] [raison...]")
+		return
+	}
+	name := parts[1]
+	var duration time.Duration
+	reason := "manuel (CLI)"
+	rest := parts[2:]
+	if len(rest) > 0 {
+		if d, err := time.ParseDuration(rest[0]); err == nil {
+			duration = d
+			rest = rest[1:]
+		}
+	}
+	if len(rest) > 0 {
+		reason = strings.Join(rest, " ")
+	}
+	client.BanName(name, reason, duration)
+	fmt.Println("→ ban", name)
+}
+
+func ProcessUnban(parts []string) {
+	if len(parts) < 2 {
+		fmt.Println("Usage: UNBAN <peer>")
+		return
+	}
+	for _, name := range parts[1:] {
+		client.DelBan(name)
+		fmt.Println("→ unban", name)
+	}
+}
+
+func ShowBans() {
+	fmt.Println("|-------------------- BANS ---------------------|")
+	for _, b := range client.ListBans() {
+		expires := "permanent"
+		if !b.Expires.IsZero() {
+			expires = "jusqu'à " + b.Expires.Format("2006-01-02 15:04:05")
+		}
+		fmt.Printf("- %s [%s] %s\n", b.Name, expires, b.Reason)
+		if b.Fingerprint != "" {
+			fmt.Printf("    clé : %s\n", b.Fingerprint)
+		}
+	}
+	fmt.Println("|------------------------------------------------|")
+}
+
+/* -------------------------------------------------------------------------
+   STATS
+   ------------------------------------------------------------------------- */
+
+// ShowStats affiche le nombre de paquets jetés, par raison.
+func ShowStats() {
+	stats := client.DropStats()
+	reasons := make([]string, 0, len(stats))
+	for r := range stats {
+		reasons = append(reasons, r)
+	}
+	sort.Strings(reasons)
+
+	fmt.Println("|---------------- PAQUETS JETÉS -----------------|")
+	for _, r := range reasons {
+		fmt.Printf("- %-22s %d\n", r, stats[r])
+	}
+	fmt.Println("|------------------------------------------------|")
+}
+
+/* -------------------------------------------------------------------------
+   LOGS
+   ------------------------------------------------------------------------- */
+
+// ProcessLog affiche ou règle les niveaux de log :
+//
+//	LOG                      → niveaux de chaque sous-système
+//	LOG debug                → tous les sous-systèmes
+//	LOG peer debug           → un sous-système
+//	LOG info,crypto=debug    → syntaxe de l'option -log
+func ProcessLog(parts []string) {
+	var err error
+	switch len(parts) {
+	case 1:
+		fmt.Println("|-------------------- LOGS ---------------------|")
+		for _, l := range logging.Levels() {
+			fmt.Println("- " + l)
+		}
+		fmt.Println("|------------------------------------------------|")
+		return
+	case 2:
+		err = logging.ParseLevels(parts[1])
+	default:
+		err = logging.ParseLevels(parts[1] + "=" + parts[2])
+	}
+	if err != nil {
+		fmt.Println("Usage : LOG [sous-système] [debug|info|warn|error] :", err)
+		return
+	}
+	fmt.Println("Niveaux de log mis à jour")
+}
+
+/* -------------------------------------------------------------------------
+   CHIFFREMENT
+   ------------------------------------------------------------------------- */
+
+// ProcessEncrypt affiche ou règle la politique de chiffrement :
+//
+//	ENCRYPT                          → politique globale et politiques par peer
+//	ENCRYPT required                 → politique globale
+//	ENCRYPT <peer> opportunistic     → politique d'un peer
+//	ENCRYPT <peer> default           → le peer revient à la politique globale
+func ProcessEncrypt(parts []string) {
+	usage := "Usage : ENCRYPT [peer] never|opportunistic|required|default"
+	switch len(parts) {
+	case 1:
+		global, perPeer := client.EncryptionPolicies()
+		names := make([]string, 0, len(perPeer))
+		for name := range perPeer {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		fmt.Println("|----------------- CHIFFREMENT ------------------|")
+		fmt.Println("- global :", global)
+		for _, name := range names {
+			fmt.Printf("- %s : %s\n", name, perPeer[name])
+		}
+		fmt.Println("|------------------------------------------------|")
+	case 2:
+		policy, err := client.ParseEncryptionPolicy(strings.ToLower(parts[1]))
+		if err != nil {
+			fmt.Println(usage)
+			return
+		}
+		client.SetEncryptionPolicy("", policy)
+		fmt.Println("→ politique globale :", policy)
+	case 3:
+		if strings.EqualFold(parts[2], "default") {
+			client.ClearEncryptionPolicy(parts[1])
+			fmt.Println("→", parts[1], ": politique globale")
+			return
+		}
+		policy, err := client.ParseEncryptionPolicy(strings.ToLower(parts[2]))
+		if err != nil {
+			fmt.Println(usage)
+			return
+		}
+		client.SetEncryptionPolicy(parts[1], policy)
+		fmt.Println("→", parts[1], ":", policy)
+	default:
+		fmt.Println(usage)
+	}
+}
+
+/* -------------------------------------------------------------------------
+   DÉDUPLICATION
+   ------------------------------------------------------------------------- */
+
+// ProcessDedup affiche le partage des chunks entre les versions retenues
+// (les trois derniers roots) de nos données ou de celles d'un peer :
+//
+//	DEDUP          → nos versions
+//	DEDUP <peer>   → versions d'un peer (nœuds déjà téléchargés)
+func ProcessDedup(parts []string) {
+	roots := client.MyRoots()
+	title := "nos données (" + clientStorage.ChunkingSummary() + ")"
+	if len(parts) > 1 {
+		peer, ok := client.FindPeer(parts[1])
+		if !ok {
+			fmt.Println("Peer inconnu")
+			return
+		}
+		peer.Mupeer.RLock()
+		roots = append([][]byte(nil), peer.Listroots...)
+		peer.Mupeer.RUnlock()
+		title = peer.Name
+		if peer.Has(client.ExtensionCDC) {
+			title += " (cdc)"
+		}
+	}
+	if len(roots) == 0 {
+		fmt.Println("Aucune version connue")
+		return
+	}
+
+	fmt.Println("|---------------- DÉDUPLICATION -----------------|")
+	fmt.Println("- " + title)
+	for i, st := range clientStorage.DedupStats(roots) {
+		line := fmt.Sprintf("- version %d : %d chunks, %d octets, %d nouveaux", i+1, st.Chunks, st.Bytes, st.NewBytes)
+		if st.Bytes > 0 {
+			line += fmt.Sprintf(" (%.1f %%)", 100*float64(st.NewBytes)/float64(st.Bytes))
+		}
+		if st.Missing > 0 {
+			line += fmt.Sprintf(", %d nœuds absents", st.Missing)
+		}
+		fmt.Println(line)
+	}
+	fmt.Println("|------------------------------------------------|")
 }
 
 /* -------------------------------------------------------------------------
    MAIN DISPATCH
    ------------------------------------------------------------------------- */
 
-func ProcessCommand(conn *net.UDPConn, priv *ecdsa.PrivateKey, cmd string) {
+func ProcessCommand(conn client.Transport, priv *ecdsa.PrivateKey, cmd string) {
 	parts := strings.Fields(cmd)
 	if len(parts) == 0 {
 		return
@@ -262,6 +548,30 @@ func ProcessCommand(conn *net.UDPConn, priv *ecdsa.PrivateKey, cmd string) {
 	case CMD_MERKLE:
 		ProcessMerkle(parts)
 
+	case CMD_ACL:
+		ProcessACL(parts)
+
+	case CMD_BAN:
+		ProcessBan(parts)
+
+	case CMD_UNBAN:
+		ProcessUnban(parts)
+
+	case CMD_BANS:
+		ShowBans()
+
+	case CMD_STATS:
+		ShowStats()
+
+	case CMD_LOG:
+		ProcessLog(parts)
+
+	case CMD_ENCRYPT:
+		ProcessEncrypt(parts)
+
+	case CMD_DEDUP:
+		ProcessDedup(parts)
+
 	default:
 		fmt.Println("Commande inconnue")
 	}
@@ -271,11 +581,11 @@ func ProcessCommand(conn *net.UDPConn, priv *ecdsa.PrivateKey, cmd string) {
    LOOP
    ------------------------------------------------------------------------- */
 
-func StartCLI(conn *net.UDPConn, priv *ecdsa.PrivateKey) {
+func StartCLI(conn client.Transport, priv *ecdsa.PrivateKey) {
 	reader := bufio.NewScanner(os.Stdin)
 
 	fmt.Println("CLI prêt.")
-	fmt.Println("Commands: SHOW | HANDSHAKE | ASK | MERKLE")
+	fmt.Println("Commands: SHOW | HANDSHAKE | ASK | MERKLE | ACL | BAN | UNBAN | BANS | STATS | LOG | ENCRYPT | DEDUP")
 
 	for {
 		fmt.Print("> ")
@@ -5,7 +5,6 @@ import (
 	"fmt"
 	"myp2p/client"
 	"myp2p/clientStorage"
-	"net"
 
 	"fyne.io/fyne/v2/widget"
 )
@@ -21,7 +20,7 @@ const (
 //-------------------------------------------------------------------------------------------------------------------
 
 // ASK ROOT
-func AskRootSelectedPeers(peerChecks *widget.CheckGroup, conn *net.UDPConn, priv *ecdsa.PrivateKey, logger *Logger) {
+func AskRootSelectedPeers(peerChecks *widget.CheckGroup, conn client.Transport, priv *ecdsa.PrivateKey, logger *Logger) {
 	if len(peerChecks.Selected) == 0 {
 		logger.Warn("Sélectionnez au moins un peer")
 		return
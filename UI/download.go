@@ -3,7 +3,6 @@ package UI
 import (
 	"crypto/ecdsa"
 	"fmt"
-	"net"
 	"os"
 	"path/filepath"
 	"time"
@@ -63,8 +62,14 @@ func DownloadFileGUI(
 	// Sauvegarde du temps de début pour mesurer la durée
 	start := time.Now()
 
-	// Reconstruit le fichier à partir du hash via clientStorage
-	if err := clientStorage.RebuildNode(hash, path); err != nil {
+	peer, ok := client.FindPeer(peerName)
+	if !ok {
+		log.Error("Peer introuvable : " + peerName)
+		return
+	}
+
+	// Reconstruit le fichier à partir du hash (un arbre dangereux est signalé au ban)
+	if err := client.RebuildFromPeer(peer, hash, path); err != nil {
 		log.Error(err.Error()) // log si reconstruction échoue
 		return
 	}
@@ -84,7 +89,7 @@ func DownloadFileGUI(
 // current = fichier actuel
 // last = dernier fichier
 // previuous = l'avant dernier fichier
-func RestoreMyFile(peerChecks *widget.CheckGroup, conn *net.UDPConn, priv *ecdsa.PrivateKey, logger *Logger, version string) {
+func RestoreMyFile(peerChecks *widget.CheckGroup, conn client.Transport, priv *ecdsa.PrivateKey, logger *Logger, version string) {
 
 	switch version {
 	case LATEST_VERSION:
@@ -94,29 +99,30 @@ func RestoreMyFile(peerChecks *widget.CheckGroup, conn *net.UDPConn, priv *ecdsa
 			return
 		}
 		// Reconstruit le fichier
-		if err := clientStorage.RebuildNode(clientStorage.RootHash, DATA_DIRECTORY); err != nil {
+		if err := clientStorage.RebuildNode(clientStorage.Root(), DATA_DIRECTORY); err != nil {
 			logger.Error(err.Error()) // log si reconstruction échoue
 			return
 		}
 		return
 	case PREVIOUS_VERSION:
+		myRoots := client.MyRoots()
 
-		if len(client.MyListroots) > 1 && client.MyListroots[1] != nil {
+		if len(myRoots) > 1 && myRoots[1] != nil {
 			// Supprime le fichier
 			if err := os.RemoveAll(DATA_DIRECTORY); err != nil {
 				logger.Error("Erreur suppression du répertoire: " + err.Error())
 				return
 			}
-			if len(client.MyListroots) > 2 {
+			if len(myRoots) > 2 {
 				// Reconstruit le fichier
-				if err := clientStorage.RebuildNode(client.MyListroots[1], DATA_DIRECTORY); err != nil {
+				if err := clientStorage.RebuildNode(myRoots[1], DATA_DIRECTORY); err != nil {
 					logger.Error(err.Error()) // log si reconstruction échoue
 					return
 				}
 			} else {
 
 				// Reconstruit le fichier
-				if err := clientStorage.RebuildNode(client.MyListroots[0], DATA_DIRECTORY); err != nil {
+				if err := clientStorage.RebuildNode(myRoots[0], DATA_DIRECTORY); err != nil {
 					logger.Error(err.Error()) // log si reconstruction échoue
 					return
 				}
@@ -127,14 +133,15 @@ func RestoreMyFile(peerChecks *widget.CheckGroup, conn *net.UDPConn, priv *ecdsa
 		}
 		return
 	case SECOND_LAST_VERSION:
-		if len(client.MyListroots) > 2 && client.MyListroots[0] != nil {
+		myRoots := client.MyRoots()
+		if len(myRoots) > 2 && myRoots[0] != nil {
 			// Supprime le fichier
 			if err := os.RemoveAll(DATA_DIRECTORY); err != nil {
 				logger.Error("Erreur suppression du répertoire: " + err.Error())
 				return
 			}
 			// Reconstruit le fichier
-			if err := clientStorage.RebuildNode(client.MyListroots[0], DATA_DIRECTORY); err != nil {
+			if err := clientStorage.RebuildNode(myRoots[0], DATA_DIRECTORY); err != nil {
 				logger.Error(err.Error()) // log si reconstruction échoue
 				return
 			}
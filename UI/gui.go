@@ -2,7 +2,8 @@ package UI
 
 import (
 	"crypto/ecdsa"
-	"net"
+	"myp2p/client"
+	"myp2p/logging"
 
 	"fyne.io/fyne/v2"
 	"fyne.io/fyne/v2/app"
@@ -10,12 +11,13 @@ import (
 	"fyne.io/fyne/v2/widget"
 )
 
-func StartGUI(conn *net.UDPConn, priv *ecdsa.PrivateKey) {
+func StartGUI(conn client.Transport, priv *ecdsa.PrivateKey) {
 
 	app := app.New()
 	win := app.NewWindow("P2P GUI")
 
 	logger := NewLogger()
+	logger.AttachLogging()
 
 	// initialiser les events
 	RegisterCallbacks(logger)
@@ -27,7 +29,12 @@ func StartGUI(conn *net.UDPConn, priv *ecdsa.PrivateKey) {
 
 	// update mon merkle
 	myMerkleBtn := widget.NewButton("UPDATE MY MERKLE", func() {
-		UpdateMyMerkle(logger)
+		UpdateMyMerkle(logger, false)
+	})
+
+	// relire tous les fichiers et vérifier le cache de hashes
+	verifyMerkleBtn := widget.NewButton("VERIFY MY MERKLE", func() {
+		UpdateMyMerkle(logger, true)
 	})
 
 	banBtn := widget.NewButton("BAN PEER SELECTED", func() {
@@ -46,6 +53,10 @@ func StartGUI(conn *net.UDPConn, priv *ecdsa.PrivateKey) {
 		HandshakeAllPeers(conn, priv, logger)
 	})
 
+	showPeersBtn := widget.NewButton("SHOW PEERS", func() {
+		ShowPeersInfo(logger)
+	})
+
 	askRootBtn := widget.NewButton("ASK ROOT", func() {
 		AskRootSelectedPeers(peerChecks, conn, priv, logger)
 	})
@@ -90,6 +101,28 @@ func StartGUI(conn *net.UDPConn, priv *ecdsa.PrivateKey) {
 		AskDataPeer(peerChecks, filename, version, logger)
 	})
 
+	// ACL : sous-arbres visibles par les peers sélectionnés
+
+	aclEntry := widget.NewEntry()
+	aclEntry.SetPlaceHolder("Chemins visibles, séparés par des virgules (vide = règle par défaut)")
+
+	setACLBtn := widget.NewButton("SET ACL SELECTED", func() {
+		SetACLSelectedPeers(peerChecks, aclEntry.Text, logger)
+	})
+
+	showACLBtn := widget.NewButton("SHOW ACL", func() {
+		ShowACL(logger)
+	})
+
+	// Chiffrement : politique des peers sélectionnés (globale si aucun)
+
+	encryptMode := widget.NewSelect([]string{"never", "opportunistic", "required", "default"}, nil)
+	encryptMode.SetSelected(client.EncryptionPolicyFor("").String())
+
+	encryptBtn := widget.NewButton("SET ENCRYPTION", func() {
+		SetEncryptionSelectedPeers(peerChecks, encryptMode.Selected, logger)
+	})
+
 	// Afficher l'arbre ( pour le debogage)
 
 	merkleBtn := widget.NewButton("PRINT MERKLE TREE", func() {
@@ -102,16 +135,30 @@ func StartGUI(conn *net.UDPConn, priv *ecdsa.PrivateKey) {
 		PrintPeerMerkle(peer, *logger)
 	})
 
+	// Niveau de log (tous les sous-systèmes et vue de log)
+	logLevel := widget.NewSelect([]string{"debug", "info", "warn", "error"}, nil)
+	logLevel.SetSelected("warn")
+	logLevel.OnChanged = func(selected string) {
+		level, err := logging.ParseLevel(selected)
+		if err != nil {
+			return
+		}
+		logging.SetLevel("all", level)
+		GUILogLevel.Set(level)
+	}
+
 	/* ================= LAYOUT ================= */
 
 	buttonsTop := container.NewGridWithColumns(4,
 		myMerkleBtn,
+		verifyMerkleBtn,
 		banBtn,
 		unbanBtn,
 		handshakeBtn,
 		handshakeAllBtn,
 		askRootBtn,
 		askMerkleBtn,
+		showPeersBtn,
 	)
 	// mettre le CheckGroup dans un conteneur scroll horizontal
 	scrollPeerChecks := container.NewHScroll(peerChecks)
@@ -127,8 +174,17 @@ func StartGUI(conn *net.UDPConn, priv *ecdsa.PrivateKey) {
 		fileEntry,
 		askDataBtn,
 		widget.NewSeparator(),
+		widget.NewLabel("ACL :"),
+		aclEntry,
+		container.NewGridWithColumns(2, setACLBtn, showACLBtn),
+		widget.NewSeparator(),
+		widget.NewLabel("CHIFFREMENT (peers sélectionnés, sinon global) :"),
+		container.NewGridWithColumns(2, encryptMode, encryptBtn),
+		widget.NewSeparator(),
 		merkleBtn,
 		restoreSplit,
+		widget.NewSeparator(),
+		container.NewBorder(nil, nil, widget.NewLabel("Logs :"), nil, logLevel),
 	)
 
 	logContainer := container.NewVScroll(logger.View)
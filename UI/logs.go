@@ -1,6 +1,9 @@
 package UI
 
 import (
+	"log/slog"
+	"myp2p/logging"
+
 	"fyne.io/fyne/v2"
 	"fyne.io/fyne/v2/theme"
 	"fyne.io/fyne/v2/widget"
@@ -54,3 +57,32 @@ func (l *Logger) append(msg string, color fyne.ThemeColorName) {
 func (l *Logger) Info(msg string)  { l.append(msg, theme.ColorNameSuccess) } // vert pour info
 func (l *Logger) Warn(msg string)  { l.append(msg, theme.ColorNameWarning) } // orange pour avertissement
 func (l *Logger) Error(msg string) { l.append(msg, theme.ColorNameError) }   // rouge pour erreur
+
+// -----------------------------
+// Journalisation des sous-systèmes
+// -----------------------------
+
+// Logger du sous-système ui
+var uiLog = logging.For("ui")
+
+// GUILogLevel : niveau minimal des messages des sous-systèmes affichés dans la vue
+var GUILogLevel = new(slog.LevelVar)
+
+func init() {
+	GUILogLevel.Set(slog.LevelWarn)
+}
+
+// AttachLogging affiche dans la vue les messages des sous-systèmes
+// (client, merkle…) de niveau au moins GUILogLevel.
+func (l *Logger) AttachLogging() {
+	logging.AddSink(GUILogLevel, func(e logging.Entry) {
+		switch {
+		case e.Level >= slog.LevelError:
+			l.Error(e.String())
+		case e.Level >= slog.LevelWarn:
+			l.Warn(e.String())
+		default:
+			l.Info(e.String())
+		}
+	})
+}
@@ -6,7 +6,6 @@ import (
 	"fmt"
 	"myp2p/client"
 	"myp2p/clientStorage"
-	"net"
 	"strings"
 	"time"
 
@@ -17,25 +16,40 @@ import (
 // UpdateMyMerkle
 // -----------------------------
 // Reconstruit le Merkle tree local à partir des fichiers dans DATA_DIRECTORY
+// - Ne relit que les fichiers modifiés (cache de hashes), tous si verify
 // - Recalcule la racine
 // - Supprime l'ancien Merkle tree si la racine a changé
-// - Log l'action
-func UpdateMyMerkle(logger *Logger) {
+// - Log l'action et les chemins modifiés
+func UpdateMyMerkle(logger *Logger, verify bool) {
 	// reconstruction du Merkle tree à partir du répertoire de données
-	racine, err := clientStorage.BuildMerkleNode(DATA_DIRECTORY)
+	racine, report, err := clientStorage.UpdateMerkle(DATA_DIRECTORY, verify)
 	if err != nil {
 		logger.Error("Erreur répertoire: " + err.Error())
 		return
 	}
+	logger.Info("Merkle : " + report.String())
+	for _, path := range report.Added {
+		logger.Info("  + " + path)
+	}
+	for _, path := range report.Removed {
+		logger.Info("  - " + path)
+	}
+	for _, path := range report.Modified {
+		logger.Info("  ~ " + path)
+	}
+	for _, path := range report.Inconsistent {
+		logger.Warn("  cache incohérent : " + path)
+	}
 
 	// sauvegarde de l'ancienne racine
-	oldroot := clientStorage.RootHash
-	clientStorage.RootHash = clientStorage.Sha(racine)
-	if bytes.Equal(oldroot, clientStorage.RootHash) {
+	oldroot := clientStorage.Root()
+	newroot := clientStorage.Sha(racine)
+	clientStorage.SetRoot(newroot)
+	if bytes.Equal(oldroot, newroot) {
 		logger.Warn("vos données non pas changé")
 		return
 	}
-	client.MyListroots = client.AddListRoot(client.MyListroots, clientStorage.RootHash)
+	client.PushMyRoot(newroot)
 
 	logger.Info("Merkle mis à jour")
 }
@@ -48,7 +62,7 @@ func UpdateMyMerkle(logger *Logger) {
 // - Vérifie que le peer existe, a un ActiveAddr et une racine connue
 // - Crée une transaction et envoie le message
 // - Démarre le suivi de téléchargement Merkle pour le peer
-func AskMerkleSelectedPeers(peerChecks *widget.CheckGroup, conn *net.UDPConn, priv *ecdsa.PrivateKey, logger *Logger) {
+func AskMerkleSelectedPeers(peerChecks *widget.CheckGroup, conn client.Transport, priv *ecdsa.PrivateKey, logger *Logger) {
 	if len(peerChecks.Selected) == 0 {
 		logger.Warn("Sélectionnez au moins un peer")
 		return
@@ -79,13 +93,16 @@ func AskMerkleSelectedPeers(peerChecks *widget.CheckGroup, conn *net.UDPConn, pr
 		client.StartAskMerkle(peer)
 
 		// si le root du peer n'a pas changé on dit directement : " C'est bon téléchargé ! "
-		if peer.RootChanged == false {
+		peer.Mupeer.Lock()
+		unchanged := !peer.RootChanged
+		if unchanged {
 			peer.MerkleDone = true
-
-			if client.OnPeerEvent != nil {
-				duration := time.Since(peer.MerkleDownloadStart)
-				client.OnPeerEvent(peer, client.EventMerkleDownloadComplete, fmt.Sprintf("durée: %s", duration.Round(time.Millisecond)))
-			}
+		}
+		start := peer.MerkleDownloadStart
+		peer.Mupeer.Unlock()
+		if unchanged {
+			duration := time.Since(start)
+			client.EmitPeerEvent(peer, client.EventMerkleDownloadComplete, fmt.Sprintf("durée: %s", duration.Round(time.Millisecond)))
 			continue
 		}
 
@@ -103,7 +120,9 @@ func AskMerkleSelectedPeers(peerChecks *widget.CheckGroup, conn *net.UDPConn, pr
 		// envoi de la requête
 		client.SendMessage(conn, peer.ActiveAddr, msg)
 		logger.Info("→ Requête MERKLE envoyée à " + name)
+		peer.Mupeer.Lock()
 		peer.RootChanged = false // On a récupéré les changements
+		peer.Mupeer.Unlock()
 	}
 }
 
@@ -164,6 +183,26 @@ func PrintTreeGUI(node []byte, depth int, log func(string)) {
 			}
 		}
 
+	case clientStorage.Symlink:
+		log(prefix + "-> " + string(node[clientStorage.IdSize:]))
+
+	case clientStorage.Metadata:
+		n, _ := clientStorage.ParseNode(node)
+		log(prefix + "(" + n.Meta.String() + ")")
+		if child, ok := clientStorage.FindHash(n.Children[0]); ok {
+			PrintTreeGUI(child, depth, log)
+		}
+
+	case clientStorage.LongDirectory:
+		log(prefix + "Directory:")
+		n, _ := clientStorage.ParseNode(node)
+		for _, e := range n.Entries {
+			log(prefix + "  " + e.Name)
+			if child, ok := clientStorage.FindHash(e.Hash); ok {
+				PrintTreeGUI(child, depth+1, log)
+			}
+		}
+
 	case clientStorage.Big, clientStorage.BigDirectory:
 		offset := clientStorage.IdSize
 		for offset+clientStorage.HashSize <= len(node) {
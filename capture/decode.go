@@ -0,0 +1,72 @@
+package capture
+
+import (
+	"crypto/ecdsa"
+	"fmt"
+	"io"
+	"myp2p/client"
+)
+
+//
+// ======================= DÉCODAGE =======================
+//
+
+// KeyFunc renvoie la clé publique d’un peer à partir de son nom (nil si inconnue).
+type KeyFunc func(name string) *ecdsa.PublicKey
+
+// -----------------------------------------------------------------------------------------
+// Decode écrit une ligne lisible par paquet : heure, sens, adresse du peer puis
+// le paquet décodé (type, champs du body, validité de la signature).
+// Les noms des peers sont appris des Hello / HelloReply de la capture ; la
+// signature d’un paquet n’est vérifiée que si keys connaît son émetteur.
+// Paramètres :
+//   - w    : destination
+//   - recs : paquets de la capture
+//   - keys : résolution des clés publiques (nil = signatures non vérifiées)
+func Decode(w io.Writer, recs []Record, keys KeyFunc) error {
+	names := map[string]string{} // adresse du peer → nom
+	var self string              // notre nom, appris de nos propres Hello
+	cache := map[string]*ecdsa.PublicKey{}
+	keyOf := func(name string) *ecdsa.PublicKey {
+		if keys == nil || name == "" {
+			return nil
+		}
+		if k, ok := cache[name]; ok {
+			return k
+		}
+		k := keys(name)
+		cache[name] = k
+		return k
+	}
+
+	for _, rec := range recs {
+		// le nom annoncé permet de vérifier la signature du Hello lui-même
+		info := client.DecodePacket(rec.Data, nil)
+		remote := rec.Remote.String()
+		if info.PeerName != "" {
+			if rec.Dir == In {
+				names[remote] = info.PeerName
+			} else {
+				self = info.PeerName
+			}
+		}
+		signer := names[remote]
+		if rec.Dir == Out {
+			signer = self
+		}
+		if info.Err == nil && info.Signature != client.SigAbsent {
+			if k := keyOf(signer); k != nil {
+				info = client.DecodePacket(rec.Data, k)
+			}
+		}
+
+		peer := remote
+		if name := names[remote]; name != "" {
+			peer = name + " (" + remote + ")"
+		}
+		if _, err := fmt.Fprintf(w, "%s %s %s %s\n", rec.Time.Format("15:04:05.000000"), rec.Dir, peer, info); err != nil {
+			return err
+		}
+	}
+	return nil
+}
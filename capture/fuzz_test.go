@@ -0,0 +1,98 @@
+package capture
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"myp2p/client"
+	"net"
+	"testing"
+	"time"
+)
+
+//
+// ======================= CIBLES DE FUZZING =======================
+//
+
+// FuzzRead : lecture d’un fichier pcapng (cf. README, « Fuzzing ») :
+//
+//	go test ./capture -run '^$' -fuzz '^FuzzRead$'
+//
+// Chaque paquet lu passe aussi par le décodage lisible. Le corpus initial est
+// une capture IPv4 et une capture IPv6 d’un échange Hello, RootRequest et
+// DatumRequest.
+func FuzzRead(f *testing.F) {
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		f.Fatal(err)
+	}
+	for _, peers := range [][2]string{
+		{"192.0.2.1:8443", "198.51.100.7:40000"},
+		{"[2001:db8::1]:8443", "[2001:db8::7]:40000"},
+	} {
+		f.Add(seedCapture(f, priv, peers[0], peers[1]))
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		recs, err := Read(bytes.NewReader(data))
+		if err != nil {
+			return
+		}
+		var out bytes.Buffer
+		Decode(&out, recs, nil)
+	})
+}
+
+// seedCapture écrit la capture d’un échange entre local et remote.
+func seedCapture(f *testing.F, priv *ecdsa.PrivateKey, local, remote string) []byte {
+	f.Helper()
+	l, err := net.ResolveUDPAddr("udp", local)
+	if err != nil {
+		f.Fatal(err)
+	}
+	r, err := net.ResolveUDPAddr("udp", remote)
+	if err != nil {
+		f.Fatal(err)
+	}
+	hash := make([]byte, 32)
+	hello, err := client.BuildHello(1, 1<<client.ExtensionVersion, "fuzz", priv, client.Hello)
+	if err != nil {
+		f.Fatal(err)
+	}
+	reply, err := client.BuildHello(1, 0, "peer", priv, client.HelloReply)
+	if err != nil {
+		f.Fatal(err)
+	}
+	rootReq, err := client.BuildMessage(2, client.RootRequest, nil, priv, true)
+	if err != nil {
+		f.Fatal(err)
+	}
+	rootReply, err := client.BuildMessage(2, client.RootReply, hash, priv, true)
+	if err != nil {
+		f.Fatal(err)
+	}
+	datumReq, err := client.BuildDatumRequest(3, hash)
+	if err != nil {
+		f.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf)
+	if err != nil {
+		f.Fatal(err)
+	}
+	at := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	for i, p := range []struct {
+		dir  Direction
+		data []byte
+	}{{Out, hello}, {In, reply}, {Out, rootReq}, {In, rootReply}, {Out, datumReq}} {
+		rec := Record{Time: at.Add(time.Duration(i) * time.Millisecond), Dir: p.dir, Local: l, Remote: r, Data: p.data}
+		if err := w.Write(rec); err != nil {
+			f.Fatal(err)
+		}
+	}
+	if recs, err := Read(bytes.NewReader(buf.Bytes())); err != nil || len(recs) != 5 {
+		f.Fatalf("capture de départ illisible : %d paquets, %v", len(recs), err)
+	}
+	return buf.Bytes()
+}
@@ -0,0 +1,450 @@
+// Package capture enregistre le trafic P2P dans un fichier pcapng.
+//
+// Chaque paquet émis ou reçu par un nœud est encapsulé dans un en-tête IP et
+// UDP synthétique (type de lien LINKTYPE_RAW) : le fichier s’ouvre tel quel
+// dans Wireshark ou tcpdump. Le sens du paquet est porté par l’option epb_flags.
+// Le paquet fournit aussi le décodage lisible d’une capture (Decode) et un
+// transport de rejeu pour les tests de non-régression (Replay).
+package capture
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"myp2p/logging"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// Logger du sous-système capture
+var captureLog = logging.For("capture")
+
+// Direction : sens d’un paquet vu par le nœud
+type Direction uint8
+
+const (
+	In  Direction = 1 // reçu (valeur de epb_flags)
+	Out Direction = 2 // émis
+)
+
+func (d Direction) String() string {
+	if d == Out {
+		return "→"
+	}
+	return "←"
+}
+
+// Record : un paquet capturé
+type Record struct {
+	Time   time.Time
+	Dir    Direction
+	Local  *net.UDPAddr // adresse du nœud
+	Remote *net.UDPAddr // adresse du peer
+	Data   []byte       // datagramme UDP (paquet du protocole)
+}
+
+//
+// ======================= FORMAT PCAPNG =======================
+//
+
+const (
+	blockSHB       = 0x0A0D0D0A
+	blockIDB       = 0x00000001
+	blockEPB       = 0x00000006
+	byteOrderMagic = 0x1A2B3C4D
+
+	linkTypeRaw = 101 // LINKTYPE_RAW : paquet IPv4 ou IPv6 sans en-tête de lien
+
+	optEndOfOpt = 0
+	optTsResol  = 9 // if_tsresol
+	optEPBFlags = 2 // epb_flags
+
+	ipv4HeaderSize = 20
+	ipv6HeaderSize = 40
+	udpHeaderSize  = 8
+)
+
+// pad4 arrondit n au multiple de 4 supérieur.
+func pad4(n int) int { return (n + 3) &^ 3 }
+
+// -----------------------------------------------------------------------------------------
+// Writer écrit une capture pcapng ; il peut être partagé entre plusieurs routines.
+type Writer struct {
+	mu     sync.Mutex
+	w      io.Writer
+	closer io.Closer
+}
+
+// NewWriter écrit l’en-tête de la capture (section et interface) sur w.
+func NewWriter(w io.Writer) (*Writer, error) {
+	le := binary.LittleEndian
+
+	// Section Header Block
+	shb := make([]byte, 28)
+	le.PutUint32(shb[0:], blockSHB)
+	le.PutUint32(shb[4:], 28)
+	le.PutUint32(shb[8:], byteOrderMagic)
+	le.PutUint16(shb[12:], 1) // version 1.0
+	le.PutUint16(shb[14:], 0)
+	le.PutUint64(shb[16:], math.MaxUint64) // longueur de section inconnue
+	le.PutUint32(shb[24:], 28)
+
+	// Interface Description Block, horodatage à la nanoseconde
+	idb := make([]byte, 32)
+	le.PutUint32(idb[0:], blockIDB)
+	le.PutUint32(idb[4:], 32)
+	le.PutUint16(idb[8:], linkTypeRaw)
+	le.PutUint32(idb[12:], 0) // pas de limite de taille
+	le.PutUint16(idb[16:], optTsResol)
+	le.PutUint16(idb[18:], 1)
+	idb[20] = 9
+	le.PutUint16(idb[24:], optEndOfOpt)
+	le.PutUint32(idb[28:], 32)
+
+	if _, err := w.Write(append(shb, idb...)); err != nil {
+		return nil, err
+	}
+	return &Writer{w: w}, nil
+}
+
+// Create crée le fichier de capture path.
+func Create(path string) (*Writer, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	cw, err := NewWriter(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	cw.closer = f
+	return cw, nil
+}
+
+// Write ajoute un paquet à la capture.
+func (cw *Writer) Write(rec Record) error {
+	src, dst := rec.Local, rec.Remote
+	if rec.Dir == In {
+		src, dst = dst, src
+	}
+	pkt := encapsulate(src, dst, rec.Data)
+
+	le := binary.LittleEndian
+	size := 28 + pad4(len(pkt)) + 12 + 4
+	b := make([]byte, size)
+	le.PutUint32(b[0:], blockEPB)
+	le.PutUint32(b[4:], uint32(size))
+	le.PutUint32(b[8:], 0) // interface 0
+	ts := uint64(rec.Time.UnixNano())
+	le.PutUint32(b[12:], uint32(ts>>32))
+	le.PutUint32(b[16:], uint32(ts))
+	le.PutUint32(b[20:], uint32(len(pkt)))
+	le.PutUint32(b[24:], uint32(len(pkt)))
+	copy(b[28:], pkt)
+	opt := 28 + pad4(len(pkt))
+	le.PutUint16(b[opt:], optEPBFlags)
+	le.PutUint16(b[opt+2:], 4)
+	le.PutUint32(b[opt+4:], uint32(rec.Dir))
+	le.PutUint16(b[opt+8:], optEndOfOpt)
+	le.PutUint32(b[size-4:], uint32(size))
+
+	cw.mu.Lock()
+	defer cw.mu.Unlock()
+	_, err := cw.w.Write(b)
+	return err
+}
+
+// Close ferme le fichier créé par Create (sans effet pour NewWriter).
+func (cw *Writer) Close() error {
+	cw.mu.Lock()
+	defer cw.mu.Unlock()
+	if cw.closer == nil {
+		return nil
+	}
+	err := cw.closer.Close()
+	cw.closer = nil
+	return err
+}
+
+// -----------------------------------------------------------------------------------------
+// encapsulate ajoute un en-tête IP et UDP synthétique devant payload ;
+// IPv4 si le peer est en IPv4, IPv6 sinon.
+func encapsulate(src, dst *net.UDPAddr, payload []byte) []byte {
+	srcIP, dstIP := addrIP(src), addrIP(dst)
+	v4 := dstIP.To4() != nil && srcIP.To4() != nil
+	if (src == nil || src.IP.IsUnspecified()) && dstIP.To4() != nil {
+		v4 = true
+	}
+	if (dst == nil || dst.IP.IsUnspecified()) && srcIP.To4() != nil {
+		v4 = true
+	}
+
+	udpLen := udpHeaderSize + len(payload)
+	var b []byte
+	var pseudo []byte
+	if v4 {
+		b = make([]byte, ipv4HeaderSize+udpLen)
+		h := b[:ipv4HeaderSize]
+		h[0] = 0x45
+		binary.BigEndian.PutUint16(h[2:], uint16(len(b)))
+		binary.BigEndian.PutUint16(h[6:], 0x4000) // DF
+		h[8] = 64
+		h[9] = 17 // UDP
+		copy(h[12:16], ip4(srcIP))
+		copy(h[16:20], ip4(dstIP))
+		binary.BigEndian.PutUint16(h[10:], checksum(h, 0))
+
+		pseudo = make([]byte, 12)
+		copy(pseudo[0:8], h[12:20])
+		pseudo[9] = 17
+		binary.BigEndian.PutUint16(pseudo[10:], uint16(udpLen))
+	} else {
+		b = make([]byte, ipv6HeaderSize+udpLen)
+		h := b[:ipv6HeaderSize]
+		h[0] = 0x60
+		binary.BigEndian.PutUint16(h[4:], uint16(udpLen))
+		h[6] = 17 // UDP
+		h[7] = 64
+		copy(h[8:24], ip16(srcIP))
+		copy(h[24:40], ip16(dstIP))
+
+		pseudo = make([]byte, 40)
+		copy(pseudo[0:32], h[8:40])
+		binary.BigEndian.PutUint32(pseudo[32:], uint32(udpLen))
+		pseudo[39] = 17
+	}
+
+	u := b[len(b)-udpLen:]
+	binary.BigEndian.PutUint16(u[0:], uint16(addrPort(src)))
+	binary.BigEndian.PutUint16(u[2:], uint16(addrPort(dst)))
+	binary.BigEndian.PutUint16(u[4:], uint16(udpLen))
+	copy(u[udpHeaderSize:], payload)
+	sum := checksum(u, checksum(pseudo, 0)^0xffff)
+	if sum == 0 {
+		sum = 0xffff
+	}
+	binary.BigEndian.PutUint16(u[6:], sum)
+	return b
+}
+
+// checksum calcule la somme de contrôle Internet de b, à partir d’une somme
+// partielle initial (déjà repliée).
+func checksum(b []byte, initial uint16) uint16 {
+	sum := uint32(initial)
+	for i := 0; i+1 < len(b); i += 2 {
+		sum += uint32(binary.BigEndian.Uint16(b[i:]))
+	}
+	if len(b)%2 == 1 {
+		sum += uint32(b[len(b)-1]) << 8
+	}
+	for sum > 0xffff {
+		sum = sum>>16 + sum&0xffff
+	}
+	return ^uint16(sum)
+}
+
+func addrIP(a *net.UDPAddr) net.IP {
+	if a == nil {
+		return nil
+	}
+	return a.IP
+}
+
+func addrPort(a *net.UDPAddr) int {
+	if a == nil {
+		return 0
+	}
+	return a.Port
+}
+
+func ip4(ip net.IP) net.IP {
+	if v4 := ip.To4(); v4 != nil {
+		return v4
+	}
+	return net.IPv4zero.To4()
+}
+
+func ip16(ip net.IP) net.IP {
+	if v6 := ip.To16(); v6 != nil {
+		return v6
+	}
+	return net.IPv6unspecified
+}
+
+//
+// ======================= LECTURE =======================
+//
+
+// ReadFile lit tous les paquets d’une capture écrite par Writer.
+func ReadFile(path string) ([]Record, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return Read(f)
+}
+
+// Read lit tous les paquets d’une capture pcapng (LINKTYPE_RAW, paquets UDP) ;
+// les blocs d’un autre type sont ignorés.
+func Read(r io.Reader) ([]Record, error) {
+	br := bufio.NewReader(r)
+	var order binary.ByteOrder = binary.LittleEndian
+	var recs []Record
+	resol := time.Nanosecond
+	first := true
+
+	for {
+		var head [8]byte
+		if _, err := io.ReadFull(br, head[:]); err != nil {
+			if errors.Is(err, io.EOF) && !first {
+				return recs, nil
+			}
+			return recs, fmt.Errorf("capture : en-tête de bloc : %w", err)
+		}
+		typ := binary.LittleEndian.Uint32(head[0:])
+		if typ == blockSHB {
+			// l’ordre des octets est donné par le magic qui suit
+			var magic [4]byte
+			if _, err := io.ReadFull(br, magic[:]); err != nil {
+				return recs, fmt.Errorf("capture : section : %w", err)
+			}
+			switch {
+			case binary.LittleEndian.Uint32(magic[:]) == byteOrderMagic:
+				order = binary.LittleEndian
+			case binary.BigEndian.Uint32(magic[:]) == byteOrderMagic:
+				order = binary.BigEndian
+			default:
+				return recs, errors.New("capture : fichier pcapng invalide")
+			}
+			size := int(order.Uint32(head[4:]))
+			if size < 28 || size%4 != 0 {
+				return recs, errors.New("capture : section invalide")
+			}
+			if _, err := br.Discard(size - 12); err != nil {
+				return recs, err
+			}
+			first = false
+			continue
+		}
+		if first {
+			return recs, errors.New("capture : fichier pcapng invalide")
+		}
+
+		typ = order.Uint32(head[0:])
+		size := int(order.Uint32(head[4:]))
+		if size < 12 || size%4 != 0 || size > 1<<20 {
+			return recs, fmt.Errorf("capture : bloc de taille invalide (%d)", size)
+		}
+		body := make([]byte, size-8)
+		if _, err := io.ReadFull(br, body); err != nil {
+			return recs, fmt.Errorf("capture : bloc tronqué : %w", err)
+		}
+		body = body[:len(body)-4] // longueur répétée en fin de bloc
+
+		switch typ {
+		case blockIDB:
+			if len(body) < 8 {
+				return recs, errors.New("capture : interface invalide")
+			}
+			if lt := order.Uint16(body[0:]); lt != linkTypeRaw {
+				return recs, fmt.Errorf("capture : type de lien %d non géré", lt)
+			}
+			resol = time.Microsecond // valeur par défaut de pcapng
+			walkOptions(body[8:], order, func(code uint16, val []byte) {
+				if code == optTsResol && len(val) == 1 && val[0] < 0x80 && val[0] <= 9 {
+					resol = time.Duration(math.Pow10(9 - int(val[0])))
+				}
+			})
+		case blockEPB:
+			rec, ok := parseEPB(body, order, resol)
+			if ok {
+				recs = append(recs, rec)
+			}
+		}
+	}
+}
+
+// parseEPB décode un Enhanced Packet Block (sans ses 8 premiers octets).
+func parseEPB(body []byte, order binary.ByteOrder, resol time.Duration) (Record, bool) {
+	if len(body) < 20 {
+		return Record{}, false
+	}
+	ts := uint64(order.Uint32(body[4:]))<<32 | uint64(order.Uint32(body[8:]))
+	capLen := int(order.Uint32(body[12:]))
+	if capLen > len(body)-20 {
+		return Record{}, false
+	}
+	src, dst, payload, ok := decapsulate(body[20 : 20+capLen])
+	if !ok {
+		return Record{}, false
+	}
+	rec := Record{
+		Time: time.Unix(0, int64(ts)*int64(resol)),
+		Dir:  In,
+		Data: payload,
+	}
+	walkOptions(body[20+pad4(capLen):], order, func(code uint16, val []byte) {
+		if code == optEPBFlags && len(val) == 4 && Direction(order.Uint32(val)&3) == Out {
+			rec.Dir = Out
+		}
+	})
+	rec.Local, rec.Remote = dst, src
+	if rec.Dir == Out {
+		rec.Local, rec.Remote = src, dst
+	}
+	return rec, true
+}
+
+// walkOptions parcourt les options d’un bloc pcapng.
+func walkOptions(b []byte, order binary.ByteOrder, fn func(code uint16, val []byte)) {
+	for len(b) >= 4 {
+		code, l := order.Uint16(b[0:]), int(order.Uint16(b[2:]))
+		if code == optEndOfOpt || 4+l > len(b) {
+			return
+		}
+		fn(code, b[4:4+l])
+		b = b[min(4+pad4(l), len(b)):]
+	}
+}
+
+// decapsulate extrait les adresses et le datagramme d’un paquet IP/UDP.
+func decapsulate(pkt []byte) (src, dst *net.UDPAddr, payload []byte, ok bool) {
+	if len(pkt) < 1 {
+		return nil, nil, nil, false
+	}
+	var udp []byte
+	src, dst = &net.UDPAddr{}, &net.UDPAddr{}
+	switch pkt[0] >> 4 {
+	case 4:
+		ihl := int(pkt[0]&0x0f) * 4
+		if ihl < ipv4HeaderSize || len(pkt) < ihl+udpHeaderSize || pkt[9] != 17 {
+			return nil, nil, nil, false
+		}
+		src.IP = net.IP(append([]byte(nil), pkt[12:16]...))
+		dst.IP = net.IP(append([]byte(nil), pkt[16:20]...))
+		udp = pkt[ihl:]
+	case 6:
+		if len(pkt) < ipv6HeaderSize+udpHeaderSize || pkt[6] != 17 {
+			return nil, nil, nil, false
+		}
+		src.IP = net.IP(append([]byte(nil), pkt[8:24]...))
+		dst.IP = net.IP(append([]byte(nil), pkt[24:40]...))
+		udp = pkt[ipv6HeaderSize:]
+	default:
+		return nil, nil, nil, false
+	}
+	udpLen := int(binary.BigEndian.Uint16(udp[4:]))
+	if udpLen < udpHeaderSize || udpLen > len(udp) {
+		return nil, nil, nil, false
+	}
+	src.Port = int(binary.BigEndian.Uint16(udp[0:]))
+	dst.Port = int(binary.BigEndian.Uint16(udp[2:]))
+	return src, dst, append([]byte(nil), udp[udpHeaderSize:udpLen]...), true
+}
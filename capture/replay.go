@@ -0,0 +1,248 @@
+package capture
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"myp2p/client"
+	"net"
+	"sync"
+	"time"
+)
+
+//
+// ======================= REJEU =======================
+//
+
+// Replay est un client.Transport qui fournit à un nœud les paquets reçus
+// d’une capture, dans l’ordre, et enregistre ce que le nœud émet en retour.
+// Seuls les paquets entrants sont rejoués : les paquets émis de la capture
+// servent de référence (cf. Diff).
+//
+// Avant de remettre un paquet, le rejeu attend (au plus ReplayWait) que le nœud
+// ait émis autant de paquets qu’avant lui dans la capture : une réponse arrive
+// donc après la requête à laquelle elle répond, comme lors de l’enregistrement.
+type Replay struct {
+	// Pace respecte les délais entre paquets de la capture (false = au plus vite)
+	Pace bool
+
+	local  *net.UDPAddr
+	in     []Record
+	before []int // pour chaque paquet entrant, nombre de paquets émis avant lui
+	pos    int
+	done   chan struct{} // fermé une fois tous les paquets rejoués
+	closed chan struct{}
+	once   sync.Once
+
+	mu    sync.Mutex
+	sent  []Record
+	wrote chan struct{} // signale un paquet émis
+}
+
+// ReplayWait : attente maximale des paquets émis par le nœud avant de remettre
+// le paquet entrant suivant
+var ReplayWait = 2 * time.Second
+
+var _ client.Transport = (*Replay)(nil)
+
+// NewReplay prépare le rejeu des paquets entrants de recs.
+func NewReplay(recs []Record) *Replay {
+	r := &Replay{
+		local:  &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)},
+		done:   make(chan struct{}),
+		closed: make(chan struct{}),
+		wrote:  make(chan struct{}, 1),
+	}
+	out := 0
+	for _, rec := range recs {
+		if rec.Dir != In {
+			out++
+			continue
+		}
+		if r.in == nil && rec.Local != nil {
+			r.local = rec.Local
+		}
+		r.in = append(r.in, rec)
+		r.before = append(r.before, out)
+	}
+	if len(r.in) == 0 {
+		close(r.done)
+	}
+	return r
+}
+
+// ReadFromUDP renvoie le paquet entrant suivant ; une fois la capture épuisée,
+// bloque jusqu’à Close.
+func (r *Replay) ReadFromUDP(b []byte) (int, *net.UDPAddr, error) {
+	if r.pos >= len(r.in) {
+		<-r.closed
+		return 0, nil, net.ErrClosed
+	}
+	rec := r.in[r.pos]
+	if !r.waitSent(r.before[r.pos]) {
+		return 0, nil, net.ErrClosed
+	}
+	if r.Pace && r.pos > 0 {
+		select {
+		case <-time.After(rec.Time.Sub(r.in[r.pos-1].Time)):
+		case <-r.closed:
+			return 0, nil, net.ErrClosed
+		}
+	}
+	r.pos++
+	if r.pos == len(r.in) {
+		close(r.done)
+	}
+	return copy(b, rec.Data), rec.Remote, nil
+}
+
+// WriteToUDP enregistre le paquet émis par le nœud.
+func (r *Replay) WriteToUDP(b []byte, addr *net.UDPAddr) (int, error) {
+	select {
+	case <-r.closed:
+		return 0, net.ErrClosed
+	default:
+	}
+	r.mu.Lock()
+	r.sent = append(r.sent, Record{Time: time.Now(), Dir: Out, Local: r.local, Remote: addr, Data: append([]byte(nil), b...)})
+	r.mu.Unlock()
+	select {
+	case r.wrote <- struct{}{}:
+	default:
+	}
+	return len(b), nil
+}
+
+// waitSent attend que le nœud ait émis count paquets, au plus ReplayWait.
+// Retour :
+//   - false si le rejeu a été fermé entre-temps
+func (r *Replay) waitSent(count int) bool {
+	timeout := time.NewTimer(ReplayWait)
+	defer timeout.Stop()
+	for {
+		r.mu.Lock()
+		n := len(r.sent)
+		r.mu.Unlock()
+		if n >= count {
+			return true
+		}
+		select {
+		case <-r.wrote:
+		case <-timeout.C:
+			return true
+		case <-r.closed:
+			return false
+		}
+	}
+}
+
+// LocalAddr renvoie l’adresse du nœud capturé.
+func (r *Replay) LocalAddr() net.Addr { return r.local }
+
+// Close termine le rejeu.
+func (r *Replay) Close() error {
+	r.once.Do(func() { close(r.closed) })
+	return nil
+}
+
+// Done est fermé une fois tous les paquets entrants remis au nœud.
+func (r *Replay) Done() <-chan struct{} { return r.done }
+
+// Sent renvoie les paquets émis par le nœud depuis le début du rejeu.
+func (r *Replay) Sent() []Record {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]Record(nil), r.sent...)
+}
+
+// -----------------------------------------------------------------------------------------
+// RunReplay rejoue une capture dans un nouveau nœud et renvoie les paquets émis.
+// Paramètres :
+//   - ctx    : annulation du rejeu
+//   - recs   : paquets de la capture
+//   - cfg    : configuration du nœud (Conn est remplacé par le rejeu ; Priv obligatoire)
+//   - settle : délai laissé au nœud pour répondre au dernier paquet
+//   - setup  : préparation du nœud avant le rejeu, par exemple les peers qu’il
+//     connaît déjà (nil = aucune)
+//
+// Retour :
+//   - les paquets émis par le nœud
+//   - erreur si le nœud n’a pas pu démarrer
+func RunReplay(ctx context.Context, recs []Record, cfg client.NodeConfig, settle time.Duration, setup func(*client.Node)) ([]Record, error) {
+	r := NewReplay(recs)
+	cfg.Conn = r
+	node := client.NewNode(cfg)
+	if setup != nil {
+		setup(node)
+	}
+
+	runCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	errc := make(chan error, 1)
+	go func() { errc <- node.Run(runCtx) }()
+
+	select {
+	case <-r.Done():
+		select {
+		case <-time.After(settle):
+		case <-ctx.Done():
+		}
+	case <-ctx.Done():
+	case err := <-errc:
+		return r.Sent(), err
+	}
+	cancel()
+	err := <-errc
+	return r.Sent(), err
+}
+
+// -----------------------------------------------------------------------------------------
+// Diff compare les paquets émis lors d’un rejeu à ceux de la capture d’origine,
+// dans l’ordre : destinataire, type et body. Les identifiants (générés par le
+// nœud) et les signatures (ECDSA, non déterministe) ne sont pas comparés.
+// Retour :
+//   - une ligne par différence (vide = comportement identique)
+func Diff(want, got []Record) []string {
+	want, got = outbound(want), outbound(got)
+	var diffs []string
+	for i := 0; i < len(want) || i < len(got); i++ {
+		switch {
+		case i >= len(got):
+			diffs = append(diffs, fmt.Sprintf("paquet %d manquant : %s", i, describe(want[i])))
+		case i >= len(want):
+			diffs = append(diffs, fmt.Sprintf("paquet %d en trop : %s", i, describe(got[i])))
+		case !samePacket(want[i], got[i]):
+			diffs = append(diffs, fmt.Sprintf("paquet %d : attendu %s, obtenu %s", i, describe(want[i]), describe(got[i])))
+		}
+	}
+	return diffs
+}
+
+func outbound(recs []Record) []Record {
+	var out []Record
+	for _, rec := range recs {
+		if rec.Dir == Out {
+			out = append(out, rec)
+		}
+	}
+	return out
+}
+
+// samePacket compare destinataire, type et body de deux paquets.
+func samePacket(a, b Record) bool {
+	if a.Remote.String() != b.Remote.String() {
+		return false
+	}
+	pa, pb := client.DecodePacket(a.Data, nil), client.DecodePacket(b.Data, nil)
+	if pa.Err != nil || pb.Err != nil {
+		return bytes.Equal(a.Data, b.Data)
+	}
+	if pa.Type != pb.Type || pa.BodyLen != pb.BodyLen {
+		return false
+	}
+	return bytes.Equal(a.Data[client.HeaderSize:client.HeaderSize+pa.BodyLen], b.Data[client.HeaderSize:client.HeaderSize+pb.BodyLen])
+}
+
+func describe(rec Record) string {
+	return rec.Remote.String() + " " + client.DecodePacket(rec.Data, nil).String()
+}
@@ -0,0 +1,277 @@
+package capture
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"log/slog"
+	"myp2p/client"
+	"myp2p/clientStorage"
+	"myp2p/internal/testkeyserver"
+	"myp2p/logging"
+	"myp2p/simnet"
+	"net"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// -update régénère la capture de référence à partir d’un vrai échange sur simnet :
+//
+//	go test ./capture -run TestReplay -update
+var update = flag.Bool("update", false, "régénère testdata/exchange.pcapng")
+
+// Capture de référence : le trafic de bob pendant qu’alice s’associe, lui
+// demande son root et télécharge son arbre nœud par nœud. exchange.pub contient la clé
+// publique d’alice (ses paquets sont signés).
+const (
+	exchangeFile = "testdata/exchange.pcapng"
+	exchangeKey  = "testdata/exchange.pub"
+	aliceAddr    = "10.0.0.1:9000"
+	bobAddr      = "10.0.0.2:9000"
+)
+
+// TestMain ne garde que les erreurs dans les logs, sauf si MYP2P_LOG est
+// défini.
+func TestMain(m *testing.M) {
+	flag.Parse()
+	if os.Getenv(logging.LevelsEnv) == "" {
+		logging.SetLevel("all", slog.LevelError)
+	}
+	os.Exit(m.Run())
+}
+
+// TestReplay rejoue les paquets reçus par bob dans un nouveau nœud, avec le
+// même arbre : ses réponses doivent être celles de la capture.
+func TestReplay(t *testing.T) {
+	if *update {
+		recordExchange(t)
+	}
+	recs, err := ReadFile(exchangeFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyHex, err := os.ReadFile(exchangeKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	aliceKey, err := hex.DecodeString(strings.TrimSpace(string(keyHex)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	alicePub, err := client.ParsePublicKey(aliceKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ks := testkeyserver.New(t)
+	ks.Register("alice", alicePub, aliceAddr)
+	store := bobTree(t)
+
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := client.NodeConfig{Name: "bob", Priv: priv, Store: store, BanFile: filepath.Join(t.TempDir(), "bans.json")}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	sent, err := RunReplay(ctx, recs, cfg, 500*time.Millisecond, func(n *client.Node) {
+		n.InitPeersMap([]string{"alice"})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(outbound(recs)) == 0 {
+		t.Fatal("capture sans paquet émis")
+	}
+	for _, d := range Diff(recs, sent) {
+		t.Error(d)
+	}
+}
+
+//
+// ======================= CAPTURE DE RÉFÉRENCE =======================
+//
+
+// recordExchange fait tourner bob sur simnet, son transport enregistrant son
+// trafic dans exchangeFile. Alice est jouée par le test, un paquet à la fois,
+// pour que la capture ne dépende pas de l’ordonnancement : Hello mutuel, Ping
+// de bob, RootRequest puis DatumRequest de chaque nœud de l’arbre et d’un
+// hash inconnu.
+func recordExchange(t *testing.T) {
+	ks := testkeyserver.New(t)
+	sim := simnet.New(1)
+
+	alicePriv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bobPriv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ks.Register("alice", &alicePriv.PublicKey, aliceAddr)
+	ks.Register("bob", &bobPriv.PublicKey, bobAddr)
+
+	ca, err := sim.Listen(aliceAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cb, err := sim.Listen(bobAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Dir(exchangeFile), 0755); err != nil {
+		t.Fatal(err)
+	}
+	w, err := Create(exchangeFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store := bobTree(t)
+	bob := client.NewNode(client.NodeConfig{Name: "bob", Conn: Wrap(cb, w), Priv: bobPriv, Store: store, BanFile: filepath.Join(t.TempDir(), "bans.json")})
+	go bob.Run(context.Background())
+	bob.InitPeersMap([]string{"alice"})
+
+	alice := newScript(t, ca, alicePriv, net.UDPAddrFromAddrPort(netip.MustParseAddrPort(bobAddr)))
+	hello := &client.HelloMsg{
+		Extensions: 1 << client.ExtensionVersion,
+		Name:       "alice",
+		TLVs:       []client.TLV{{Tag: client.ExtensionVersion, Value: binary.BigEndian.AppendUint16(nil, client.ProtocolVersion)}},
+	}
+	alice.send(1, hello, true)
+	alice.expect(client.HelloReply)
+	bobHello := alice.expect(client.Hello)
+	reply := *hello
+	reply.Reply = true
+	alice.send(bobHello.ID, &reply, true)
+	ping := alice.expect(client.Ping)
+	alice.send(ping.ID, &client.OkMsg{}, false)
+
+	alice.send(2, &client.RootRequestMsg{}, false)
+	alice.expect(client.RootReply)
+	id := uint32(3)
+	for hashes := [][]byte{store.Root()}; len(hashes) > 0; hashes = hashes[1:] {
+		alice.send(id, &client.DatumRequestMsg{Hash: hashes[0]}, false)
+		p := alice.expect(client.Datum)
+		m, err := p.Message()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, h := range clientStorage.ListChildrenHashes(m.(*client.DatumMsg).Value) {
+			hash, _ := hex.DecodeString(h)
+			hashes = append(hashes, hash)
+		}
+		id++
+	}
+	alice.send(id, &client.DatumRequestMsg{Hash: make([]byte, 32)}, false)
+	alice.expect(client.NoDatum)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	bob.Shutdown(ctx)
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	pub := hex.EncodeToString(client.SerializePublicKey(&alicePriv.PublicKey))
+	if err := os.WriteFile(exchangeKey, []byte(pub+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// script joue un peer paquet par paquet sur une connexion simnet.
+type script struct {
+	t    *testing.T
+	conn *simnet.Conn
+	priv *ecdsa.PrivateKey
+	peer *net.UDPAddr
+	in   chan []byte
+}
+
+func newScript(t *testing.T, conn *simnet.Conn, priv *ecdsa.PrivateKey, peer *net.UDPAddr) *script {
+	s := &script{t: t, conn: conn, priv: priv, peer: peer, in: make(chan []byte, 64)}
+	go func() {
+		buf := make([]byte, 65536)
+		for {
+			n, _, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			s.in <- append([]byte(nil), buf[:n]...)
+		}
+	}()
+	t.Cleanup(func() { conn.Close() })
+	return s
+}
+
+// send envoie m au peer sous l’identifiant id.
+func (s *script) send(id uint32, m client.Message, sign bool) {
+	s.t.Helper()
+	pkt, err := client.EncodeMessage(id, m, s.priv, sign)
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	if err := client.SendMessage(s.conn, s.peer, pkt); err != nil {
+		s.t.Fatal(err)
+	}
+}
+
+// expect attend le paquet suivant du peer, qui doit être de type msgType.
+func (s *script) expect(msgType uint8) client.Packet {
+	s.t.Helper()
+	select {
+	case pkt := <-s.in:
+		p, err := client.ParsePacket(pkt)
+		if err != nil {
+			s.t.Fatal(err)
+		}
+		if p.Type != msgType {
+			s.t.Fatalf("attendu %s, reçu %s", client.MsgTypeName(msgType), client.DecodePacket(pkt, nil))
+		}
+		return p
+	case <-time.After(5 * time.Second):
+		s.t.Fatalf("pas de %s", client.MsgTypeName(msgType))
+	}
+	return client.Packet{}
+}
+
+// bobTree construit l’arbre partagé par bob : toujours le même, sans
+// métadonnées (dates de modification).
+func bobTree(t *testing.T) *clientStorage.Store {
+	t.Helper()
+	old := clientStorage.FileMetadata
+	clientStorage.FileMetadata = false
+	defer func() { clientStorage.FileMetadata = old }()
+
+	dir := t.TempDir()
+	for d := range 2 {
+		sub := filepath.Join(dir, fmt.Sprintf("dir%d", d))
+		if err := os.Mkdir(sub, 0755); err != nil {
+			t.Fatal(err)
+		}
+		for f := range 3 {
+			data := make([]byte, 1500*(f+1)+d)
+			for i := range data {
+				data[i] = byte(i*(f+3) + d)
+			}
+			if err := os.WriteFile(filepath.Join(sub, fmt.Sprintf("file%d.bin", f)), data, 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	store := clientStorage.NewStore()
+	node, err := store.BuildMerkleNode(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.SetRoot(clientStorage.Sha(node))
+	return store
+}
@@ -0,0 +1,62 @@
+package capture
+
+import (
+	"errors"
+	"myp2p/client"
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+//
+// ======================= TRANSPORT CAPTURÉ =======================
+//
+
+// Transport enregistre tout le trafic d’un client.Transport dans une capture.
+type Transport struct {
+	client.Transport
+	w      *Writer
+	failed atomic.Bool // une erreur d’écriture a déjà été signalée
+}
+
+var _ client.Transport = (*Transport)(nil)
+
+// Wrap renvoie un transport qui enregistre chaque paquet lu ou écrit sur t ;
+// la capture est fermée avec le transport.
+// Paramètres :
+//   - t : transport à observer (UDP ou simnet)
+//   - w : capture de destination
+func Wrap(t client.Transport, w *Writer) *Transport {
+	return &Transport{Transport: t, w: w}
+}
+
+// ReadFromUDP lit un paquet et l’enregistre comme reçu.
+func (t *Transport) ReadFromUDP(b []byte) (int, *net.UDPAddr, error) {
+	size, addr, err := t.Transport.ReadFromUDP(b)
+	if err == nil {
+		t.record(In, addr, b[:size])
+	}
+	return size, addr, err
+}
+
+// WriteToUDP envoie un paquet et l’enregistre comme émis.
+func (t *Transport) WriteToUDP(b []byte, addr *net.UDPAddr) (int, error) {
+	size, err := t.Transport.WriteToUDP(b, addr)
+	if err == nil {
+		t.record(Out, addr, b)
+	}
+	return size, err
+}
+
+// Close ferme le transport puis la capture.
+func (t *Transport) Close() error {
+	return errors.Join(t.Transport.Close(), t.w.Close())
+}
+
+func (t *Transport) record(dir Direction, remote *net.UDPAddr, data []byte) {
+	local, _ := t.LocalAddr().(*net.UDPAddr)
+	rec := Record{Time: time.Now(), Dir: dir, Local: local, Remote: remote, Data: data}
+	if err := t.w.Write(rec); err != nil && !t.failed.Swap(true) {
+		captureLog.Warn("écriture de la capture impossible (erreurs suivantes non signalées)", "err", err)
+	}
+}
@@ -0,0 +1,320 @@
+package client
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"myp2p/clientStorage"
+	"net"
+	"os"
+	"sort"
+	"strings"
+)
+
+//
+// ======================= LISTES DE CONTRÔLE D’ACCÈS =======================
+//
+
+// Un fichier ACL décide, pour chaque peer, quels sous-arbres de nos données
+// il peut voir. Les sujets d’une règle sont :
+//   - un nom de peer               : "alice"
+//   - une empreinte de clé         : "key:<sha256 hex de la clé publique>"
+//   - un groupe déclaré dans Groups : "group:amis"
+//
+// Tous les peers qui partagent la même liste de chemins forment une "audience",
+// pour laquelle on construit une racine Merkle filtrée.
+//
+// Exemple :
+//
+//	{
+//	  "default": [],
+//	  "groups": { "amis": ["alice", "key:3f2a…"] },
+//	  "grants": { "group:amis": ["photos", "rapport"], "bob": ["/"] }
+//	}
+
+// Fichier de configuration des ACL par défaut
+const ACLFile = "acl.json"
+
+// ACL décrit les droits de lecture sur nos données.
+type ACL struct {
+	Default []string            `json:"default"` // chemins visibles des peers sans règle
+	Groups  map[string][]string `json:"groups"`  // groupe → membres (nom ou "key:…")
+	Grants  map[string][]string `json:"grants"`  // sujet → chemins visibles
+}
+
+// audience : arbre filtré partagé par tous les peers ayant les mêmes droits
+type audience struct {
+	source    []byte          // racine complète à partir de laquelle l’arbre a été construit
+	root      []byte          // racine filtrée
+	created   []string        // nœuds créés par le filtrage (à libérer)
+	reachable map[string]bool // nœuds que l’audience a le droit de demander
+	all       bool            // pas d’ACL (simple conversion de format) : tout nœud présent est servi
+}
+
+// -----------------------------------------------------------------------------------------
+// LoadACL charge les ACL depuis un fichier JSON.
+// Si le fichier n’existe pas, les ACL sont désactivées et tout est partagé.
+func (n *Node) LoadACL(path string) error {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		n.SetACL(nil)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var a ACL
+	if err := json.Unmarshal(data, &a); err != nil {
+		return fmt.Errorf("%s : ACL invalide : %w", path, err)
+	}
+	n.SetACL(&a)
+	aclLog.Debug(fmt.Sprintf("ACL chargées depuis %s : %d règle(s), %d groupe(s)", path, len(a.Grants), len(a.Groups)))
+	return nil
+}
+
+// SaveACL enregistre les ACL courantes dans un fichier JSON.
+func (n *Node) SaveACL(path string) error {
+	n.aclMu.RLock()
+	if n.acl == nil {
+		n.aclMu.RUnlock()
+		return errors.New("aucune ACL à enregistrer")
+	}
+	data, err := json.MarshalIndent(n.acl, "", "  ")
+	n.aclMu.RUnlock()
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+// SetACL remplace les ACL courantes (nil pour tout partager) et invalide les audiences.
+func (n *Node) SetACL(a *ACL) {
+	n.aclMu.Lock()
+	n.acl = a
+	if n.acl != nil {
+		if n.acl.Groups == nil {
+			n.acl.Groups = map[string][]string{}
+		}
+		if n.acl.Grants == nil {
+			n.acl.Grants = map[string][]string{}
+		}
+	}
+	n.resetAudiencesLocked()
+	n.aclMu.Unlock()
+}
+
+// GetACL renvoie une copie des ACL courantes (nil si désactivées).
+func (n *Node) GetACL() *ACL {
+	n.aclMu.RLock()
+	defer n.aclMu.RUnlock()
+	if n.acl == nil {
+		return nil
+	}
+	data, _ := json.Marshal(n.acl)
+	var cp ACL
+	json.Unmarshal(data, &cp)
+	return &cp
+}
+
+// SetGrant définit les chemins visibles par un sujet (nil ou vide = supprime la règle).
+// Active les ACL si elles ne l’étaient pas (Default vaut alors "tout").
+func (n *Node) SetGrant(subject string, paths []string) {
+	n.aclMu.Lock()
+	if n.acl == nil {
+		n.acl = &ACL{Default: []string{"/"}, Groups: map[string][]string{}, Grants: map[string][]string{}}
+	}
+	if len(paths) == 0 {
+		delete(n.acl.Grants, subject)
+	} else {
+		n.acl.Grants[subject] = paths
+	}
+	n.resetAudiencesLocked()
+	n.aclMu.Unlock()
+}
+
+// SetGroup définit les membres d’un groupe (vide = supprime le groupe).
+func (n *Node) SetGroup(group string, members []string) {
+	n.aclMu.Lock()
+	if n.acl == nil {
+		n.acl = &ACL{Default: []string{"/"}, Groups: map[string][]string{}, Grants: map[string][]string{}}
+	}
+	if len(members) == 0 {
+		delete(n.acl.Groups, group)
+	} else {
+		n.acl.Groups[group] = members
+	}
+	n.resetAudiencesLocked()
+	n.aclMu.Unlock()
+}
+
+// resetAudiencesLocked libère tous les arbres filtrés (aclMu doit être verrouillé).
+func (n *Node) resetAudiencesLocked() {
+	for key, a := range n.audiences {
+		n.store.ReleaseNodes(a.created)
+		delete(n.audiences, key)
+	}
+}
+
+// -----------------------------------------------------------------------------------------
+// grantsFor calcule la liste triée des chemins visibles pour un peer.
+// Retour :
+//   - la liste des chemins (nil si aucun)
+//   - false si les ACL sont désactivées
+func (n *Node) grantsFor(name string, pub *ecdsa.PublicKey) ([]string, bool) {
+	n.aclMu.RLock()
+	defer n.aclMu.RUnlock()
+	if n.acl == nil {
+		return nil, false
+	}
+
+	ids := []string{name}
+	if fp := KeyFingerprint(pub); fp != "" {
+		ids = append(ids, "key:"+fp)
+	}
+	subjects := append([]string{}, ids...)
+	for group, members := range n.acl.Groups {
+		for _, m := range members {
+			if containsString(ids, m) {
+				subjects = append(subjects, "group:"+group)
+				break
+			}
+		}
+	}
+
+	set := map[string]struct{}{}
+	matched := false
+	for _, s := range subjects {
+		if paths, ok := n.acl.Grants[s]; ok {
+			matched = true
+			for _, p := range paths {
+				set[p] = struct{}{}
+			}
+		}
+	}
+	if !matched {
+		for _, p := range n.acl.Default {
+			set[p] = struct{}{}
+		}
+	}
+
+	paths := make([]string, 0, len(set))
+	for p := range set {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	return paths, true
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// -----------------------------------------------------------------------------------------
+// audienceFor renvoie l’arbre filtré correspondant aux droits d’un peer,
+// en le (re)construisant si notre racine a changé depuis. L’arbre est de plus
+// converti dans le format que le peer sait décoder (cf. treeFormat).
+// Retour :
+//   - l’audience (nil si les ACL sont désactivées et l’arbre complet convient)
+func (n *Node) audienceFor(peer *Peer) *audience {
+	var name string
+	var pub *ecdsa.PublicKey
+	if peer != nil {
+		peer.Mupeer.RLock()
+		name, pub = peer.Name, peer.PublicKey
+		peer.Mupeer.RUnlock()
+	}
+	format := treeFormat(peer)
+	source := n.store.Root()
+	paths, enabled := n.grantsFor(name, pub)
+	if !enabled {
+		if format == clientStorage.FormatFull || source == nil {
+			return nil
+		}
+		paths = []string{"/"}
+	}
+	key := fmt.Sprintf("%d\x01", format) + strings.Join(paths, "\x00")
+
+	n.aclMu.Lock()
+	defer n.aclMu.Unlock()
+	if a, ok := n.audiences[key]; ok && bytes.Equal(a.source, source) {
+		return a
+	}
+	if old, ok := n.audiences[key]; ok {
+		n.store.ReleaseNodes(old.created)
+		delete(n.audiences, key)
+	}
+
+	root, created, err := n.store.FilterTree(source, paths)
+	if err == nil {
+		var converted []string
+		root, converted, err = n.store.DowngradeTree(root, format)
+		if err != nil {
+			n.store.ReleaseNodes(created)
+		}
+		created = append(created, converted...)
+	}
+	if err != nil {
+		aclLog.Warn("Erreur filtrage ACL", "err", err)
+		if !enabled {
+			return nil
+		}
+		return &audience{source: source, reachable: map[string]bool{}}
+	}
+	a := &audience{source: source, root: root, created: created, all: !enabled}
+	if enabled {
+		a.reachable = n.store.ReachableHashes(root)
+	}
+	n.audiences[key] = a
+	aclLog.Debug(fmt.Sprintf("ACL : audience %q (format %d) → racine %s (%d nœuds)", paths, format, hex.EncodeToString(root), len(a.reachable)))
+	return a
+}
+
+// treeFormat renvoie les types de nœuds optionnels que le peer sait décoder,
+// d’après les extensions négociées (aucun pour un peer inconnu).
+func treeFormat(peer *Peer) clientStorage.TreeFormat {
+	format := clientStorage.FormatLegacy
+	if peer == nil {
+		return format
+	}
+	if peer.Has(ExtensionLongNames) {
+		format |= clientStorage.FormatLongNames
+	}
+	if peer.Has(ExtensionMetadata) {
+		format |= clientStorage.FormatMetadata
+	}
+	if peer.Has(ExtensionSymlinks) {
+		format |= clientStorage.FormatSymlinks
+	}
+	return format
+}
+
+// -----------------------------------------------------------------------------------------
+// RootForAddr renvoie la racine Merkle à annoncer au peer situé à addr.
+// Sans ACL, c’est notre racine complète (convertie au format du peer).
+func (n *Node) RootForAddr(addr *net.UDPAddr) []byte {
+	peer, _ := n.FindPeerByAddr(addr)
+	a := n.audienceFor(peer)
+	if a == nil {
+		return n.store.Root()
+	}
+	return a.root
+}
+
+// CanServeHash indique si le peer situé à addr a le droit d’obtenir le nœud hash.
+// Sans ACL, tout nœud présent est servi (comportement historique).
+func (n *Node) CanServeHash(addr *net.UDPAddr, hash []byte) bool {
+	peer, _ := n.FindPeerByAddr(addr)
+	a := n.audienceFor(peer)
+	if a == nil || a.all {
+		return true
+	}
+	return a.reachable[hex.EncodeToString(hash)]
+}
@@ -0,0 +1,387 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+//
+// ======================= BAN / DÉBAN =======================
+//
+
+// Les bans sont persistés dans BanFile avec leur raison, leur date d’expiration
+// et l’empreinte de la clé du peer (un peer qui change de nom mais garde sa clé
+// reste banni). Un peer qui se comporte mal (signatures invalides, Datums
+// corrompus, paquets malformés, DatumRequest répétées pour un même hash)
+// accumule un score ; au-delà de MisbehaviourThreshold il est banni
+// temporairement. Le volume de DatumRequest n’est pas un mauvais comportement :
+// au-delà de DatumQuota, le peer reçoit seulement quota-exceeded.
+
+// Fichier de persistance des bans
+var BanFile = "bans.json"
+
+// BanEntry décrit un ban.
+type BanEntry struct {
+	Name        string    `json:"name"`
+	Fingerprint string    `json:"fingerprint,omitempty"` // empreinte de la clé publique (cf. KeyFingerprint)
+	Reason      string    `json:"reason"`
+	Since       time.Time `json:"since"`
+	Expires     time.Time `json:"expires,omitempty"` // zéro = permanent
+	Auto        bool      `json:"auto"`              // ban automatique (mauvais comportement)
+}
+
+// Expired indique si le ban est arrivé à échéance.
+func (b *BanEntry) Expired(now time.Time) bool {
+	return !b.Expires.IsZero() && now.After(b.Expires)
+}
+
+// -----------------------------------------------------------------------------------------
+// LoadBans charge la liste des bans depuis un fichier JSON (absent = liste vide).
+// Les bans expirés sont ignorés.
+func (n *Node) LoadBans(path string) error {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var entries []*BanEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return fmt.Errorf("%s : liste de bans invalide : %w", path, err)
+	}
+
+	now := time.Now()
+	n.banMu.Lock()
+	n.bans = map[string]*BanEntry{}
+	for _, b := range entries {
+		if b.Name == "" || b.Expired(now) {
+			continue
+		}
+		n.bans[b.Name] = b
+	}
+	count := len(n.bans)
+	n.banMu.Unlock()
+	banLog.Debug(fmt.Sprintf("%d ban(s) chargé(s) depuis %s", count, path))
+	return nil
+}
+
+// saveBans enregistre la liste des bans (fichier temporaire puis renommage).
+// Les sauvegardes sont sérialisées et la liste est relue sous le même verrou :
+// la dernière renommée est toujours la plus récente.
+func (n *Node) saveBans() {
+	n.banSave.Lock()
+	defer n.banSave.Unlock()
+	data, err := json.MarshalIndent(n.ListBans(), "", "  ")
+	if err != nil {
+		banLog.Warn("Erreur sérialisation des bans", "err", err)
+		return
+	}
+	tmp := n.BanFile + ".tmp"
+	if err := os.MkdirAll(filepath.Dir(n.BanFile), 0755); err != nil {
+		banLog.Warn("Erreur sauvegarde des bans", "err", err)
+		return
+	}
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		banLog.Warn("Erreur sauvegarde des bans", "err", err)
+		return
+	}
+	if err := os.Rename(tmp, n.BanFile); err != nil {
+		banLog.Warn("Erreur sauvegarde des bans", "err", err)
+	}
+}
+
+// -----------------------------------------------------------------------------------------
+// AddBan bannit un peer.
+// Paramètres :
+//   - peer     : peer à bannir
+//   - reason   : raison du ban (affichée dans la liste)
+//   - duration : durée du ban (0 = permanent)
+func (n *Node) AddBan(peer *Peer, reason string, duration time.Duration) {
+	peer.Mupeer.RLock()
+	name, pub := peer.Name, peer.PublicKey
+	peer.Mupeer.RUnlock()
+	n.addBanEntry(name, KeyFingerprint(pub), reason, duration, false)
+}
+
+// BanName bannit un peer par son nom (même s’il n’est pas connu actuellement).
+func (n *Node) BanName(name string, reason string, duration time.Duration) {
+	fp := ""
+	if p, ok := n.FindPeer(name); ok {
+		p.Mupeer.RLock()
+		fp = KeyFingerprint(p.PublicKey)
+		p.Mupeer.RUnlock()
+	}
+	n.addBanEntry(name, fp, reason, duration, false)
+}
+
+func (n *Node) addBanEntry(name, fingerprint, reason string, duration time.Duration, auto bool) {
+	now := time.Now()
+	b := &BanEntry{
+		Name:        name,
+		Fingerprint: fingerprint,
+		Reason:      reason,
+		Since:       now,
+		Auto:        auto,
+	}
+	if duration > 0 {
+		b.Expires = now.Add(duration)
+	}
+	n.banMu.Lock()
+	// un ban permanent n’est jamais remplacé par un ban automatique temporaire
+	if old, ok := n.bans[name]; ok && auto && old.Expires.IsZero() {
+		n.banMu.Unlock()
+		return
+	}
+	n.bans[name] = b
+	n.banMu.Unlock()
+	n.saveBans()
+
+	banLog.Debug(fmt.Sprintf("Peer banni : %s (%s)", name, reason))
+}
+
+// DelBan lève le ban d’un peer.
+func (n *Node) DelBan(name string) {
+	n.banMu.Lock()
+	_, exists := n.bans[name]
+	delete(n.bans, name)
+	n.banMu.Unlock()
+	n.resetMisbehaviour(name)
+	if exists {
+		n.saveBans()
+	}
+}
+
+// ListBans renvoie la liste des bans actifs, triée par nom.
+func (n *Node) ListBans() []BanEntry {
+	now := time.Now()
+	n.banMu.RLock()
+	list := make([]BanEntry, 0, len(n.bans))
+	for _, b := range n.bans {
+		if !b.Expired(now) {
+			list = append(list, *b)
+		}
+	}
+	n.banMu.RUnlock()
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+// lookupBan cherche un ban actif par nom ou par empreinte de clé.
+// Les bans expirés rencontrés sont supprimés.
+func (n *Node) lookupBan(name, fingerprint string) (*BanEntry, bool) {
+	now := time.Now()
+	n.banMu.Lock()
+	defer n.banMu.Unlock()
+
+	if b, ok := n.bans[name]; ok {
+		if !b.Expired(now) {
+			return b, true
+		}
+		delete(n.bans, name)
+	}
+	if fingerprint == "" {
+		return nil, false
+	}
+	for key, b := range n.bans {
+		if b.Fingerprint != fingerprint {
+			continue
+		}
+		if b.Expired(now) {
+			delete(n.bans, key)
+			continue
+		}
+		return b, true
+	}
+	return nil, false
+}
+
+func (n *Node) IsBan(name string) bool {
+	fp := ""
+	if p, ok := n.FindPeer(name); ok {
+		p.Mupeer.RLock()
+		fp = KeyFingerprint(p.PublicKey)
+		p.Mupeer.RUnlock()
+	}
+	_, banned := n.lookupBan(name, fp)
+	return banned
+}
+
+func (n *Node) IsBanByaddr(addr *net.UDPAddr) bool {
+	peer, found := n.FindPeerByAddr(addr)
+	if !found {
+		return false
+	}
+	peer.Mupeer.RLock()
+	name, fp := peer.Name, KeyFingerprint(peer.PublicKey)
+	peer.Mupeer.RUnlock()
+	_, banned := n.lookupBan(name, fp)
+	return banned
+}
+
+//
+// ======================= BANS AUTOMATIQUES =======================
+//
+
+// Misbehaviour identifie un type de mauvais comportement.
+type Misbehaviour int
+
+const (
+	MisBadSignature Misbehaviour = iota // signature invalide
+	MisBadDatum                         // Datum qui échoue à VerifyDataIntegrity
+	MisMalformed                        // paquet malformé
+	MisDatumFlood                       // DatumRequest répétées pour un même hash
+	MisUnsafeTree                       // arbre refusé par la reconstruction (cf. RebuildFromPeer)
+)
+
+func (m Misbehaviour) String() string {
+	switch m {
+	case MisBadSignature:
+		return "signatures invalides"
+	case MisBadDatum:
+		return "Datums corrompus"
+	case MisMalformed:
+		return "paquets malformés"
+	case MisDatumFlood:
+		return "DatumRequest répétées"
+	case MisUnsafeTree:
+		return "arbres dangereux"
+	}
+	return "comportement inconnu"
+}
+
+// Poids de chaque mauvais comportement dans le score
+var misbehaviourWeight = map[Misbehaviour]int{
+	MisBadSignature: 20,
+	MisBadDatum:     20,
+	MisMalformed:    5,
+	MisDatumFlood:   25,
+	MisUnsafeTree:   50,
+}
+
+var (
+	MisbehaviourThreshold = 100              // score déclenchant un ban automatique
+	MisbehaviourWindow    = 1 * time.Minute  // fenêtre de calcul du score
+	AutoBanDuration       = 10 * time.Minute // durée d’un ban automatique
+	DatumQuota            = 50000            // hashes servis par fenêtre de DatumFloodWindow
+	DatumRepeatLimit      = 16               // demandes d’un même hash tolérées par fenêtre de DatumFloodWindow
+	DatumFloodWindow      = 10 * time.Second
+)
+
+// compteurs de mauvais comportement par peer
+type misbehaviourScore struct {
+	windowStart time.Time
+	score       int
+
+	datumStart time.Time      // fenêtre de comptage des DatumRequest
+	datumCount int            // unités de quota consommées
+	datumSeen  map[string]int // demandes par hash
+}
+
+func (n *Node) resetMisbehaviour(name string) {
+	n.misMu.Lock()
+	delete(n.misScore, name)
+	n.misMu.Unlock()
+}
+
+// -----------------------------------------------------------------------------------------
+// ReportMisbehaviour signale un mauvais comportement du peer situé à addr.
+// Si le score du peer sur la fenêtre courante dépasse MisbehaviourThreshold,
+// il est banni pour AutoBanDuration. Les adresses inconnues sont ignorées.
+func (n *Node) ReportMisbehaviour(addr *net.UDPAddr, kind Misbehaviour) {
+	peer, ok := n.FindPeerByAddr(addr)
+	if !ok {
+		return
+	}
+	n.reportPeerMisbehaviour(peer, kind)
+}
+
+func (n *Node) reportPeerMisbehaviour(peer *Peer, kind Misbehaviour) {
+	peer.Mupeer.RLock()
+	name, fp := peer.Name, KeyFingerprint(peer.PublicKey)
+	peer.Mupeer.RUnlock()
+	if name == NameofServeurUDP {
+		return
+	}
+	now := time.Now()
+
+	n.misMu.Lock()
+	s, ok := n.misScore[name]
+	if !ok {
+		s = &misbehaviourScore{windowStart: now}
+		n.misScore[name] = s
+	}
+	if now.Sub(s.windowStart) > MisbehaviourWindow {
+		s.windowStart = now
+		s.score = 0
+	}
+	s.score += misbehaviourWeight[kind]
+	exceeded := s.score >= MisbehaviourThreshold
+	if exceeded {
+		s.score = 0
+	}
+	n.misMu.Unlock()
+
+	banLog.Debug(fmt.Sprintf("Mauvais comportement de %s : %s", name, kind))
+	if !exceeded {
+		return
+	}
+
+	n.addBanEntry(name, fp, "automatique : "+kind.String(), AutoBanDuration, true)
+	n.EmitPeerEvent(peer, EventBanned, kind.String())
+}
+
+// noteDatumRequest compte count unités de quota consommées par le peer situé à
+// addr (1 par DatumRequest, une par hash d'un BatchDatumRequest, 1 par
+// SubtreeRequest) et les demandes de chacun des hashes. Dépasser DatumQuota
+// n’est pas un mauvais comportement (un téléchargement honnête avec une grande
+// fenêtre peut l’atteindre) ; demander plus de DatumRepeatLimit fois le même
+// hash par DatumFloodWindow en est un, signalé une fois par hash et par
+// fenêtre (les renvois d’une transaction restent bien en dessous).
+// Retour : false si le peer a atteint son quota (la requête ne doit pas être servie)
+func (n *Node) noteDatumRequest(addr *net.UDPAddr, count int, hashes [][]byte) bool {
+	peer, ok := n.FindPeerByAddr(addr)
+	if !ok {
+		return true
+	}
+	peer.Mupeer.RLock()
+	name := peer.Name
+	peer.Mupeer.RUnlock()
+	now := time.Now()
+
+	n.misMu.Lock()
+	s, ok := n.misScore[name]
+	if !ok {
+		s = &misbehaviourScore{windowStart: now}
+		n.misScore[name] = s
+	}
+	if now.Sub(s.datumStart) > DatumFloodWindow {
+		s.datumStart = now
+		s.datumCount = 0
+		s.datumSeen = nil
+	}
+	s.datumCount += count
+	within := s.datumCount <= DatumQuota
+	if s.datumSeen == nil {
+		s.datumSeen = map[string]int{}
+	}
+	repeated := 0
+	for _, h := range hashes {
+		s.datumSeen[string(h)]++
+		if s.datumSeen[string(h)] == DatumRepeatLimit+1 {
+			repeated++
+		}
+	}
+	n.misMu.Unlock()
+
+	for range repeated {
+		n.reportPeerMisbehaviour(peer, MisDatumFlood)
+	}
+	return within
+}
@@ -0,0 +1,35 @@
+package client_test
+
+import (
+	"fmt"
+	"myp2p/client"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+// TestConcurrentBansSaved : des bans posés en même temps par plusieurs
+// workers sont tous dans BanFile une fois les sauvegardes terminées.
+func TestConcurrentBansSaved(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bans.json")
+	n := client.NewNode(client.NodeConfig{Name: "alice", BanFile: path})
+
+	const count = 32
+	var wg sync.WaitGroup
+	for i := range count {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			n.BanName(fmt.Sprintf("peer%02d", i), "test", 0)
+		}()
+	}
+	wg.Wait()
+
+	reloaded := client.NewNode(client.NodeConfig{Name: "bob", BanFile: filepath.Join(t.TempDir(), "autre.json")})
+	if err := reloaded.LoadBans(path); err != nil {
+		t.Fatal(err)
+	}
+	if got := len(reloaded.ListBans()); got != count {
+		t.Fatalf("%d bans relus, attendu %d", got, count)
+	}
+}
@@ -0,0 +1,178 @@
+package client
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"myp2p/clientStorage"
+	"net"
+	"time"
+)
+
+//-----------------------------------------------------------------------------------------
+// Ce fichier regroupe les requêtes groupées, négociées par l’extension
+// ExtensionBatching. Un BatchDatumRequest porte plusieurs hashes mis bout à
+// bout ; le peer répond par un Datum ou un NoDatum par hash, tous avec l’id de
+// la requête. Côté demandeur, une seule transaction suit la requête : elle
+// garde les hashes encore sans réponse (Transaction.Pending), n’est résolue
+// qu’une fois tous reçus et, en cas de renvoi, ne redemande que ceux-là.
+//
+// La fenêtre glissante du peer compte les hashes en vol, pas les requêtes :
+// une requête groupée occupe autant de places que de hashes.
+
+// BatchDatagramSize : taille maximale d’un paquet BatchDatumRequest, choisie
+// sous la MTU courante pour que la requête tienne dans un seul datagramme
+const BatchDatagramSize = 1200
+
+// MaxBatchHashes : nombre maximal de hashes d’un BatchDatumRequest
+const MaxBatchHashes = (BatchDatagramSize - HeaderSize) / clientStorage.HashSize
+
+// BatchingEnabled : annonce de l'extension ExtensionBatching
+var BatchingEnabled = true
+
+//
+// ======================= ENVOI =======================
+//
+
+// -----------------------------------------------------------------------------------------
+// sendBatch envoie à peer un BatchDatumRequest qui regroupe job et les autres
+// hashes en attente pour ce peer, dans la limite de MaxBatchHashes et des
+// places libres de sa fenêtre (celle de job est déjà disponible).
+// Paramètres :
+//   - conn : connexion UDP
+//   - peer : peer interrogé
+//   - job  : premier hash de la requête
+//   - held : jobs mis de côté lors des regroupements précédents
+//
+// Retour :
+//   - jobs toujours en attente (ceux d’autres peers, ou au-delà de la requête)
+func (n *Node) sendBatch(conn Transport, peer *Peer, job DatumJob, held []DatumJob) []DatumJob {
+	peer.Window.OnSend()
+	hashes, held := n.gatherJobs(job, held, MaxBatchHashes, func() bool {
+		if !peer.Window.CanSend() {
+			return false
+		}
+		peer.Window.OnSend()
+		return true
+	})
+
+	id := n.GenerateId()
+	msg, err := BuildBatchDatumRequest(id, hashes)
+	if err != nil {
+		datumLog.Warn("Erreur BuildBatchDatumRequest", "err", err)
+		peer.Window.OnTimeoutN(len(hashes))
+		return held
+	}
+
+	tx := n.CreateTransaction(id, peer, job.Addr, BatchDatumRequest, msg, Retries+2)
+	n.txMu.Lock()
+	tx.Pending = hashes
+	n.txMu.Unlock()
+
+	datumLog.Debug("requête groupée", "peer", peer.Name, "hashes", len(hashes))
+	SendMessage(conn, job.Addr, msg)
+	return held
+}
+
+//
+// ======================= RÉPONSE =======================
+//
+
+// HandleBatchDatumRequestWrapper : BatchDatumRequest reçu, servi seulement si
+// l’extension a été négociée avec le peer (sinon le type est inconnu, comme
+// pour un peer qui ne l’implémente pas). Chaque hash compte dans le quota de
+// DatumRequest.
+func (n *Node) HandleBatchDatumRequestWrapper(conn Transport, priv *ecdsa.PrivateKey, id uint32, addr *net.UDPAddr, hashes [][]byte) {
+	transportLog.Debug("BatchDatumRequest reçu", "hashes", len(hashes))
+	peer, ok := n.FindPeerByAddr(addr)
+	if !ok || !peer.Has(ExtensionBatching) {
+		SendErrorCode(conn, id, priv, addr, ErrCodeUnknownType, "extension "+ExtensionName(ExtensionBatching)+" non négociée")
+		return
+	}
+	if !n.admitDatumRequest(conn, priv, id, addr, len(hashes), hashes) {
+		return
+	}
+	n.HandleBatchDatumRequest(conn, priv, addr, id, hashes)
+}
+
+// HandleBatchDatumRequest répond à chaque hash d’un BatchDatumRequest par un
+// Datum ou un NoDatum (cf. datumReply), tous avec l’id de la requête. Une
+// erreur (peer non associé, erreur interne) interrompt les réponses.
+func (n *Node) HandleBatchDatumRequest(conn Transport, priv *ecdsa.PrivateKey, addr *net.UDPAddr, id uint32, hashes [][]byte) {
+	for _, hash := range hashes {
+		typ, body, code := n.datumReply(addr, hash)
+		if code != ErrCodeNone {
+			SendErrorCode(conn, id, priv, addr, code, "")
+			return
+		}
+		sendGenericMessage(conn, priv, addr, id, typ, body, typ == NoDatum)
+	}
+}
+
+//
+// ======================= SUIVI DES RÉPONSES =======================
+//
+
+// resolveBatchHash retire hash des hashes en attente de la requête groupée id
+// si la réponse vient de son destinataire ; la transaction est terminée
+// quand tous ses hashes ont reçu une réponse.
+// Retour :
+//   - transaction correspondante
+//   - false si hash n’était pas attendu (réponse en double, renvoi déjà servi)
+func (n *Node) resolveBatchHash(id uint32, addr *net.UDPAddr, hash []byte) (*Transaction, bool) {
+	n.txMu.Lock()
+	defer n.txMu.Unlock()
+
+	tx, ok := n.transactions[id]
+	if !ok || tx.MsgType != BatchDatumRequest || tx.State == TxDone || !sameUDPAddr(tx.Addr, addr) {
+		return nil, false
+	}
+	for i, h := range tx.Pending {
+		if bytes.Equal(h, hash) {
+			tx.Pending = append(tx.Pending[:i], tx.Pending[i+1:]...)
+			if len(tx.Pending) == 0 {
+				tx.State = TxDone
+			}
+			return tx, true
+		}
+	}
+	return nil, false
+}
+
+// handleBatchDatum : Datum reçu en réponse à une requête groupée. Le hash
+// n’est connu qu’après déchiffrement ; il désigne le hash de la requête
+// auquel le Datum répond, vérifié ensuite comme pour un DatumRequest.
+func (n *Node) handleBatchDatum(id uint32, addr *net.UDPAddr, datum *DatumMsg) {
+	peer, exist := n.FindPeerByAddr(addr)
+	if !exist {
+		transportLog.Debug("Peer non trouvé pour Datum")
+		return
+	}
+	datum, ok := n.openDatum(peer, datum)
+	if !ok {
+		return
+	}
+	tr, ok := n.resolveBatchHash(id, addr, datum.Hash)
+	if !ok {
+		transportLog.Debug("Datum non attendu dans la requête groupée", "id", id)
+		return
+	}
+	rtt := time.Since(tr.SentAt)
+	peer.Window.OnSuccess(rtt)
+	n.observeRTT(rtt)
+
+	n.acceptDatum(peer, addr, datum, datum.Hash)
+}
+
+// refreshBatch reconstruit le message d’une requête groupée avant un renvoi :
+// seuls les hashes encore sans réponse sont redemandés (à appeler sous txMu).
+func (tx *Transaction) refreshBatch() {
+	if tx.MsgType != BatchDatumRequest || len(tx.Pending) == 0 {
+		return
+	}
+	msg, err := BuildBatchDatumRequest(tx.Id, tx.Pending)
+	if err != nil {
+		txLog.Warn("Erreur BuildBatchDatumRequest", "err", err)
+		return
+	}
+	tx.Msg = msg
+}
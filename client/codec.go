@@ -0,0 +1,608 @@
+package client
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"encoding/binary"
+	"fmt"
+	"myp2p/clientStorage"
+	"net"
+	"strings"
+	"unicode/utf8"
+)
+
+//
+// ======================= CODEC DES MESSAGES =======================
+//
+
+// Chaque type de message du protocole a sa structure, avec un encodage
+// (MarshalBody) et un décodage strict (UnmarshalBody) : longueurs exactes,
+// champs obligatoires, valeurs bornées. Les handlers ne manipulent plus
+// d’octets bruts venant du réseau.
+
+// Message : body typé d’un paquet
+type Message interface {
+	MsgType() uint8
+	MarshalBody() ([]byte, error)
+	UnmarshalBody(body []byte) error
+}
+
+// Taille maximale d’un nom de peer annoncé dans un Hello
+const MaxPeerNameSize = 255
+
+// Taille d’une clé publique sérialisée (X || Y, cf. SerializePublicKey)
+const PublicKeySize = 64
+
+// newMessage renvoie un message vide du type donné (nil si le type est inconnu).
+func newMessage(t uint8) Message {
+	switch t {
+	case Ping:
+		return &PingMsg{}
+	case Hello:
+		return &HelloMsg{}
+	case RootRequest:
+		return &RootRequestMsg{}
+	case DatumRequest:
+		return &DatumRequestMsg{}
+	case BatchDatumRequest:
+		return &BatchDatumRequestMsg{}
+	case SubtreeRequest:
+		return &SubtreeRequestMsg{}
+	case NatTraversalRequest:
+		return &NatTraversalMsg{}
+	case NatTraversalRequest2:
+		return &NatTraversalMsg{Second: true}
+	case Ok:
+		return &OkMsg{}
+	case Error:
+		return &ErrorMsg{}
+	case HelloReply:
+		return &HelloMsg{Reply: true}
+	case RootReply:
+		return &RootReplyMsg{}
+	case Datum:
+		return &DatumMsg{}
+	case NoDatum:
+		return &NoDatumMsg{}
+	case SubtreeEnd:
+		return &SubtreeEndMsg{}
+	}
+	return nil
+}
+
+// ------------------------------------------------------------------------------------
+// EncodeMessage construit un paquet complet à partir d’un message typé.
+// Paramètres :
+//   - id   : identifiant de la transaction
+//   - m    : message
+//   - priv : clé privée (utilisée si sign)
+//   - sign : ajoute la signature du paquet
+func EncodeMessage(id uint32, m Message, priv *ecdsa.PrivateKey, sign bool) ([]byte, error) {
+	body, err := m.MarshalBody()
+	if err != nil {
+		return nil, err
+	}
+	return BuildMessage(id, m.MsgType(), body, priv, sign)
+}
+
+// malformed construit une erreur ErrMalformed pour un type de message.
+func malformed(t uint8, format string, args ...any) error {
+	return fmt.Errorf("%w : %s : %s", ErrMalformed, MsgTypeName(t), fmt.Sprintf(format, args...))
+}
+
+//
+// ======================= MESSAGES SANS BODY =======================
+//
+
+// PingMsg : Ping (body vide)
+type PingMsg struct{}
+
+func (*PingMsg) MsgType() uint8                  { return Ping }
+func (*PingMsg) MarshalBody() ([]byte, error)    { return nil, nil }
+func (*PingMsg) UnmarshalBody(body []byte) error { return expectEmpty(Ping, body) }
+
+// OkMsg : Ok (body vide)
+type OkMsg struct{}
+
+func (*OkMsg) MsgType() uint8                  { return Ok }
+func (*OkMsg) MarshalBody() ([]byte, error)    { return nil, nil }
+func (*OkMsg) UnmarshalBody(body []byte) error { return expectEmpty(Ok, body) }
+
+// RootRequestMsg : RootRequest (body vide)
+type RootRequestMsg struct{}
+
+func (*RootRequestMsg) MsgType() uint8                  { return RootRequest }
+func (*RootRequestMsg) MarshalBody() ([]byte, error)    { return nil, nil }
+func (*RootRequestMsg) UnmarshalBody(body []byte) error { return expectEmpty(RootRequest, body) }
+
+func expectEmpty(t uint8, body []byte) error {
+	if len(body) != 0 {
+		return malformed(t, "body de %d octets, vide attendu", len(body))
+	}
+	return nil
+}
+
+//
+// ======================= MESSAGES À HASH =======================
+//
+
+// DatumRequestMsg : demande du nœud Merkle de hash Hash
+type DatumRequestMsg struct{ Hash []byte }
+
+func (*DatumRequestMsg) MsgType() uint8 { return DatumRequest }
+func (m *DatumRequestMsg) MarshalBody() ([]byte, error) {
+	return marshalHash(DatumRequest, m.Hash)
+}
+func (m *DatumRequestMsg) UnmarshalBody(body []byte) (err error) {
+	m.Hash, err = unmarshalHash(DatumRequest, body)
+	return err
+}
+
+// BatchDatumRequestMsg : demande groupée des nœuds de hashes Hashes
+// (1 à MaxBatchHashes hashes mis bout à bout, cf. batch.go)
+type BatchDatumRequestMsg struct{ Hashes [][]byte }
+
+func (*BatchDatumRequestMsg) MsgType() uint8 { return BatchDatumRequest }
+func (m *BatchDatumRequestMsg) MarshalBody() ([]byte, error) {
+	if len(m.Hashes) == 0 || len(m.Hashes) > MaxBatchHashes {
+		return nil, malformed(BatchDatumRequest, "%d hashes, de 1 à %d attendus", len(m.Hashes), MaxBatchHashes)
+	}
+	body := make([]byte, 0, len(m.Hashes)*clientStorage.HashSize)
+	for _, h := range m.Hashes {
+		b, err := marshalHash(BatchDatumRequest, h)
+		if err != nil {
+			return nil, err
+		}
+		body = append(body, b...)
+	}
+	return body, nil
+}
+func (m *BatchDatumRequestMsg) UnmarshalBody(body []byte) error {
+	count := len(body) / clientStorage.HashSize
+	if len(body)%clientStorage.HashSize != 0 || count == 0 || count > MaxBatchHashes {
+		return malformed(BatchDatumRequest, "body de %d octets, de 1 à %d hashes attendus", len(body), MaxBatchHashes)
+	}
+	m.Hashes = make([][]byte, count)
+	for i := range m.Hashes {
+		m.Hashes[i] = body[i*clientStorage.HashSize : (i+1)*clientStorage.HashSize]
+	}
+	return nil
+}
+
+// RootReplyMsg : racine Merkle annoncée par un peer
+type RootReplyMsg struct{ Hash []byte }
+
+func (*RootReplyMsg) MsgType() uint8 { return RootReply }
+func (m *RootReplyMsg) MarshalBody() ([]byte, error) {
+	return marshalHash(RootReply, m.Hash)
+}
+func (m *RootReplyMsg) UnmarshalBody(body []byte) (err error) {
+	m.Hash, err = unmarshalHash(RootReply, body)
+	return err
+}
+
+// NoDatumMsg : le peer n’a pas le nœud de hash Hash
+type NoDatumMsg struct{ Hash []byte }
+
+func (*NoDatumMsg) MsgType() uint8 { return NoDatum }
+func (m *NoDatumMsg) MarshalBody() ([]byte, error) {
+	return marshalHash(NoDatum, m.Hash)
+}
+func (m *NoDatumMsg) UnmarshalBody(body []byte) (err error) {
+	m.Hash, err = unmarshalHash(NoDatum, body)
+	return err
+}
+
+func marshalHash(t uint8, hash []byte) ([]byte, error) {
+	if len(hash) != clientStorage.HashSize {
+		return nil, malformed(t, "hash de %d octets", len(hash))
+	}
+	return append([]byte(nil), hash...), nil
+}
+
+func unmarshalHash(t uint8, body []byte) ([]byte, error) {
+	if len(body) != clientStorage.HashSize {
+		return nil, malformed(t, "body de %d octets, %d attendus", len(body), clientStorage.HashSize)
+	}
+	return body, nil
+}
+
+//
+// ======================= DATUM =======================
+//
+
+// DatumMsg : nœud Merkle Value et son hash Hash. Si le Datum est chiffré
+// (clé partagée avec le peer), le body entier est un chiffré AES-GCM : Hash et
+// Value ne sont significatifs qu’après déchiffrement et nouveau décodage.
+type DatumMsg struct {
+	Hash  []byte
+	Value []byte
+}
+
+func (*DatumMsg) MsgType() uint8 { return Datum }
+
+func (m *DatumMsg) MarshalBody() ([]byte, error) {
+	if len(m.Hash) != clientStorage.HashSize || len(m.Value) == 0 {
+		return nil, malformed(Datum, "hash de %d octets, valeur de %d octets", len(m.Hash), len(m.Value))
+	}
+	return append(append([]byte(nil), m.Hash...), m.Value...), nil
+}
+
+func (m *DatumMsg) UnmarshalBody(body []byte) error {
+	if len(body) <= clientStorage.HashSize {
+		return malformed(Datum, "body de %d octets, hash et valeur attendus", len(body))
+	}
+	m.Hash, m.Value = body[:clientStorage.HashSize], body[clientStorage.HashSize:]
+	return nil
+}
+
+// Node décode la valeur du Datum comme nœud Merkle.
+func (m *DatumMsg) Node() (clientStorage.MerkleNode, error) {
+	return clientStorage.ParseNode(m.Value)
+}
+
+//
+// ======================= SOUS-ARBRES =======================
+//
+
+// Taille des champs d’un SubtreeRequest avant ses hashes : profondeur (1),
+// nœuds (2), octets (4)
+const subtreeHeaderSize = 1 + 2 + 4
+
+// SubtreeRequestMsg : demande des nœuds Hashes et de leurs descendants (cf.
+// subtree.go). MaxDepth et MaxBytes valent 0 pour « sans limite » ; MaxNodes
+// (au moins 1) est le nombre de places que le demandeur réserve dans sa
+// fenêtre. Hashes compte de 1 à MaxSubtreeRoots hashes.
+type SubtreeRequestMsg struct {
+	MaxDepth uint8
+	MaxNodes uint16
+	MaxBytes uint32
+	Hashes   [][]byte
+}
+
+func (*SubtreeRequestMsg) MsgType() uint8 { return SubtreeRequest }
+func (m *SubtreeRequestMsg) MarshalBody() ([]byte, error) {
+	if m.MaxNodes == 0 {
+		return nil, malformed(SubtreeRequest, "aucun nœud demandé")
+	}
+	if len(m.Hashes) == 0 || len(m.Hashes) > MaxSubtreeRoots {
+		return nil, malformed(SubtreeRequest, "%d hashes, de 1 à %d attendus", len(m.Hashes), MaxSubtreeRoots)
+	}
+	body := make([]byte, 0, subtreeHeaderSize+len(m.Hashes)*clientStorage.HashSize)
+	body = append(body, m.MaxDepth)
+	body = binary.BigEndian.AppendUint16(body, m.MaxNodes)
+	body = binary.BigEndian.AppendUint32(body, m.MaxBytes)
+	for _, h := range m.Hashes {
+		b, err := marshalHash(SubtreeRequest, h)
+		if err != nil {
+			return nil, err
+		}
+		body = append(body, b...)
+	}
+	return body, nil
+}
+func (m *SubtreeRequestMsg) UnmarshalBody(body []byte) error {
+	if len(body) < subtreeHeaderSize {
+		return malformed(SubtreeRequest, "body de %d octets, au moins %d attendus", len(body), subtreeHeaderSize)
+	}
+	m.MaxDepth = body[0]
+	m.MaxNodes = binary.BigEndian.Uint16(body[1:])
+	m.MaxBytes = binary.BigEndian.Uint32(body[3:])
+	if m.MaxNodes == 0 {
+		return malformed(SubtreeRequest, "aucun nœud demandé")
+	}
+	hashes := body[subtreeHeaderSize:]
+	count := len(hashes) / clientStorage.HashSize
+	if len(hashes)%clientStorage.HashSize != 0 || count == 0 || count > MaxSubtreeRoots {
+		return malformed(SubtreeRequest, "%d octets de hashes, de 1 à %d hashes attendus", len(hashes), MaxSubtreeRoots)
+	}
+	m.Hashes = make([][]byte, count)
+	for i := range m.Hashes {
+		m.Hashes[i] = hashes[i*clientStorage.HashSize : (i+1)*clientStorage.HashSize]
+	}
+	return nil
+}
+
+// SubtreeEndMsg : fin de la réponse à un SubtreeRequest, Count réponses
+// (Datum ou NoDatum) envoyées avant lui
+type SubtreeEndMsg struct{ Count uint16 }
+
+func (*SubtreeEndMsg) MsgType() uint8 { return SubtreeEnd }
+func (m *SubtreeEndMsg) MarshalBody() ([]byte, error) {
+	return binary.BigEndian.AppendUint16(nil, m.Count), nil
+}
+func (m *SubtreeEndMsg) UnmarshalBody(body []byte) error {
+	if len(body) != 2 {
+		return malformed(SubtreeEnd, "body de %d octets, 2 attendus", len(body))
+	}
+	m.Count = binary.BigEndian.Uint16(body)
+	return nil
+}
+
+//
+// ======================= HELLO / HELLOREPLY =======================
+//
+
+// HelloMsg : Hello ou HelloReply (Reply). Le body contient le champ
+// Extensions (4 octets), le nom du peer, puis, si l’extension ExtensionVersion
+// est annoncée, un octet nul suivi du bloc TLV et enfin, si l’extension de
+// chiffrement est annoncée (sauf par le serveur), la clé publique Diffie-Hellman.
+type HelloMsg struct {
+	Reply      bool
+	Extensions uint32
+	Name       string
+	TLVs       []TLV  // paramètres des extensions (cf. ExtensionVersion), dans l’ordre reçu
+	DHPub      []byte // clé publique Diffie-Hellman (PublicKeySize octets) ou nil
+}
+
+// TLV : paramètre d’extension porté par un Hello (tag 1 octet, longueur 1 octet, valeur).
+// Le tag est le bit de l’extension concernée.
+type TLV struct {
+	Tag   uint8
+	Value []byte
+}
+
+func (m *HelloMsg) MsgType() uint8 {
+	if m.Reply {
+		return HelloReply
+	}
+	return Hello
+}
+
+// Encrypted indique si le peer annonce l’extension de chiffrement.
+func (m *HelloMsg) Encrypted() bool {
+	return m.Extensions&(1<<ExtensionChiffrement) != 0
+}
+
+// TLV renvoie la valeur du paramètre tag, ou nil s’il est absent.
+func (m *HelloMsg) TLV(tag uint8) []byte {
+	for _, t := range m.TLVs {
+		if t.Tag == tag {
+			return t.Value
+		}
+	}
+	return nil
+}
+
+func (m *HelloMsg) MarshalBody() ([]byte, error) {
+	t := m.MsgType()
+	if err := validPeerName(t, []byte(m.Name)); err != nil {
+		return nil, err
+	}
+	if m.DHPub != nil && len(m.DHPub) != PublicKeySize {
+		return nil, malformed(t, "clé DH de %d octets", len(m.DHPub))
+	}
+	if len(m.TLVs) > 0 && m.Extensions&(1<<ExtensionVersion) == 0 {
+		return nil, malformed(t, "bloc TLV sans l’extension %s", ExtensionName(ExtensionVersion))
+	}
+	body := make([]byte, ExtensionField, ExtensionField+len(m.Name)+len(m.DHPub))
+	binary.BigEndian.PutUint32(body, m.Extensions)
+	body = append(body, m.Name...)
+	if len(m.TLVs) > 0 {
+		body = append(body, 0)
+		seen := map[uint8]bool{}
+		for _, tlv := range m.TLVs {
+			if len(tlv.Value) > 255 || seen[tlv.Tag] {
+				return nil, malformed(t, "TLV %d invalide", tlv.Tag)
+			}
+			seen[tlv.Tag] = true
+			body = append(body, tlv.Tag, byte(len(tlv.Value)))
+			body = append(body, tlv.Value...)
+		}
+	}
+	return append(body, m.DHPub...), nil
+}
+
+func (m *HelloMsg) UnmarshalBody(body []byte) error {
+	t := m.MsgType()
+	if len(body) < ExtensionField {
+		return malformed(t, "body de %d octets, champ Extensions absent", len(body))
+	}
+	m.Extensions = binary.BigEndian.Uint32(body[:ExtensionField])
+	rest := body[ExtensionField:]
+
+	// avec l’extension de chiffrement, la clé DH termine le body ; un body
+	// trop court pour un nom et une clé n’en porte pas (le serveur renvoie nos
+	// bits sans clé, et nous-mêmes ne lui en envoyons pas). Le handler décide
+	// d’après les extensions négociées si la clé était exigée.
+	m.DHPub = nil
+	if m.Encrypted() && len(rest) > PublicKeySize {
+		m.DHPub = rest[len(rest)-PublicKeySize:]
+		rest = rest[:len(rest)-PublicKeySize]
+	}
+
+	m.TLVs = nil
+	if i := bytes.IndexByte(rest, 0); i >= 0 {
+		if m.Extensions&(1<<ExtensionVersion) != 0 {
+			// le nom est suivi du bloc TLV
+			tlvs, err := parseTLVs(t, rest[i+1:])
+			if err != nil {
+				return err
+			}
+			m.TLVs = tlvs
+		} else if len(bytes.Trim(rest[i:], "\x00")) != 0 {
+			// des versions précédentes complétaient le nom par des octets nuls
+			return malformed(t, "octet nul dans le nom")
+		}
+		rest = rest[:i]
+	}
+	if err := validPeerName(t, rest); err != nil {
+		return err
+	}
+	m.Name = string(rest)
+	return nil
+}
+
+// parseTLVs découpe un bloc TLV ; chaque tag n’apparaît qu’une fois.
+func parseTLVs(t uint8, b []byte) ([]TLV, error) {
+	var tlvs []TLV
+	seen := map[uint8]bool{}
+	for len(b) > 0 {
+		if len(b) < 2 || len(b) < 2+int(b[1]) {
+			return nil, malformed(t, "TLV tronqué")
+		}
+		if seen[b[0]] {
+			return nil, malformed(t, "TLV %d dupliqué", b[0])
+		}
+		seen[b[0]] = true
+		tlvs = append(tlvs, TLV{Tag: b[0], Value: b[2 : 2+int(b[1])]})
+		b = b[2+int(b[1]):]
+	}
+	return tlvs, nil
+}
+
+// validPeerName vérifie un nom de peer : non vide, UTF-8, borné, sans octet nul.
+func validPeerName(t uint8, name []byte) error {
+	switch {
+	case len(name) == 0:
+		return malformed(t, "nom vide")
+	case len(name) > MaxPeerNameSize:
+		return malformed(t, "nom de %d octets", len(name))
+	case !utf8.Valid(name) || bytes.IndexByte(name, 0) >= 0:
+		return malformed(t, "nom invalide")
+	}
+	return nil
+}
+
+//
+// ======================= NAT TRAVERSAL =======================
+//
+
+// NatTraversalMsg : NatTraversalRequest (ou NatTraversalRequest2 si Second),
+// portant l’adresse IPv4 (6 octets) ou IPv6 (18 octets) d’un peer.
+type NatTraversalMsg struct {
+	Second bool
+	Addr   *net.UDPAddr
+}
+
+func (m *NatTraversalMsg) MsgType() uint8 {
+	if m.Second {
+		return NatTraversalRequest2
+	}
+	return NatTraversalRequest
+}
+
+func (m *NatTraversalMsg) MarshalBody() ([]byte, error) {
+	if m.Addr == nil || m.Addr.Port <= 0 || m.Addr.Port > 0xffff {
+		return nil, malformed(m.MsgType(), "adresse invalide %v", m.Addr)
+	}
+	ip := m.Addr.IP.To4()
+	if ip == nil {
+		ip = m.Addr.IP.To16()
+	}
+	if ip == nil {
+		return nil, malformed(m.MsgType(), "adresse IP invalide %v", m.Addr.IP)
+	}
+	return binary.BigEndian.AppendUint16(append([]byte(nil), ip...), uint16(m.Addr.Port)), nil
+}
+
+func (m *NatTraversalMsg) UnmarshalBody(body []byte) error {
+	var ipLen int
+	switch len(body) {
+	case net.IPv4len + 2:
+		ipLen = net.IPv4len
+	case net.IPv6len + 2:
+		ipLen = net.IPv6len
+	default:
+		return malformed(m.MsgType(), "body de %d octets, 6 ou 18 attendus", len(body))
+	}
+	port := int(binary.BigEndian.Uint16(body[ipLen:]))
+	if port == 0 {
+		return malformed(m.MsgType(), "port nul")
+	}
+	m.Addr = &net.UDPAddr{IP: net.IP(append([]byte(nil), body[:ipLen]...)), Port: port}
+	return nil
+}
+
+//
+// ======================= ERROR =======================
+//
+
+// ErrorCode : cause d’une erreur, lisible par la machine
+type ErrorCode uint8
+
+const (
+	ErrCodeNone               ErrorCode = iota // pas de code (texte libre, autres implémentations)
+	ErrCodeBanned                              // l’émetteur est banni
+	ErrCodeNotAssociated                       // pas de Hello préalable : refaire le Hello
+	ErrCodeRateLimited                         // limite de débit atteinte
+	ErrCodeUnknownType                         // type de message non géré
+	ErrCodeBadSignature                        // signature absente ou invalide
+	ErrCodeQuotaExceeded                       // quota de requêtes dépassé
+	ErrCodeMalformed                           // message malformé
+	ErrCodeShutdown                            // le peer s’arrête
+	ErrCodeInternal                            // erreur interne du peer
+	ErrCodeEncryptionRequired                  // le peer exige le chiffrement
+)
+
+var errorCodeNames = [...]string{
+	ErrCodeNone:               "",
+	ErrCodeBanned:             "banned",
+	ErrCodeNotAssociated:      "not-associated",
+	ErrCodeRateLimited:        "rate-limited",
+	ErrCodeUnknownType:        "unknown-type",
+	ErrCodeBadSignature:       "bad-signature",
+	ErrCodeQuotaExceeded:      "quota-exceeded",
+	ErrCodeMalformed:          "malformed",
+	ErrCodeShutdown:           "shutdown",
+	ErrCodeInternal:           "internal",
+	ErrCodeEncryptionRequired: "encryption-required",
+}
+
+func (c ErrorCode) String() string {
+	if int(c) < len(errorCodeNames) {
+		return errorCodeNames[c]
+	}
+	return "unknown"
+}
+
+// parseErrorCode renvoie le code de nom name (false s’il est inconnu).
+func parseErrorCode(name string) (ErrorCode, bool) {
+	for c, n := range errorCodeNames {
+		if n != "" && n == name {
+			return ErrorCode(c), true
+		}
+	}
+	return ErrCodeNone, false
+}
+
+// ErrorMsg : message d’erreur. Le body reste un texte lisible, préfixé du
+// code entre crochets : "[not-associated] Please Hello First". Un body sans
+// préfixe reconnu (autre implémentation) donne ErrCodeNone et le texte entier.
+type ErrorMsg struct {
+	Code ErrorCode
+	Text string
+}
+
+func (*ErrorMsg) MsgType() uint8 { return Error }
+
+func (m *ErrorMsg) MarshalBody() ([]byte, error) { return []byte(m.String()), nil }
+
+func (m *ErrorMsg) UnmarshalBody(body []byte) error {
+	m.Code, m.Text = ErrCodeNone, string(body)
+	if len(body) == 0 || body[0] != '[' {
+		return nil
+	}
+	end := bytes.IndexByte(body, ']')
+	if end < 0 {
+		return nil
+	}
+	code, ok := parseErrorCode(string(body[1:end]))
+	if !ok {
+		return nil
+	}
+	m.Code = code
+	m.Text = strings.TrimPrefix(string(body[end+1:]), " ")
+	return nil
+}
+
+// String renvoie le texte du body ("[code] texte").
+func (m *ErrorMsg) String() string {
+	if m.Code == ErrCodeNone {
+		return m.Text
+	}
+	if m.Text == "" {
+		return "[" + m.Code.String() + "]"
+	}
+	return "[" + m.Code.String() + "] " + m.Text
+}
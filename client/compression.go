@@ -0,0 +1,128 @@
+package client
+
+import (
+	"bytes"
+	"compress/flate"
+	"fmt"
+	"io"
+	"myp2p/clientStorage"
+	"sync"
+)
+
+//-----------------------------------------------------------------------------------------
+// Ce fichier regroupe la compression des Datum, négociée par l’extension
+// ExtensionCompression. La valeur d’un Datum compressé commence par un octet
+// qui ne peut pas être un type de nœud :
+//
+//	DatumCompressed | algorithme (1) | nœud compressé
+//
+// Le hash du Datum reste celui du nœud décompressé : le destinataire
+// décompresse avant de vérifier l’intégrité (cf. HandleDatum). L’émetteur
+// n’envoie la forme compressée que si elle est plus courte, et le chiffrement
+// éventuel s’applique après la compression.
+
+// Octet de tête d’une valeur compressée (les bits bas portent l’algorithme)
+const DatumCompressed = 0x80
+
+// Algorithmes de compression
+const (
+	CompressDeflate = 1 // DEFLATE (RFC 1951)
+)
+
+// CompressionEnabled : annonce de l'extension ExtensionCompression
+var CompressionEnabled = true
+
+// compressMinSize : en deçà, un nœud est toujours envoyé tel quel
+var compressMinSize = 64
+
+// maxNodeSize : taille maximale d’un nœud Merkle (LongDirectory complet),
+// borne de la décompression
+const maxNodeSize = clientStorage.IdSize + clientStorage.MaxDirEntries*(1+clientStorage.LongNameSize+clientStorage.HashSize)
+
+// compresseurs réutilisés (un flate.Writer alloue plusieurs centaines de Ko)
+var deflaters = sync.Pool{New: func() any {
+	w, _ := flate.NewWriter(nil, flate.DefaultCompression)
+	return w
+}}
+
+// compressNode renvoie la forme compressée de node, ou nil si elle n’est pas
+// plus courte que node.
+func compressNode(node []byte) []byte {
+	if len(node) < compressMinSize {
+		return nil
+	}
+	var buf bytes.Buffer
+	buf.Grow(len(node))
+	buf.Write([]byte{DatumCompressed | CompressDeflate})
+	w := deflaters.Get().(*flate.Writer)
+	defer deflaters.Put(w)
+	w.Reset(&buf)
+	if _, err := w.Write(node); err != nil {
+		return nil
+	}
+	if err := w.Close(); err != nil {
+		return nil
+	}
+	if buf.Len() >= len(node) {
+		return nil
+	}
+	return buf.Bytes()
+}
+
+// isCompressed indique si une valeur de Datum est compressée.
+func isCompressed(value []byte) bool {
+	return len(value) > 0 && value[0]&DatumCompressed != 0
+}
+
+// decompressNode renvoie le nœud d’une valeur compressée.
+// Retour : erreur si l’algorithme est inconnu, le flux invalide ou le nœud
+// plus grand que maxNodeSize
+func decompressNode(value []byte) ([]byte, error) {
+	if len(value) < 2 || value[0] != DatumCompressed|CompressDeflate {
+		return nil, malformed(Datum, "compression %#x inconnue", value[0])
+	}
+	r := flate.NewReader(bytes.NewReader(value[1:]))
+	defer r.Close()
+	node, err := io.ReadAll(io.LimitReader(r, maxNodeSize+1))
+	if err != nil {
+		return nil, malformed(Datum, "flux compressé invalide : %v", err)
+	}
+	if len(node) == 0 || len(node) > maxNodeSize {
+		return nil, malformed(Datum, "nœud décompressé de %d octets", len(node))
+	}
+	return node, nil
+}
+
+// datumValue renvoie la valeur à envoyer à peer pour node : compressée si le
+// peer a négocié ExtensionCompression et si cela réduit sa taille.
+func (n *Node) datumValue(peer *Peer, node []byte) []byte {
+	if !peer.Has(ExtensionCompression) {
+		return node
+	}
+	packed := compressNode(node)
+	if packed == nil {
+		return node
+	}
+	n.metrics.compressed.Add(1)
+	n.metrics.compressionSaved.Add(uint64(len(node) - len(packed)))
+	datumLog.Debug("Datum compressé", "octets", len(node), "compressé", len(packed))
+	return packed
+}
+
+// inflateDatum remplace la valeur compressée d’un Datum reçu de peer par le
+// nœud qu’elle contient. Une valeur compressée n’est acceptée que si
+// l’extension a été négociée.
+func inflateDatum(peer *Peer, datum *DatumMsg) error {
+	if !isCompressed(datum.Value) {
+		return nil
+	}
+	if !peer.Has(ExtensionCompression) {
+		return fmt.Errorf("Datum compressé sans extension %s", ExtensionName(ExtensionCompression))
+	}
+	node, err := decompressNode(datum.Value)
+	if err != nil {
+		return err
+	}
+	datum.Value = node
+	return nil
+}
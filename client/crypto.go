@@ -8,20 +8,17 @@ import (
 	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/sha256"
+	"encoding/hex"
 	"errors"
-	"fmt"
 	"math/big"
 	"myp2p/clientStorage"
 	"net"
 )
 
 const (
-	AESGCMNonceSize = 12    // taille du nonce pour AES-GCM
-	chiffre         = false // Chiffrement activé ou non
+	AESGCMNonceSize = 12 // taille du nonce pour AES-GCM
 )
 
-var debugCrypto = true
-
 // -------------------------
 // Génération de clés ECDSA
 // -------------------------
@@ -31,13 +28,11 @@ func GenerateKeyPair() (*ecdsa.PrivateKey, *ecdsa.PublicKey, error) {
 	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
 	if err != nil {
 
-		fmt.Println("Erreur génération clé ECDSA :", err)
+		cryptoLog.Warn("Erreur génération clé ECDSA", "err", err)
 
 		return nil, nil, err
 	}
-	if debugCrypto {
-		fmt.Println("Clé ECDSA générée avec succès")
-	}
+	cryptoLog.Debug("Clé ECDSA générée avec succès")
 	return priv, &priv.PublicKey, nil
 }
 
@@ -50,26 +45,20 @@ func SerializePublicKey(pub *ecdsa.PublicKey) []byte {
 	formatted := make([]byte, 64)
 	pub.X.FillBytes(formatted[:clientStorage.HashSize])
 	pub.Y.FillBytes(formatted[clientStorage.HashSize:])
-	if debugCrypto {
-		fmt.Println("Clé publique sérialisée :", formatted)
-	}
+	cryptoLog.Debug("Clé publique sérialisée", "formatted", formatted)
 	return formatted
 }
 
 // ParsePublicKey reconstruit une clé publique à partir de 64 bytes
 func ParsePublicKey(data []byte) (*ecdsa.PublicKey, error) {
 	if len(data) != 64 {
-		if debugCrypto {
-			fmt.Println("Erreur ParsePublicKey : data != 64 bytes")
-		}
+		cryptoLog.Debug("Erreur ParsePublicKey : data != 64 bytes")
 		return nil, errors.New("clé publique invalide, doit faire 64 bytes")
 	}
 	var x, y big.Int
 	x.SetBytes(data[:clientStorage.HashSize])
 	y.SetBytes(data[clientStorage.HashSize:])
-	if debugCrypto {
-		fmt.Println("Clé publique parsée avec succès")
-	}
+	cryptoLog.Debug("Clé publique parsée avec succès")
 	return &ecdsa.PublicKey{
 		Curve: elliptic.P256(),
 		X:     &x,
@@ -77,6 +66,16 @@ func ParsePublicKey(data []byte) (*ecdsa.PublicKey, error) {
 	}, nil
 }
 
+// KeyFingerprint renvoie l'empreinte d'une clé publique :
+// SHA-256 de sa forme sérialisée (64 bytes), en hexadécimal.
+// Retourne une chaîne vide si la clé est nil.
+func KeyFingerprint(pub *ecdsa.PublicKey) string {
+	if pub == nil {
+		return ""
+	}
+	return hex.EncodeToString(clientStorage.Sha(SerializePublicKey(pub)))
+}
+
 // -------------------------
 // Signature et vérification
 // -------------------------
@@ -86,32 +85,24 @@ func SignMessage(priv *ecdsa.PrivateKey, message []byte) ([]byte, error) {
 	hash := sha256.Sum256(message)
 	r, s, err := ecdsa.Sign(rand.Reader, priv, hash[:])
 	if err != nil {
-		if debugCrypto {
-			fmt.Println("Erreur signature :", err)
-		}
+		cryptoLog.Debug("Erreur signature", "err", err)
 		return nil, err
 	}
 	signature := make([]byte, 64)
 	r.FillBytes(signature[:clientStorage.HashSize])
 	s.FillBytes(signature[clientStorage.HashSize:])
-	if debugCrypto {
-		fmt.Println("Message signé :", signature)
-	}
+	cryptoLog.Debug("Message signé", "signature", signature)
 	return signature, nil
 }
 
 // VerifyMessage vérifie la signature d'un message avec la clé publique
 func VerifyMessage(pub *ecdsa.PublicKey, message []byte, signature []byte) (bool, error) {
 	if signature == nil || message == nil {
-		if debugCrypto {
-			fmt.Println("Erreur VerifyMessage : signature ou message nil")
-		}
+		cryptoLog.Debug("Erreur VerifyMessage : signature ou message nil")
 		return false, errors.New("signature ou message signé vaut nil")
 	}
 	if len(signature) != 64 {
-		if debugCrypto {
-			fmt.Println("Erreur VerifyMessage : signature != 64 bytes")
-		}
+		cryptoLog.Debug("Erreur VerifyMessage : signature != 64 bytes")
 		return false, errors.New("signature invalide, doit faire 64 bytes")
 	}
 	hash := sha256.Sum256(message)
@@ -119,13 +110,7 @@ func VerifyMessage(pub *ecdsa.PublicKey, message []byte, signature []byte) (bool
 	r.SetBytes(signature[:clientStorage.HashSize])
 	s.SetBytes(signature[clientStorage.HashSize:])
 	valid := ecdsa.Verify(pub, hash[:], &r, &s)
-	if debugCrypto {
-		if valid {
-			fmt.Println("Signature valide")
-		} else {
-			fmt.Println("Signature invalide")
-		}
-	}
+	cryptoLog.Debug("vérification de signature", "valid", valid)
 	return valid, nil
 }
 
@@ -136,9 +121,7 @@ func VerifyMessage(pub *ecdsa.PublicKey, message []byte, signature []byte) (bool
 // VerifyDataIntegrity vérifie que le hash du corps correspond au hash demandé
 func VerifyDataIntegrity(body []byte, requestHash []byte) bool {
 	if len(body) < clientStorage.HashSize {
-		if debugCrypto {
-			fmt.Println("Message trop court pour contenir un hash")
-		}
+		cryptoLog.Debug("Message trop court pour contenir un hash")
 		return false
 	}
 
@@ -147,21 +130,15 @@ func VerifyDataIntegrity(body []byte, requestHash []byte) bool {
 
 	computedHash := clientStorage.Sha(data)
 	if !bytes.Equal(requestHash, computedHash) {
-		if debugCrypto {
-			fmt.Println("Intégrité des données compromise : hash data invalide")
-		}
+		cryptoLog.Debug("Intégrité des données compromise : hash data invalide")
 		return false
 	}
 	if !bytes.Equal(requestHash, receivedHash) {
-		if debugCrypto {
-			fmt.Println("Intégrité des données compromise : hash reçu != hash demandé")
-		}
+		cryptoLog.Debug("Intégrité des données compromise : hash reçu != hash demandé")
 		return false
 	}
 
-	if debugCrypto {
-		fmt.Println("Données reçues intactes, hash correct")
-	}
+	cryptoLog.Debug("Données reçues intactes, hash correct")
 	return true
 }
 
@@ -169,42 +146,46 @@ func VerifyDataIntegrity(body []byte, requestHash []byte) bool {
 // Vérification de signature par peer
 // -------------------------
 
-func VerifSign(addr *net.UDPAddr, message []byte, sig []byte) bool {
-	peer, find := FindPeerByAddr(addr)
+func (n *Node) VerifSign(addr *net.UDPAddr, message []byte, sig []byte) bool {
+	return n.checkSign(addr, message, sig) == ErrCodeNone
+}
+
+// checkSign vérifie la signature comme VerifSign et renvoie la cause d’un échec :
+// ErrCodeNotAssociated (peer inconnu), ErrCodeInternal (clé introuvable) ou
+// ErrCodeBadSignature ; ErrCodeNone si la signature est valide.
+func (n *Node) checkSign(addr *net.UDPAddr, message []byte, sig []byte) ErrorCode {
+	peer, find := n.FindPeerByAddr(addr)
 	if !find {
-		if debugCrypto {
-			fmt.Println("Peer inconnu pour VerifSign :", addr)
-		}
-		return false
+		cryptoLog.Debug("Peer inconnu pour VerifSign", "addr", addr)
+		return ErrCodeNotAssociated
 	}
 	peerkey, err := GetPeerKey(peer.Name)
 	if err != nil {
-		fmt.Printf("Erreur GetPeerKey pour %s: %v\n", peer.Name, err)
-		return false
+		cryptoLog.Warn("Erreur GetPeerKey", "peer", peer.Name, "err", err)
+		return ErrCodeInternal
 	}
-	peer.PublicKey = peerkey
-	if peer.PublicKey == nil {
-		fmt.Println("Clé publique nil pour le peer", peer.Name)
-		return false
+	if peerkey == nil {
+		cryptoLog.Info("Clé publique nil pour le peer", "peer", peer.Name)
+		return ErrCodeInternal
 	}
+	peer.Mupeer.Lock()
+	peer.PublicKey = peerkey
+	peer.Mupeer.Unlock()
 
-	check, err := VerifyMessage(peer.PublicKey, message, sig)
+	check, err := VerifyMessage(peerkey, message, sig)
 	if err != nil {
-		if debugCrypto {
-			fmt.Println("Erreur VerifyMessage :", err)
-		}
-		return false
+		cryptoLog.Debug("Erreur VerifyMessage", "err", err)
+		n.countSigFailure()
+		return ErrCodeBadSignature
 	}
 	if !check {
-		if debugCrypto {
-			fmt.Println("Signature invalide pour le message reçu")
-		}
-		return false
-	}
-	if debugCrypto {
-		fmt.Println("Signature valide pour le message reçu de", peer.Name)
+		cryptoLog.Debug("Signature invalide pour le message reçu")
+		n.countSigFailure()
+		n.reportPeerMisbehaviour(peer, MisBadSignature)
+		return ErrCodeBadSignature
 	}
-	return true
+	cryptoLog.Debug("Signature valide pour le message reçu de", "peer", peer.Name)
+	return ErrCodeNone
 }
 
 // -------------------------
@@ -221,9 +202,7 @@ func ComputeSharedKey(privateKey *ecdsa.PrivateKey, peerPublicKey *ecdsa.PublicK
 	x.FillBytes(xBytes)
 
 	sharedKey := sha256.Sum256(xBytes) // clé symétrique de 32 bytes
-	if debugCrypto {
-		fmt.Println("Clé partagée calculée :", sharedKey[:])
-	}
+	cryptoLog.Debug("Clé partagée calculée")
 	return sharedKey[:], nil
 }
 
@@ -236,7 +215,7 @@ func encryptAESGCM(key, plaintext []byte) ([]byte, error) {
 	block, err := aes.NewCipher(key)
 	if err != nil {
 
-		fmt.Println("Erreur création cipher AES :", err)
+		cryptoLog.Warn("Erreur création cipher AES", "err", err)
 
 		return nil, err
 	}
@@ -244,7 +223,7 @@ func encryptAESGCM(key, plaintext []byte) ([]byte, error) {
 	nonce := make([]byte, AESGCMNonceSize)
 	if _, err := rand.Read(nonce); err != nil {
 
-		fmt.Println("Erreur génération nonce :", err)
+		cryptoLog.Warn("Erreur génération nonce", "err", err)
 
 		return nil, err
 	}
@@ -252,15 +231,13 @@ func encryptAESGCM(key, plaintext []byte) ([]byte, error) {
 	aesgcm, err := cipher.NewGCM(block)
 	if err != nil {
 
-		fmt.Println("Erreur création GCM :", err)
+		cryptoLog.Warn("Erreur création GCM", "err", err)
 
 		return nil, err
 	}
 
 	ciphertext := aesgcm.Seal(nonce, nonce, plaintext, nil)
-	if debugCrypto {
-		fmt.Println("Message chiffré :", ciphertext)
-	}
+	cryptoLog.Debug("Message chiffré", "ciphertext", ciphertext)
 	return ciphertext, nil
 }
 
@@ -273,7 +250,7 @@ func decryptAESGCM(key, ciphertext []byte) ([]byte, error) {
 	block, err := aes.NewCipher(key)
 	if err != nil {
 
-		fmt.Println("Erreur création cipher AES :", err)
+		cryptoLog.Warn("Erreur création cipher AES", "err", err)
 
 		return nil, err
 	}
@@ -284,7 +261,7 @@ func decryptAESGCM(key, ciphertext []byte) ([]byte, error) {
 	aesgcm, err := cipher.NewGCM(block)
 	if err != nil {
 
-		fmt.Println("Erreur création GCM :", err)
+		cryptoLog.Warn("Erreur création GCM", "err", err)
 
 		return nil, err
 	}
@@ -292,12 +269,10 @@ func decryptAESGCM(key, ciphertext []byte) ([]byte, error) {
 	plaintext, err := aesgcm.Open(nil, nonce, ciphertext, nil)
 	if err != nil {
 
-		fmt.Println("Erreur déchiffrement GCM :", err)
+		cryptoLog.Warn("Erreur déchiffrement GCM", "err", err)
 
 		return nil, err
 	}
-	if debugCrypto {
-		fmt.Println("Message déchiffré :", plaintext)
-	}
+	cryptoLog.Debug("Message déchiffré", "plaintext", plaintext)
 	return plaintext, nil
 }
@@ -1,9 +1,10 @@
 package client
 
 import (
+	"bytes"
 	"crypto/ecdsa"
 	"encoding/hex"
-	"fmt"
+	"errors"
 	"myp2p/clientStorage"
 	"net"
 	"time"
@@ -19,10 +20,9 @@ type DatumJob struct {
 	Addr *net.UDPAddr // adresse UDP du peer à interroger
 }
 
-// DatumQueue : canal global des jobs de données à traiter.
+// Taille de la file des jobs de données (Node.datumQueue).
 // On utilise un buffer important (8192) pour éviter les blocages si plusieurs jobs arrivent rapidement.
-var DatumQueue = make(chan DatumJob, 8192)
-var debugDatum = true
+const DatumQueueSize = 8192
 
 // --------------------------------------------
 // DatumScheduler
@@ -40,32 +40,59 @@ var debugDatum = true
 // 5. Construit le message DatumRequest.
 // 6. Met à jour la fenêtre du peer et crée une transaction pour le suivi.
 // 7. Envoie le message UDP.
-func DatumScheduler(conn *net.UDPConn) {
-	for job := range DatumQueue {
-		peer, ok := FindPeerByAddr(job.Addr)
+//
+// Avec un peer qui a négocié ExtensionSubtree ou ExtensionBatching, les
+// étapes 4 à 7 regroupent les hashes en attente pour ce peer (cf. gatherJobs)
+// dans un seul SubtreeRequest, qui demande aussi leurs descendants (cf.
+// sendSubtree), ou BatchDatumRequest (cf. sendBatch) ; les jobs d’autres
+// peers lus entre-temps sont traités ensuite.
+func (n *Node) DatumScheduler(conn Transport) {
+	var held []DatumJob // jobs mis de côté par gatherJobs, prioritaires sur la file
+	for {
+		var job DatumJob
+		if len(held) > 0 {
+			job, held = held[0], held[1:]
+		} else {
+			select {
+			case job = <-n.datumQueue:
+			case <-n.drain:
+				// arrêt en cours : on ne lance plus de nouvelles requêtes
+				return
+			}
+		}
+		peer, ok := n.FindPeerByAddr(job.Addr)
 		if !ok {
 			continue // le peer n'existe pas → on ignore
 		}
 
 		// attendre qu'il y ait de la place dans la fenêtre du peer
 		for !peer.Window.CanSend() {
-			if debugSlidingWindow {
-				fmt.Println("boucle infini !")
+			datumLog.Debug("boucle infini !")
+			if !n.sleep(200 * time.Microsecond) {
+				return
 			}
-			time.Sleep(200 * time.Microsecond)
 		}
 
-		id := GenerateId()
+		if peer.Has(ExtensionSubtree) {
+			held = n.sendSubtree(conn, peer, job, held)
+			continue
+		}
+		if peer.Has(ExtensionBatching) {
+			held = n.sendBatch(conn, peer, job, held)
+			continue
+		}
+
+		id := n.GenerateId()
 		msg, err := BuildDatumRequest(id, job.Hash)
 		if err != nil {
-			fmt.Println("Erreur BuildDatumRequest:", err)
+			datumLog.Warn("Erreur BuildDatumRequest", "err", err)
 			continue
 		}
 
 		peer.Window.OnSend()
 
 		// crée une transaction pour suivre la réponse
-		CreateTransaction(
+		n.CreateTransaction(
 			id,
 			peer,
 			job.Addr,
@@ -78,51 +105,114 @@ func DatumScheduler(conn *net.UDPConn) {
 	}
 }
 
+// Jobs lus au plus dans la file pour compléter une requête groupée
+// (les jobs d’autres peers sont mis de côté, cf. gatherJobs)
+const gatherLookahead = 4 * MaxBatchHashes
+
+// --------------------------------------------
+// gatherJobs
+// --------------------------------------------
+// Regroupe first et les autres hashes en attente pour le même peer : d’abord
+// ceux mis de côté (held), puis ceux de la file, sans attendre. Un hash déjà
+// regroupé n’est pas repris (nœud partagé) ; accept (nil = toujours) peut
+// refuser un hash supplémentaire, qui reste en attente.
+//
+// Paramètres :
+// - first : premier job de la requête
+// - held : jobs mis de côté lors des regroupements précédents
+// - limit : nombre maximal de hashes
+// - accept : réserve la place d’un hash supplémentaire
+//
+// Retour :
+// - hashes regroupés
+// - jobs toujours en attente (ceux d’autres peers, ou au-delà de limit)
+func (n *Node) gatherJobs(first DatumJob, held []DatumJob, limit int, accept func() bool) ([][]byte, []DatumJob) {
+	hashes := [][]byte{first.Hash}
+	add := func(j DatumJob) bool {
+		if len(hashes) >= limit || !sameUDPAddr(j.Addr, first.Addr) {
+			return false
+		}
+		for _, h := range hashes {
+			if bytes.Equal(h, j.Hash) {
+				return true // nœud partagé, déjà demandé
+			}
+		}
+		if accept != nil && !accept() {
+			return false
+		}
+		hashes = append(hashes, j.Hash)
+		return true
+	}
+
+	// jobs déjà mis de côté pour ce peer
+	kept := held[:0]
+	for _, j := range held {
+		if !add(j) {
+			kept = append(kept, j)
+		}
+	}
+	held = kept
+
+	// puis ceux de la file, sans attendre
+gather:
+	for read := 0; read < gatherLookahead && len(hashes) < limit; read++ {
+		select {
+		case j := <-n.datumQueue:
+			if !add(j) {
+				held = append(held, j)
+			}
+		default:
+			break gather
+		}
+	}
+	return hashes, held
+}
+
+// queueDatum ajoute job à DatumQueue. Une fois l’arrêt commencé, DatumScheduler
+// ne lit plus la file : le job est abandonné au lieu de bloquer le handler.
+// Retour : false si le job a été abandonné
+func (n *Node) queueDatum(job DatumJob) bool {
+	select {
+	case n.datumQueue <- job:
+		return true
+	case <-n.drain:
+	case <-n.ctx.Done():
+	}
+	return false
+}
+
 // --------------------------------------------
 // HandlefileDataWindow
 // --------------------------------------------
-// Analyse le body d'une donnée reçue et programme de nouvelles requêtes si nécessaire.
+// Analyse le nœud d'un Datum reçu et programme de nouvelles requêtes si nécessaire.
 //
 // Paramètres :
-// - body : le contenu du message Datum reçu
+// - datum : le message Datum reçu (déchiffré, intégrité vérifiée)
 // - conn : connexion UDP (non utilisé ici mais peut être utile)
 // - addr : adresse du peer ayant envoyé la donnée
 //
 // Fonctionnement :
-// 1. Récupère le "node" en supprimant le hash initial.
-// 2. Détermine le type de donnée (chunk, directory, big, bigDirectory).
-// 3. Stocke le node dans le clientStorage.
-// 4. Si c’est un chunk → rien de plus à faire.
-// 5. Si c’est un directory → pour chaque entrée, ajoute un job dans DatumQueue pour récupérer le hash.
-// 6. Si c’est un "big" ou "bigDirectory" → idem, ajoute les hash des sous-données dans DatumQueue.
-func HandlefileDataWindow(body []byte, conn *net.UDPConn, addr *net.UDPAddr) {
-	node := body[clientStorage.HashSize:]    // supprimer le hash en tête
-	nodeType := clientStorage.Typedata(node) // déterminer le type
-
-	clientStorage.FillMap(node) // stocker le node
-
-	if nodeType == clientStorage.Chunk {
-		return // chunk = pas d'autres hash à demander
+// 1. Décode le nœud (ParseNode) ; un nœud malformé est rejeté et signalé.
+// 2. Stocke le node dans le clientStorage.
+// 3. Si c’est un chunk → rien de plus à faire.
+// 4. Sinon (directory, big, bigDirectory) → pour chaque enfant, ajoute un job dans DatumQueue.
+func (n *Node) HandlefileDataWindow(datum *DatumMsg, conn Transport, addr *net.UDPAddr) {
+	node, err := datum.Node()
+	if err != nil {
+		datumLog.Warn("Datum rejeté", "err", err)
+		n.ReportMisbehaviour(addr, MisMalformed)
+		return
 	}
 
-	switch nodeType {
-	case clientStorage.Directory:
-		// pour chaque entrée de directory, ajouter un job pour récupérer le hash
-		for i := 0; i < len(node[clientStorage.IdSize:])/clientStorage.DirEntrySize; i++ {
-			hash := node[clientStorage.IdSize+i*clientStorage.DirEntrySize+clientStorage.NameSize : clientStorage.IdSize+i*clientStorage.DirEntrySize+clientStorage.DirEntrySize]
-			DatumQueue <- DatumJob{Hash: hash, Addr: addr}
-		}
+	n.store.FillMap(datum.Value) // stocker le node
 
-	case clientStorage.Big, clientStorage.BigDirectory:
-		// pour chaque sous-hash, ajouter un job pour le récupérer
-		for i := 0; i < len(node[clientStorage.IdSize:])/clientStorage.HashSize; i++ {
-			hash := node[clientStorage.IdSize+i*clientStorage.HashSize : clientStorage.IdSize+i*clientStorage.HashSize+clientStorage.HashSize]
-			DatumQueue <- DatumJob{Hash: hash, Addr: addr}
+	// chunk = pas d'autres hash à demander
+	for _, hash := range node.ChildHashes() {
+		if !n.queueDatum(DatumJob{Hash: hash, Addr: addr}) {
+			return
 		}
 	}
-	if debugSlidingWindow {
-		fmt.Println("Handle file data window terminé")
-	}
+	datumLog.Debug("Handle file data window terminé")
 }
 
 // --------------------------------------------
@@ -135,60 +225,102 @@ func HandlefileDataWindow(body []byte, conn *net.UDPConn, addr *net.UDPAddr) {
 // - priv : clé privée du client (pour signer ou chiffrer si nécessaire)
 // - addr : adresse du peer ayant envoyé la requête
 // - id : ID de la requête (pour répondre correctement)
-// - body : corps de la requête (contient le hash demandé)
+// - hash : le hash demandé (validé par le codec)
+//
+// Fonctionnement : construit la réponse (cf. datumReply) et l’envoie ; un
+// NoDatum est signé, un Datum ne l’est pas.
+func (n *Node) HandleDatumRequest(conn Transport, priv *ecdsa.PrivateKey, addr *net.UDPAddr, id uint32, hash []byte) {
+	typ, body, code := n.datumReply(addr, hash)
+	if code != ErrCodeNone {
+		SendErrorCode(conn, id, priv, addr, code, "")
+		return
+	}
+	sendGenericMessage(conn, priv, addr, id, typ, body, typ == NoDatum)
+}
+
+// --------------------------------------------
+// datumReply
+// --------------------------------------------
+// Construit la réponse à la demande du nœud hash par le peer situé à addr.
 //
 // Fonctionnement :
-// 1. Récupère le hash demandé.
-// 2. Cherche la donnée correspondante dans le clientStorage.
-// 3. Si trouvée :
+// 1. Cherche la donnée correspondante dans le clientStorage (limitée à l'arbre visible du peer, cf. acl.go).
+// 2. Si trouvée :
 //   - recalcul du hash pour vérifier l'intégrité
+//   - compression si le peer a négocié ExtensionCompression et si elle réduit la taille
 //   - chiffrement AES si le peer utilise le chiffrement
-//   - envoi du message Datum avec hash + valeur
-//
-// 4. Sinon : envoi d’un NoDatum contenant juste le hash.
-func HandleDatumRequest(conn *net.UDPConn, priv *ecdsa.PrivateKey, addr *net.UDPAddr, id uint32, body []byte) {
-	hash := body[:clientStorage.HashSize]
-	data, found := clientStorage.FindHash(hash)
-
-	if found {
-		if debugDatum {
-			fmt.Println("DatumRequest: found data for hash", hex.EncodeToString(hash))
-		}
-		value := data
-		hash := clientStorage.Sha(value)
-		body := append(hash, value...)
-		// chiffrement AES si nécessaire
-		peer, exist := FindPeerByAddr(addr)
-		if !exist {
-			if debugDatum {
-				fmt.Println("Le Peer n'existe pas")
-			}
-			return
-		}
-		if peer.SharedKey != nil {
-			if debugCrypto {
-				fmt.Println(" ici je chiffre les datum request")
-				fmt.Printf("Key encrypt: %x\n", peer.SharedKey)
-			}
+//   - réponse Datum avec hash + valeur
+//
+// 3. Sinon : réponse NoDatum contenant juste le hash.
+//
+// Retour :
+// - type et body de la réponse
+// - code d’erreur à renvoyer à la place (ErrCodeNone si la réponse est valable)
+func (n *Node) datumReply(addr *net.UDPAddr, hash []byte) (uint8, []byte, ErrorCode) {
+	data, found := n.store.FindHash(hash)
 
-			body_encrypted, err := encryptAESGCM(peer.SharedKey, body)
-			if err != nil {
-				fmt.Println("Erreur encrypt AES")
-				return
-			}
-			sendGenericMessage(conn, priv, addr, id, Datum, body_encrypted, false)
-			return
+	// un nœud hors des droits du peer est traité comme absent
+	if found && !n.CanServeHash(addr, hash) {
+		datumLog.Debug("DatumRequest: hash hors ACL", "hash", hex.EncodeToString(hash))
+		found = false
+	}
 
+	if !found {
+		datumLog.Debug("DatumRequest: no data for hash", "hash", hex.EncodeToString(hash))
+		return NoDatum, hash, ErrCodeNone
+	}
+
+	datumLog.Debug("DatumRequest: found data for hash", "hash", hex.EncodeToString(hash))
+	peer, exist := n.FindPeerByAddr(addr)
+	if !exist {
+		datumLog.Debug("Le Peer n'existe pas")
+		return 0, nil, ErrCodeNotAssociated
+	}
+	body, code := n.datumBody(peer, data)
+	return Datum, body, code
+}
+
+// datumBody construit le body du Datum qui porte data pour peer : hash du
+// nœud puis valeur, compressée si le peer a négocié ExtensionCompression
+// (cf. compression.go), le tout chiffré si une clé est partagée avec le peer.
+// Retour : body, ErrCodeInternal si le chiffrement échoue
+func (n *Node) datumBody(peer *Peer, data []byte) ([]byte, ErrorCode) {
+	body := append(clientStorage.Sha(data), n.datumValue(peer, data)...)
+	// chiffrement AES si nécessaire
+	sharedKey := getSharedKey(peer)
+	if sharedKey != nil {
+		datumLog.Debug("ici je chiffre les datum request")
+
+		body_encrypted, err := encryptAESGCM(sharedKey, body)
+		if err != nil {
+			datumLog.Warn("Erreur encrypt AES")
+			return nil, ErrCodeInternal
 		}
-		sendGenericMessage(conn, priv, addr, id, Datum, body, false)
-		return
-	} else {
-		if debugDatum {
-			fmt.Println("DatumRequest: no data for hash", hex.EncodeToString(hash))
-		}
-		sendGenericMessage(conn, priv, addr, id, NoDatum, hash, true)
-		return
+		return body_encrypted, ErrCodeNone
 	}
+	return body, ErrCodeNone
+}
+
+//
+// ======================= RECONSTRUCTION =======================
+//
+
+// RebuildFromPeer reconstruit en path un arbre téléchargé depuis peer
+// (cf. clientStorage.RebuildNode). Un arbre refusé par la reconstruction
+// (nom dangereux, limite dépassée, nœud incohérent) est signalé comme
+// mauvais comportement du peer.
+//
+// Paramètres :
+// - peer : peer dont provient l’arbre
+// - hash : racine de l’arbre à reconstruire
+// - path : chemin local de la reconstruction
+func (n *Node) RebuildFromPeer(peer *Peer, hash []byte, path string) error {
+	err := n.store.RebuildNode(hash, path)
+	if errors.Is(err, clientStorage.ErrUnsafeTree) {
+		datumLog.Warn("arbre refusé", "peer", peer.Name, "err", err)
+		n.reportPeerMisbehaviour(peer, MisUnsafeTree)
+	}
+	return err
 }
 
 //
@@ -198,45 +330,66 @@ func HandleDatumRequest(conn *net.UDPConn, priv *ecdsa.PrivateKey, addr *net.UDP
 // CheckRoots parcourt tous les peers connus et envoie une requête RootRequest
 // pour obtenir le Merkle Root actuel de chaque peer toutes les 30 secondes
 // (Elle est commenté dans main.go)
-func CheckRoots(conn *net.UDPConn, priv *ecdsa.PrivateKey) {
+func (n *Node) CheckRoots(conn Transport, priv *ecdsa.PrivateKey) {
 	ticker := time.NewTicker(3 * time.Minute) // déclenchement toutes les 30s
+	defer ticker.Stop()
 
-	for range ticker.C {
-		for _, peer := range Peers {
-			// Ignorer si pas connecté ou pas d'adresse active
+	for {
+		select {
+		case <-ticker.C:
+		case <-n.ctx.Done():
+			return
+		}
+		for _, peer := range n.ListPeers() {
+			// Ignorer si pas connecté, pas d'adresse active
+			// ou si le peer n’a pas encore de root connu
 			peer.Mupeer.RLock()
-			if peer.State != PeerAssociated || peer.ActiveAddr == nil {
-				continue
-			}
-
-			// Ignorer si le peer n’a pas encore de root connu
-			if peer.Root == nil {
+			skip := peer.State != PeerAssociated || peer.ActiveAddr == nil || peer.Root == nil
+			peer.Mupeer.RUnlock()
+			if skip {
 				continue
 			}
-			peer.Mupeer.RUnlock()
 			// Ignorer les peers bannis
-			if IsBan(peer.Name) {
-				if debugDatum {
-					fmt.Println("le peer est ban ! on lui delande pas son hashroot")
-				}
+			if n.IsBan(peer.Name) {
+				datumLog.Debug("le peer est ban ! on lui delande pas son hashroot")
 				continue
 			}
 
 			// Générer un ID unique pour la transaction
-			id := GenerateId()
+			id := n.GenerateId()
 
 			// Construire le message RootRequest
 			msg, err := BuildMessage(id, RootRequest, []byte{}, priv, false)
 			if err != nil {
-				fmt.Println("Erreur ROOT pour " + peer.Name)
+				datumLog.Warn("Erreur ROOT pour", "peer", peer.Name)
 				continue
 			}
 
 			// Créer la transaction pour gérer la réponse
-			CreateTransaction(id, peer, peer.ActiveAddr, RootRequest, msg, Retries)
+			n.CreateTransaction(id, peer, peer.ActiveAddr, RootRequest, msg, Retries)
 
 			// Envoyer la requête RootRequest
 			SendMessage(conn, peer.ActiveAddr, msg)
 		}
 	}
 }
+
+// pushRoot envoie notre root (RootReply signé, sans RootRequest) aux peers
+// associés qui ont négocié ExtensionRootPush. L'envoi n'est pas acquitté :
+// CheckRoots reste le filet de sécurité si le paquet est perdu.
+func (n *Node) pushRoot() {
+	if n.conn == nil || n.priv == nil {
+		return
+	}
+	for _, peer := range n.ListPeers() {
+		peer.Mupeer.RLock()
+		addr := peer.ActiveAddr
+		skip := peer.State != PeerAssociated || addr == nil
+		peer.Mupeer.RUnlock()
+		if skip || !peer.Has(ExtensionRootPush) || n.IsBan(peer.Name) {
+			continue
+		}
+		datumLog.Debug("envoi du root au peer", "peer", peer.Name)
+		sendGenericMessage(n.conn, n.priv, addr, n.GenerateId(), RootReply, n.RootForAddr(addr), true)
+	}
+}
@@ -0,0 +1,165 @@
+package client
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"encoding/hex"
+	"fmt"
+	"myp2p/clientStorage"
+	"strings"
+)
+
+//
+// ======================= DÉCODAGE POUR LE DÉBOGAGE =======================
+//
+
+// Décodage lisible d’un paquet brut, avec la même lecture que les handlers (ParsePacket et codec.go) :
+// utilisé par la commande decode pour relire une capture (cf. paquet capture).
+
+// État de la signature d’un paquet décodé
+const (
+	SigAbsent  = "absente"
+	SigUnknown = "non vérifiée" // clé publique inconnue
+	SigValid   = "valide"
+	SigInvalid = "invalide"
+)
+
+// PacketInfo : description d’un paquet décodé
+type PacketInfo struct {
+	ID        uint32
+	Type      uint8
+	BodyLen   int
+	Fields    []string // champs du body, "clé=valeur"
+	PeerName  string   // nom annoncé (Hello / HelloReply)
+	Signature string   // SigAbsent, SigUnknown, SigValid ou SigInvalid
+	Err       error    // paquet malformé
+}
+
+// String met le paquet en forme sur une ligne.
+func (p PacketInfo) String() string {
+	if p.Err != nil {
+		return "paquet invalide : " + p.Err.Error()
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "#%d %s len=%d", p.ID, MsgTypeName(p.Type), p.BodyLen)
+	for _, f := range p.Fields {
+		b.WriteString(" " + f)
+	}
+	b.WriteString(" sig=" + p.Signature)
+	return b.String()
+}
+
+// -----------------------------------------------------------------------------------------
+// DecodePacket décode un paquet brut.
+// Paramètres :
+//   - pkt : paquet tel que reçu ou émis sur le réseau
+//   - pub : clé publique de l’émetteur pour vérifier la signature (nil = non vérifiée)
+//
+// Retour :
+//   - la description du paquet (Err renseigné s’il est malformé)
+func DecodePacket(pkt []byte, pub *ecdsa.PublicKey) PacketInfo {
+	p, err := ParsePacket(pkt)
+	if err != nil {
+		return PacketInfo{Err: err}
+	}
+	info := PacketInfo{ID: p.ID, Type: p.Type, BodyLen: len(p.Body), Signature: SigAbsent}
+	signed, sig := p.Signed, p.Sig
+
+	m, err := p.Message()
+	if err != nil {
+		info.Fields = append(info.Fields, "body=invalide ("+err.Error()+")")
+	}
+	switch m := m.(type) {
+	case *HelloMsg:
+		info.PeerName = m.Name
+		info.Fields = append(info.Fields,
+			fmt.Sprintf("extensions=%#08x (%s)", m.Extensions, ExtensionNames(m.Extensions)),
+			fmt.Sprintf("chiffrement=%t", m.Encrypted()),
+			fmt.Sprintf("name=%q", m.Name))
+		for _, t := range m.TLVs {
+			info.Fields = append(info.Fields, fmt.Sprintf("tlv.%s=%x", ExtensionName(t.Tag), t.Value))
+		}
+		if m.DHPub != nil {
+			info.Fields = append(info.Fields, "dh="+hex.EncodeToString(m.DHPub[:8])+"…")
+		}
+	case *RootReplyMsg:
+		info.Fields = append(info.Fields, decodeHash(m.Hash))
+	case *DatumRequestMsg:
+		info.Fields = append(info.Fields, decodeHash(m.Hash))
+	case *BatchDatumRequestMsg:
+		info.Fields = append(info.Fields, fmt.Sprintf("hashes=%d", len(m.Hashes)))
+		for _, h := range m.Hashes {
+			info.Fields = append(info.Fields, decodeHash(h))
+		}
+	case *NoDatumMsg:
+		info.Fields = append(info.Fields, decodeHash(m.Hash))
+	case *SubtreeRequestMsg:
+		info.Fields = append(info.Fields,
+			fmt.Sprintf("profondeur=%d", m.MaxDepth), fmt.Sprintf("nœuds=%d", m.MaxNodes), fmt.Sprintf("octets=%d", m.MaxBytes),
+			fmt.Sprintf("hashes=%d", len(m.Hashes)))
+		for _, h := range m.Hashes {
+			info.Fields = append(info.Fields, decodeHash(h))
+		}
+	case *SubtreeEndMsg:
+		info.Fields = append(info.Fields, fmt.Sprintf("envoyés=%d", m.Count))
+	case *DatumMsg:
+		info.Fields = append(info.Fields, decodeHash(m.Hash))
+		value := m.Value
+		if isCompressed(value) {
+			if node, err := decompressNode(value); err == nil {
+				info.Fields = append(info.Fields, fmt.Sprintf("compressé=%d→%d", len(value), len(node)))
+				value = node
+			}
+		}
+		if bytes.Equal(clientStorage.Sha(value), m.Hash) {
+			info.Fields = append(info.Fields, "node="+nodeTypeName(value[0]), "intégrité=ok")
+		} else {
+			info.Fields = append(info.Fields, "intégrité=ko (chiffré ou corrompu)")
+		}
+	case *NatTraversalMsg:
+		info.Fields = append(info.Fields, "addr="+m.Addr.String())
+	case *ErrorMsg:
+		if m.Code != ErrCodeNone {
+			info.Fields = append(info.Fields, "code="+m.Code.String())
+		}
+		info.Fields = append(info.Fields, fmt.Sprintf("message=%q", m.Text))
+	}
+
+	if sig != nil {
+		info.Signature = SigUnknown
+		if pub != nil {
+			if valid, err := VerifyMessage(pub, signed, sig); err == nil && valid {
+				info.Signature = SigValid
+			} else {
+				info.Signature = SigInvalid
+			}
+		}
+	}
+	return info
+}
+
+// decodeHash met en forme un hash.
+func decodeHash(hash []byte) string {
+	return "hash=" + hex.EncodeToString(hash)
+}
+
+// nodeTypeName renvoie le nom d’un type de nœud Merkle.
+func nodeTypeName(t byte) string {
+	switch t {
+	case clientStorage.Chunk:
+		return "Chunk"
+	case clientStorage.Directory:
+		return "Directory"
+	case clientStorage.Big:
+		return "Big"
+	case clientStorage.BigDirectory:
+		return "BigDirectory"
+	case clientStorage.LongDirectory:
+		return "LongDirectory"
+	case clientStorage.Metadata:
+		return "Metadata"
+	case clientStorage.Symlink:
+		return "Symlink"
+	}
+	return fmt.Sprintf("inconnu(%d)", t)
+}
@@ -2,14 +2,13 @@ package client
 
 import (
 	"crypto/ecdsa"
-	"fmt"
+	"errors"
 	"net"
 )
 
 // HandshaWithServer indique si un handshake avec le serveur est nécessaire.
 // True par défaut : on doit effectuer un handshake initial avant de considérer le peer comme pleinement connecté.
 var HandshaWithServer = true
-var debugDispatcher = false
 
 // IncomingPacket représente un paquet reçu d’un peer.
 // Il contient :
@@ -32,17 +31,22 @@ type IncomingPacket struct {
 // 2. Lit un paquet UDP.
 // 3. Copie le contenu reçu pour éviter que le buffer soit écrasé lors du prochain Read.
 // 4. Redirige le paquet vers le routeur pour savoir s’il s’agit d’une requête ou d’une réponse.
-func CaptureMessage(conn *net.UDPConn, priv *ecdsa.PrivateKey) {
+// La boucle se termine lorsque le transport est fermé.
+func (n *Node) CaptureMessage(conn Transport, priv *ecdsa.PrivateKey) {
 	buf := make([]byte, 65535) // buffer large pour recevoir tout type de paquet
 
 	for {
-		n, raddr, err := conn.ReadFromUDP(buf)
-		if err == nil && n > 0 {
+		size, raddr, err := conn.ReadFromUDP(buf)
+		if errors.Is(err, net.ErrClosed) {
+			// transport fermé : fin de la lecture
+			return
+		}
+		if err == nil && size > 0 {
 			// copier le paquet reçu pour ne pas écraser le buffer
-			pkt := make([]byte, n)
-			copy(pkt, buf[:n])
+			pkt := make([]byte, size)
+			copy(pkt, buf[:size])
 			// dispatcher le paquet selon son type
-			Routeur(pkt, raddr, conn, priv)
+			n.Routeur(pkt, raddr, conn, priv)
 		}
 	}
 }
@@ -57,34 +61,41 @@ func CaptureMessage(conn *net.UDPConn, priv *ecdsa.PrivateKey) {
 // - priv : clé privée (utile si signature/déchiffrement)
 //
 // Fonctionnement :
-// 1. Vérifie que le paquet est suffisamment long (>=7 octets) pour contenir ID, type et longueur.
-// 2. Parse le paquet avec parseRecvMessage (extrait type, corps, signature, etc.).
-// 3. Si le type du message > 127 → c’est une réponse, on le met dans responseChan.
-// 4. Sinon → c’est une requête, on le met dans requestChan.
-func Routeur(pkt []byte, addr *net.UDPAddr, conn *net.UDPConn, priv *ecdsa.PrivateKey) {
-	if len(pkt) < 7 {
-		if debugDispatcher {
-			fmt.Println("Paquet trop court, ignoré")
-		}
+// 1. Découpe le paquet avec ParsePacket (en-tête, longueur et signature cohérents).
+// 2. Le body est décodé plus tard, par le handler (cf. codec.go).
+// 3. Applique la limite de débit par adresse source, sauf aux réponses attendues (cf. expectsReply).
+// 4. Si le type du message > 127 → c’est une réponse, on le met dans responseChan.
+// 5. Sinon → c’est une requête, on le met dans requestChan.
+// Les files sont bornées : si elles restent pleines plus de BackpressureTimeout,
+// le paquet est jeté et compté.
+func (n *Node) Routeur(pkt []byte, addr *net.UDPAddr, conn Transport, priv *ecdsa.PrivateKey) {
+	p, err := ParsePacket(pkt)
+	if err != nil {
+		transportLog.Debug("paquet ignoré", "err", err)
+		n.countDrop(DropMalformed, addr)
+		n.ReportMisbehaviour(addr, MisMalformed)
 		return
 	}
+	typ := p.Type
 
-	_, typ, _, _, _, _, ok := parseRecvMessage(pkt)
-	if !ok {
-		fmt.Println("erreur lors du parseRecvMessage")
-		return
+	// les réponses attendues (transaction en cours avec cette adresse) ne
+	// consomment pas le seau par adresse, qui protège le chemin des requêtes
+	if typ <= 127 || !n.expectsReply(p.ID, addr) {
+		if !n.allowFromAddr(addr) {
+			return
+		}
 	}
 
 	// typ > 127 = réponse, typ <= 127 = requête
 	if typ > 127 {
-		if debugDispatcher {
-			fmt.Println("Dispatcher: réponse → responseChan")
+		transportLog.Debug("Dispatcher: réponse → responseChan")
+		if !enqueue(n.responseChan, IncomingPacket{pkt: pkt, addr: addr}) {
+			n.countDrop(DropResponseQueueFull, addr)
 		}
-		responseChan <- IncomingPacket{pkt: pkt, addr: addr}
 	} else {
-		if debugDispatcher {
-			fmt.Println("Dispatcher: requête  → requestChan")
+		transportLog.Debug("Dispatcher: requête → requestChan")
+		if !enqueue(n.requestChan, IncomingPacket{pkt: pkt, addr: addr}) {
+			n.countDrop(DropRequestQueueFull, addr)
 		}
-		requestChan <- IncomingPacket{pkt: pkt, addr: addr}
 	}
 }
@@ -0,0 +1,147 @@
+package client
+
+import "fmt"
+
+//
+// ======================= POLITIQUE DE CHIFFREMENT =======================
+//
+
+// EncryptionPolicy : usage de l’extension de chiffrement (Diffie-Hellman puis
+// AES-GCM des Datum) avec un peer
+type EncryptionPolicy uint8
+
+const (
+	EncryptNever         EncryptionPolicy = iota // l’extension n’est pas annoncée
+	EncryptOpportunistic                         // chiffré dès que le peer annonce aussi l’extension
+	EncryptRequired                              // pas d’association avec un peer qui ne l’annonce pas
+)
+
+var encryptionPolicyNames = [...]string{
+	EncryptNever:         "never",
+	EncryptOpportunistic: "opportunistic",
+	EncryptRequired:      "required",
+}
+
+// DefaultEncryptionPolicy : politique globale des nœuds créés par NewNode
+var DefaultEncryptionPolicy = EncryptNever
+
+func (p EncryptionPolicy) String() string {
+	if int(p) < len(encryptionPolicyNames) {
+		return encryptionPolicyNames[p]
+	}
+	return "unknown"
+}
+
+// ParseEncryptionPolicy lit une politique : never, opportunistic ou required.
+func ParseEncryptionPolicy(s string) (EncryptionPolicy, error) {
+	for p, name := range encryptionPolicyNames {
+		if name == s {
+			return EncryptionPolicy(p), nil
+		}
+	}
+	return EncryptNever, fmt.Errorf("politique de chiffrement %q inconnue (never, opportunistic ou required)", s)
+}
+
+// EncryptionPolicy renvoie la politique appliquée au peer name : la sienne si
+// elle est définie, sinon la politique globale. Le serveur n’est jamais chiffré.
+func (n *Node) EncryptionPolicy(name string) EncryptionPolicy {
+	if name == NameofServeurUDP {
+		return EncryptNever
+	}
+	n.encMu.RLock()
+	defer n.encMu.RUnlock()
+	if p, ok := n.encPolicies[name]; ok {
+		return p
+	}
+	return n.encPolicy
+}
+
+// EncryptionPolicies renvoie la politique globale et les politiques par peer.
+func (n *Node) EncryptionPolicies() (EncryptionPolicy, map[string]EncryptionPolicy) {
+	n.encMu.RLock()
+	defer n.encMu.RUnlock()
+	perPeer := make(map[string]EncryptionPolicy, len(n.encPolicies))
+	for name, p := range n.encPolicies {
+		perPeer[name] = p
+	}
+	return n.encPolicy, perPeer
+}
+
+// -----------------------------------------------------------------------------------------
+// SetEncryptionPolicy règle la politique globale (name vide) ou celle d’un peer.
+// Les associations en cours qui ne respectent plus la politique sont
+// renégociées par un nouveau Hello (et rompues si le peer ne chiffre pas alors
+// que le chiffrement est exigé).
+func (n *Node) SetEncryptionPolicy(name string, p EncryptionPolicy) {
+	n.encMu.Lock()
+	if name == "" {
+		n.encPolicy = p
+	} else {
+		n.encPolicies[name] = p
+	}
+	n.encMu.Unlock()
+	cryptoLog.Info("politique de chiffrement", "peer", name, "policy", p)
+	n.renegotiateEncryption(name)
+}
+
+// ClearEncryptionPolicy : le peer name revient à la politique globale.
+func (n *Node) ClearEncryptionPolicy(name string) {
+	n.encMu.Lock()
+	delete(n.encPolicies, name)
+	n.encMu.Unlock()
+	n.renegotiateEncryption(name)
+}
+
+// renegotiateEncryption refait le Hello avec les peers associés (tous si name
+// est vide) dont l’état chiffré ou en clair contredit leur politique.
+func (n *Node) renegotiateEncryption(name string) {
+	if n.conn == nil || n.priv == nil {
+		return
+	}
+	for _, peer := range n.ListPeers() {
+		if name != "" && peer.Name != name {
+			continue
+		}
+		peer.Mupeer.RLock()
+		associated := peer.State == PeerAssociated && peer.ActiveAddr != nil
+		peer.Mupeer.RUnlock()
+		if !associated || !n.encryptionMismatch(peer) {
+			continue
+		}
+		cryptoLog.Info("association à renégocier (politique de chiffrement)", "peer", peer.Name)
+		n.SendHello(n.conn, n.priv, peer)
+	}
+}
+
+// encryptionMismatch indique si l’état de l’association contredit la politique :
+// chiffrée alors que la politique est never, en clair alors qu’elle est
+// required, ou en clair alors que le peer annonce le chiffrement.
+func (n *Node) encryptionMismatch(peer *Peer) bool {
+	peer.Mupeer.RLock()
+	offered := peer.Extensions&(1<<ExtensionChiffrement) != 0
+	peer.Mupeer.RUnlock()
+	encrypted := peer.Encrypted()
+	switch n.EncryptionPolicy(peer.Name) {
+	case EncryptNever:
+		return encrypted
+	case EncryptRequired:
+		return !encrypted
+	default:
+		return !encrypted && offered
+	}
+}
+
+// Encrypted indique si l’association avec le peer est chiffrée (clé partagée établie).
+func (p *Peer) Encrypted() bool {
+	return getSharedKey(p) != nil
+}
+
+// EncryptionState décrit l’état effectif de l’association et la politique
+// appliquée au peer, ex. "chiffré (opportunistic)".
+func (n *Node) EncryptionState(peer *Peer) string {
+	state := "en clair"
+	if peer.Encrypted() {
+		state = "chiffré"
+	}
+	return state + " (" + n.EncryptionPolicy(peer.Name).String() + ")"
+}
@@ -14,12 +14,15 @@ const (
 	EventNatTraversal2Received  PeerEventType = "NatTraversal2Received"  // Réception d'une réponse NAT Traversal de type 2.
 	EventDisconnected           PeerEventType = "Deconnected"            // peer déconnecté
 	EventMerkleDownloadLocal    PeerEventType = "MerkleDownloadLocal"    // téléchargement depuis ce qu'on possède déjà
+	EventBanned                 PeerEventType = "Banned"                 // peer banni automatiquement pour mauvais comportement
+	EventErrorReceived          PeerEventType = "ErrorReceived"          // le peer a répondu par une erreur (details : "[code] texte", cf. Peer.LastError)
+	EventCapabilities           PeerEventType = "Capabilities"           // les extensions négociées avec le peer ont changé (details : leurs noms)
 )
 
-// OnPeerEvent est un callback global optionnel qui peut être défini par le client.
+// PeerEventFunc est le callback optionnel défini par le client (cf. Node.OnPeerEvent).
 // Il est appelé à chaque événement important concernant un peer.
 // Paramètres :
 // - peer : le peer concerné
 // - event : le type d'événement (PeerEventType)
 // - details : informations supplémentaires ou message associé à l'événement
-var OnPeerEvent func(peer *Peer, event PeerEventType, details string)
+type PeerEventFunc func(peer *Peer, event PeerEventType, details string)
@@ -1,34 +1,232 @@
 package client
 
-import "fmt"
+import (
+	"encoding/binary"
+	"fmt"
+	"myp2p/clientStorage"
+	"strings"
+	"sync"
+)
 
 // Constantes qui délimitent le bit d'une extension donnée
 const (
-	ExtensionNat         = 0 // bit 0
-	ExtensionChiffrement = 1 // bit 1
+	ExtensionNat         = 0  // bit 0
+	ExtensionChiffrement = 1  // bit 1
+	ExtensionVersion     = 2  // bit 2 : bloc TLV après le nom, version du protocole
+	ExtensionRootPush    = 3  // bit 3 : envoi spontané de notre root quand il change
+	ExtensionCompression = 4  // bit 4 : Datum compressés (cf. compression.go)
+	ExtensionBatching    = 5  // bit 5 : DatumRequest groupés (cf. batch.go)
+	ExtensionLongNames   = 6  // bit 6 : nœuds LongDirectory (noms de plus de 32 octets)
+	ExtensionMetadata    = 7  // bit 7 : nœuds Metadata (mode, date, taille des fichiers)
+	ExtensionSymlinks    = 8  // bit 8 : nœuds Symlink (liens symboliques)
+	ExtensionCDC         = 9  // bit 9 : nos fichiers sont découpés selon leur contenu (informatif)
+	ExtensionSubtree     = 10 // bit 10 : demande d'un nœud et de ses descendants (cf. subtree.go)
 )
 
+// ProtocolVersion : version du protocole annoncée dans le TLV ExtensionVersion.
+// La version retenue avec un peer est la plus petite des deux.
+const ProtocolVersion uint16 = 1
+
+// extensions annoncées au serveur : il renvoie nos propres bits sans les
+// implémenter, on s'en tient donc au protocole d'origine
+const serverExtensions uint32 = 1<<ExtensionNat | 1<<ExtensionChiffrement
+
+// RootPushEnabled : annonce de l'extension ExtensionRootPush
+var RootPushEnabled = true
+
+//
+// ======================= REGISTRE DES EXTENSIONS =======================
+//
+
+// Extension décrit une extension du champ Extensions du Hello. Un
+// comportement lié à une extension n'est activé avec un peer que si les deux
+// côtés l'annoncent (cf. Peer.Has).
+type Extension struct {
+	Bit  uint8
+	Name string
+
+	// Advertise indique si le nœud annonce l'extension au peer peerName (nil = toujours).
+	Advertise func(n *Node, peerName string) bool
+	// Payload renvoie la valeur TLV jointe à nos Hello (nil = aucune).
+	Payload func(n *Node) []byte
+	// Negotiated est appelé quand les deux côtés annoncent l'extension, avec
+	// la valeur TLV envoyée par le peer (nil si absente).
+	Negotiated func(n *Node, peer *Peer, payload []byte)
+}
+
+var (
+	extensionsMu sync.RWMutex
+	extensions   = map[uint8]*Extension{}
+)
+
+func init() {
+	RegisterExtension(Extension{Bit: ExtensionNat, Name: "nat"})
+	RegisterExtension(Extension{
+		Bit:       ExtensionChiffrement,
+		Name:      "chiffrement",
+		Advertise: func(n *Node, peerName string) bool { return n.EncryptionPolicy(peerName) != EncryptNever },
+	})
+	RegisterExtension(Extension{
+		Bit:        ExtensionVersion,
+		Name:       "version",
+		Payload:    func(*Node) []byte { return binary.BigEndian.AppendUint16(nil, ProtocolVersion) },
+		Negotiated: negotiateVersion,
+	})
+	RegisterExtension(Extension{
+		Bit:       ExtensionRootPush,
+		Name:      "root-push",
+		Advertise: func(*Node, string) bool { return RootPushEnabled },
+	})
+	RegisterExtension(Extension{
+		Bit:       ExtensionCompression,
+		Name:      "compression",
+		Advertise: func(*Node, string) bool { return CompressionEnabled },
+	})
+	RegisterExtension(Extension{
+		Bit:       ExtensionBatching,
+		Name:      "batching",
+		Advertise: func(*Node, string) bool { return BatchingEnabled },
+	})
+	RegisterExtension(Extension{Bit: ExtensionLongNames, Name: "long-names"})
+	RegisterExtension(Extension{Bit: ExtensionMetadata, Name: "metadata"})
+	RegisterExtension(Extension{Bit: ExtensionSymlinks, Name: "symlinks"})
+	RegisterExtension(Extension{
+		Bit:       ExtensionCDC,
+		Name:      "cdc",
+		Advertise: func(*Node, string) bool { return clientStorage.UsesCDC() },
+	})
+	RegisterExtension(Extension{
+		Bit:       ExtensionSubtree,
+		Name:      "subtree",
+		Advertise: func(*Node, string) bool { return SubtreeEnabled },
+	})
+}
+
+// RegisterExtension ajoute une extension au registre (à appeler avant Run).
+// Un bit hors du champ ou déjà enregistré est une erreur de programmation.
+func RegisterExtension(e Extension) {
+	if e.Bit >= 32 {
+		panic(fmt.Sprintf("extension %q : bit %d hors du champ Extensions", e.Name, e.Bit))
+	}
+	extensionsMu.Lock()
+	defer extensionsMu.Unlock()
+	if _, exists := extensions[e.Bit]; exists {
+		panic(fmt.Sprintf("extension %q : bit %d déjà enregistré", e.Name, e.Bit))
+	}
+	extensions[e.Bit] = &e
+}
+
+// registeredExtensions renvoie les extensions enregistrées, par bit croissant.
+func registeredExtensions() []*Extension {
+	extensionsMu.RLock()
+	defer extensionsMu.RUnlock()
+	list := make([]*Extension, 0, len(extensions))
+	for bit := uint8(0); bit < 32; bit++ {
+		if e, ok := extensions[bit]; ok {
+			list = append(list, e)
+		}
+	}
+	return list
+}
+
+// ExtensionName renvoie le nom d'une extension ("bitN" si elle est inconnue).
+func ExtensionName(bit uint8) string {
+	extensionsMu.RLock()
+	e, ok := extensions[bit]
+	extensionsMu.RUnlock()
+	if !ok {
+		return fmt.Sprintf("bit%d", bit)
+	}
+	return e.Name
+}
+
+// ExtensionNames liste les extensions d'un masque, séparées par des virgules ("-" si aucune).
+func ExtensionNames(mask uint32) string {
+	var names []string
+	for bit := uint8(0); bit < 32; bit++ {
+		if mask&(1<<bit) != 0 {
+			names = append(names, ExtensionName(bit))
+		}
+	}
+	if len(names) == 0 {
+		return "-"
+	}
+	return strings.Join(names, ",")
+}
+
+//
+// ======================= NÉGOCIATION =======================
+//
+
 // -----------------------------------------------------------------------------------------------------
-// Pour débuger l'extension
-var debugExtension = false
+// helloExtensions construit le champ Extensions et le bloc TLV d'un Hello ou HelloReply.
+// Paramètres :
+//   - peerName : destinataire (le serveur ne reçoit que serverExtensions)
+//   - peerExt  : extensions annoncées par le peer (0 si inconnues) ; le bloc TLV
+//     n'est joint que s'il annonce ExtensionVersion, un ancien peer le lirait
+//     comme une partie du nom
+//
+// Retour :
+//   - le champ Extensions et les TLV à joindre
+func (n *Node) helloExtensions(peerName string, peerExt uint32) (uint32, []TLV) {
+	var ext uint32
+	var tlvs []TLV
+	for _, e := range registeredExtensions() {
+		if e.Advertise != nil && !e.Advertise(n, peerName) {
+			continue
+		}
+		ext |= 1 << e.Bit
+		if e.Payload != nil {
+			tlvs = append(tlvs, TLV{Tag: e.Bit, Value: e.Payload(n)})
+		}
+	}
+	if peerName == NameofServeurUDP {
+		return ext & serverExtensions, nil
+	}
+	if peerExt&(1<<ExtensionVersion) == 0 {
+		tlvs = nil
+	}
+	return ext, tlvs
+}
 
 // -----------------------------------------------------------------------------------------------------
-// BuildExtension génère le uint32 prêt à être utilisé
-func BuildExtension() uint32 {
-	var ext uint32 = 0
-	//  on mets toujours à 1 l'extension du Nat
-	ext |= 1 << ExtensionNat
+// negotiate enregistre sur le peer les extensions qu'il annonce et
+// l'intersection avec les nôtres, puis appelle le handler Negotiated de
+// chaque extension commune.
+// Paramètres :
+//   - ours  : extensions que nous avons annoncées au peer
+//   - hello : Hello ou HelloReply reçu du peer
+func (n *Node) negotiate(peer *Peer, ours uint32, hello *HelloMsg) {
+	common := ours & hello.Extensions
 
-	// si c'est chiffré alors extension comporte 0000 0000 0000 0000 0000 0000 0000 0011
-	// 00 00 00 03 en base 16 (on fait des paquets de 4 car 16 = 2^4)
+	peer.Mupeer.Lock()
+	changed := peer.Capabilities != common || peer.Extensions != hello.Extensions
+	peer.Extensions = hello.Extensions
+	peer.Capabilities = common
+	peer.Version = 0 // protocole d'origine, sauf négociation (cf. negotiateVersion)
+	peer.Mupeer.Unlock()
 
-	// le ou logique permet l'addition =>  1 | 0 => 1,  1 | 1 reste inchangé, ça permet de mettre un seul bit à 1
-	// exemple : 0000 | 0010 => 0010
-	if chiffre {
+	for _, e := range registeredExtensions() {
+		if common&(1<<e.Bit) != 0 && e.Negotiated != nil {
+			e.Negotiated(n, peer, hello.TLV(e.Bit))
+		}
+	}
+	peerLog.Debug("extensions négociées", "peer", peer.Name, "annoncées", ExtensionNames(hello.Extensions), "communes", ExtensionNames(common))
+	if changed {
+		n.EmitPeerEvent(peer, EventCapabilities, ExtensionNames(common))
+	}
+}
 
-		ext |= 1 << ExtensionChiffrement
+// negotiateVersion retient la plus petite des deux versions du protocole.
+// Un peer qui annonce l'extension sans TLV (premier Hello) est en version 1.
+func negotiateVersion(_ *Node, peer *Peer, payload []byte) {
+	v := ProtocolVersion
+	if len(payload) == 2 {
+		v = min(v, binary.BigEndian.Uint16(payload))
 	}
-	return ext
+	peer.Mupeer.Lock()
+	peer.Version = v
+	peer.Mupeer.Unlock()
 }
 
 // -----------------------------------------------------------------------------------------------------
@@ -42,9 +240,7 @@ func ParseExtensions(body []byte) (uint32, error) {
 
 	// Vérifie que le body contient au moins 4 octets pour le champ Extensions
 	if len(body) < 4 {
-		if debugExtension {
-			fmt.Println("Erreur aucun champ Extension Trouvé !")
-		}
+		transportLog.Debug("Erreur aucun champ Extension Trouvé !")
 		return 0, fmt.Errorf("Erreur aucun champ Extension Trouvé !") // retourne une erreur si le body est trop court
 	}
 
@@ -0,0 +1,234 @@
+package client
+
+import (
+	"bytes"
+	"encoding/hex"
+	"myp2p/clientStorage"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+//
+// ======================= CIBLES DE FUZZING =======================
+//
+
+// Fuzzing natif des décodeurs de paquets (cf. README, « Fuzzing ») :
+//
+//	go test ./client -run '^$' -fuzz '^FuzzPacket$'
+//
+// Le corpus initial est fait de paquets réels, construits comme un nœud les
+// émet. Au-delà de l’absence de panic, chaque cible vérifie que l’encodage
+// d’un message décodé se relit à l’identique.
+
+// FuzzPacket : découpage d’un paquet et décodage de son body
+func FuzzPacket(f *testing.F) {
+	for _, pkt := range seedPackets(f) {
+		f.Add(pkt)
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		p, err := ParsePacket(data)
+		if err != nil {
+			return
+		}
+		if len(p.Signed) != HeaderSize+len(p.Body) || (p.Sig != nil && len(p.Sig) != SizeSignature) {
+			t.Fatal("découpage incohérent")
+		}
+		m, err := p.Message()
+		if err != nil {
+			return
+		}
+		checkRoundTrip(t, m)
+
+		// le paquet réencodé se redécoupe à l’identique
+		pkt, err := EncodeMessage(p.ID, m, nil, false)
+		if err != nil {
+			t.Fatalf("%s décodé mais non réencodable : %v", MsgTypeName(p.Type), err)
+		}
+		q, err := ParsePacket(pkt)
+		if err != nil || q.ID != p.ID || q.Type != p.Type {
+			t.Fatal("paquet réencodé invalide")
+		}
+	})
+}
+
+// FuzzHello : body d’un Hello
+func FuzzHello(f *testing.F) { fuzzBody(f, func() Message { return &HelloMsg{} }, Hello, HelloReply) }
+
+// FuzzNatTraversal : body d’un NatTraversalRequest
+func FuzzNatTraversal(f *testing.F) {
+	fuzzBody(f, func() Message { return &NatTraversalMsg{} }, NatTraversalRequest, NatTraversalRequest2)
+}
+
+// FuzzBatchDatumRequest : body d’un BatchDatumRequest
+func FuzzBatchDatumRequest(f *testing.F) {
+	fuzzBody(f, func() Message { return &BatchDatumRequestMsg{} }, BatchDatumRequest)
+}
+
+// FuzzSubtreeRequest : body d’un SubtreeRequest
+func FuzzSubtreeRequest(f *testing.F) {
+	fuzzBody(f, func() Message { return &SubtreeRequestMsg{} }, SubtreeRequest)
+}
+
+// FuzzDatum : body d’un Datum, décompression éventuelle, puis nœud Merkle
+func FuzzDatum(f *testing.F) {
+	addBodies(f, Datum)
+	f.Fuzz(func(t *testing.T, data []byte) {
+		m := &DatumMsg{}
+		if err := m.UnmarshalBody(data); err != nil {
+			return
+		}
+		checkRoundTrip(t, m)
+		if isCompressed(m.Value) {
+			node, err := decompressNode(m.Value)
+			if err != nil {
+				return
+			}
+			if len(node) > maxNodeSize {
+				t.Fatal("décompression non bornée")
+			}
+			m.Value = node
+		}
+		m.Node()
+	})
+}
+
+// FuzzDecodePacket : décodage lisible (commande decode)
+func FuzzDecodePacket(f *testing.F) {
+	for _, pkt := range seedPackets(f) {
+		f.Add(pkt)
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		info := DecodePacket(data, nil)
+		_ = info.String()
+	})
+}
+
+// fuzzBody décode des bodies de type types avec le message donné par msg.
+func fuzzBody(f *testing.F, msg func() Message, types ...uint8) {
+	addBodies(f, types...)
+	f.Fuzz(func(t *testing.T, data []byte) {
+		m := msg()
+		if err := m.UnmarshalBody(data); err != nil {
+			return
+		}
+		checkRoundTrip(t, m)
+	})
+}
+
+// addBodies ajoute au corpus le body des paquets réels de type types.
+func addBodies(f *testing.F, types ...uint8) {
+	for _, pkt := range seedPackets(f) {
+		p, err := ParsePacket(pkt)
+		if err != nil {
+			f.Fatal(err)
+		}
+		for _, t := range types {
+			if p.Type == t {
+				f.Add(p.Body)
+			}
+		}
+	}
+}
+
+// checkRoundTrip vérifie que Marshal(Unmarshal(Marshal(m))) == Marshal(m).
+func checkRoundTrip(t *testing.T, m Message) {
+	body, err := m.MarshalBody()
+	if err != nil {
+		t.Fatalf("%s décodé mais non réencodable : %v", MsgTypeName(m.MsgType()), err)
+	}
+	again := newMessage(m.MsgType())
+	if err := again.UnmarshalBody(body); err != nil {
+		t.Fatalf("%s réencodé illisible : %v", MsgTypeName(m.MsgType()), err)
+	}
+	body2, err := again.MarshalBody()
+	if err != nil || !bytes.Equal(body, body2) {
+		t.Fatalf("%s : aller-retour instable %x / %x", MsgTypeName(m.MsgType()), body, body2)
+	}
+}
+
+//
+// ======================= CORPUS INITIAL =======================
+//
+
+// seedPackets construit un paquet réel de chaque type : Hello avec extensions,
+// TLV et clé Diffie-Hellman, NatTraversal IPv4 et IPv6, requêtes et Datum
+// d’un vrai arbre de Merkle (nœuds compressés compris), erreurs.
+func seedPackets(f *testing.F) [][]byte {
+	f.Helper()
+	priv, _, err := GenerateKeyPair()
+	if err != nil {
+		f.Fatal(err)
+	}
+	_, dhPub, err := GenerateKeyPair()
+	if err != nil {
+		f.Fatal(err)
+	}
+	ext, tlvs := NewNode(NodeConfig{Name: "fuzz"}).helloExtensions("peer", ^uint32(0))
+
+	// un petit arbre : un répertoire, un fichier compressible et un fichier de plusieurs chunks
+	dir := f.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "texte.txt"), bytes.Repeat([]byte("bonjour "), 100), 0644); err != nil {
+		f.Fatal(err)
+	}
+	big := make([]byte, 3*clientStorage.ChunkSize+17)
+	for i := range big {
+		big[i] = byte(i * 7)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "gros.bin"), big, 0644); err != nil {
+		f.Fatal(err)
+	}
+	store := clientStorage.NewStore()
+	rootNode, err := store.BuildMerkleNode(dir)
+	if err != nil {
+		f.Fatal(err)
+	}
+	root := clientStorage.Sha(rootNode)
+	nodes := [][]byte{rootNode}
+	for i := 0; i < len(nodes); i++ {
+		for _, h := range clientStorage.ListChildrenHashes(nodes[i]) {
+			hash, _ := hex.DecodeString(h)
+			if child, ok := store.FindHash(hash); ok {
+				nodes = append(nodes, child)
+			}
+		}
+	}
+
+	msgs := []Message{
+		&PingMsg{},
+		&OkMsg{},
+		&RootRequestMsg{},
+		&RootReplyMsg{Hash: root},
+		&DatumRequestMsg{Hash: root},
+		&NoDatumMsg{Hash: root},
+		&BatchDatumRequestMsg{Hashes: [][]byte{root, clientStorage.Sha(nodes[len(nodes)-1])}},
+		&SubtreeRequestMsg{MaxDepth: 4, MaxNodes: 64, MaxBytes: 65536, Hashes: [][]byte{root}},
+		&SubtreeEndMsg{Count: uint16(len(nodes))},
+		&HelloMsg{Extensions: ext, Name: "fuzz", TLVs: tlvs},
+		&HelloMsg{Reply: true, Extensions: ext | 1<<ExtensionChiffrement, Name: "fuzz", TLVs: tlvs, DHPub: SerializePublicKey(dhPub)},
+		&HelloMsg{Extensions: 1 << ExtensionNat, Name: "jch.irif.fr"},
+		&NatTraversalMsg{Addr: &net.UDPAddr{IP: net.IPv4(81, 194, 27, 155), Port: 8443}},
+		&NatTraversalMsg{Second: true, Addr: &net.UDPAddr{IP: net.ParseIP("2001:660:3301:9200::51c2:1b9b"), Port: 8443}},
+		&ErrorMsg{Code: ErrCodeNotAssociated, Text: "Please Hello First ! ;)"},
+		&ErrorMsg{Text: "erreur d’une autre implémentation"},
+	}
+	for _, node := range nodes {
+		msgs = append(msgs, &DatumMsg{Hash: clientStorage.Sha(node), Value: node})
+		if c := compressNode(node); c != nil {
+			msgs = append(msgs, &DatumMsg{Hash: clientStorage.Sha(node), Value: c})
+		}
+	}
+
+	var pkts [][]byte
+	for i, m := range msgs {
+		for _, sign := range []bool{false, true} {
+			pkt, err := EncodeMessage(uint32(i+1), m, priv, sign)
+			if err != nil {
+				f.Fatalf("%s : %v", MsgTypeName(m.MsgType()), err)
+			}
+			pkts = append(pkts, pkt)
+		}
+	}
+	return pkts
+}
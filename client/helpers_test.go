@@ -0,0 +1,276 @@
+package client_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"fmt"
+	"log/slog"
+	"myp2p/client"
+	"myp2p/clientStorage"
+	"myp2p/internal/testkeyserver"
+	"myp2p/logging"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Les tests de ce paquet font tourner de vrais Node sur un réseau simnet.
+// simnet importe client : ils sont donc dans le paquet client_test.
+
+// TestMain ne garde que les erreurs dans les logs, sauf si MYP2P_LOG est
+// défini (ex. MYP2P_LOG=transport=debug go test ./client).
+func TestMain(m *testing.M) {
+	if os.Getenv(logging.LevelsEnv) == "" {
+		logging.SetLevel("all", slog.LevelError)
+	}
+	os.Exit(m.Run())
+}
+
+//
+// ======================= NŒUDS DE TEST =======================
+//
+
+// testNode : un Node lancé sur une extrémité simnet
+type testNode struct {
+	*client.Node
+	conn   client.Transport
+	priv   *ecdsa.PrivateKey
+	events chan peerEvent
+	done   chan error // résultat de Run
+}
+
+// peerEvent : événement reçu par OnPeerEvent
+type peerEvent struct {
+	peer    string
+	event   client.PeerEventType
+	details string
+}
+
+// startNode crée un nœud sur conn avec le Store store (nil = vide), publie sa
+// clé et addr sur le serveur de clés puis le lance ; il est arrêté à la fin
+// du test.
+func startNode(t *testing.T, ks *testkeyserver.Server, name string, conn client.Transport, addr string, store *clientStorage.Store) *testNode {
+	t.Helper()
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ks.Register(name, &priv.PublicKey, addr)
+
+	tn := &testNode{
+		Node: client.NewNode(client.NodeConfig{
+			Name:    name,
+			Conn:    conn,
+			Priv:    priv,
+			Store:   store,
+			BanFile: filepath.Join(t.TempDir(), "bans.json"),
+		}),
+		conn:   conn,
+		priv:   priv,
+		events: make(chan peerEvent, 1024),
+		done:   make(chan error, 1),
+	}
+	tn.OnPeerEvent = func(peer *client.Peer, event client.PeerEventType, details string) {
+		select {
+		case tn.events <- peerEvent{peer.Name, event, details}:
+		default:
+		}
+	}
+	go func() { tn.done <- tn.Run(context.Background()) }()
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		tn.Shutdown(ctx)
+	})
+	return tn
+}
+
+// waitEvent attend un événement event concernant le peer name.
+func (tn *testNode) waitEvent(t *testing.T, name string, event client.PeerEventType, timeout time.Duration) peerEvent {
+	t.Helper()
+	deadline := time.After(timeout)
+	for {
+		select {
+		case e := <-tn.events:
+			if e.peer == name && e.event == event {
+				return e
+			}
+		case <-deadline:
+			t.Fatalf("%s : pas d’événement %s pour %s après %s", tn.Name, event, name, timeout)
+		}
+	}
+}
+
+// peerState renvoie l’état du peer name vu par tn.
+func (tn *testNode) peerState(name string) client.PeerState {
+	peer, ok := tn.FindPeer(name)
+	if !ok {
+		return client.PeerDiscovered
+	}
+	peer.Mupeer.RLock()
+	defer peer.Mupeer.RUnlock()
+	return peer.State
+}
+
+// associate fait découvrir a et b l’un à l’autre (comme le rafraîchissement
+// de la liste des peers) puis lance le Hello de a vers b, et attend que les
+// deux côtés soient associés.
+func associate(t *testing.T, a, b *testNode) {
+	t.Helper()
+	a.InitPeersMap([]string{b.Name})
+	b.InitPeersMap([]string{a.Name})
+	peer, ok := a.FindPeer(b.Name)
+	if !ok {
+		t.Fatalf("%s ne connaît pas %s", a.Name, b.Name)
+	}
+	if !a.HelloToPeer(a.conn, a.priv, peer) {
+		t.Fatalf("Hello de %s vers %s non envoyé", a.Name, b.Name)
+	}
+	a.waitEvent(t, b.Name, client.EventConnected, 5*time.Second)
+	waitFor(t, 5*time.Second, func() bool { return b.peerState(a.Name) == client.PeerAssociated })
+}
+
+// waitFor attend que cond soit vraie.
+func waitFor(t *testing.T, timeout time.Duration, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(timeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("condition non atteinte après %s", timeout)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// askRoot demande à b son root (RootRequest) et attend la réponse.
+func askRoot(t *testing.T, a, b *testNode) []byte {
+	t.Helper()
+	sendRootRequest(t, a, b)
+	peer, _ := a.FindPeer(b.Name)
+	a.waitEvent(t, b.Name, client.EventNewRoot, 5*time.Second)
+
+	peer.Mupeer.RLock()
+	defer peer.Mupeer.RUnlock()
+	return peer.Root
+}
+
+// sendRootRequest envoie un RootRequest de a à b sans attendre la réponse.
+func sendRootRequest(t *testing.T, a, b *testNode) {
+	t.Helper()
+	peer, _ := a.FindPeer(b.Name)
+	peer.Mupeer.RLock()
+	addr := peer.ActiveAddr
+	peer.Mupeer.RUnlock()
+
+	id := a.GenerateId()
+	msg, err := client.BuildMessage(id, client.RootRequest, []byte{}, a.priv, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.CreateTransaction(id, peer, addr, client.RootRequest, msg, client.Retries)
+	client.SendMessage(a.conn, addr, msg)
+}
+
+// startDownload lance le téléchargement de l’arbre root de b par a (comme la
+// commande ASK MERKLE), sans attendre sa fin.
+func startDownload(t *testing.T, a, b *testNode, root []byte) {
+	t.Helper()
+	peer, _ := a.FindPeer(b.Name)
+	peer.Mupeer.Lock()
+	addr := peer.ActiveAddr
+	peer.MerkleDownloadStart = time.Now()
+	peer.MerkleDone = false
+	peer.Mupeer.Unlock()
+
+	id := a.GenerateId()
+	msg, err := client.BuildDatumRequest(id, root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.CreateTransaction(id, peer, addr, client.DatumRequest, msg, client.Retries)
+	client.SendMessage(a.conn, addr, msg)
+}
+
+//
+// ======================= DONNÉES =======================
+//
+
+// makeTree écrit sous dir dirs répertoires de files fichiers de size octets
+// aléatoires et renvoie un Store contenant leur arbre, avec sa racine.
+func makeTree(t *testing.T, dir string, dirs, files, size int) (*clientStorage.Store, []byte) {
+	t.Helper()
+	for d := range dirs {
+		sub := filepath.Join(dir, fmt.Sprintf("dir%02d", d))
+		if err := os.MkdirAll(sub, 0755); err != nil {
+			t.Fatal(err)
+		}
+		for f := range files {
+			data := make([]byte, size+d*files+f)
+			rand.Read(data)
+			if err := os.WriteFile(filepath.Join(sub, fmt.Sprintf("file%02d.bin", f)), data, 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	store := clientStorage.NewStore()
+	node, err := store.BuildMerkleNode(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	root := clientStorage.Sha(node)
+	store.SetRoot(root)
+	return store, root
+}
+
+// sameFiles compare récursivement le contenu de deux répertoires.
+func sameFiles(t *testing.T, want, got string) {
+	t.Helper()
+	count := 0
+	err := filepath.Walk(want, func(path string, fi os.FileInfo, err error) error {
+		if err != nil || fi.IsDir() {
+			return err
+		}
+		rel, _ := filepath.Rel(want, path)
+		a, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		b, err := os.ReadFile(filepath.Join(got, rel))
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(a, b) {
+			return fmt.Errorf("%s : contenu différent", rel)
+		}
+		count++
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count == 0 {
+		t.Fatal("aucun fichier comparé")
+	}
+}
+
+// udpAddr convertit "ip:port" en *net.UDPAddr.
+func udpAddr(t *testing.T, s string) *net.UDPAddr {
+	t.Helper()
+	a, err := net.ResolveUDPAddr("udp", s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return a
+}
+
+// setVar donne à *p la valeur v le temps du test.
+func setVar[T any](t *testing.T, p *T, v T) {
+	old := *p
+	*p = v
+	t.Cleanup(func() { *p = old })
+}
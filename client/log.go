@@ -0,0 +1,21 @@
+package client
+
+import "myp2p/logging"
+
+//
+// ======================= JOURNALISATION =======================
+//
+
+// Un logger par sous-système ; leurs niveaux se règlent à chaud
+// (logging.SetLevel, commande CLI LOG, option -log, variable MYP2P_LOG).
+var (
+	transportLog = logging.For("transport")   // réception, envoi, routage et traitement des paquets
+	cryptoLog    = logging.For("crypto")      // signatures, clés, Diffie-Hellman
+	peerLog      = logging.For("peer")        // peers connus, connexion, maintenance
+	txLog        = logging.For("transaction") // transactions, retransmissions, fenêtre glissante
+	datumLog     = logging.For("datum")       // téléchargement des Datums
+	banLog       = logging.For("ban")         // bans et mauvais comportements
+	aclLog       = logging.For("acl")         // listes de contrôle d’accès
+	serverLog    = logging.For("server")      // API HTTP et handshake avec le serveur
+	nodeLog      = logging.For("node")        // cycle de vie du nœud
+)
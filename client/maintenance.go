@@ -2,20 +2,16 @@ package client
 
 import (
 	"crypto/ecdsa"
-	"fmt"
-	"log"
 	"net"
 	"time"
 )
 
-var debugMaintenance = true
-
 //
 // ======================= MAINTENANCE PÉRIODIQUE =======================
 //
 
 // Maintenance : gère les pings réguliers et la déconnexion des peers inactifs
-func MaintenancePerPeer(conn *net.UDPConn, priv *ecdsa.PrivateKey, peer *Peer) {
+func (n *Node) MaintenancePerPeer(conn Transport, priv *ecdsa.PrivateKey, peer *Peer) {
 
 	PingInterval := 1 * time.Minute // fréquence d'envoi du ping
 	timeout := 6 * time.Minute      // délai avant de déconnecter un peer inactif
@@ -27,27 +23,26 @@ func MaintenancePerPeer(conn *net.UDPConn, priv *ecdsa.PrivateKey, peer *Peer) {
 
 		now := time.Now() // timestamp actuel
 
-		if debugMaintenance {
-			fmt.Println("-> Début de la maintenance, peer de nom :", peer.Name)
-		}
+		peerLog.Debug("Début de la maintenance, peer de nom", "peer", peer.Name)
 		peer.Mupeer.RLock()
 		addr := peer.ActiveAddr
+		lastSeen := peer.LastSeen
 		peer.Mupeer.RUnlock()
 
-		id := GenerateId()
+		id := n.GenerateId()
 		// Envoyer le ping
 		sendGenericMessage(conn, priv, addr, id, Ping, []byte{}, false)
 
 		// Si pas de réponse depuis plus de timeout → déconnecter le peer
-		if now.Sub(peer.LastSeen) >= timeout {
-			if debug {
-				fmt.Println("Maintenance: Timeout mark disconnected:", peer.Name)
-			}
-			DeconnectPeer(peer)
+		if now.Sub(lastSeen) >= timeout {
+			peerLog.Debug("Maintenance: Timeout mark disconnected", "peer", peer.Name)
+			n.DeconnectPeer(peer)
 			return
 		}
 
-		time.Sleep(PingInterval)
+		if !n.sleep(PingInterval) {
+			return
+		}
 	}
 
 }
@@ -57,43 +52,41 @@ func MaintenancePerPeer(conn *net.UDPConn, priv *ecdsa.PrivateKey, peer *Peer) {
 //
 
 // AliveHTTPS envoie périodiquement des messages au serveur pour rester actif
-func KeepAlive(
-	conn *net.UDPConn,
+func (n *Node) KeepAlive(
+	conn Transport,
 	priv *ecdsa.PrivateKey,
 	pub *ecdsa.PublicKey,
 	addrServeur *net.UDPAddr,
 ) {
 	// on effectue le premier handshake avec le serveur
-	time.Sleep(200 * time.Millisecond)
+	if !n.sleep(200 * time.Millisecond) {
+		return
+	}
 	// Handshake périodique
-	if err := HandShakeWithServer(conn, priv, addrServeur); err != nil {
-		if debugMaintenance {
-			fmt.Println(" Erreur handshake périodique :", err)
-		}
+	if err := n.HandShakeWithServer(conn, priv, addrServeur); err != nil {
+		peerLog.Debug("Erreur handshake périodique", "err", err)
 	}
 	time.Sleep(200 * time.Millisecond)
 
 	for {
 		// on maintient la connexion toutes les 28min
-		time.Sleep(20 * time.Minute)
-
-		if debugMaintenance {
-			fmt.Println(" KeepAlive / Handshake périodique")
+		if !n.sleep(20 * time.Minute) {
+			return
 		}
 
+		peerLog.Debug("KeepAlive / Handshake périodique")
+
 		pubBytes := SerializePublicKey(pub)
-		if err := RegisterKey(NameofOurPeer, pubBytes); err != nil {
-			log.Fatal("Erreur lors de l'enregistrement du peer :", err)
+		if err := RegisterKey(n.Name, pubBytes); err != nil {
+			// on réessaiera au prochain tour plutôt que d'arrêter le programme
+			peerLog.Warn("Erreur lors de l'enregistrement du peer", "err", err)
+			continue
 		}
 		// Handshake périodique
-		if err := HandShakeWithServer(conn, priv, addrServeur); err != nil {
-			if debugMaintenance {
-				fmt.Println(" Erreur handshake périodique :", err)
-			}
+		if err := n.HandShakeWithServer(conn, priv, addrServeur); err != nil {
+			peerLog.Debug("Erreur handshake périodique", "err", err)
 		}
 
-		if debugMaintenance {
-			fmt.Println(" FINN KeepAlive / Handshake périodique")
-		}
+		peerLog.Debug("FINN KeepAlive / Handshake périodique")
 	}
 }
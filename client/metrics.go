@@ -0,0 +1,400 @@
+package client
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+//
+// ======================= MÉTRIQUES =======================
+//
+
+// Métriques du nœud, exposées au format texte de Prometheus :
+//   - compteurs mis à jour au fil de l’eau (paquets et octets par type et par
+//     adresse, échecs de signature et d’intégrité, retransmissions, expirations) ;
+//   - jauges calculées au moment de la lecture (fenêtres glissantes, taille du
+//     store, état des peers, transactions en vol, paquets jetés) ;
+//   - un histogramme des RTT mesurés sur les DatumRequest.
+// Les compteurs par adresse sont rattachés au nom du peer lors de la lecture.
+
+// Préfixe de toutes les métriques
+const metricsPrefix = "myp2p_"
+
+// Nombre d’adresses suivies au-delà duquel les octets sont comptés sous "other"
+const maxMetricAddrs = 4096
+
+// Bornes (en secondes) de l’histogramme des RTT
+var RTTBuckets = []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// addrBytes : octets échangés avec une adresse
+type addrBytes struct {
+	addr     *net.UDPAddr
+	sent     atomic.Uint64
+	received atomic.Uint64
+}
+
+// histogram : histogramme cumulatif à bornes fixes
+type histogram struct {
+	bounds []float64
+	counts []atomic.Uint64 // une case par borne, plus +Inf
+	sum    atomic.Uint64   // somme en nanosecondes
+	total  atomic.Uint64
+}
+
+func newHistogram(bounds []float64) *histogram {
+	return &histogram{bounds: bounds, counts: make([]atomic.Uint64, len(bounds)+1)}
+}
+
+func (h *histogram) observe(d time.Duration) {
+	i := sort.SearchFloat64s(h.bounds, d.Seconds())
+	h.counts[i].Add(1)
+	h.sum.Add(uint64(d))
+	h.total.Add(1)
+}
+
+// nodeMetrics : compteurs d’un nœud
+type nodeMetrics struct {
+	packetsSent      [256]atomic.Uint64 // par type de message
+	packetsReceived  [256]atomic.Uint64
+	sigFailures      atomic.Uint64
+	integrityFails   atomic.Uint64
+	retransmissions  atomic.Uint64
+	compressed       atomic.Uint64                      // Datum envoyés compressés
+	compressionSaved atomic.Uint64                      // octets économisés par la compression
+	expired          [256]atomic.Uint64                 // transactions expirées par type de message
+	errorsReceived   [len(errorCodeNames)]atomic.Uint64 // erreurs reçues par code
+	rtt              *histogram
+
+	bytesMu    sync.RWMutex
+	bytes      map[string]*addrBytes // clé : adresse "ip:port"
+	bytesOther addrBytes
+}
+
+func newNodeMetrics() *nodeMetrics {
+	return &nodeMetrics{rtt: newHistogram(RTTBuckets), bytes: map[string]*addrBytes{}}
+}
+
+// addrCounters renvoie les compteurs d’octets d’une adresse (créés au besoin).
+func (m *nodeMetrics) addrCounters(addr *net.UDPAddr) *addrBytes {
+	if addr == nil {
+		return &m.bytesOther
+	}
+	key := addr.String()
+	m.bytesMu.RLock()
+	c, ok := m.bytes[key]
+	m.bytesMu.RUnlock()
+	if ok {
+		return c
+	}
+	m.bytesMu.Lock()
+	defer m.bytesMu.Unlock()
+	if c, ok = m.bytes[key]; ok {
+		return c
+	}
+	if len(m.bytes) >= maxMetricAddrs {
+		return &m.bytesOther
+	}
+	c = &addrBytes{addr: addr}
+	m.bytes[key] = c
+	return c
+}
+
+// -----------------------------------------------------------------------------------------
+// meteredTransport compte les paquets et octets qui passent par le transport.
+type meteredTransport struct {
+	Transport
+	m *nodeMetrics
+}
+
+func (t meteredTransport) ReadFromUDP(b []byte) (int, *net.UDPAddr, error) {
+	size, addr, err := t.Transport.ReadFromUDP(b)
+	if err == nil {
+		if size > 4 {
+			t.m.packetsReceived[b[4]].Add(1)
+		}
+		t.m.addrCounters(addr).received.Add(uint64(size))
+	}
+	return size, addr, err
+}
+
+func (t meteredTransport) WriteToUDP(b []byte, addr *net.UDPAddr) (int, error) {
+	size, err := t.Transport.WriteToUDP(b, addr)
+	if err == nil {
+		if len(b) > 4 {
+			t.m.packetsSent[b[4]].Add(1)
+		}
+		t.m.addrCounters(addr).sent.Add(uint64(size))
+	}
+	return size, err
+}
+
+// -----------------------------------------------------------------------------------------
+// Points de mesure appelés par le reste du paquet
+
+func (n *Node) countSigFailure()       { n.metrics.sigFailures.Add(1) }
+func (n *Node) countIntegrityFailure() { n.metrics.integrityFails.Add(1) }
+func (n *Node) countRetransmission()   { n.metrics.retransmissions.Add(1) }
+func (n *Node) countExpired(t uint8)   { n.metrics.expired[t].Add(1) }
+
+func (n *Node) countErrorReceived(c ErrorCode) {
+	if int(c) < len(n.metrics.errorsReceived) {
+		n.metrics.errorsReceived[c].Add(1)
+	}
+}
+func (n *Node) observeRTT(rtt time.Duration) {
+	n.metrics.rtt.observe(rtt)
+}
+
+//
+// ======================= EXPOSITION =======================
+//
+
+// metricWriter écrit des familles de métriques au format texte de Prometheus.
+type metricWriter struct {
+	w *bufio.Writer
+}
+
+// family écrit les lignes HELP et TYPE d’une métrique.
+func (mw *metricWriter) family(name, typ, help string) {
+	fmt.Fprintf(mw.w, "# HELP %s%s %s\n# TYPE %s%s %s\n", metricsPrefix, name, help, metricsPrefix, name, typ)
+}
+
+// sample écrit une valeur ; labels alterne noms et valeurs.
+func (mw *metricWriter) sample(name string, value float64, labels ...string) {
+	mw.w.WriteString(metricsPrefix + name)
+	if len(labels) > 0 {
+		mw.w.WriteByte('{')
+		for i := 0; i+1 < len(labels); i += 2 {
+			if i > 0 {
+				mw.w.WriteByte(',')
+			}
+			mw.w.WriteString(labels[i] + `="` + escapeLabel(labels[i+1]) + `"`)
+		}
+		mw.w.WriteByte('}')
+	}
+	mw.w.WriteString(" " + formatValue(value) + "\n")
+}
+
+func escapeLabel(v string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(v)
+}
+
+func formatValue(v float64) string {
+	if math.IsInf(v, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// -----------------------------------------------------------------------------------------
+// WriteMetrics écrit toutes les métriques du nœud au format texte de Prometheus.
+// Paramètre :
+//   - w : destination (réponse HTTP, fichier…)
+//
+// Retour :
+//   - erreur d’écriture éventuelle
+func (n *Node) WriteMetrics(w io.Writer) error {
+	m := n.metrics
+	mw := &metricWriter{w: bufio.NewWriter(w)}
+
+	// ----- Paquets par type de message -----
+	for _, f := range []struct {
+		name, help string
+		counts     *[256]atomic.Uint64
+	}{
+		{"packets_sent_total", "Paquets émis, par type de message.", &m.packetsSent},
+		{"packets_received_total", "Paquets reçus, par type de message.", &m.packetsReceived},
+		{"transactions_expired_total", "Transactions abandonnées sans réponse, par type de message.", &m.expired},
+	} {
+		// les types non définis sont regroupés sous "unknown"
+		byName := map[string]uint64{}
+		for t := range f.counts {
+			if v := f.counts[t].Load(); v > 0 {
+				byName[MsgTypeName(uint8(t))] += v
+			}
+		}
+		names := make([]string, 0, len(byName))
+		for name := range byName {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		mw.family(f.name, "counter", f.help)
+		for _, name := range names {
+			mw.sample(f.name, float64(byName[name]), "type", name)
+		}
+	}
+
+	// ----- Octets par peer -----
+	sent, received := n.bytesByPeer()
+	peers := make([]string, 0, len(sent))
+	for name := range sent {
+		peers = append(peers, name)
+	}
+	sort.Strings(peers)
+	mw.family("bytes_sent_total", "counter", "Octets émis, par peer.")
+	for _, name := range peers {
+		mw.sample("bytes_sent_total", float64(sent[name]), "peer", name)
+	}
+	mw.family("bytes_received_total", "counter", "Octets reçus, par peer.")
+	for _, name := range peers {
+		mw.sample("bytes_received_total", float64(received[name]), "peer", name)
+	}
+
+	// ----- Erreurs et retransmissions -----
+	mw.family("signature_failures_total", "counter", "Signatures invalides ou impossibles à vérifier.")
+	mw.sample("signature_failures_total", float64(m.sigFailures.Load()))
+	mw.family("integrity_failures_total", "counter", "Datum rejetés (hash invalide ou déchiffrement impossible).")
+	mw.sample("integrity_failures_total", float64(m.integrityFails.Load()))
+	mw.family("retransmissions_total", "counter", "Requêtes renvoyées après un timeout.")
+	mw.sample("retransmissions_total", float64(m.retransmissions.Load()))
+	mw.family("datum_compressed_total", "counter", "Datum envoyés sous forme compressée.")
+	mw.sample("datum_compressed_total", float64(m.compressed.Load()))
+	mw.family("datum_compression_saved_bytes_total", "counter", "Octets économisés par la compression des Datum.")
+	mw.sample("datum_compression_saved_bytes_total", float64(m.compressionSaved.Load()))
+	mw.family("errors_received_total", "counter", "Erreurs reçues des peers, par code (\"none\" : texte libre).")
+	for c := range m.errorsReceived {
+		name := ErrorCode(c).String()
+		if name == "" {
+			name = "none"
+		}
+		mw.sample("errors_received_total", float64(m.errorsReceived[c].Load()), "code", name)
+	}
+
+	drops := n.DropStats()
+	mw.family("packets_dropped_total", "counter", "Paquets jetés, par raison.")
+	for r := DropReason(0); r < dropReasonCount; r++ {
+		mw.sample("packets_dropped_total", float64(drops[r.String()]), "reason", r.String())
+	}
+
+	// ----- RTT -----
+	mw.family("rtt_seconds", "histogram", "RTT des DatumRequest.")
+	var cumul uint64
+	for i, b := range m.rtt.bounds {
+		cumul += m.rtt.counts[i].Load()
+		mw.sample("rtt_seconds_bucket", float64(cumul), "le", formatValue(b))
+	}
+	cumul += m.rtt.counts[len(m.rtt.bounds)].Load()
+	mw.sample("rtt_seconds_bucket", float64(cumul), "le", "+Inf")
+	mw.sample("rtt_seconds_sum", time.Duration(m.rtt.sum.Load()).Seconds())
+	mw.sample("rtt_seconds_count", float64(m.rtt.total.Load()))
+
+	// ----- Jauges -----
+	list := n.ListPeers()
+	states := map[PeerState]int{}
+	mw.family("window_size", "gauge", "Taille de la fenêtre glissante, par peer.")
+	for _, p := range list {
+		p.Window.mu.RLock()
+		size := p.Window.Size
+		p.Window.mu.RUnlock()
+		mw.sample("window_size", float64(size), "peer", p.Name)
+	}
+	mw.family("window_in_flight", "gauge", "DatumRequest en vol, par peer.")
+	for _, p := range list {
+		p.Window.mu.RLock()
+		inFlight := p.Window.InFlight
+		p.Window.mu.RUnlock()
+		mw.sample("window_in_flight", float64(inFlight), "peer", p.Name)
+
+		p.Mupeer.RLock()
+		states[p.State]++
+		p.Mupeer.RUnlock()
+	}
+	mw.family("peers", "gauge", "Peers connus, par état.")
+	for s := PeerDiscovered; s <= PeerExpired; s++ {
+		mw.sample("peers", float64(states[s]), "state", s.String())
+	}
+	mw.family("store_nodes", "gauge", "Nœuds Merkle présents dans le store.")
+	mw.sample("store_nodes", float64(n.store.Len()))
+	mw.family("transactions_pending", "gauge", "Transactions en attente de réponse.")
+	mw.sample("transactions_pending", float64(n.pendingTransactions()))
+
+	return mw.w.Flush()
+}
+
+// bytesByPeer regroupe les compteurs d’octets par nom de peer ("unknown" pour
+// les adresses non rattachées, "other" au-delà de maxMetricAddrs).
+func (n *Node) bytesByPeer() (sent, received map[string]uint64) {
+	sent, received = map[string]uint64{}, map[string]uint64{}
+	n.metrics.bytesMu.RLock()
+	counters := make([]*addrBytes, 0, len(n.metrics.bytes))
+	for _, c := range n.metrics.bytes {
+		counters = append(counters, c)
+	}
+	n.metrics.bytesMu.RUnlock()
+
+	for _, c := range counters {
+		name := "unknown"
+		if n.serverAddr != nil && c.addr.String() == n.serverAddr.String() {
+			name = NameofServeurUDP
+		} else if p, ok := n.FindPeerByAddr(c.addr); ok {
+			name = p.Name
+		}
+		sent[name] += c.sent.Load()
+		received[name] += c.received.Load()
+	}
+	if s, r := n.metrics.bytesOther.sent.Load(), n.metrics.bytesOther.received.Load(); s+r > 0 {
+		sent["other"] += s
+		received["other"] += r
+	}
+	return sent, received
+}
+
+// -----------------------------------------------------------------------------------------
+// MetricsHandler renvoie un handler HTTP servant les métriques du nœud.
+func (n *Node) MetricsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		if err := n.WriteMetrics(w); err != nil {
+			nodeLog.Debug("écriture des métriques", "err", err)
+		}
+	})
+}
+
+// -----------------------------------------------------------------------------------------
+// ServeMetrics expose les métriques sur http://addr/metrics jusqu’à l’arrêt du nœud.
+// Seules les adresses de loopback sont acceptées : les métriques révèlent les
+// noms et l’activité des peers.
+// Paramètre :
+//   - addr : adresse d’écoute "ip:port" (ex. 127.0.0.1:9464)
+//
+// Retour :
+//   - erreur si l’adresse n’est pas locale ou si l’écoute échoue
+func (n *Node) ServeMetrics(addr string) error {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+		return errors.New("métriques : seule une adresse de loopback est autorisée (" + addr + ")")
+	}
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", n.MetricsHandler())
+	srv := &http.Server{Handler: mux, ReadHeaderTimeout: 5 * time.Second}
+
+	n.spawn(func() {
+		<-n.ctx.Done()
+		srv.Close()
+	})
+	go func() {
+		if err := srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			nodeLog.Warn("serveur de métriques arrêté", "err", err)
+		}
+	}()
+	nodeLog.Info("métriques disponibles", "url", "http://"+ln.Addr().String()+"/metrics")
+	return nil
+}
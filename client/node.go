@@ -0,0 +1,276 @@
+package client
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"errors"
+	"myp2p/clientStorage"
+	"net"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+//
+// ======================= NŒUD =======================
+//
+
+// Node regroupe tout l’état d’un peer : peers connus, transactions, bans, ACL,
+// files de traitement, compteurs et arbre de Merkle. Plusieurs Node peuvent
+// tourner dans un même processus (par exemple sur un réseau simnet) ; les
+// fonctions du paquet opèrent sur le nœud par défaut (cf. Default).
+type Node struct {
+	Name     string // nom sous lequel on s’annonce
+	BanFile  string // fichier de persistance des bans
+	StateDir string // répertoire de sauvegarde de l’état à l’arrêt ("" = pas de sauvegarde)
+
+	// OnPeerEvent est un callback optionnel appelé à chaque événement
+	// important concernant un peer (à définir avant Run).
+	OnPeerEvent PeerEventFunc
+
+	conn       Transport
+	priv       *ecdsa.PrivateKey
+	serverAddr *net.UDPAddr // adresse UDP du serveur (nil = pas de handshake)
+	store      *clientStorage.Store
+
+	// peers connus
+	peersMu sync.RWMutex
+	peers   map[string]*Peer
+
+	// nos 3 derniers roots
+	rootsMu sync.RWMutex
+	myRoots [][]byte
+
+	// transactions en vol
+	txMu         sync.Mutex
+	transactions map[uint32]*Transaction
+	globalId     uint32
+
+	// bans et mauvais comportements
+	banMu    sync.RWMutex
+	bans     map[string]*BanEntry
+	banSave  sync.Mutex // une sauvegarde de BanFile à la fois (cf. saveBans)
+	misMu    sync.Mutex
+	misScore map[string]*misbehaviourScore
+
+	// politique de chiffrement (cf. encryption.go)
+	encMu       sync.RWMutex
+	encPolicy   EncryptionPolicy            // politique globale
+	encPolicies map[string]EncryptionPolicy // politiques propres à un peer
+
+	// ACL
+	aclMu     sync.RWMutex
+	acl       *ACL                 // nil = pas d’ACL, tout est partagé (comportement historique)
+	audiences map[string]*audience // clé d’audience → arbre filtré
+
+	// files de traitement
+	requestChan  chan IncomingPacket
+	responseChan chan IncomingPacket
+	datumQueue   chan DatumJob
+
+	// limitation de débit
+	addrLimiter      *rateLimiter
+	peerLimiter      *rateLimiter
+	expensiveLimiter *rateLimiter
+	errorLimiter     *rateLimiter
+	dropCounters     [dropReasonCount]atomic.Uint64
+	metrics          *nodeMetrics
+	peerListMu       sync.Mutex
+	peerListLast     time.Time
+	peersETag        string // ETag pour cache HTTP de GET /peers/
+
+	// cycle de vie
+	runMu        sync.Mutex
+	running      bool
+	ctx          context.Context // annulé à l’arrêt : toutes les boucles s’arrêtent
+	cancel       context.CancelFunc
+	drain        chan struct{} // fermé au début de l’arrêt : plus de nouveaux téléchargements
+	shutdownOnce sync.Once
+	shutdownErr  error
+	wg           sync.WaitGroup
+}
+
+// NodeConfig : paramètres de création d’un nœud
+type NodeConfig struct {
+	Name       string               // nom du peer (défaut : NameofOurPeer)
+	Conn       Transport            // transport (UDP ou simnet)
+	Priv       *ecdsa.PrivateKey    // clé privée de signature
+	ServerAddr *net.UDPAddr         // adresse UDP du serveur (nil = pas de handshake ni KeepAlive)
+	Store      *clientStorage.Store // arbre de Merkle (défaut : un Store vide)
+	BanFile    string               // défaut : BanFile
+	StateDir   string               // répertoire de sauvegarde de l’état ("" = pas de sauvegarde)
+}
+
+// NewNode crée un nœud ; il ne traite aucun paquet avant l’appel à Run.
+func NewNode(cfg NodeConfig) *Node {
+	if cfg.Name == "" {
+		cfg.Name = NameofOurPeer
+	}
+	if cfg.Store == nil {
+		cfg.Store = clientStorage.NewStore()
+	}
+	if cfg.BanFile == "" {
+		cfg.BanFile = BanFile
+	}
+	metrics := newNodeMetrics()
+	if cfg.Conn != nil {
+		// tout le trafic passe par le transport : on le compte à la source
+		cfg.Conn = meteredTransport{Transport: cfg.Conn, m: metrics}
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Node{
+		Name:             cfg.Name,
+		BanFile:          cfg.BanFile,
+		StateDir:         cfg.StateDir,
+		conn:             cfg.Conn,
+		priv:             cfg.Priv,
+		serverAddr:       cfg.ServerAddr,
+		store:            cfg.Store,
+		peers:            map[string]*Peer{},
+		transactions:     map[uint32]*Transaction{},
+		bans:             map[string]*BanEntry{},
+		misScore:         map[string]*misbehaviourScore{},
+		audiences:        map[string]*audience{},
+		encPolicy:        DefaultEncryptionPolicy,
+		encPolicies:      map[string]EncryptionPolicy{},
+		requestChan:      make(chan IncomingPacket, RequestQueueSize),
+		responseChan:     make(chan IncomingPacket, ResponseQueueSize),
+		datumQueue:       make(chan DatumJob, DatumQueueSize),
+		addrLimiter:      newRateLimiter(&RateAddrPerSec, &RateAddrBurst),
+		peerLimiter:      newRateLimiter(&RatePeerPerSec, &RatePeerBurst),
+		expensiveLimiter: newRateLimiter(&RateExpensivePerSec, &RateExpensiveBurst),
+		errorLimiter:     newRateLimiter(&RateErrorPerSec, &RateErrorBurst),
+		metrics:          metrics,
+		ctx:              ctx,
+		cancel:           cancel,
+		drain:            make(chan struct{}),
+	}
+}
+
+// Store renvoie l’arbre de Merkle du nœud.
+func (n *Node) Store() *clientStorage.Store { return n.store }
+
+// Conn renvoie le transport du nœud.
+func (n *Node) Conn() Transport { return n.conn }
+
+// Context renvoie le contexte du nœud, annulé lorsqu’il est arrêté.
+func (n *Node) Context() context.Context { return n.ctx }
+
+// Done renvoie un canal fermé lorsque le nœud est arrêté.
+func (n *Node) Done() <-chan struct{} { return n.ctx.Done() }
+
+// -----------------------------------------------------------------------------------------
+// Run lance les routines du nœud (lecture du socket, traitement des requêtes et
+// des réponses, retransmissions, téléchargements, vérification des roots et,
+// si le serveur est configuré, handshake périodique) puis attend la fin de ctx.
+// Le nœud est arrêté proprement en sortie (cf. Shutdown).
+// Retour :
+//   - erreur si le nœud n’a pas de transport ou tourne déjà
+func (n *Node) Run(ctx context.Context) error {
+	if n.conn == nil || n.priv == nil {
+		return errors.New("node : transport ou clé privée manquant")
+	}
+	n.runMu.Lock()
+	if n.running {
+		n.runMu.Unlock()
+		return errors.New("node : déjà démarré")
+	}
+	n.running = true
+	n.runMu.Unlock()
+
+	n.spawn(func() { n.ResponseHandler(n.conn, n.priv) })
+	n.spawn(func() { n.RequestHandler(n.conn, n.priv) })
+	n.spawn(func() { n.CaptureMessage(n.conn, n.priv) })
+	n.spawn(func() { n.CleanupTransactionsLoop(n.conn, n.priv) })
+	n.spawn(func() { n.CheckRoots(n.conn, n.priv) })
+	n.spawn(func() { n.DatumScheduler(n.conn) })
+	if n.serverAddr != nil {
+		pub := &n.priv.PublicKey
+		n.spawn(func() { n.KeepAlive(n.conn, n.priv, pub, n.serverAddr) })
+	}
+
+	select {
+	case <-ctx.Done():
+	case <-n.ctx.Done():
+	}
+	return n.Shutdown(context.Background())
+}
+
+// spawn lance une routine suivie par Close.
+func (n *Node) spawn(f func()) {
+	n.wg.Add(1)
+	go func() {
+		defer n.wg.Done()
+		f()
+	}()
+}
+
+// Close arrête le nœud (cf. Shutdown).
+func (n *Node) Close() error {
+	return n.Shutdown(context.Background())
+}
+
+// sleep attend d, ou moins si le nœud est arrêté entre-temps.
+// Retour :
+//   - false si le nœud a été arrêté
+func (n *Node) sleep(d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-n.ctx.Done():
+		return false
+	}
+}
+
+//
+// ======================= ACCESSEURS =======================
+//
+
+// ListPeers renvoie les peers connus, triés par nom.
+func (n *Node) ListPeers() []*Peer {
+	n.peersMu.RLock()
+	list := make([]*Peer, 0, len(n.peers))
+	for _, p := range n.peers {
+		list = append(list, p)
+	}
+	n.peersMu.RUnlock()
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+// PeerNames renvoie les noms des peers connus, triés.
+func (n *Node) PeerNames() []string {
+	peers := n.ListPeers()
+	names := make([]string, len(peers))
+	for i, p := range peers {
+		names[i] = p.Name
+	}
+	return names
+}
+
+// MyRoots renvoie nos derniers roots (le plus ancien en premier).
+func (n *Node) MyRoots() [][]byte {
+	n.rootsMu.RLock()
+	defer n.rootsMu.RUnlock()
+	return append([][]byte(nil), n.myRoots...)
+}
+
+// PushMyRoot ajoute un root à notre historique (3 au maximum) et l’annonce
+// aux peers associés qui ont négocié ExtensionRootPush.
+func (n *Node) PushMyRoot(hash []byte) {
+	roots := n.AddListRoot(n.MyRoots(), hash)
+	n.rootsMu.Lock()
+	n.myRoots = roots
+	n.rootsMu.Unlock()
+	n.pushRoot()
+}
+
+// EmitPeerEvent appelle OnPeerEvent s’il est défini.
+func (n *Node) EmitPeerEvent(peer *Peer, event PeerEventType, details string) {
+	if n.OnPeerEvent != nil {
+		n.OnPeerEvent(peer, event, details)
+	}
+}
@@ -0,0 +1,299 @@
+package client
+
+import (
+	"crypto/ecdsa"
+	"myp2p/clientStorage"
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+//
+// ======================= NŒUD PAR DÉFAUT =======================
+//
+
+// Le nœud par défaut porte l’état des fonctions du paquet ; il partage l’arbre
+// de Merkle de clientStorage.Default. Le programme principal le remplace par
+// son propre nœud via SetDefault avant de lancer Run.
+var defaultNode atomic.Pointer[Node]
+
+func init() {
+	defaultNode.Store(NewNode(NodeConfig{Store: clientStorage.Default}))
+}
+
+// Default renvoie le nœud par défaut.
+func Default() *Node { return defaultNode.Load() }
+
+// SetDefault remplace le nœud par défaut.
+func SetDefault(n *Node) { defaultNode.Store(n) }
+
+// ListPeers renvoie les peers du nœud par défaut, triés par nom.
+func ListPeers() []*Peer { return Default().ListPeers() }
+
+// PeerNames renvoie les noms des peers du nœud par défaut, triés.
+func PeerNames() []string { return Default().PeerNames() }
+
+// MyRoots renvoie nos derniers roots.
+func MyRoots() [][]byte { return Default().MyRoots() }
+
+// PushMyRoot ajoute un root à notre historique.
+func PushMyRoot(hash []byte) { Default().PushMyRoot(hash) }
+
+// EmitPeerEvent déclenche OnPeerEvent sur le nœud par défaut.
+func EmitPeerEvent(peer *Peer, event PeerEventType, details string) {
+	Default().EmitPeerEvent(peer, event, details)
+}
+
+//
+// ======================= FONCTIONS DU PAQUET =======================
+//
+
+// Chacune délègue à la méthode de même nom du nœud par défaut.
+
+// ----- acl.go -----
+
+func LoadACL(path string) error { return Default().LoadACL(path) }
+
+func SaveACL(path string) error { return Default().SaveACL(path) }
+
+func SetACL(a *ACL) { Default().SetACL(a) }
+
+func GetACL() *ACL { return Default().GetACL() }
+
+func SetGrant(subject string, paths []string) { Default().SetGrant(subject, paths) }
+
+func SetGroup(group string, members []string) { Default().SetGroup(group, members) }
+
+func RootForAddr(addr *net.UDPAddr) []byte { return Default().RootForAddr(addr) }
+
+func CanServeHash(addr *net.UDPAddr, hash []byte) bool { return Default().CanServeHash(addr, hash) }
+
+// ----- ban.go -----
+
+func LoadBans(path string) error { return Default().LoadBans(path) }
+
+func AddBan(peer *Peer, reason string, duration time.Duration) {
+	Default().AddBan(peer, reason, duration)
+}
+
+func BanName(name string, reason string, duration time.Duration) {
+	Default().BanName(name, reason, duration)
+}
+
+func DelBan(name string) { Default().DelBan(name) }
+
+func ListBans() []BanEntry { return Default().ListBans() }
+
+func IsBan(name string) bool { return Default().IsBan(name) }
+
+func IsBanByaddr(addr *net.UDPAddr) bool { return Default().IsBanByaddr(addr) }
+
+func ReportMisbehaviour(addr *net.UDPAddr, kind Misbehaviour) {
+	Default().ReportMisbehaviour(addr, kind)
+}
+
+// ----- batch.go -----
+
+func HandleBatchDatumRequestWrapper(conn Transport, priv *ecdsa.PrivateKey, id uint32, addr *net.UDPAddr, hashes [][]byte) {
+	Default().HandleBatchDatumRequestWrapper(conn, priv, id, addr, hashes)
+}
+
+func HandleBatchDatumRequest(conn Transport, priv *ecdsa.PrivateKey, addr *net.UDPAddr, id uint32, hashes [][]byte) {
+	Default().HandleBatchDatumRequest(conn, priv, addr, id, hashes)
+}
+
+// ----- crypto.go -----
+
+func VerifSign(addr *net.UDPAddr, message []byte, sig []byte) bool {
+	return Default().VerifSign(addr, message, sig)
+}
+
+// ----- datum.go -----
+
+func DatumScheduler(conn Transport) { Default().DatumScheduler(conn) }
+
+func HandlefileDataWindow(datum *DatumMsg, conn Transport, addr *net.UDPAddr) {
+	Default().HandlefileDataWindow(datum, conn, addr)
+}
+
+func HandleDatumRequest(conn Transport, priv *ecdsa.PrivateKey, addr *net.UDPAddr, id uint32, hash []byte) {
+	Default().HandleDatumRequest(conn, priv, addr, id, hash)
+}
+
+func RebuildFromPeer(peer *Peer, hash []byte, path string) error {
+	return Default().RebuildFromPeer(peer, hash, path)
+}
+
+func CheckRoots(conn Transport, priv *ecdsa.PrivateKey) { Default().CheckRoots(conn, priv) }
+
+// ----- dispatcher.go -----
+
+func CaptureMessage(conn Transport, priv *ecdsa.PrivateKey) { Default().CaptureMessage(conn, priv) }
+
+func Routeur(pkt []byte, addr *net.UDPAddr, conn Transport, priv *ecdsa.PrivateKey) {
+	Default().Routeur(pkt, addr, conn, priv)
+}
+
+// ----- encryption.go -----
+
+func EncryptionPolicyFor(name string) EncryptionPolicy { return Default().EncryptionPolicy(name) }
+
+func EncryptionPolicies() (EncryptionPolicy, map[string]EncryptionPolicy) {
+	return Default().EncryptionPolicies()
+}
+
+func SetEncryptionPolicy(name string, p EncryptionPolicy) { Default().SetEncryptionPolicy(name, p) }
+
+func ClearEncryptionPolicy(name string) { Default().ClearEncryptionPolicy(name) }
+
+func EncryptionState(peer *Peer) string { return Default().EncryptionState(peer) }
+
+// ----- maintenance.go -----
+
+func MaintenancePerPeer(conn Transport, priv *ecdsa.PrivateKey, peer *Peer) {
+	Default().MaintenancePerPeer(conn, priv, peer)
+}
+
+func KeepAlive(conn Transport, priv *ecdsa.PrivateKey, pub *ecdsa.PublicKey, addrServeur *net.UDPAddr) {
+	Default().KeepAlive(conn, priv, pub, addrServeur)
+}
+
+// ----- peer.go -----
+
+func AddPeer(name string, addr *net.UDPAddr, key *ecdsa.PublicKey, connected PeerState) (*Peer, bool) {
+	return Default().AddPeer(name, addr, key, connected)
+}
+
+func FindPeer(name string) (*Peer, bool) { return Default().FindPeer(name) }
+
+func FindPeerByAddr(addr *net.UDPAddr) (*Peer, bool) { return Default().FindPeerByAddr(addr) }
+
+func InitPeersMap(names []string) { Default().InitPeersMap(names) }
+
+func AddRootToPeer(peer *Peer, hash []byte) error { return Default().AddRootToPeer(peer, hash) }
+
+func AddRootToPeerbyaddr(addr *net.UDPAddr, hash []byte) error {
+	return Default().AddRootToPeerbyaddr(addr, hash)
+}
+
+func AddListRoot(listRoots [][]byte, newRoot []byte) [][]byte {
+	return Default().AddListRoot(listRoots, newRoot)
+}
+
+func GetNameByAddr(addr *net.UDPAddr) (string, bool) { return Default().GetNameByAddr(addr) }
+
+func RefreshPeers(peerNames []string) { Default().RefreshPeers(peerNames) }
+
+func DeletePeer(name string) { Default().DeletePeer(name) }
+
+func DeconnectPeer(p *Peer) { Default().DeconnectPeer(p) }
+
+// ----- ratelimit.go -----
+
+func DropStats() map[string]uint64 { return Default().DropStats() }
+
+// ----- requestHandler.go -----
+
+func RequestHandler(conn Transport, priv *ecdsa.PrivateKey) { Default().RequestHandler(conn, priv) }
+
+func HandleNatTraversalRequest(conn Transport, priv *ecdsa.PrivateKey, id uint32, target *net.UDPAddr, addr *net.UDPAddr, signed []byte, sig []byte) {
+	Default().HandleNatTraversalRequest(conn, priv, id, target, addr, signed, sig)
+}
+
+func HandleNatTraversalRequest2(conn Transport, priv *ecdsa.PrivateKey, id uint32, target *net.UDPAddr, addr *net.UDPAddr, signed []byte, sig []byte) {
+	Default().HandleNatTraversalRequest2(conn, priv, id, target, addr, signed, sig)
+}
+
+func HandleRootRequest(conn Transport, priv *ecdsa.PrivateKey, id uint32, addr *net.UDPAddr) {
+	Default().HandleRootRequest(conn, priv, id, addr)
+}
+
+func HandlePing(conn Transport, priv *ecdsa.PrivateKey, id uint32, addr *net.UDPAddr) {
+	Default().HandlePing(conn, priv, id, addr)
+}
+
+func HandleDatumRequestWrapper(conn Transport, priv *ecdsa.PrivateKey, id uint32, addr *net.UDPAddr, hash []byte) {
+	Default().HandleDatumRequestWrapper(conn, priv, id, addr, hash)
+}
+
+func HandleHelloRequest(conn Transport, priv *ecdsa.PrivateKey, addr *net.UDPAddr, id uint32, hello *HelloMsg, signed []byte, sig []byte) {
+	Default().HandleHelloRequest(conn, priv, addr, id, hello, signed, sig)
+}
+
+// ----- responseHandler.go -----
+
+func ResponseHandler(conn Transport, priv *ecdsa.PrivateKey) { Default().ResponseHandler(conn, priv) }
+
+func HandleRootReply(id uint32, addr *net.UDPAddr, signed []byte, sig []byte, hash []byte) {
+	Default().HandleRootReply(id, addr, signed, sig, hash)
+}
+
+func HandleOk(id uint32, addr *net.UDPAddr) { Default().HandleOk(id, addr) }
+
+func HandleErrorReply(id uint32, conn Transport, priv *ecdsa.PrivateKey, addr *net.UDPAddr, e *ErrorMsg) {
+	Default().HandleErrorReply(id, conn, priv, addr, e)
+}
+
+func HandleDatum(id uint32, addr *net.UDPAddr, datum *DatumMsg) {
+	Default().HandleDatum(id, addr, datum)
+}
+
+func HandleNoDatum(id uint32, addr *net.UDPAddr, hash []byte, signed []byte, sig []byte) {
+	Default().HandleNoDatum(id, addr, hash, signed, sig)
+}
+
+func HandleHelloReply(id uint32, conn Transport, priv *ecdsa.PrivateKey, reply *HelloMsg, signed []byte, sig []byte) error {
+	return Default().HandleHelloReply(id, conn, priv, reply, signed, sig)
+}
+
+// ----- sendAndBuildPacket.go -----
+
+func GenerateId() uint32 { return Default().GenerateId() }
+
+func TryNatTraversal(conn Transport, priv *ecdsa.PrivateKey, peer *Peer) bool {
+	return Default().TryNatTraversal(conn, priv, peer)
+}
+
+func HelloToPeer(conn Transport, priv *ecdsa.PrivateKey, peer *Peer) bool {
+	return Default().HelloToPeer(conn, priv, peer)
+}
+
+func SendHello(conn Transport, priv *ecdsa.PrivateKey, peer *Peer) bool {
+	return Default().SendHello(conn, priv, peer)
+}
+
+// ----- server_api.go -----
+
+func GetPeerListIfChanged() ([]string, bool, error) { return Default().GetPeerListIfChanged() }
+
+func HandShakeWithServer(conn Transport, priv *ecdsa.PrivateKey, addrServeur *net.UDPAddr) error {
+	return Default().HandShakeWithServer(conn, priv, addrServeur)
+}
+
+// ----- subtree.go -----
+
+func HandleSubtreeRequestWrapper(conn Transport, priv *ecdsa.PrivateKey, id uint32, addr *net.UDPAddr, m *SubtreeRequestMsg) {
+	Default().HandleSubtreeRequestWrapper(conn, priv, id, addr, m)
+}
+
+func HandleSubtreeRequest(conn Transport, priv *ecdsa.PrivateKey, addr *net.UDPAddr, id uint32, m *SubtreeRequestMsg) {
+	Default().HandleSubtreeRequest(conn, priv, addr, id, m)
+}
+
+func HandleSubtreeEnd(id uint32, addr *net.UDPAddr, count uint16) {
+	Default().HandleSubtreeEnd(id, addr, count)
+}
+
+// ----- transaction.go -----
+
+func CleanupTransactionsLoop(conn Transport, priv *ecdsa.PrivateKey) {
+	Default().CleanupTransactionsLoop(conn, priv)
+}
+
+func CleanupTransactions(conn Transport, priv *ecdsa.PrivateKey) {
+	Default().CleanupTransactions(conn, priv)
+}
+
+func CreateTransaction(id uint32, p *Peer, addr *net.UDPAddr, msgType uint8, msg []byte, retries int) *Transaction {
+	return Default().CreateTransaction(id, p, addr, msgType, msg, retries)
+}
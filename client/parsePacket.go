@@ -2,12 +2,10 @@ package client
 
 import (
 	"encoding/binary"
+	"errors"
 	"fmt"
-	"net"
 )
 
-var debugParse = false
-
 /*
 0..3   : Id        (4 bytes)
 4      : Type      (1 byte)
@@ -32,120 +30,77 @@ const (
 
 	// Taille minimale sans body ni signature
 	HeaderSize = OffsetBody
+
+	// Taille maximale d’un body (champ longueur sur 2 octets)
+	MaxBodySize = 1<<16 - 1
 )
 
-// ------------------------------------------------------------------------------------
-// getID renvoie l'ID d'un paquet
-func getID(pkt []byte) (uint32, bool) {
-	if len(pkt) < HeaderSize {
-		return 0, false
-	}
-	return binary.BigEndian.Uint32(pkt[OffsetID : OffsetID+SizeID]), true
-}
+// Erreurs de décodage (à tester avec errors.Is)
+var (
+	ErrMalformed   = errors.New("paquet malformé")
+	ErrUnknownType = errors.New("type de message inconnu")
+)
 
-// ------------------------------------------------------------------------------------
-// getType renvoie le type d'un paquet
-func getType(pkt []byte) (uint8, bool) {
-	if len(pkt) < OffsetLength {
-		return 0, false
-	}
-	return pkt[OffsetType], true
-}
+//
+// ======================= DÉCOUPAGE D’UN PAQUET =======================
+//
 
-// ------------------------------------------------------------------------------------
-// getBody renvoie le corps du message et sa longueur
-func getBody(pkt []byte) ([]byte, int, bool) {
-	if len(pkt) < HeaderSize {
-		return nil, 0, false
-	}
-	bodyLen := int(pkt[OffsetLength])<<8 | int(pkt[EndLength])
-	if len(pkt) < HeaderSize+bodyLen {
-		if debugParse {
-			fmt.Println("Paquet body déclaré plus long que reçu, ignoré")
-		}
-		return nil, 0, false
-	}
-	body := pkt[OffsetBody : OffsetBody+bodyLen]
-	return body, bodyLen, true
+// Packet : paquet découpé en en-tête, body et signature. Les tranches
+// pointent dans le paquet d’origine (aucune copie).
+type Packet struct {
+	ID     uint32
+	Type   uint8
+	Body   []byte
+	Signed []byte // en-tête + body : partie couverte par la signature
+	Sig    []byte // signature (nil si le paquet n’est pas signé)
 }
 
 // ------------------------------------------------------------------------------------
-// getSigned renvoie le segment signé (jusqu'à la fin du body)
-func getSigned(pkt []byte) ([]byte, bool) {
+// ParsePacket découpe un paquet reçu ; c’est le seul point d’entrée des octets
+// venant du réseau. Après le body, seuls 0 (paquet non signé) ou 64 octets
+// (signature) sont acceptés.
+// Paramètre :
+//   - pkt : datagramme reçu
+//
+// Retour :
+//   - le paquet découpé
+//   - erreur (ErrMalformed) si l’en-tête, la longueur ou la signature sont incohérents
+func ParsePacket(pkt []byte) (Packet, error) {
 	if len(pkt) < HeaderSize {
-		return nil, false
-	}
-	bodyLen := int(pkt[OffsetLength])<<8 | int(pkt[EndLength])
-	if len(pkt) < HeaderSize+bodyLen {
-		return nil, false
+		return Packet{}, fmt.Errorf("%w : %d octets, en-tête incomplet", ErrMalformed, len(pkt))
 	}
-	signed := pkt[:OffsetBody+bodyLen]
-
-	return signed, true
-}
-
-// ------------------------------------------------------------------------------------
-// getSig renvoie la signature si elle existe
-func getSig(pkt []byte) ([]byte, bool) {
-	bodyLen := int(pkt[OffsetLength])<<8 | int(pkt[EndLength])
-	if len(pkt) < HeaderSize+bodyLen+SizeSignature {
-		return nil, false
-	}
-	sig := pkt[OffsetBody+bodyLen : OffsetBody+bodyLen+SizeSignature]
-	return sig, true
-}
-
-// ------------------------------------------------------------------------------------
-// parseRecvMessage peut maintenant être simplifiée en appelant les utilitaires
-func parseRecvMessage(pkt []byte) (uint32, uint8, int, []byte, []byte, []byte, bool) {
-	id, ok := getID(pkt)
-	if !ok {
-		return 0, 0, 0, nil, nil, nil, false
+	bodyLen := int(binary.BigEndian.Uint16(pkt[OffsetLength : EndLength+1]))
+	end := HeaderSize + bodyLen
+	if len(pkt) < end {
+		return Packet{}, fmt.Errorf("%w : body déclaré de %d octets, %d reçus", ErrMalformed, bodyLen, len(pkt)-HeaderSize)
 	}
-	typ, ok := getType(pkt)
-	if !ok {
-		return 0, 0, 0, nil, nil, nil, false
+	p := Packet{
+		ID:     binary.BigEndian.Uint32(pkt[OffsetID : OffsetID+SizeID]),
+		Type:   pkt[OffsetType],
+		Body:   pkt[OffsetBody:end],
+		Signed: pkt[:end],
 	}
-	body, bodyLen, ok := getBody(pkt)
-	if !ok {
-		return 0, 0, 0, nil, nil, nil, false
-	}
-	signed, ok1 := getSigned(pkt)
-	sig, ok2 := getSig(pkt)
-
-	if !ok1 || !ok2 {
-		if debugParse {
-			fmt.Println("le paquet est non signé !")
-		}
-		return id, typ, bodyLen, body, nil, nil, true
+	switch len(pkt) - end {
+	case 0:
+	case SizeSignature:
+		p.Sig = pkt[end:]
+	default:
+		return Packet{}, fmt.Errorf("%w : %d octets après le body", ErrMalformed, len(pkt)-end)
 	}
-
-	return id, typ, bodyLen, body, signed, sig, true
+	return p, nil
 }
 
-// ------------------------------------------------------------------------------------
-// ParseNATBody parse le body d'un NatTraversalRequest ou NatTraversalRequest2
-// et retourne une *net.UDPAddr correspondant à l'adresse cible.
-func ParseNATBody(body []byte, len uint8) (*net.UDPAddr, error) {
-	switch len {
-	case 6: // IPv4
-		ip := net.IP(body[:4])
-		port := int(binary.BigEndian.Uint16(body[4:6]))
-		return &net.UDPAddr{
-			IP:   ip,
-			Port: port,
-		}, nil
-	case 18: // IPv6
-		ip := net.IP(body[:16])
-		port := int(binary.BigEndian.Uint16(body[16:18]))
-		return &net.UDPAddr{
-			IP:   ip,
-			Port: port,
-		}, nil
-	default:
-		if debugParse {
-			fmt.Println("unexpected NAT body length")
-		}
-		return nil, fmt.Errorf("unexpected NAT body length: %d", len)
+// Message décode le body selon le type du paquet (cf. codec.go).
+// Retour :
+//   - le message typé (*HelloMsg, *DatumMsg…)
+//   - ErrUnknownType pour un type non défini, ErrMalformed pour un body invalide
+func (p Packet) Message() (Message, error) {
+	m := newMessage(p.Type)
+	if m == nil {
+		return nil, fmt.Errorf("%w : %d", ErrUnknownType, p.Type)
+	}
+	if err := m.UnmarshalBody(p.Body); err != nil {
+		return nil, err
 	}
+	return m, nil
 }
@@ -5,24 +5,11 @@ import (
 	"crypto/ecdsa"
 	"encoding/hex"
 	"fmt"
-	"myp2p/clientStorage"
 	"net"
 	"sync"
 	"time"
 )
 
-//
-// ======================= VARIABLES GLOBALES =======================
-//
-
-// Mutex pour protéger l'accès concurrent aux maps
-var (
-	PeersMu sync.RWMutex
-	Peers   = map[string]*Peer{} // Tous les peers connus
-	ban     = map[string]*Peer{} // Liste des peers bannis
-	banMu   sync.RWMutex
-)
-
 type PeerState int
 
 const (
@@ -32,15 +19,24 @@ const (
 	PeerExpired                       // association expirée
 )
 
-// Liste de nos roots racine
-var MyListroots [][]byte // nos 3 derniers roots choisi
+func (s PeerState) String() string {
+	switch s {
+	case PeerDiscovered:
+		return "discovered"
+	case PeerWaitHelloNat:
+		return "wait_hello_nat"
+	case PeerAssociated:
+		return "associated"
+	case PeerExpired:
+		return "expired"
+	}
+	return "unknown"
+}
 
 //
 // ======================= STRUCTURE D’UN PEER =======================
 //
 
-var debugPeer = true
-
 // Peer représente un noeud connu dans le réseau
 type Peer struct {
 	Name                string       // Nom unique du peer
@@ -57,75 +53,51 @@ type Peer struct {
 	MerkleDone          bool          // Merkle Terminé ou non
 	RootChanged         bool          // le peer a récemment changé son arborescence
 	State               PeerState     // état du peer
+	LastError           *ErrorMsg     // dernière erreur reçue du peer (nil = aucune)
+	Extensions          uint32        // extensions annoncées par le peer dans son dernier Hello
+	Capabilities        uint32        // extensions annoncées des deux côtés (cf. Has)
+	Version             uint16        // version du protocole négociée (0 = protocole d'origine)
 	Mupeer              sync.RWMutex  // Mutex
+
+	helloRetryAt time.Time // dernier Hello refait sur erreur not-associated
 }
 
 //
 // ======================= UTILITAIRES DE NOM ET ADRESSES =======================
 //
 
-// Extraction du nom du peer depuis le body d'un Hello
-func ExtractPeerName(body []byte) (string, error) {
-	encrypted := IsChiffrementEnabled(body)
-	if len(body) < ExtensionField {
-		return "", fmt.Errorf("body trop court pour extensions")
-	}
-	var checksrv bool
-
-	// Si la longueur du body est supérieure ou égale à 16, on vérifie la correspondance avec NameofServeurUDP
-	if len(body) >= 4+len(NameofServeurUDP) {
-		if bytes.Equal(body[ExtensionField:ExtensionField+len(NameofServeurUDP)], []byte(NameofServeurUDP)) {
-			checksrv = true
-		}
-	} else {
-		checksrv = false
-	}
-
-	var nameBytes []byte
-	if encrypted == false || checksrv {
-		nameBytes = body[ExtensionField:]
-	} else {
-		nameBytes = body[ExtensionField : len(body)-SizeSignature]
-	}
-
-	// retirer padding 0 éventuels
-	i := bytes.IndexByte(nameBytes, 0)
-	if i >= 0 {
-		nameBytes = nameBytes[:i]
-	}
-
-	return string(nameBytes), nil
-}
-
 // Ajouter un peer dans la liste
 // le boolean retourner signifie oui il a été ajouté non il a juste été modifié mais il existait déjà
-func AddPeer(name string, addr *net.UDPAddr, key *ecdsa.PublicKey, connected PeerState) (*Peer, bool) {
-	PeersMu.Lock()
-	defer PeersMu.Unlock()
-	p, exists := Peers[name]
+func (n *Node) AddPeer(name string, addr *net.UDPAddr, key *ecdsa.PublicKey, connected PeerState) (*Peer, bool) {
+	n.peersMu.Lock()
+	defer n.peersMu.Unlock()
+	p, exists := n.peers[name]
 	add := false
 	if !exists {
-		p = &Peer{Name: name}
-		Peers[name] = p
+		// la fenêtre n'est créée qu'une fois : un nouveau Hello ne la remplace
+		// pas, les requêtes en vol continuent d'y être comptées
+		p = &Peer{Name: name, Window: NewSlidingWindow(
+			1,     // min
+			32,    // initial
+			10000, // max
+		)}
+		n.peers[name] = p
 		add = true
 	}
+	p.Mupeer.Lock()
 	p.AddrIndex = 0
 	p.ActiveAddr = addr
 	p.PublicKey = key
 	p.LastSeen = time.Now()
 	p.State = connected
-	p.Window = NewSlidingWindow(
-		1,     // min
-		32,    // initial
-		10000, // max
-	)
+	p.Mupeer.Unlock()
 	return p, add
 }
 
-func FindPeer(name string) (*Peer, bool) {
-	PeersMu.RLock()
-	p, exists := Peers[name]
-	PeersMu.RUnlock()
+func (n *Node) FindPeer(name string) (*Peer, bool) {
+	n.peersMu.RLock()
+	p, exists := n.peers[name]
+	n.peersMu.RUnlock()
 	return p, exists
 }
 
@@ -196,19 +168,24 @@ func sameUDPAddr(a, b *net.UDPAddr) bool {
 //     → Typiquement : NAT traversal, probing, ou tentative initiale
 //
 // NE MODIFIE PAS le peer (pas d'effet de bord).
-func FindPeerByAddr(addr *net.UDPAddr) (*Peer, bool) {
+func (n *Node) FindPeerByAddr(addr *net.UDPAddr) (*Peer, bool) {
 	if addr == nil {
 		return nil, false
 	}
 
-	PeersMu.RLock()
-	defer PeersMu.RUnlock()
+	// ActiveAddr et Addresses sont protégés par le verrou de chaque peer :
+	// on travaille sur une copie de la liste pour ne pas imbriquer peersMu
+	// et Mupeer
+	peers := n.ListPeers()
 
 	// -----------------------------
 	// 1) Recherche sur ActiveAddr
 	// -----------------------------
-	for _, p := range Peers {
-		if p.ActiveAddr != nil && sameUDPAddr(p.ActiveAddr, addr) {
+	for _, p := range peers {
+		p.Mupeer.RLock()
+		active := p.ActiveAddr
+		p.Mupeer.RUnlock()
+		if active != nil && sameUDPAddr(active, addr) {
 			return p, true
 		}
 	}
@@ -216,11 +193,14 @@ func FindPeerByAddr(addr *net.UDPAddr) (*Peer, bool) {
 	// -----------------------------------
 	// 2) Recherche dans Addresses[]
 	// -----------------------------------
-	for _, p := range Peers {
-		for _, s := range p.Addresses {
+	for _, p := range peers {
+		p.Mupeer.RLock()
+		addresses := p.Addresses
+		p.Mupeer.RUnlock()
+		for _, s := range addresses {
 			udpAddr, err := net.ResolveUDPAddr("udp", s)
 			if err != nil {
-				fmt.Println("erreur de resolution de l'addresse")
+				peerLog.Warn("erreur de resolution de l'addresse")
 				continue
 			}
 			if sameUDPAddr(udpAddr, addr) {
@@ -232,6 +212,27 @@ func FindPeerByAddr(addr *net.UDPAddr) (*Peer, bool) {
 	return nil, false
 }
 
+// Has indique si l'extension bit a été négociée avec le peer (annoncée des deux côtés).
+func (p *Peer) Has(bit uint8) bool {
+	p.Mupeer.RLock()
+	defer p.Mupeer.RUnlock()
+	return p.Capabilities&(1<<bit) != 0
+}
+
+// resetAddrIndex remet AddrIndex à 0 si le changement d'adresse a été bloqué
+// (AddrIndex à -1, cf. NoChangeAddr).
+// Retourne false dans ce cas : il n'y a pas d'autre adresse à tester.
+func (p *Peer) resetAddrIndex() bool {
+	p.Mupeer.Lock()
+	defer p.Mupeer.Unlock()
+	peerLog.Debug(fmt.Sprintf("addrIndex : %d , len Addresses : %d", p.AddrIndex, len(p.Addresses)))
+	if p.AddrIndex == -1 {
+		p.AddrIndex = 0
+		return false
+	}
+	return true
+}
+
 // NextAddress retourne la prochaine adresse à tester pour le peer.
 // Elle met à jour AddrIndex et ActiveAddr.
 // Retourne l'adresse UDP, true si une adresse existe, false sinon.
@@ -255,9 +256,7 @@ func (p *Peer) NextAddress() (*net.UDPAddr, bool) {
 	udpAddr, err := net.ResolveUDPAddr("udp", addrString)
 	if err != nil {
 		// Si l'adresse est malformée, on passe à la suivante
-		if debug {
-			fmt.Println("NextAddress: impossible de résoudre", addrString)
-		}
+		peerLog.Debug("NextAddress: impossible de résoudre", "addrString", addrString)
 		p.AddrIndex++
 		return p.NextAddress() // récursion pour passer à la suivante
 	}
@@ -304,16 +303,10 @@ func SetPeerAddrIndex(peer *Peer, addr *net.UDPAddr) bool {
 // pour comprendre les problèmes IPv4 / IPv6.
 func debugUDPAddr(label string, a *net.UDPAddr) {
 	if a == nil {
-		fmt.Println(label, ": <nil>")
+		peerLog.Debug(label, "addr", nil)
 		return
 	}
-	fmt.Printf(
-		"%s → %s | IP brute=%v | IP normalisée=%v\n",
-		label,
-		a.String(),
-		a.IP,
-		normalizeIP(a.IP),
-	)
+	peerLog.Debug(fmt.Sprintf("%s → %s | IP brute=%v | IP normalisée=%v", label, a.String(), a.IP, normalizeIP(a.IP)))
 }
 
 //
@@ -322,212 +315,197 @@ func debugUDPAddr(label string, a *net.UDPAddr) {
 
 // Initialise la map de peers connus à partir de noms
 // (On ignore les peers qui n'ont pas d'adresses)
-func InitPeersMap(names []string) {
+// Les peers restaurés par LoadState et pas encore contactés récupèrent leurs adresses à jour.
+func (n *Node) InitPeersMap(names []string) {
 	for _, name := range names {
-		if name == NameofOurPeer {
+		if name == n.Name {
+			continue
+		}
+		known, exists := n.FindPeer(name) // j'ai changé ici
+		restored := false
+		if exists {
+			known.Mupeer.RLock()
+			restored = known.State == PeerDiscovered && known.ActiveAddr == nil
+			known.Mupeer.RUnlock()
+		}
+		if restored {
+			if addresses, err := GetPeerAddresses(name); err == nil && len(addresses) > 0 {
+				known.Mupeer.Lock()
+				known.Addresses = addresses
+				known.Mupeer.Unlock()
+			}
 			continue
 		}
-		_, exists := FindPeer(name) // j'ai changé ici
 		if !exists {
 			// on donne juste le nom et adresse, mais clé publique reste vide
 			addresses, err := GetPeerAddresses(name)
 			if len(addresses) == 0 {
-				if debugPeer {
-					fmt.Println("Pas d'adresses pour ce peer, on ignore")
-				}
+				peerLog.Debug("Pas d'adresses pour ce peer, on ignore")
 				continue
 			}
 			if err != nil {
-				fmt.Println("Error lors de la récupération de l'adresse dans InitPeersMap du peer " + name)
+				peerLog.Warn("Erreur lors de la récupération des adresses dans InitPeersMap", "peer", name, "err", err)
 				return
 			}
-			peer, ok := AddPeer(name, nil, nil, PeerDiscovered)
+			peer, ok := n.AddPeer(name, nil, nil, PeerDiscovered)
 
 			if ok {
+				peer.Mupeer.Lock()
 				peer.Addresses = addresses
-				if debugPeer {
-					fmt.Println("je viens d'ajouter l'adresse au peer "+name+" ses adresses sont ", addresses)
-				}
+				peer.Mupeer.Unlock()
+				peerLog.Debug("adresses ajoutées au peer", "peer", name, "addresses", addresses)
 			}
 		}
 	}
 }
 
 // Ajoute un root Merkle à un peer et met à jour la liste (pour les versions)
-func AddRootToPeer(peer *Peer, hash []byte) error {
+func (n *Node) AddRootToPeer(peer *Peer, hash []byte) error {
 
+	peer.Mupeer.Lock()
 	if peer.Root != nil && bytes.Equal(peer.Root, hash) {
-		if debugPeer {
-			fmt.Println("Nouveau root reçu du peer inchangé " + peer.Name)
-		}
-		OnPeerEvent(peer, EventNewRoot, "(Inchangé)")
+		peer.Mupeer.Unlock()
+		peerLog.Debug("Nouveau root reçu du peer inchangé", "peer", peer.Name)
+		n.EmitPeerEvent(peer, EventNewRoot, "(Inchangé)")
 		return nil // pas de changement
 	}
-	if debugPeer {
-		fmt.Println("Nouveau root reçu du peer " + peer.Name)
-	}
+	peerLog.Debug("Nouveau root reçu du peer", "peer", peer.Name)
+	var oldRoot []byte
 	peer.Root = hash
-	peer.Listroots = AddListRoot(peer.Listroots, hash)
+	peer.Listroots, oldRoot = pushRoot(peer.Listroots, hash)
 	peer.RootChanged = true
+	peer.Mupeer.Unlock()
 
-	if OnPeerEvent != nil {
-		OnPeerEvent(peer, EventNewRoot, hex.EncodeToString(hash))
+	// hors du verrou : pruneRoot lit le root de chaque peer
+	if oldRoot != nil {
+		n.pruneRoot(oldRoot)
 	}
 
+	n.EmitPeerEvent(peer, EventNewRoot, hex.EncodeToString(hash))
+
 	return nil
 }
 
 // Ajoute un root Merkle à un peer identifié par adresse
-func AddRootToPeerbyaddr(addr *net.UDPAddr, hash []byte) error {
+func (n *Node) AddRootToPeerbyaddr(addr *net.UDPAddr, hash []byte) error {
 
-	name, found := GetNameByAddr(addr)
+	peer, found := n.FindPeerByAddr(addr)
 	if !found {
-		if debugPeer {
-			fmt.Println("peer non trouvé pour l'adresse")
-		}
+		peerLog.Debug("peer non trouvé pour l'adresse")
 		return fmt.Errorf("peer non trouvé pour l'adresse %s", addr.String())
 	}
-	peer, exists := FindPeer(name)
-	if !exists {
-		if debugPeer {
-			fmt.Println("peer non trouvé pour le nom ")
-		}
-		return fmt.Errorf("peer non trouvé pour le nom %s", name)
-	}
-	AddRootToPeer(peer, hash)
+	n.AddRootToPeer(peer, hash)
 	return nil
 }
 
 // Maintient uniquement les 3 derniers roots et supprime l'ancien
-func AddListRoot(listRoots [][]byte, newRoot []byte) [][]byte {
-	listRoots = append(listRoots, newRoot)
-	if len(listRoots) > 3 {
-		oldRoot := listRoots[0]
-		listRoots = listRoots[1:] // supprime le plus ancien
-		for _, p := range Peers {
-			if bytes.Equal(p.Root, oldRoot) {
-				return listRoots
-			}
-		}
-		for _, l := range MyListroots {
-			if bytes.Equal(l, oldRoot) {
-				return listRoots
-			}
-		}
-
-		clientStorage.DeleteMerkleTree(oldRoot)
-		if debugPeer {
-			fmt.Println("Suppression de l'ancien root de la liste et de l'arbre de Merkle :", oldRoot)
-		}
+func (n *Node) AddListRoot(listRoots [][]byte, newRoot []byte) [][]byte {
+	listRoots, oldRoot := pushRoot(listRoots, newRoot)
+	if oldRoot != nil {
+		n.pruneRoot(oldRoot)
 	}
 	return listRoots
 }
 
-// Retourne le nom du peer à partir d'une adresse
-func GetNameByAddr(addr *net.UDPAddr) (string, bool) {
+// pushRoot ajoute newRoot à la liste et retire le plus ancien au-delà de 3
+// (nil si la liste n'a pas débordé)
+func pushRoot(listRoots [][]byte, newRoot []byte) ([][]byte, []byte) {
+	listRoots = append(listRoots, newRoot)
+	if len(listRoots) <= 3 {
+		return listRoots, nil
+	}
+	return listRoots[1:], listRoots[0]
+}
 
-	for name, p := range Peers {
-		if p.ActiveAddr != nil && p.ActiveAddr.IP.Equal(addr.IP) && p.ActiveAddr.Port == addr.Port {
-			return name, true
+// pruneRoot supprime l'arbre de Merkle de oldRoot s'il n'est plus le root
+// d'aucun peer ni l'une de nos versions
+func (n *Node) pruneRoot(oldRoot []byte) {
+	for _, p := range n.ListPeers() {
+		p.Mupeer.RLock()
+		root := p.Root
+		p.Mupeer.RUnlock()
+		if bytes.Equal(root, oldRoot) {
+			return
+		}
+	}
+	for _, l := range n.MyRoots() {
+		if bytes.Equal(l, oldRoot) {
+			return
 		}
 	}
-	return "", false
+
+	n.store.DeleteMerkleTree(oldRoot)
+	peerLog.Debug("Suppression de l'ancien root de la liste et de l'arbre de Merkle", "oldRoot", oldRoot)
+}
+
+// Retourne le nom du peer à partir d'une adresse (cf. FindPeerByAddr)
+func (n *Node) GetNameByAddr(addr *net.UDPAddr) (string, bool) {
+	p, found := n.FindPeerByAddr(addr)
+	if !found {
+		return "", false
+	}
+	return p.Name, true
 }
 
 // Rafraîchit la liste des peers : ajoute les nouveaux et supprime les absents
-func RefreshPeers(peerNames []string) {
+func (n *Node) RefreshPeers(peerNames []string) {
 
 	// Créer un set pour savoir quels peers sont encore actifs
 	activePeers := make(map[string]struct{})
 	for _, name := range peerNames {
-		if name == NameofOurPeer {
+		if name == n.Name {
 			continue
 		}
 		activePeers[name] = struct{}{}
 
 		// Vérifier si le peer existe déjà
-		peer, exists := FindPeer(name)
+		peer, exists := n.FindPeer(name)
 
 		// Récupérer ses adresses
 		addresses, err := GetPeerAddresses(name)
 		if err != nil {
-			fmt.Println("-> Impossible de récupérer les adresses de", name)
+			peerLog.Warn("Impossible de récupérer les adresses de", "name", name)
 			continue
 		}
 		if len(addresses) == 0 {
-			if debugPeer {
-				fmt.Println("Pas d'adresses pour ce peer, on l'ignore")
-			}
+			peerLog.Debug("Pas d'adresses pour ce peer, on l'ignore")
 			continue
 		}
 
 		if exists {
-			if debugPeer {
-				fmt.Println(" Peer déjà connu :", name)
-			}
+			peerLog.Debug("Peer déjà connu", "name", name)
+			peer.Mupeer.Lock()
 			peer.Addresses = addresses
+			peer.Mupeer.Unlock()
 			continue
 		}
 
 		// Ajouter un nouveau peer
-		peer, added := AddPeer(name, nil, nil, PeerDiscovered)
+		peer, added := n.AddPeer(name, nil, nil, PeerDiscovered)
 		if !added {
 			continue
 		}
+		peer.Mupeer.Lock()
 		peer.Addresses = addresses
+		peer.Mupeer.Unlock()
 
-		if debugPeer {
-			fmt.Printf("➕ Nouveau peer ajouté : %s → %v\n", name, peer.Addresses)
-		}
+		peerLog.Debug(fmt.Sprintf("Nouveau peer ajouté : %s → %v", name, addresses))
 	}
 
 	// Supprimer les peers qui ne sont plus dans la liste
-	for name, peer := range Peers { // AllPeers retourne tous les peers connus
-		if _, ok := activePeers[name]; !ok {
-			if debugPeer {
-				fmt.Printf("➖ Peer supprimé : %s\n", name)
-			}
-			DeletePeer(peer.Name)
+	for _, peer := range n.ListPeers() { // tous les peers connus
+		if _, ok := activePeers[peer.Name]; !ok {
+			peerLog.Debug(fmt.Sprintf("Peer supprimé : %s", peer.Name))
+			n.DeletePeer(peer.Name)
 		}
 	}
 }
 
 // Supprimer un peer de la map des peers (Peers)
-func DeletePeer(name string) {
-	PeersMu.Lock()
-	delete(Peers, name)
-	PeersMu.Unlock()
-}
-
-// ======================= BAN / DÉBAN =======================
-func AddBan(peer *Peer) {
-	banMu.Lock()
-	ban[peer.Name] = peer
-	banMu.Unlock()
-}
-
-func DelBan(name string) {
-	banMu.Lock()
-	delete(ban, name)
-	banMu.Unlock()
-}
-
-func IsBan(name string) bool {
-	banMu.RLock()
-	_, exists := ban[name]
-	banMu.RUnlock()
-	return exists
-}
-
-func IsBanByaddr(addr *net.UDPAddr) bool {
-	name, found := GetNameByAddr(addr)
-	if !found {
-		return false
-	}
-	banMu.RLock()
-	_, exists := ban[name]
-	banMu.RUnlock()
-	return exists
+func (n *Node) DeletePeer(name string) {
+	n.peersMu.Lock()
+	delete(n.peers, name)
+	n.peersMu.Unlock()
 }
 
 // ======================= UTILITAIRES DE CONNEXION =======================
@@ -535,67 +513,57 @@ func IsBanByaddr(addr *net.UDPAddr) bool {
 // ---------------------------------
 // Utilitaire pour connecter un peer
 // ---------------------------------
-func connectPeer(peer *Peer) {
+func (n *Node) connectPeer(peer *Peer) {
 	peer.Mupeer.Lock()
-	if debugPeer {
-		fmt.Println("connectPeer")
-	}
+	peerLog.Debug("connectPeer")
 	if peer.State == PeerDiscovered {
-		if debugPeer {
-			fmt.Println("Le peer n'est pas encore connecté")
-		}
+		peerLog.Debug("Le peer n'est pas encore connecté")
 		peer.State = PeerAssociated
 		peer.LastSeen = time.Now()
 		peer.AddrIndex = 0
-		if OnPeerEvent != nil {
-			OnPeerEvent(peer, EventConnected, "")
-		}
+		n.EmitPeerEvent(peer, EventConnected, "")
 	} else {
-		if OnPeerEvent != nil {
-			OnPeerEvent(peer, EventConnected, "")
-		}
+		n.EmitPeerEvent(peer, EventConnected, "")
 	}
 
-	if debugPeer {
-		fmt.Println("fin connectPeer")
-	}
+	peerLog.Debug("fin connectPeer")
 	peer.Mupeer.Unlock()
 }
 
 // ----------------------
 // Met à jour LastSeen
 // ----------------------
-func updateLastSeen(addr *net.UDPAddr) {
-	peer, exist := FindPeerByAddr(addr)
+func (n *Node) updateLastSeen(addr *net.UDPAddr) {
+	peer, exist := n.FindPeerByAddr(addr)
 	if exist {
-		if debugPeer {
-			fmt.Println("LastSeen mis à jour pour le peer : ", peer.Name)
-		}
+		peerLog.Debug("LastSeen mis à jour pour le peer", "peer", peer.Name)
+		peer.Mupeer.Lock()
 		peer.LastSeen = time.Now()
+		peer.Mupeer.Unlock()
 	}
 }
 
 // ----------------------------------------------------------------------------------
 // Marque un peer comme déconnecté et réinitialise les attributs qui lui correspondent
 // ----------------------------------------------------------------------------------
-func DeconnectPeer(p *Peer) {
+func (n *Node) DeconnectPeer(p *Peer) {
 	p.Mupeer.Lock()
 	p.State = PeerExpired
 	p.AddrIndex = 0    // on retourne à l'index 0 de la liste d'adresse, si le peer se connecte on teste les addresses jusqu'à qu'il y en ait une qui fonctionne
 	p.ActiveAddr = nil // plus d'adresse active
 	p.Mupeer.Unlock()
-	if debugPeer {
-		fmt.Println("peer déconnecté réussi")
-	}
-	OnPeerEvent(p, EventDisconnected, "")
+	peerLog.Debug("peer déconnecté réussi")
+	n.EmitPeerEvent(p, EventDisconnected, "")
 }
 
 // ---------------------------------------------------
 // Commence une demande de donnée à un peer spécifique
 // ---------------------------------------------------
 func StartAskMerkle(peer *Peer) {
+	peer.Mupeer.Lock()
 	peer.MerkleDownloadStart = time.Now()
 	peer.MerkleDone = false
+	peer.Mupeer.Unlock()
 }
 
 func IsPeerDisconnected(peer *Peer) bool {
@@ -606,8 +574,17 @@ func IsPeerDisconnected(peer *Peer) bool {
 	return state == PeerExpired
 }
 
+// renvoie la clé partagée (DH) du peer, nil si les échanges ne sont pas chiffrés
+func getSharedKey(peer *Peer) []byte {
+	peer.Mupeer.RLock()
+	defer peer.Mupeer.RUnlock()
+	return peer.SharedKey
+}
+
 // pas de changement d'addresse au cours de la connexion
 func NoChangeAddr(peer *Peer, addr *net.UDPAddr) {
+	peer.Mupeer.Lock()
 	peer.AddrIndex = -1
 	peer.ActiveAddr = addr
+	peer.Mupeer.Unlock()
 }
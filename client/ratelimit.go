@@ -0,0 +1,236 @@
+package client
+
+import (
+	"crypto/ecdsa"
+	"net"
+	"sync"
+	"time"
+)
+
+//
+// ======================= LIMITATION DE DÉBIT =======================
+//
+
+// Protection contre les abus sur le chemin des requêtes :
+//   - un seau à jetons par adresse source, appliqué dès la lecture du socket
+//     (avant toute mise en file) aux requêtes et aux réponses non sollicitées :
+//     les réponses d’une transaction en cours (nos propres Datums) passent ;
+//   - un seau à jetons par peer, appliqué aux requêtes une fois le peer identifié ;
+//   - un seau dédié aux requêtes coûteuses (vérification ECDSA, appels HTTPS) ;
+//   - des files bornées : un paquet qui ne trouve pas de place est jeté et compté.
+
+// Paramètres des limites (paquets par seconde et rafale autorisée)
+var (
+	RateAddrPerSec      = 300.0
+	RateAddrBurst       = 600.0
+	RatePeerPerSec      = 200.0
+	RatePeerBurst       = 400.0
+	RateExpensivePerSec = 5.0
+	RateExpensiveBurst  = 10.0
+	RateErrorPerSec     = 1.0 // erreurs renvoyées pour des requêtes jetées, par adresse
+	RateErrorBurst      = 5.0
+
+	PeerListMinInterval = 5 * time.Second // délai minimal entre deux GET /peers/ déclenchés par le réseau
+)
+
+// tokenBucket : seau à jetons classique, rempli à rate jetons/s jusqu’à burst
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// rateLimiter regroupe un seau par clé (adresse ou nom de peer)
+type rateLimiter struct {
+	mu      sync.Mutex
+	rate    *float64
+	burst   *float64
+	buckets map[string]*tokenBucket
+}
+
+func newRateLimiter(rate, burst *float64) *rateLimiter {
+	return &rateLimiter{rate: rate, burst: burst, buckets: map[string]*tokenBucket{}}
+}
+
+// Nombre de seaux au-delà duquel on purge les seaux inactifs
+const maxBuckets = 4096
+
+// allow consomme un jeton pour key et indique si le paquet peut passer.
+func (l *rateLimiter) allow(key string) bool {
+	now := time.Now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b, ok := l.buckets[key]
+	if !ok {
+		if len(l.buckets) >= maxBuckets {
+			l.purgeLocked(now)
+		}
+		b = &tokenBucket{tokens: *l.burst, last: now}
+		l.buckets[key] = b
+	}
+
+	b.tokens += now.Sub(b.last).Seconds() * *l.rate
+	if b.tokens > *l.burst {
+		b.tokens = *l.burst
+	}
+	b.last = now
+
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// purgeLocked supprime les seaux redevenus pleins (inactifs depuis assez longtemps).
+func (l *rateLimiter) purgeLocked(now time.Time) {
+	for key, b := range l.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()**l.rate >= *l.burst {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+//
+// ======================= COMPTEURS DE PAQUETS JETÉS =======================
+//
+
+// DropReason identifie la raison pour laquelle un paquet a été jeté.
+type DropReason int
+
+const (
+	DropRequestQueueFull  DropReason = iota // requestChan plein
+	DropResponseQueueFull                   // responseChan plein
+	DropRateAddr                            // limite par adresse source
+	DropRatePeer                            // limite par peer
+	DropRateExpensive                       // limite des requêtes coûteuses
+	DropMalformed                           // paquet malformé
+	DropUnsigned                            // signature absente là où elle est obligatoire
+	dropReasonCount
+)
+
+func (r DropReason) String() string {
+	switch r {
+	case DropRequestQueueFull:
+		return "request_queue_full"
+	case DropResponseQueueFull:
+		return "response_queue_full"
+	case DropRateAddr:
+		return "rate_addr"
+	case DropRatePeer:
+		return "rate_peer"
+	case DropRateExpensive:
+		return "rate_expensive"
+	case DropMalformed:
+		return "malformed"
+	case DropUnsigned:
+		return "unsigned"
+	}
+	return "unknown"
+}
+
+// countDrop incrémente le compteur associé à reason.
+func (n *Node) countDrop(reason DropReason, addr *net.UDPAddr) {
+	n.dropCounters[reason].Add(1)
+	transportLog.Debug("paquet jeté", "reason", reason, "addr", addr)
+}
+
+// DropStats renvoie le nombre de paquets jetés par raison.
+func (n *Node) DropStats() map[string]uint64 {
+	stats := make(map[string]uint64, dropReasonCount)
+	for r := DropReason(0); r < dropReasonCount; r++ {
+		stats[r.String()] = n.dropCounters[r].Load()
+	}
+	return stats
+}
+
+//
+// ======================= VÉRIFICATIONS =======================
+//
+
+// allowFromAddr applique la limite par adresse source (appelée avant mise en file).
+func (n *Node) allowFromAddr(addr *net.UDPAddr) bool {
+	if addr == nil {
+		return false
+	}
+	if !n.addrLimiter.allow(addr.String()) {
+		n.countDrop(DropRateAddr, addr)
+		return false
+	}
+	return true
+}
+
+// allowRequest applique, pour une requête déjà parsée, la limite par peer
+// puis la limite des requêtes coûteuses et les pré-vérifications bon marché.
+// Une requête refusée est signalée à l’émetteur (cf. refuseRequest).
+// Paramètres :
+//   - conn, priv : pour répondre par une erreur
+//   - id   : identifiant de la requête
+//   - typ  : type de la requête
+//   - addr : adresse source
+//   - sig  : signature extraite du paquet (nil si absente)
+func (n *Node) allowRequest(conn Transport, priv *ecdsa.PrivateKey, id uint32, typ uint8, addr *net.UDPAddr, sig []byte) bool {
+	reason, ok := n.checkRequest(typ, addr, sig)
+	if !ok {
+		n.countDrop(reason, addr)
+		n.refuseRequest(conn, priv, id, addr, reason)
+	}
+	return ok
+}
+
+// checkRequest renvoie la raison du refus d’une requête (false si refusée).
+func (n *Node) checkRequest(typ uint8, addr *net.UDPAddr, sig []byte) (DropReason, bool) {
+	if peer, ok := n.FindPeerByAddr(addr); ok {
+		if !n.peerLimiter.allow(peer.Name) {
+			return DropRatePeer, false
+		}
+	}
+
+	switch typ {
+	case Hello, NatTraversalRequest, NatTraversalRequest2:
+		// ces requêtes déclenchent une vérification ECDSA et des appels HTTPS :
+		// on refuse d’emblée celles qui ne sont pas signées
+		if len(sig) != SizeSignature {
+			return DropUnsigned, false
+		}
+		if !n.expensiveLimiter.allow(addr.String()) {
+			return DropRateExpensive, false
+		}
+	}
+	return 0, true
+}
+
+// refuseRequest répond à une requête jetée par une erreur rate-limited ou
+// bad-signature. Ces réponses sont elles-mêmes limitées par adresse : un flood
+// ne doit pas provoquer un flood de réponses.
+func (n *Node) refuseRequest(conn Transport, priv *ecdsa.PrivateKey, id uint32, addr *net.UDPAddr, reason DropReason) {
+	if !n.errorLimiter.allow(addr.String()) {
+		return
+	}
+	code := ErrCodeRateLimited
+	if reason == DropUnsigned {
+		code = ErrCodeBadSignature
+	}
+	SendErrorCode(conn, id, priv, addr, code, "")
+}
+
+// -----------------------------------------------------------------------------------------
+// refreshPeerListThrottled rafraîchit la liste des peers depuis le serveur,
+// au plus une fois par PeerListMinInterval : un peer ne peut pas nous faire
+// marteler le serveur HTTPS en envoyant des NatTraversalRequest2 en boucle.
+func (n *Node) refreshPeerListThrottled() error {
+	n.peerListMu.Lock()
+	if time.Since(n.peerListLast) < PeerListMinInterval {
+		n.peerListMu.Unlock()
+		return nil
+	}
+	n.peerListLast = time.Now()
+	n.peerListMu.Unlock()
+
+	names, err := GetPeerList()
+	if err != nil {
+		return err
+	}
+	n.RefreshPeers(names)
+	return nil
+}
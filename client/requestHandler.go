@@ -3,8 +3,8 @@ package client
 import (
 	"crypto/ecdsa"
 	"encoding/hex"
+	"errors"
 	"fmt"
-	"myp2p/clientStorage"
 	"net"
 )
 
@@ -12,180 +12,203 @@ import (
 // ======================= CHANNEL DE REQUÊTES =======================
 //
 
-// Canal pour recevoir toutes les requêtes entrantes
-var requestChan = make(chan IncomingPacket, 1024)
-var debugRequest = true
+// Taille du canal des requêtes entrantes (Node.requestChan)
+const RequestQueueSize = 1024
 
 //
 // ======================= LOOP DE TRAITEMENT DES REQUÊTES =======================
 //
 
-// RequestHandler lit le canal et dispatch les requêtes selon leur type
-func RequestHandler(conn *net.UDPConn, priv *ecdsa.PrivateKey) {
-	for {
-		msg := <-requestChan
-		pkt := msg.pkt
-		addr := msg.addr
-
-		// On parse le message
-		id, typ, bodyLen, body, signed, sig, ok := parseRecvMessage(pkt)
-		if !ok {
-			if debugRequest {
-				fmt.Println("Request parse error")
-			}
-			continue
-		}
+// RequestHandler lit le canal et répartit les requêtes sur RequestWorkers workers
+// (les requêtes d’un même peer restent traitées dans l’ordre)
+func (n *Node) RequestHandler(conn Transport, priv *ecdsa.PrivateKey) {
+	pool := newWorkerPool(RequestWorkers, func(msg IncomingPacket) {
+		n.handleRequest(conn, priv, msg)
+	})
+	pool.run(n.requestChan, n.ctx.Done())
+}
 
-		if debugRequest {
-			fmt.Printf("RequestHandler: reçu type=%d id=%d body=%s\n", typ, id, string(body))
-		}
+// handleRequest parse une requête et la dispatch selon son type
+func (n *Node) handleRequest(conn Transport, priv *ecdsa.PrivateKey, msg IncomingPacket) {
+	pkt := msg.pkt
+	addr := msg.addr
 
-		// dispatch vers la fonction spécifique
-		switch typ {
-		case NatTraversalRequest:
-			HandleNatTraversalRequest(conn, priv, id, body, bodyLen, addr, signed, sig)
+	// On découpe le paquet
+	p, err := ParsePacket(pkt)
+	if err != nil {
+		transportLog.Debug("Request parse error", "err", err)
+		n.ReportMisbehaviour(addr, MisMalformed)
+		return
+	}
+	id, typ, signed, sig := p.ID, p.Type, p.Signed, p.Sig
 
-		case NatTraversalRequest2:
-			HandleNatTraversalRequest2(conn, priv, id, body, bodyLen, addr, signed, sig)
+	// limites par peer et pré-vérifications avant tout travail coûteux
+	if !n.allowRequest(conn, priv, id, typ, addr, sig) {
+		return
+	}
 
-		case Hello:
-			HandleHelloRequest(conn, priv, addr, id, body, signed, sig)
-		case RootRequest:
-			HandleRootRequest(conn, priv, id, addr)
+	// On décode le body selon son type
+	m, err := p.Message()
+	switch {
+	case errors.Is(err, ErrUnknownType):
+		transportLog.Debug(fmt.Sprintf("Requête inconnue type=%d", typ))
+		SendErrorCode(conn, id, priv, addr, ErrCodeUnknownType, fmt.Sprintf("type %d", typ))
+		return
+	case err != nil:
+		transportLog.Debug("Requête malformée", "err", err)
+		n.ReportMisbehaviour(addr, MisMalformed)
+		SendErrorCode(conn, id, priv, addr, ErrCodeMalformed, MsgTypeName(typ)+" malformé")
+		return
+	}
 
-		case Ping:
-			HandlePing(conn, priv, id, addr)
+	transportLog.Debug(fmt.Sprintf("RequestHandler: reçu type=%d id=%d", typ, id))
 
-		case DatumRequest:
-			HandleDatumRequestWrapper(conn, priv, id, addr, body)
+	// dispatch vers la fonction spécifique
+	switch m := m.(type) {
+	case *NatTraversalMsg:
+		if m.Second {
+			n.HandleNatTraversalRequest2(conn, priv, id, m.Addr, addr, signed, sig)
+		} else {
+			n.HandleNatTraversalRequest(conn, priv, id, m.Addr, addr, signed, sig)
+		}
 
-		default:
-			if debugRequest {
-				fmt.Printf("Requête inconnue type=%d\n", typ)
-			}
-			SendError(conn, id, priv, addr)
+	case *HelloMsg:
+		if m.Reply {
+			// une réponse n’a rien à faire ici (cf. Routeur)
+			SendErrorCode(conn, id, priv, addr, ErrCodeUnknownType, "")
+			return
 		}
+		n.HandleHelloRequest(conn, priv, addr, id, m, signed, sig)
+
+	case *RootRequestMsg:
+		n.HandleRootRequest(conn, priv, id, addr)
+
+	case *PingMsg:
+		n.HandlePing(conn, priv, id, addr)
 
-		updateLastSeen(addr)
+	case *DatumRequestMsg:
+		n.HandleDatumRequestWrapper(conn, priv, id, addr, m.Hash)
+
+	case *BatchDatumRequestMsg:
+		n.HandleBatchDatumRequestWrapper(conn, priv, id, addr, m.Hashes)
+
+	case *SubtreeRequestMsg:
+		n.HandleSubtreeRequestWrapper(conn, priv, id, addr, m)
+
+	default:
+		transportLog.Debug(fmt.Sprintf("Requête inconnue type=%d", typ))
+		SendErrorCode(conn, id, priv, addr, ErrCodeUnknownType, "")
 	}
+
+	n.updateLastSeen(addr)
 }
 
 // ----------------------
 // Fonctions auxiliaires
 // ----------------------
 
-// NatTraversalRequest : premier message pour initier traversée NAT
-func HandleNatTraversalRequest(conn *net.UDPConn, priv *ecdsa.PrivateKey, id uint32, body []byte, bodyLen int, addr *net.UDPAddr, signed []byte, sig []byte) {
-	if debugRequest {
-		fmt.Println("-> NatTraversalRequest Reçu !")
+// verifRequestSign vérifie la signature d’une requête ; une signature invalide
+// est signalée à l’émetteur par une erreur bad-signature.
+func (n *Node) verifRequestSign(conn Transport, priv *ecdsa.PrivateKey, id uint32, addr *net.UDPAddr, signed []byte, sig []byte) bool {
+	code := n.checkSign(addr, signed, sig)
+	if code == ErrCodeBadSignature {
+		SendErrorCode(conn, id, priv, addr, ErrCodeBadSignature, "")
 	}
+	return code == ErrCodeNone
+}
+
+// NatTraversalRequest : premier message pour initier traversée NAT
+// target : adresse du peer à joindre, portée par la requête
+func (n *Node) HandleNatTraversalRequest(conn Transport, priv *ecdsa.PrivateKey, id uint32, target *net.UDPAddr, addr *net.UDPAddr, signed []byte, sig []byte) {
+	transportLog.Debug("NatTraversalRequest Reçu !")
 
 	// On vérifie la signature
-	if !VerifSign(addr, signed, sig) {
-		if debugRequest {
-			fmt.Println("Erreur de signature dans NatTraversalRequest")
-		}
+	if !n.verifRequestSign(conn, priv, id, addr, signed, sig) {
+		transportLog.Debug("Erreur de signature dans NatTraversalRequest")
 		return
 	}
 	// On envoie Ok à celui qui a fait la requete
 	SendOk(conn, id, priv, addr)
 
-	// On exxtrait l'adresse de celui avec qu'il veut discuter
-	addrExtracted, err := ParseNATBody(body, uint8(bodyLen))
-	if err != nil {
-		fmt.Println("Erreur ParseNATBody")
-		return
-	}
+	// l'adresse de celui avec qui il veut discuter
+	addrExtracted := target
 
 	// on construit un NatTraversalRequest2
-	newID := GenerateId()
+	newID := n.GenerateId()
 	msg, err := BuildNatTraversalRequest(newID, priv, addr, NatTraversalRequest2)
 	if err != nil {
-		fmt.Println("Erreur de construction NatTraversalRequest2")
+		transportLog.Warn("Erreur de construction NatTraversalRequest2")
 		return
 	}
 	// on crée une transaction et on l'envoie
-	CreateTransaction(newID, nil, addrExtracted, NatTraversalRequest2, msg, Retries)
+	n.CreateTransaction(newID, nil, addrExtracted, NatTraversalRequest2, msg, Retries)
 	SendMessage(conn, addrExtracted, msg)
 }
 
 // NatTraversalRequest2 : réponse pour compléter traversée NAT
-func HandleNatTraversalRequest2(conn *net.UDPConn, priv *ecdsa.PrivateKey, id uint32, body []byte, bodyLen int, addr *net.UDPAddr, signed []byte, sig []byte) {
-	if debugRequest {
-		fmt.Println("-> NatTraversalRequest2 Reçu !")
-	}
+// target : adresse du peer qui tente de nous joindre, portée par la requête
+func (n *Node) HandleNatTraversalRequest2(conn Transport, priv *ecdsa.PrivateKey, id uint32, target *net.UDPAddr, addr *net.UDPAddr, signed []byte, sig []byte) {
+	transportLog.Debug("NatTraversalRequest2 Reçu !")
 
-	// On extrait le nom
-	addrExtracted, err := ParseNATBody(body, uint8(bodyLen))
-	if err != nil {
-		fmt.Println("Erreur ParseNATBody")
-		return
-	}
+	addrExtracted := target
 
-	// On rafraichit la liste par prudence
-	names, err := GetPeerList()
-	if err != nil {
-		fmt.Println("Peer List Error in NatTraversalRequest2")
+	// On rafraichit la liste par prudence (au plus une fois par PeerListMinInterval)
+	if err := n.refreshPeerListThrottled(); err != nil {
+		transportLog.Warn("Peer List Error in NatTraversalRequest2")
 		return
 	}
-	RefreshPeers(names)
 
 	// On retrouve le peer pour pouvoir lui ajouter sa clé public
-	peer, exist := FindPeerByAddr(addrExtracted)
+	peer, exist := n.FindPeerByAddr(addrExtracted)
 	if !exist {
-		if debugRequest {
-			fmt.Println("NatTraversalRequest2 ignoré, peer inconnu")
-		}
+		transportLog.Debug("NatTraversalRequest2 ignoré, peer inconnu")
 		return
 	}
-	if OnPeerEvent != nil {
-		OnPeerEvent(peer, EventNatTraversal2Received, "")
-	}
+	n.EmitPeerEvent(peer, EventNatTraversal2Received, "")
+	peer.Mupeer.Lock()
 	peer.ActiveAddr = addrExtracted
+	peer.Mupeer.Unlock()
 
 	key, err := GetPeerKey(peer.Name)
 	if err != nil {
-		fmt.Println("Erreur récupération clé publique dans NatTraversal2")
+		transportLog.Warn("Erreur récupération clé publique dans NatTraversal2")
 		return
 	}
+	peer.Mupeer.Lock()
 	peer.PublicKey = key
+	peer.Mupeer.Unlock()
 
-	if !VerifSign(addr, signed, sig) {
-		if debugRequest {
-			fmt.Println("Erreur de signature dans NatTraversalRequest2")
-		}
+	if !n.verifRequestSign(conn, priv, id, addr, signed, sig) {
+		transportLog.Debug("Erreur de signature dans NatTraversalRequest2")
 		return
 	}
 
 	SendOk(conn, id, priv, addr)
 
-	newID := GenerateId()
+	newID := n.GenerateId()
 
 	sendGenericMessage(conn, priv, addrExtracted, newID, Ping, []byte{}, false)
 }
 
 // RootRequest : renvoie la racine Merkle si autorisé
-func HandleRootRequest(conn *net.UDPConn, priv *ecdsa.PrivateKey, id uint32, addr *net.UDPAddr) {
-	if debugRequest {
-		fmt.Println("-> RootRequest reçu")
-	}
-	if IsBanByaddr(addr) {
-		SendErrorMessage(conn, id, priv, addr, "Tu es banni.")
+func (n *Node) HandleRootRequest(conn Transport, priv *ecdsa.PrivateKey, id uint32, addr *net.UDPAddr) {
+	transportLog.Debug("RootRequest reçu")
+	if n.IsBanByaddr(addr) {
+		SendErrorCode(conn, id, priv, addr, ErrCodeBanned, "Tu es banni.")
 	} else {
-		sendGenericMessage(conn, priv, addr, id, RootReply, clientStorage.RootHash, true)
+		// racine complète ou filtrée selon les ACL du peer
+		sendGenericMessage(conn, priv, addr, id, RootReply, n.RootForAddr(addr), true)
 	}
 }
 
 // Ping : simple vérification de présence
-func HandlePing(conn *net.UDPConn, priv *ecdsa.PrivateKey, id uint32, addr *net.UDPAddr) {
-	if debugRequest {
-		fmt.Println("-> Ping reçu")
-	}
+func (n *Node) HandlePing(conn Transport, priv *ecdsa.PrivateKey, id uint32, addr *net.UDPAddr) {
+	transportLog.Debug("Ping reçu")
 	// je cherche le peer correspondant à l'addresse
-	peer, ok := FindPeerByAddr(addr)
+	peer, ok := n.FindPeerByAddr(addr)
 	if !ok {
-		fmt.Println("peer not found")
-		SendErrorMessage(conn, id, priv, addr, "Please Hello First ! ;)")
+		transportLog.Info("peer not found")
+		SendErrorCode(conn, id, priv, addr, ErrCodeNotAssociated, "Please Hello First ! ;)")
 		return
 	}
 	peer.Mupeer.RLock()
@@ -201,11 +224,11 @@ func HandlePing(conn *net.UDPConn, priv *ecdsa.PrivateKey, id uint32, addr *net.
 
 		NoChangeAddr(peer, addr)
 		// j'envoie Hello
-		SendHello(conn, priv, peer)
+		n.SendHello(conn, priv, peer)
 
 	} else {
 		if state == PeerDiscovered || state == PeerExpired {
-			SendErrorMessage(conn, id, priv, addr, "Please Hello First ! ;)")
+			SendErrorCode(conn, id, priv, addr, ErrCodeNotAssociated, "Please Hello First ! ;)")
 			return
 		}
 	}
@@ -214,26 +237,37 @@ func HandlePing(conn *net.UDPConn, priv *ecdsa.PrivateKey, id uint32, addr *net.
 }
 
 // DatumRequest : wrapper pour vérifier bannissement avant traitement
-func HandleDatumRequestWrapper(conn *net.UDPConn, priv *ecdsa.PrivateKey, id uint32, addr *net.UDPAddr, body []byte) {
-	if debugRequest {
-		fmt.Println("-> DatumRequest reçu")
-	}
-	if IsBanByaddr(addr) {
-		SendErrorMessage(conn, id, priv, addr, "Tu es banni.")
+// (hash : hash demandé, déjà validé par le codec)
+func (n *Node) HandleDatumRequestWrapper(conn Transport, priv *ecdsa.PrivateKey, id uint32, addr *net.UDPAddr, hash []byte) {
+	transportLog.Debug("DatumRequest reçu")
+	if !n.admitDatumRequest(conn, priv, id, addr, 1, [][]byte{hash}) {
 		return
 	}
-	HandleDatumRequest(conn, priv, addr, id, body)
+	n.HandleDatumRequest(conn, priv, addr, id, hash)
 }
 
-// HelloRequest : traitement d’un Hello reçu
-func HandleHelloRequest(conn *net.UDPConn, priv *ecdsa.PrivateKey, addr *net.UDPAddr, id uint32, body []byte, signed []byte, sig []byte) {
-	if debugRequest {
-		fmt.Println("-> Hello reçu")
+// admitDatumRequest compte count unités de quota et les hashes demandés par
+// addr (cf. noteDatumRequest) et répond par une erreur si le peer est banni ou
+// a dépassé son quota.
+// Retour : true si la requête doit être servie
+func (n *Node) admitDatumRequest(conn Transport, priv *ecdsa.PrivateKey, id uint32, addr *net.UDPAddr, count int, hashes [][]byte) bool {
+	within := n.noteDatumRequest(addr, count, hashes)
+	if n.IsBanByaddr(addr) {
+		SendErrorCode(conn, id, priv, addr, ErrCodeBanned, "Tu es banni.")
+		return false
 	}
-	if !VerifSign(addr, signed, sig) {
-		if debugRequest {
-			fmt.Println("Erreur de signature dans Hellorequest")
-		}
+	if !within {
+		SendErrorCode(conn, id, priv, addr, ErrCodeQuotaExceeded, fmt.Sprintf("%d DatumRequest par %s", DatumQuota, DatumFloodWindow))
+		return false
+	}
+	return true
+}
+
+// HelloRequest : traitement d’un Hello reçu
+func (n *Node) HandleHelloRequest(conn Transport, priv *ecdsa.PrivateKey, addr *net.UDPAddr, id uint32, hello *HelloMsg, signed []byte, sig []byte) {
+	transportLog.Debug("Hello reçu")
+	if !n.verifRequestSign(conn, priv, id, addr, signed, sig) {
+		transportLog.Debug("Erreur de signature dans Hellorequest")
 		return
 	}
 
@@ -242,68 +276,67 @@ func HandleHelloRequest(conn *net.UDPConn, priv *ecdsa.PrivateKey, addr *net.UDP
 	var err error
 	var sharesecret []byte
 
-	// Créer un peer
-	name, err := ExtractPeerName(body)
-	if err != nil {
-		fmt.Println("Impossible d'extraire le nom du peer:", err)
-		return
-	}
-
-	if debugExtension {
-		ext, err := ParseExtensions(body)
-		if err != nil {
-			fmt.Println("Erreur aucun champs extension trouvé")
+	name := hello.Name
+	transportLog.Debug("extension du Hello reçu", "ext", fmt.Sprintf("0x%08X", hello.Extensions))
+
+	// on répond avec nos extensions (bloc TLV si le peer l'attend) ; le
+	// chiffrement n'est utilisé que si les deux côtés l'annoncent
+	ext, tlvs := n.helloExtensions(name, hello.Extensions)
+	answer := &HelloMsg{Reply: true, Extensions: ext, Name: n.Name, TLVs: tlvs}
+	crypted := hello.DHPub != nil && answer.Encrypted()
+	if !crypted && n.EncryptionPolicy(name) == EncryptRequired {
+		// le peer n'annonce pas le chiffrement : pas d'association
+		transportLog.Info("Hello refusé : chiffrement exigé", "peer", name)
+		SendErrorCode(conn, id, priv, addr, ErrCodeEncryptionRequired, "chiffrement exigé")
+		if peer, exist := n.FindPeer(name); exist {
+			n.EmitPeerEvent(peer, EventConnectionFailed, "chiffrement exigé mais non annoncé par le peer")
 		}
-		fmt.Printf("Voici l'extension du Hello reçu : 0x%08X\n", ext)
+		return
 	}
-
-	ext := BuildExtension()
-	crypted := IsChiffrementEnabled(body)
 	// Si le message est n'est pas chiffré
 	if !crypted {
-		if debugExtension {
-			fmt.Println("Hello Request : Message non chiffré")
-		}
+		transportLog.Debug("Hello Request : Message non chiffré")
+		// sans clé DH dans la réponse, on ne peut pas annoncer le chiffrement
+		answer.Extensions &^= 1 << ExtensionChiffrement
 
-		if debugExtension {
-			fmt.Printf("Voici l'extension Construite quand je reçois un Hello et j'envoie HelloReply: 0x%08X\n", ext)
-		}
+		transportLog.Debug(fmt.Sprintf("Voici l'extension Construite quand je reçois un Hello et j'envoie HelloReply: 0x%08X", answer.Extensions))
 
-		reply, err = BuildHello(id, ext, NameofOurPeer, priv, HelloReply)
+		reply, err = BuildHelloMsg(id, answer, priv)
 		if err != nil {
-			fmt.Println("erreur lors de la construction du helloReply")
+			transportLog.Warn("erreur lors de la construction du helloReply")
+			SendErrorCode(conn, id, priv, addr, ErrCodeInternal, "")
 			return
 		}
 	} else {
-		if debugExtension {
-			fmt.Println("Hello Request : Message chiffré")
-		}
+		transportLog.Debug("Hello Request : Message chiffré")
 		// Si le message n'est pas chiffré
 		dh_priv, dh_pub, err := GenerateKeyPair()
 		if err != nil {
-			fmt.Println("erreur génération de clé")
+			transportLog.Warn("erreur génération de clé")
+			SendErrorCode(conn, id, priv, addr, ErrCodeInternal, "")
 			return
 		}
 		dh_pubByte := SerializePublicKey(dh_pub)
+		answer.DHPub = dh_pubByte
 
-		reply, err = BuildHelloDH(id, ext, NameofOurPeer, dh_pubByte, priv, HelloReply)
+		reply, err = BuildHelloMsg(id, answer, priv)
 		if err != nil {
-			fmt.Println("erreur lors de la construction du helloReply")
+			transportLog.Warn("erreur lors de la construction du helloReply")
+			SendErrorCode(conn, id, priv, addr, ErrCodeInternal, "")
 			return
 		}
 
-		dh_pubpeer, err := ParsePublicKey(body[len(body)-64:])
+		dh_pubpeer, err := ParsePublicKey(hello.DHPub)
 		if err != nil {
-			fmt.Println("erreur lors de ParsePublicKey")
+			transportLog.Warn("erreur lors de ParsePublicKey")
+			SendErrorCode(conn, id, priv, addr, ErrCodeMalformed, "clé Diffie-Hellman invalide")
 			return
 		}
 		sharesecret, err = ComputeSharedKey(dh_priv, dh_pubpeer)
-		if debugRequest {
-			fmt.Println("cle pub genere : ", hex.EncodeToString(dh_pubByte), "\n cle public recu : ", hex.EncodeToString(body[len(body)-64:]))
-			fmt.Println("secret partager :", hex.EncodeToString(sharesecret))
-		}
+		transportLog.Debug("cle pub genere", "dh_pubByte", hex.EncodeToString(dh_pubByte), "cle_public_recu", hex.EncodeToString(hello.DHPub))
 		if err != nil {
-			fmt.Println("erreur lors du computesharekey")
+			transportLog.Warn("erreur lors du computesharekey")
+			SendErrorCode(conn, id, priv, addr, ErrCodeMalformed, "clé Diffie-Hellman invalide")
 
 			return
 		}
@@ -314,13 +347,13 @@ func HandleHelloRequest(conn *net.UDPConn, priv *ecdsa.PrivateKey, addr *net.UDP
 
 	key, err := GetPeerKey(name)
 	if err != nil {
-		fmt.Println("Probleme de clé dans request handler:", err)
+		transportLog.Info("Probleme de clé dans request handler", "err", err)
 
 		return
 	}
-	peer, exist := FindPeer(name)
+	peer, exist := n.FindPeer(name)
 	if !exist {
-		fmt.Println("peer inconnu.")
+		transportLog.Info("peer inconnu.")
 		return
 	}
 
@@ -331,32 +364,31 @@ func HandleHelloRequest(conn *net.UDPConn, priv *ecdsa.PrivateKey, addr *net.UDP
 	if state == PeerAssociated {
 		// si le peer a deja fait helloreply mais ya eu un autre hello apres
 		// si le peer existe et est connecté alors je le laisse connecté
-		AddPeer(name, addr, key, PeerAssociated)
+		n.AddPeer(name, addr, key, PeerAssociated)
 
 	}
 	// si le peer n'existe pas ou n'est pas connecté
 	if peer != nil && state == PeerDiscovered {
-		AddPeer(name, addr, key, PeerDiscovered)
+		n.AddPeer(name, addr, key, PeerDiscovered)
 	}
 	if crypted || name != NameofServeurUDP {
 
 		if !exist {
-			if debugRequest {
-				fmt.Println("Peer non connu")
-			}
+			transportLog.Debug("Peer non connu")
 			return
 		}
+		peer.Mupeer.Lock()
 		peer.SharedKey = sharesecret
+		peer.Mupeer.Unlock()
 	}
+	n.negotiate(peer, answer.Extensions, hello)
 
 	SendMessage(conn, addr, reply)
 
 	// le deuxième cas au cas où au moment ou on entre dans la phase tenté le natTraversal à ce moment là on reçoit une réponse
 	if state == PeerDiscovered || state == PeerWaitHelloNat {
-		if debugRequest {
-			fmt.Println("-> je veux savoir qui il est je lui envoie également hello (il est pas encore connecté)")
-		}
+		transportLog.Debug("je veux savoir qui il est je lui envoie également hello (il est pas encore connecté)")
 		SetPeerAddrIndex(peer, peer.ActiveAddr)
-		SendHello(conn, priv, peer)
+		n.SendHello(conn, priv, peer)
 	}
 }
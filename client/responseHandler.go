@@ -1,8 +1,10 @@
 package client
 
 import (
+	"bytes"
 	"crypto/ecdsa"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"myp2p/clientStorage"
 	"net"
@@ -13,67 +15,79 @@ import (
 // ======================= CHANNEL DE RÉCEPTION =======================
 //
 
-// Canal pour recevoir tous les paquets entrants
-var responseChan = make(chan IncomingPacket, 1024)
-var debugResponse = true
+// Taille du canal des réponses entrantes (Node.responseChan)
+const ResponseQueueSize = 1024
 
 //
 // ======================= LOOP DE TRAITEMENT DES RÉPONSES =======================
 //
 
-// ResponseHandler traite toutes les réponses reçues sur le canal
-func ResponseHandler(conn *net.UDPConn, priv *ecdsa.PrivateKey) {
-	for msg := range responseChan {
-		pkt := msg.pkt
-		addr := msg.addr
-
-		// On parse le message
-		id, typ, bodyLen, body, signed, sig, ok := parseRecvMessage(pkt)
-		if !ok {
-			if debugResponse {
-				fmt.Println("Response parse error")
-			}
-			continue
-		}
-		if debugResponse {
-			fmt.Printf("ResponseHandler: reçu type=%d id=%d bodyLen=%d\n", typ, id, bodyLen)
-		}
-		// On cherche le type de message pour le rediriger vers le bon handler
-		switch typ {
-		case HelloReply:
-			if debugExtension {
-				ext, err := ParseExtensions(body)
-				if err != nil {
-					fmt.Println("Erreur aucun champs extension trouvé")
-				}
-				fmt.Printf("Voici l'extension du HelloReply reçu : 0x%08X\n", ext)
-			}
-
-			if err := HandleHelloReply(id, conn, priv, signed, sig); err != nil && debugResponse {
-				fmt.Println("Erreur HelloReply :", err)
-			}
-		case RootReply:
-			HandleRootReply(id, addr, signed, sig, body)
-		case Ok:
-			HandleOk(id, addr)
-		case Error:
-			resolveTransaction(id)
-			fmt.Println("→ Error reçu, body :", string(body))
-
-		case Datum:
-			HandleDatum(id, addr, body)
-		case NoDatum:
-			HandleNoDatum(id, addr, signed, sig)
-		default:
-			if debugResponse {
-				fmt.Printf("Réponse inconnue type=%d\n", typ)
-			}
+// ResponseHandler lit le canal et répartit les réponses sur ResponseWorkers workers
+// (les réponses d’un même peer restent traitées dans l’ordre)
+func (n *Node) ResponseHandler(conn Transport, priv *ecdsa.PrivateKey) {
+	pool := newWorkerPool(ResponseWorkers, func(msg IncomingPacket) {
+		n.handleResponse(conn, priv, msg)
+	})
+	pool.run(n.responseChan, n.ctx.Done())
+}
 
-		}
+// handleResponse parse une réponse et la redirige vers le bon handler
+func (n *Node) handleResponse(conn Transport, priv *ecdsa.PrivateKey, msg IncomingPacket) {
+	pkt := msg.pkt
+	addr := msg.addr
 
-		// Mise à jour du LastSeen du peer
-		updateLastSeen(addr)
+	// On découpe le paquet puis on décode le body
+	p, err := ParsePacket(pkt)
+	if err != nil {
+		transportLog.Debug("Response parse error", "err", err)
+		n.ReportMisbehaviour(addr, MisMalformed)
+		return
 	}
+	id, typ, signed, sig := p.ID, p.Type, p.Signed, p.Sig
+	transportLog.Debug(fmt.Sprintf("ResponseHandler: reçu type=%d id=%d bodyLen=%d", typ, id, len(p.Body)))
+
+	m, err := p.Message()
+	switch {
+	case errors.Is(err, ErrUnknownType):
+		transportLog.Debug(fmt.Sprintf("Réponse inconnue type=%d", typ))
+		return
+	case err != nil:
+		transportLog.Debug("Réponse malformée", "err", err)
+		n.ReportMisbehaviour(addr, MisMalformed)
+		return
+	}
+
+	// On cherche le type de message pour le rediriger vers le bon handler
+	switch m := m.(type) {
+	case *HelloMsg:
+		if !m.Reply {
+			transportLog.Debug("Hello reçu comme réponse, ignoré")
+			return
+		}
+		transportLog.Debug("extension du HelloReply reçu", "ext", fmt.Sprintf("0x%08X", m.Extensions))
+		if err := n.HandleHelloReply(id, conn, priv, m, signed, sig); err != nil {
+			transportLog.Debug("Erreur HelloReply", "err", err)
+		}
+	case *RootReplyMsg:
+		n.HandleRootReply(id, addr, signed, sig, m.Hash)
+	case *OkMsg:
+		n.HandleOk(id, addr)
+	case *ErrorMsg:
+		n.HandleErrorReply(id, conn, priv, addr, m)
+
+	case *DatumMsg:
+		n.HandleDatum(id, addr, m)
+	case *NoDatumMsg:
+		n.HandleNoDatum(id, addr, m.Hash, signed, sig)
+	case *SubtreeEndMsg:
+		n.HandleSubtreeEnd(id, addr, m.Count)
+	default:
+		transportLog.Debug(fmt.Sprintf("Réponse inconnue type=%d", typ))
+
+	}
+
+	// Mise à jour du LastSeen du peer
+	n.updateLastSeen(addr)
 }
 
 //
@@ -81,248 +95,393 @@ func ResponseHandler(conn *net.UDPConn, priv *ecdsa.PrivateKey) {
 //
 
 // RootReply : ajout de la racine Merkle au peer
-func HandleRootReply(id uint32, addr *net.UDPAddr, signed []byte, sig []byte, body []byte) {
-	if debugResponse {
-		fmt.Println("→ RootReply reçu")
+func (n *Node) HandleRootReply(id uint32, addr *net.UDPAddr, signed []byte, sig []byte, hash []byte) {
+	transportLog.Debug("RootReply reçu")
+	tr, ok := n.resolveTransaction(id)
+	if !ok {
+		n.handlePushedRoot(addr, signed, sig, hash)
+		return
+	}
+	if tr.MsgType != RootRequest {
+		return
+	}
+
+	if !n.VerifSign(addr, signed, sig) {
+		transportLog.Debug("Erreur de signature dans RootReply")
+		return
+	}
+
+	n.AddRootToPeerbyaddr(addr, hash)
+}
+
+// handlePushedRoot : RootReply sans RootRequest, accepté seulement d’un peer
+// associé avec qui ExtensionRootPush a été négociée (cf. pushRoot).
+func (n *Node) handlePushedRoot(addr *net.UDPAddr, signed []byte, sig []byte, hash []byte) {
+	peer, exist := n.FindPeerByAddr(addr)
+	if !exist || !peer.Has(ExtensionRootPush) {
+		transportLog.Debug("RootReply ignoré : pas de transaction correspondante")
+		return
 	}
-	tr, ok := resolveTransaction(id)
-	if !ok || tr.MsgType != RootRequest {
+	if !n.VerifSign(addr, signed, sig) {
+		transportLog.Debug("Erreur de signature dans le root poussé")
 		return
 	}
+	transportLog.Debug("root poussé par le peer", "peer", peer.Name)
+	n.AddRootToPeer(peer, hash)
+}
+
+// Error : erreur renvoyée par un peer, traitée selon son code
+//   - not-associated : le peer nous a oubliés, on refait le Hello
+//   - banned, shutdown : le peer ne nous répondra plus, on le marque déconnecté
+//   - encryption-required : une association en clair est marquée déconnectée
+//   - rate-limited, quota-exceeded : la fenêtre de DatumRequest est réduite
+func (n *Node) HandleErrorReply(id uint32, conn Transport, priv *ecdsa.PrivateKey, addr *net.UDPAddr, e *ErrorMsg) {
+	transportLog.Warn("Error reçu", "addr", addr, "code", e.Code, "message", e.Text)
+	n.countErrorReceived(e.Code)
 
-	if !VerifSign(addr, signed, sig) {
-		if debugResponse {
-			fmt.Println("Erreur de signature dans RootReply")
+	peer, exist := n.FindPeerByAddr(addr)
+	tr, resolved := n.resolveTransactionFrom(id, addr)
+	if resolved && tr.Peer != nil {
+		peer, exist = tr.Peer, true
+	}
+	if !exist {
+		return
+	}
+	if resolved && tr.datumSlots() > 0 {
+		// pas de Datum à attendre : on libère les places et on ralentit
+		peer.Window.OnTimeoutN(tr.datumSlots())
+	}
+
+	peer.Mupeer.Lock()
+	peer.LastError = e
+	peer.Mupeer.Unlock()
+	n.EmitPeerEvent(peer, EventErrorReceived, e.String())
+
+	switch e.Code {
+	case ErrCodeNotAssociated:
+		n.rehello(conn, priv, peer, addr)
+	case ErrCodeEncryptionRequired:
+		// le peer exige le chiffrement : une association en clair est rompue
+		if peer.Encrypted() {
+			return
 		}
+		peer.Mupeer.RLock()
+		associated := peer.State == PeerAssociated
+		peer.Mupeer.RUnlock()
+		if associated {
+			n.DeconnectPeer(peer)
+		}
+	case ErrCodeBanned, ErrCodeShutdown:
+		if !IsPeerDisconnected(peer) {
+			n.DeconnectPeer(peer)
+		}
+	}
+}
+
+// Délai minimal entre deux Hello refaits sur erreur not-associated
+var RehelloMinInterval = 10 * time.Second
+
+// rehello refait le Hello avec un peer qui ne nous connaît plus (au plus une
+// fois par RehelloMinInterval : deux peers qui s’oublient ne bouclent pas).
+func (n *Node) rehello(conn Transport, priv *ecdsa.PrivateKey, peer *Peer, addr *net.UDPAddr) {
+	peer.Mupeer.Lock()
+	if time.Since(peer.helloRetryAt) < RehelloMinInterval {
+		peer.Mupeer.Unlock()
 		return
 	}
+	peer.helloRetryAt = time.Now()
+	if peer.ActiveAddr == nil {
+		peer.ActiveAddr = addr
+	}
+	peer.Mupeer.Unlock()
 
-	AddRootToPeerbyaddr(addr, body)
+	transportLog.Info("peer non associé, on refait le Hello", "peer", peer.Name)
+	n.SendHello(conn, priv, peer)
 }
 
 // OK : confirmation reçue
-func HandleOk(id uint32, addr *net.UDPAddr) {
-	if debugResponse {
-		fmt.Println("→ OK reçu")
-	}
-	tx, ok := resolveTransaction(id)
+func (n *Node) HandleOk(id uint32, addr *net.UDPAddr) {
+	transportLog.Debug("OK reçu")
+	tx, ok := n.resolveTransaction(id)
 	if !ok {
 		return
 	}
 
 	if tx.MsgType == NatTraversalRequest {
 		peer := tx.Peer
-		if debugResponse {
-			fmt.Println("Ok reçu pour NatTraversalRequest, peer connecté ?", peer.State == PeerAssociated)
-		}
+		transportLog.Debug("Ok reçu pour NatTraversalRequest", "associated", peer.State == PeerAssociated)
 	}
 }
 
 // Datum : données reçues d’un peer
-func HandleDatum(id uint32, addr *net.UDPAddr, body []byte) {
-	tr, ok := resolveTransaction(id)
+func (n *Node) HandleDatum(id uint32, addr *net.UDPAddr, datum *DatumMsg) {
+	switch typ, _ := n.transactionType(id); typ {
+	case BatchDatumRequest:
+		n.handleBatchDatum(id, addr, datum)
+		return
+	case SubtreeRequest:
+		n.handleSubtreeDatum(id, addr, datum)
+		return
+	}
+	tr, ok := n.resolveTransaction(id)
 	if !ok || tr.MsgType != DatumRequest {
-		if debugResponse {
-			fmt.Println("Différent de Datum request")
-		}
+		transportLog.Debug("Différent de Datum request")
 		return
 	}
 
-	peer, exist := FindPeerByAddr(addr)
+	peer, exist := n.FindPeerByAddr(addr)
 	if !exist {
-		if debugResponse {
-			fmt.Println("Peer non trouvé pour Datum")
-		}
+		transportLog.Debug("Peer non trouvé pour Datum")
 		return
 	}
 	// Calcul RTT
 	rtt := time.Since(tr.SentAt)
 	peer.Window.OnSuccess(rtt)
+	n.observeRTT(rtt)
 
-	DataBody := body
-	// Déchiffrement si nécessaire
-	if peer.SharedKey != nil {
-		if len(body) < clientStorage.HashSize {
-			if debugResponse {
-				fmt.Println("Corps de message trop court pour déchiffrement")
-			}
-			return
-		}
-		cipher := body
-		if debugCrypto {
-			fmt.Println("ici on déchiffre les messages ")
-			fmt.Printf("Key decrypt: %x\n", peer.SharedKey)
-		}
-		plaintext, err := decryptAESGCM(peer.SharedKey, cipher)
+	datum, ok = n.openDatum(peer, datum)
+	if !ok {
+		return
+	}
+
+	// Hash demandé, relu dans notre propre requête
+	request, err := sentMessage(tr)
+	req, ok := request.(*DatumRequestMsg)
+	if err != nil || !ok {
+		transportLog.Debug("error body not find", "err", err)
+		return
+	}
+	n.acceptDatum(peer, addr, datum, req.Hash)
+}
+
+// openDatum déchiffre (si une clé est partagée avec peer) et décompresse un
+// Datum reçu ; un échec est compté contre le peer.
+// Retour : Datum en clair, false s’il est rejeté
+func (n *Node) openDatum(peer *Peer, datum *DatumMsg) (*DatumMsg, bool) {
+	// Déchiffrement si nécessaire : tout le body est chiffré, on le décode à nouveau
+	sharedKey := getSharedKey(peer)
+	if sharedKey != nil {
+		cipher, _ := datum.MarshalBody()
+		transportLog.Debug("ici on déchiffre les messages")
+		plaintext, err := decryptAESGCM(sharedKey, cipher)
 		if err != nil {
-			fmt.Println("Erreur déchiffrement Datum :", err)
-			return
+			transportLog.Warn("Erreur déchiffrement Datum", "err", err)
+			n.countIntegrityFailure()
+			n.reportPeerMisbehaviour(peer, MisBadDatum)
+			return nil, false
+		}
+		datum = &DatumMsg{}
+		if err := datum.UnmarshalBody(plaintext); err != nil {
+			transportLog.Debug("Datum déchiffré malformé", "err", err)
+			n.reportPeerMisbehaviour(peer, MisMalformed)
+			return nil, false
 		}
-		DataBody = plaintext
 	}
 
-	body, len, ok := getBody(tr.Msg)
-	if !ok {
-		if debugResponse {
-			fmt.Println("error body not find")
-		}
+	// Valeur compressée : le hash porte sur le nœud décompressé
+	if err := inflateDatum(peer, datum); err != nil {
+		transportLog.Warn("Datum compressé rejeté", "err", err)
+		n.countIntegrityFailure()
+		n.reportPeerMisbehaviour(peer, MisMalformed)
+		return nil, false
+	}
+	return datum, true
+}
+
+// acceptDatum vérifie qu’un Datum en clair est bien le nœud requested, puis
+// le stocke et programme la demande de ses enfants (cf. HandlefileDataWindow).
+func (n *Node) acceptDatum(peer *Peer, addr *net.UDPAddr, datum *DatumMsg, requested []byte) {
+	if !n.verifyDatum(peer, datum, requested) {
 		return
 	}
+	n.HandlefileDataWindow(datum, nil, addr)
+	n.checkMerkleDone(peer)
+}
 
-	// Vérification de l’intégrité des données
-	if VerifyDataIntegrity(DataBody, body[:len]) {
-		if debugResponse {
-			fmt.Println("Intégrité des données vérifiée")
-		}
-		HandlefileDataWindow(DataBody, nil, addr)
+// verifyDatum vérifie l’intégrité d’un Datum en clair : son hash et celui de
+// sa valeur doivent être requested. Un échec est compté contre le peer.
+func (n *Node) verifyDatum(peer *Peer, datum *DatumMsg, requested []byte) bool {
+	if bytes.Equal(datum.Hash, requested) && bytes.Equal(clientStorage.Sha(datum.Value), requested) {
+		transportLog.Debug("Intégrité des données vérifiée")
+		return true
+	}
+	transportLog.Debug("Intégrité des données échouée pour Datum")
+	n.countIntegrityFailure()
+	n.reportPeerMisbehaviour(peer, MisBadDatum)
+	return false
+}
 
-		if debugResponse {
-			fmt.Println("Merkle Done : ", peer.MerkleDone)
-			fmt.Println("clientStorage.VerifyMerkle(peer.Root)", clientStorage.VerifyMerkle(peer.Root))
-		}
-		if !peer.MerkleDone && clientStorage.VerifyMerkle(peer.Root) {
-			peer.MerkleDone = true
+// checkMerkleDone signale la fin du téléchargement de l’arbre de peer dès
+// que tous ses nœuds sont dans le Store.
+func (n *Node) checkMerkleDone(peer *Peer) {
+	peer.Mupeer.RLock()
+	done, root := peer.MerkleDone, peer.Root
+	peer.Mupeer.RUnlock()
+	transportLog.Debug("Merkle Done", "merkleDone", done)
+	if done || !n.store.VerifyMerkle(root) {
+		return
+	}
+
+	// un seul worker signale la fin, et seulement si le root n'a pas changé entre-temps
+	peer.Mupeer.Lock()
+	if peer.MerkleDone || !bytes.Equal(peer.Root, root) {
+		peer.Mupeer.Unlock()
+		return
+	}
+	peer.MerkleDone = true
+	start := peer.MerkleDownloadStart
+	peer.Mupeer.Unlock()
 
-			fmt.Println("-> ----  Téléchargement terminée ----")
+	transportLog.Info("Téléchargement terminée")
 
-			if !peer.MerkleDownloadStart.IsZero() && OnPeerEvent != nil {
-				duration := time.Since(peer.MerkleDownloadStart)
-				OnPeerEvent(peer, EventMerkleDownloadComplete, fmt.Sprintf("durée: %s", duration.Round(time.Millisecond)))
-			}
-		}
-	} else {
-		if debugResponse {
-			fmt.Println("Intégrité des données échouée pour Datum")
-		}
+	if !start.IsZero() {
+		duration := time.Since(start)
+		n.EmitPeerEvent(peer, EventMerkleDownloadComplete, fmt.Sprintf("durée: %s", duration.Round(time.Millisecond)))
 	}
 }
 
-// NoDatum : le peer n’a pas la donnée demandée
-func HandleNoDatum(id uint32, addr *net.UDPAddr, signed []byte, sig []byte) {
-	tr, ok := resolveTransaction(id)
-	if !ok || tr.MsgType != DatumRequest {
+// NoDatum : le peer n’a pas la donnée demandée (hash : hash annoncé, qui
+// désigne la réponse dans une requête groupée)
+func (n *Node) HandleNoDatum(id uint32, addr *net.UDPAddr, hash []byte, signed []byte, sig []byte) {
+	var tr *Transaction
+	var ok bool
+	switch typ, _ := n.transactionType(id); typ {
+	case BatchDatumRequest:
+		tr, ok = n.resolveBatchHash(id, addr, hash)
+	case SubtreeRequest:
+		n.handleSubtreeNoDatum(id, addr, hash, signed, sig)
 		return
+	default:
+		tr, ok = n.resolveTransaction(id)
+		ok = ok && tr.MsgType == DatumRequest
 	}
-	peer, exist := FindPeerByAddr(addr)
+	if !ok {
+		return
+	}
+	peer, exist := n.FindPeerByAddr(addr)
 	if !exist {
-		if debugResponse {
-			fmt.Println("le peer n'existe pas Handle No Datum")
-		}
+		transportLog.Debug("le peer n'existe pas Handle No Datum")
 		return
 	}
 
 	rtt := time.Since(tr.SentAt)
 	peer.Window.OnSuccess(rtt)
+	n.observeRTT(rtt)
 
-	if !VerifSign(addr, signed, sig) {
-		fmt.Println("Erreur de signature dans NoDatum")
+	if !n.VerifSign(addr, signed, sig) {
+		transportLog.Warn("Erreur de signature dans NoDatum")
 	}
 
-	if OnPeerEvent != nil {
-		OnPeerEvent(peer, EventNoDatum, " :(")
-	}
+	n.EmitPeerEvent(peer, EventNoDatum, " :(")
 }
 
 // HelloReply : traitement du retour Hello d’un peer
-func HandleHelloReply(id uint32, conn *net.UDPConn, priv *ecdsa.PrivateKey, signed []byte, sig []byte) error {
-	if debugResponse {
-		fmt.Println("-> HandleHelloReply")
-	}
+func (n *Node) HandleHelloReply(id uint32, conn Transport, priv *ecdsa.PrivateKey, reply *HelloMsg, signed []byte, sig []byte) error {
+	transportLog.Debug("HandleHelloReply")
 
 	// 1. Vérifier si une transaction existe
-	transaction, ok := resolveTransaction(id)
+	transaction, ok := n.resolveTransaction(id)
 	if !ok {
-		if debugResponse {
-			fmt.Println("HelloReply ignoré : pas de transaction correspondante")
-		}
+		transportLog.Debug("HelloReply ignoré : pas de transaction correspondante")
 		return nil
 	}
 
 	peer := transaction.Peer
 	if peer == nil {
-		if debugResponse {
-			fmt.Println("transaction sans peer associé")
-		}
+		transportLog.Debug("transaction sans peer associé")
 		return fmt.Errorf("transaction sans peer associé")
 	}
 
 	// 2. Vérifier la signature du message
-	okSign, err := VerifyMessage(peer.PublicKey, signed, sig)
+	peer.Mupeer.RLock()
+	peerKey := peer.PublicKey
+	peer.Mupeer.RUnlock()
+	okSign, err := VerifyMessage(peerKey, signed, sig)
 	if err != nil {
-		if debugResponse {
-			fmt.Println("Erreur de verification de la signature :", err)
-		}
+		transportLog.Debug("Erreur de verification de la signature", "err", err)
+		n.countSigFailure()
 		return err
 	}
 	if !okSign {
-		OnPeerEvent(peer, EventConnectionFailed, "HelloReply Non Signé Correctement, on ignore le peer.")
-		if debugResponse {
-			fmt.Println("Paquet non signé correctement, rejet du peer")
-		}
+		n.countSigFailure()
+		n.reportPeerMisbehaviour(peer, MisBadSignature)
+		n.EmitPeerEvent(peer, EventConnectionFailed, "HelloReply Non Signé Correctement, on ignore le peer.")
+		transportLog.Debug("Paquet non signé correctement, rejet du peer")
 		return nil
 	}
 
-	if debugResponse {
-		fmt.Println("Paquet vérifié conforme, on traite le peer")
-	}
+	transportLog.Debug("Paquet vérifié conforme, on traite le peer")
 
-	// 1. Extraire le body de la transaction
-	body, _, ok := getBody(transaction.Msg)
-	if !ok {
-		if debugResponse {
-			fmt.Println("Erreur parsing body transaction")
-		}
-		return fmt.Errorf("Erreur parsing body transaction %d", transaction.Id)
+	// 1. Relire notre Hello dans la transaction
+	sent, err := sentMessage(transaction)
+	hello, ok := sent.(*HelloMsg)
+	if err != nil || !ok {
+		transportLog.Debug("Erreur parsing body transaction")
+		return fmt.Errorf("transaction %d : HelloReply inattendu (%s)", transaction.Id, MsgTypeName(transaction.MsgType))
 	}
 
 	// 3. Gérer le peer "simple" (pas de chiffrement DH)
-	// On vérifie si le peer implémente l'extension de diffie hellman
-	crypted := IsChiffrementEnabled(body)
-	if debugResponse {
-		if crypted == true {
-			fmt.Println("crypted vaut true")
+	// On vérifie si notre Hello annonçait l'extension de diffie hellman avec
+	// une clé (jamais au serveur) ; on négocie avec les extensions annoncées
+	n.negotiate(peer, hello.Extensions, reply)
+	crypted := hello.Encrypted() && hello.DHPub != nil && reply.Encrypted()
+	transportLog.Debug("HelloReply reçu", "crypted", crypted)
+	if !crypted && n.EncryptionPolicy(peer.Name) == EncryptRequired {
+		transportLog.Info("HelloReply refusé : chiffrement exigé", "peer", peer.Name)
+		n.EmitPeerEvent(peer, EventConnectionFailed, "chiffrement exigé mais non annoncé par le peer")
+		peer.Mupeer.Lock()
+		peer.SharedKey = nil
+		associated := peer.State == PeerAssociated
+		peer.Mupeer.Unlock()
+		if associated {
+			n.DeconnectPeer(peer)
 		}
+		return nil
 	}
-	if !crypted || peer.Name == NameofServeurUDP {
-		if debugExtension {
-			fmt.Println("-> Hello Reply: Message non chiffré")
-		}
-		return handlePlainHelloReply(transaction, peer, conn, priv)
+	if !crypted {
+		transportLog.Debug("Hello Reply: Message non chiffré")
+		return n.handlePlainHelloReply(transaction, peer, conn, priv)
 	}
-	if debugExtension {
-		fmt.Println("-> Hello Reply : Message chiffré")
+	if reply.DHPub == nil {
+		transportLog.Debug("HelloReply sans clé Diffie-Hellman")
+		return fmt.Errorf("HelloReply de %s sans clé Diffie-Hellman", peer.Name)
 	}
-	// 4. Gérer le peer chiffré / clé DHted :=
-	return handleDHHelloReply(transaction, peer, signed, body, conn, priv)
+	transportLog.Debug("Hello Reply : Message chiffré")
+	// 4. Gérer le peer chiffré / clé DH
+	return n.handleDHHelloReply(transaction, peer, reply.DHPub, conn, priv)
+}
+
+// sentMessage décode le message que nous avons envoyé dans une transaction.
+func sentMessage(tr *Transaction) (Message, error) {
+	p, err := ParsePacket(tr.Msg)
+	if err != nil {
+		return nil, err
+	}
+	return p.Message()
 }
 
 // -----------------------------
 // Gestion d'un HelloReply non chiffré
 // -----------------------------
-func handlePlainHelloReply(transaction *Transaction, peer *Peer, conn *net.UDPConn, priv *ecdsa.PrivateKey) error {
+func (n *Node) handlePlainHelloReply(transaction *Transaction, peer *Peer, conn Transport, priv *ecdsa.PrivateKey) error {
 	if transaction.MsgType != Hello {
-		if debugResponse {
-			fmt.Println("Transaction non attendue pour HelloReply, on ignore")
-		}
+		transportLog.Debug("Transaction non attendue pour HelloReply, on ignore")
 		return nil
 	}
 
-	if debugResponse {
-		fmt.Println("Connection établie pour peer non chiffré !")
-	}
-	peer.Mupeer.RLock()
+	transportLog.Debug("Connection établie pour peer non chiffré !")
+	peer.Mupeer.Lock()
+	peer.SharedKey = nil // association renégociée en clair
 	state := peer.State
-	peer.Mupeer.RUnlock()
+	peer.Mupeer.Unlock()
 
 	// on lance la maintenance
 	if state == PeerDiscovered {
-		if debugMaintenance {
-			fmt.Println("-> Peer discovered on lance la maintenance")
-		}
-		go MaintenancePerPeer(conn, priv, peer)
+		transportLog.Debug("Peer discovered on lance la maintenance")
+		n.spawn(func() { n.MaintenancePerPeer(conn, priv, peer) })
 	}
 	// puis on le note comme connecté
-	connectPeer(peer)
+	n.connectPeer(peer)
 
 	return nil
 }
@@ -330,53 +489,42 @@ func handlePlainHelloReply(transaction *Transaction, peer *Peer, conn *net.UDPCo
 // -----------------------------
 // Gestion d'un HelloReply avec clé partagée DH
 // -----------------------------
-func handleDHHelloReply(transaction *Transaction, peer *Peer, signed []byte, body []byte, conn *net.UDPConn, priv *ecdsa.PrivateKey) error {
+// (peerDH : clé publique Diffie-Hellman portée par le HelloReply)
+func (n *Node) handleDHHelloReply(transaction *Transaction, peer *Peer, peerDH []byte, conn Transport, priv *ecdsa.PrivateKey) error {
 
-	// 2. Récupérer clé publique du peer depuis le message signé
-	if len(signed) < 64 {
-		if debugResponse {
-			fmt.Println("message HelloReply trop court pour extraire clé publique")
-		}
-		return fmt.Errorf("message HelloReply trop court pour extraire clé publique")
-	}
-	dhPub, err := ParsePublicKey(signed[len(signed)-64:])
+	// 2. Décoder la clé publique DH du peer
+	dhPub, err := ParsePublicKey(peerDH)
 	if err != nil {
-		fmt.Println("Erreur ParsePublicKey :", err)
+		transportLog.Warn("Erreur ParsePublicKey", "err", err)
 		return err
 	}
 
 	// 3. Calculer la clé partagée
 	sharedKey, err := ComputeSharedKey(transaction.DhPriv, dhPub)
 	if err != nil {
-		fmt.Println("Erreur calcul clé partagée :", err)
+		transportLog.Warn("Erreur calcul clé partagée", "err", err)
 		return err
 	}
 
-	if debugResponse {
-		fmt.Println("Clé publique reçue :", hex.EncodeToString(signed[len(signed)-64:]))
-		fmt.Println("Clé publique body :", hex.EncodeToString(body[len(body)-64:]))
-		fmt.Println("Secret partagé :", hex.EncodeToString(sharedKey))
-	}
+	transportLog.Debug("Clé publique reçue", "dh", hex.EncodeToString(peerDH))
 
 	// 4. Connecter le peer et stocker la clé partagée
 	if transaction.MsgType == Hello {
 
+		peer.Mupeer.Lock()
 		peer.SharedKey = sharedKey
-		if debugResponse {
-			fmt.Println("Connection établie et clé partagée générée !")
-		}
+		peer.Mupeer.Unlock()
+		transportLog.Debug("Connection établie et clé partagée générée !")
 
 		peer.Mupeer.RLock()
 		state := peer.State
 		peer.Mupeer.RUnlock()
 		if state == PeerDiscovered {
-			if debugMaintenance {
-				fmt.Println("-> Peer discovered on lance la maintenance")
-			}
-			go MaintenancePerPeer(conn, priv, peer)
+			transportLog.Debug("Peer discovered on lance la maintenance")
+			n.spawn(func() { n.MaintenancePerPeer(conn, priv, peer) })
 		}
 		// on lance la maintenance puis on le note comme connecté
-		connectPeer(peer)
+		n.connectPeer(peer)
 	}
 
 	return nil
@@ -6,14 +6,11 @@ import (
 	"encoding/binary"
 	"encoding/hex"
 	"fmt"
-	"myp2p/clientStorage"
 	"net"
 	"sync/atomic"
 	"time"
 )
 
-var debug = true
-
 //
 // ======================= TYPES DE MESSAGES =======================
 //
@@ -27,6 +24,8 @@ const (
 	DatumRequest         uint8 = 3
 	NatTraversalRequest  uint8 = 4
 	NatTraversalRequest2 uint8 = 5
+	BatchDatumRequest    uint8 = 6 // extension batching (cf. batch.go)
+	SubtreeRequest       uint8 = 7 // extension subtree (cf. subtree.go)
 	// … autres types de requêtes possibles
 
 	// ---------- Réponses ----------
@@ -36,17 +35,53 @@ const (
 	RootReply  uint8 = 131
 	Datum      uint8 = 132
 	NoDatum    uint8 = 133
+	SubtreeEnd uint8 = 134 // fin d’une réponse à SubtreeRequest
 )
 
 //
 // ======================= FONCTIONS UTILITAIRES =======================
 //
 
-var globalId uint32 = 0
+// MsgTypeName renvoie le nom d’un type de message ("unknown" s’il n’est pas défini).
+func MsgTypeName(t uint8) string {
+	switch t {
+	case Ping:
+		return "Ping"
+	case Hello:
+		return "Hello"
+	case RootRequest:
+		return "RootRequest"
+	case DatumRequest:
+		return "DatumRequest"
+	case NatTraversalRequest:
+		return "NatTraversalRequest"
+	case NatTraversalRequest2:
+		return "NatTraversalRequest2"
+	case BatchDatumRequest:
+		return "BatchDatumRequest"
+	case SubtreeRequest:
+		return "SubtreeRequest"
+	case Ok:
+		return "Ok"
+	case Error:
+		return "Error"
+	case HelloReply:
+		return "HelloReply"
+	case RootReply:
+		return "RootReply"
+	case Datum:
+		return "Datum"
+	case NoDatum:
+		return "NoDatum"
+	case SubtreeEnd:
+		return "SubtreeEnd"
+	}
+	return "unknown"
+}
 
-func GenerateId() uint32 {
+func (n *Node) GenerateId() uint32 {
 	// incrémente atomiquement et retourne la nouvelle valeur
-	return atomic.AddUint32(&globalId, 1) - 1
+	return atomic.AddUint32(&n.globalId, 1) - 1
 }
 
 //
@@ -62,6 +97,9 @@ func GenerateId() uint32 {
 //   - sign  : booléen pour indiquer si on signe le message
 
 func BuildMessage(id uint32, types uint8, body []byte, priv *ecdsa.PrivateKey, sign bool) ([]byte, error) {
+	if len(body) > MaxBodySize {
+		return nil, fmt.Errorf("body de %d octets, maximum %d", len(body), MaxBodySize)
+	}
 	buf := new(bytes.Buffer)
 
 	// ID (4 octets)
@@ -81,16 +119,12 @@ func BuildMessage(id uint32, types uint8, body []byte, priv *ecdsa.PrivateKey, s
 		signPart := buf.Bytes()
 		sig, err := SignMessage(priv, signPart)
 		if err != nil {
-			if debug {
-				fmt.Println("Erreur de signature")
-			}
+			transportLog.Debug("Erreur de signature")
 			return nil, err
 		}
 		// Ajouter la signature à la fin
 		if _, err := buf.Write(sig); err != nil {
-			if debug {
-				fmt.Println("erreur d'écriture ")
-			}
+			transportLog.Debug("erreur d'écriture")
 			return nil, err
 		}
 	}
@@ -107,113 +141,56 @@ func BuildMessage(id uint32, types uint8, body []byte, priv *ecdsa.PrivateKey, s
 //   - reply      : type du message (Hello ou HelloReply)
 
 func BuildHello(id uint32, extensions uint32, name string, priv *ecdsa.PrivateKey, reply uint8) ([]byte, error) {
-	// Construire le body : extensions (4 octets) + nom du peer
-	body := make([]byte, ExtensionField+len(name))
-
-	// Extensions
-	body[0] = byte(extensions >> 24)
-	body[1] = byte(extensions >> 16)
-	body[2] = byte(extensions >> 8)
-	body[3] = byte(extensions)
-
-	// Nom du peer
-	copy(body[ExtensionField:], []byte(name))
-
-	// Utiliser BuildMessage pour créer le message complet avec signature
-	msg, err := BuildMessage(id, byte(reply), body, priv, true)
-	if err != nil {
-		if reply == Hello {
-			if debug {
-				fmt.Println("fail build Hello :", err, reply)
-			}
-		}
-		if reply == HelloReply {
-			if debug {
-				fmt.Println("fail build HelloReply :", err, reply)
-			}
-		}
-		return nil, err
-	}
-
-	return msg, nil
+	return BuildHelloDH(id, extensions, name, nil, priv, reply)
 }
 
 // BuildHelloDH construit un Hello/HelloReply avec clé Diffie-Hellman
+// (dh_pub nil : pas de clé, cf. BuildHello)
 func BuildHelloDH(id uint32, extensions uint32, name string, dh_pub []byte, priv *ecdsa.PrivateKey, reply uint8) ([]byte, error) {
-	if debug {
-		fmt.Println("BuildHelloDH")
-	}
-	body := make([]byte, ExtensionField+len(name)+len(dh_pub))
-
-	// Extensions
-	body[0] = byte(extensions >> 24)
-	body[1] = byte(extensions >> 16)
-	body[2] = byte(extensions >> 8)
-	body[3] = byte(extensions)
-
-	// Nom du peer
-	copy(body[ExtensionField:], []byte(name))
-	body = append(body, dh_pub...)
+	return BuildHelloMsg(id, &HelloMsg{Reply: reply == HelloReply, Extensions: extensions, Name: name, DHPub: dh_pub}, priv)
+}
 
-	// Utiliser BuildMessage pour créer le message complet avec signature
-	msg, err := BuildMessage(id, byte(reply), body, priv, true)
+// BuildHelloMsg construit et signe un Hello/HelloReply complet (TLV compris)
+func BuildHelloMsg(id uint32, m *HelloMsg, priv *ecdsa.PrivateKey) ([]byte, error) {
+	// Utiliser EncodeMessage pour créer le message complet avec signature
+	msg, err := EncodeMessage(id, m, priv, true)
 	if err != nil {
-		if reply == Hello {
-			if debug {
-				fmt.Println("fail build Hello :", err, reply)
-			}
-		}
-		if reply == HelloReply {
-			if debug {
-				fmt.Println("fail build HelloReply :", err, reply)
-			}
-		}
+		transportLog.Debug("fail build "+MsgTypeName(m.MsgType()), "err", err)
 		return nil, err
 	}
-
 	return msg, nil
-
 }
 
 // BuildDatumRequest construit une requête de donnée pour un hash donné
 func BuildDatumRequest(id uint32, hash []byte) ([]byte, error) {
-	// Construire le body : hash (32 octets)
-	body := make([]byte, clientStorage.HashSize)
-	// hash
-	copy(body, hash)
-
-	// Utiliser BuildMessage pour créer le message complet avec signature
-	msg, err := BuildMessage(id, byte(DatumRequest), body, nil, false)
+	msg, err := EncodeMessage(id, &DatumRequestMsg{Hash: hash}, nil, false)
 	if err != nil {
-		if debug {
-			fmt.Println("fail build DatumRequest :", err)
-		}
+		transportLog.Debug("fail build DatumRequest", "err", err)
 		return nil, err
 	}
-
 	return msg, nil
+}
 
+// BuildBatchDatumRequest construit une requête groupée pour plusieurs hashes
+func BuildBatchDatumRequest(id uint32, hashes [][]byte) ([]byte, error) {
+	msg, err := EncodeMessage(id, &BatchDatumRequestMsg{Hashes: hashes}, nil, false)
+	if err != nil {
+		transportLog.Debug("fail build BatchDatumRequest", "err", err)
+		return nil, err
+	}
+	return msg, nil
 }
 
 // BuildNatTraversalRequest construit une requête NAT Traversal
+// (msgType : NatTraversalRequest ou NatTraversalRequest2)
 func BuildNatTraversalRequest(
 	id uint32,
 	priv *ecdsa.PrivateKey,
 	addr *net.UDPAddr,
 	msgType uint8,
 ) ([]byte, error) {
-
-	buf := new(bytes.Buffer)
-
-	if ip4 := addr.IP.To4(); ip4 != nil {
-		buf.Write(ip4)
-		binary.Write(buf, binary.BigEndian, uint16(addr.Port))
-	} else {
-		buf.Write(addr.IP.To16())
-		binary.Write(buf, binary.BigEndian, uint16(addr.Port))
-	}
-
-	return BuildMessage(id, msgType, buf.Bytes(), priv, true)
+	m := &NatTraversalMsg{Second: msgType == NatTraversalRequest2, Addr: addr}
+	return EncodeMessage(id, m, priv, true)
 }
 
 //
@@ -221,66 +198,54 @@ func BuildNatTraversalRequest(
 //
 
 // SendMessage envoie un message UDP à un peer et affiche les infos
-func SendMessage(conn *net.UDPConn, addr *net.UDPAddr, msg []byte) error {
+func SendMessage(conn Transport, addr *net.UDPAddr, msg []byte) error {
 	n, err := conn.WriteToUDP(msg, addr)
 
-	id, msgType, _, body, _, _, ok := parseRecvMessage(msg)
-
-	if ok {
-		if debug {
-			fmt.Printf("→ Envoyé type=%d id=%d à %s\n %s,  nombre d'octets écrits  n : %d ", msgType, id, addr.String(), string(body), n)
-		}
-	}
-	if debug {
-		fmt.Println(" nombre d'octets écrits  n : ", n)
+	if p, perr := ParsePacket(msg); perr == nil {
+		transportLog.Debug(fmt.Sprintf("Envoyé type=%d id=%d à %s, nombre d'octets écrits n : %d", p.Type, p.ID, addr.String(), n))
 	}
+	transportLog.Debug("nombre d'octets écrits n", "n", n)
 	return err
 }
 
 // SendOk envoie une réponse OK
-func SendOk(conn *net.UDPConn, id uint32, priv *ecdsa.PrivateKey, addr *net.UDPAddr) error {
+func SendOk(conn Transport, id uint32, priv *ecdsa.PrivateKey, addr *net.UDPAddr) error {
 	msg, err := BuildMessage(id, Ok, []byte{}, priv, false)
 	if err != nil {
-		if debug {
-			fmt.Println("Erreur BuildMessage dans SendOk")
-		}
+		transportLog.Debug("Erreur BuildMessage dans SendOk")
 		return err
 	}
 	return SendMessage(conn, addr, msg)
 }
 
-// SendErrorMessage envoie un message d'erreur avec texte
-func SendErrorMessage(conn *net.UDPConn, id uint32, priv *ecdsa.PrivateKey, addr *net.UDPAddr, msg string) error {
-	body := []byte(msg)
-
-	pkt, err := BuildMessage(id, Error, body, priv, false)
+// SendErrorCode envoie une erreur avec son code et un texte optionnel
+// Paramètres :
+//   - code : cause de l’erreur (cf. ErrorCode)
+//   - msg  : texte lisible ("" = aucun)
+func SendErrorCode(conn Transport, id uint32, priv *ecdsa.PrivateKey, addr *net.UDPAddr, code ErrorCode, msg string) error {
+	pkt, err := EncodeMessage(id, &ErrorMsg{Code: code, Text: msg}, priv, false)
 	if err != nil {
-		if debug {
-			fmt.Println("Erreur BuildMessage dans SendErrorMessage")
-		}
+		transportLog.Debug("Erreur BuildMessage dans SendErrorCode")
 		return err
 	}
 	return SendMessage(conn, addr, pkt)
 }
 
-// SendError envoie une erreur générique sans body
-func SendError(conn *net.UDPConn, id uint32, priv *ecdsa.PrivateKey, addr *net.UDPAddr) error {
+// SendErrorMessage envoie un message d'erreur avec texte, sans code
+func SendErrorMessage(conn Transport, id uint32, priv *ecdsa.PrivateKey, addr *net.UDPAddr, msg string) error {
+	return SendErrorCode(conn, id, priv, addr, ErrCodeNone, msg)
+}
 
-	msg, err := BuildMessage(id, Error, []byte{}, priv, false)
-	if err != nil {
-		fmt.Println("Erreur BuildMessage dans SendOk")
-		return err
-	}
-	return SendMessage(conn, addr, msg)
+// SendError envoie une erreur générique sans body
+func SendError(conn Transport, id uint32, priv *ecdsa.PrivateKey, addr *net.UDPAddr) error {
+	return SendErrorCode(conn, id, priv, addr, ErrCodeNone, "")
 }
 
 // sendGenericMessage envoie un message UDP générique (option sign)
-func sendGenericMessage(conn *net.UDPConn, priv *ecdsa.PrivateKey, addr *net.UDPAddr, id uint32, msgType uint8, body []byte, sign bool) {
+func sendGenericMessage(conn Transport, priv *ecdsa.PrivateKey, addr *net.UDPAddr, id uint32, msgType uint8, body []byte, sign bool) {
 	msg, err := BuildMessage(id, msgType, body, priv, sign)
 	if err != nil {
-		if debug {
-			fmt.Printf("Erreur BuildMessage type=%d: %v\n", msgType, err)
-		}
+		transportLog.Debug(fmt.Sprintf("Erreur BuildMessage type=%d: %v", msgType, err))
 		return
 	}
 	SendMessage(conn, addr, msg)
@@ -291,17 +256,14 @@ func sendGenericMessage(conn *net.UDPConn, priv *ecdsa.PrivateKey, addr *net.UDP
 //
 
 // Essaie de traverser un NAT pour un peer donné avec mécanisme de changement d'adresse en cas d'échec
-func TryNatTraversal(conn *net.UDPConn, priv *ecdsa.PrivateKey, peer *Peer) bool {
-	if debug {
-		fmt.Println("On essaye le NatTraversal : " + peer.Name)
-	}
+func (n *Node) TryNatTraversal(conn Transport, priv *ecdsa.PrivateKey, peer *Peer) bool {
+	transportLog.Debug("On essaye le NatTraversal", "peer", peer.Name)
 	if peer.Name == NameofServeurUDP {
 		return false
 	}
 
-	if peer.AddrIndex == -1 {
-		peer.AddrIndex = 0
-		fmt.Println("pas de changement d'addresse")
+	if !peer.resetAddrIndex() {
+		transportLog.Info("pas de changement d'addresse")
 		return false
 	}
 
@@ -310,16 +272,17 @@ func TryNatTraversal(conn *net.UDPConn, priv *ecdsa.PrivateKey, peer *Peer) bool
 	// si j'ai envoyé un NatTraversal la 1er fois AddrIndex était au max
 
 	// récupérer la prochaine adresse
-	if _, err := peer.NextAddress(); err == false {
+	target, ok := peer.NextAddress()
+	if !ok {
 		return false
 	}
 	// on construit le message
-	id := GenerateId()
+	id := n.GenerateId()
 
-	msg, err := BuildNatTraversalRequest(id, priv, peer.ActiveAddr, NatTraversalRequest)
+	msg, err := BuildNatTraversalRequest(id, priv, target, NatTraversalRequest)
 	if err != nil {
 
-		fmt.Println("erreur lors de la construction du NatTraversal dans TryNatTraversal ")
+		transportLog.Warn("erreur lors de la construction du NatTraversal dans TryNatTraversal")
 
 		return false
 	}
@@ -328,14 +291,14 @@ func TryNatTraversal(conn *net.UDPConn, priv *ecdsa.PrivateKey, peer *Peer) bool
 	server_addr, err := net.ResolveUDPAddr("udp", AddrServeurUDP)
 	if err != nil {
 
-		fmt.Printf("erreur lors de la transformation de l'adresse %s\n", server_addr)
+		transportLog.Warn(fmt.Sprintf("erreur lors de la transformation de l'adresse %s", server_addr))
 
 		return false
 	}
-	fmt.Println("Construction et envoie d'un message NatTraversalRequest, id : ", id)
+	transportLog.Info("Construction et envoie d'un message NatTraversalRequest, id", "id", id)
 	// on crée une transaction
 
-	CreateTransaction(id, peer, server_addr, NatTraversalRequest, msg, Retries-1)
+	n.CreateTransaction(id, peer, server_addr, NatTraversalRequest, msg, Retries-1)
 	SendMessage(conn, server_addr, msg)
 
 	return true
@@ -347,17 +310,11 @@ func TryNatTraversal(conn *net.UDPConn, priv *ecdsa.PrivateKey, peer *Peer) bool
 //
 
 // Essaie de se connecter à un peer via Hello avec mécanisme de changement d'adresse
-func HelloToPeer(conn *net.UDPConn, priv *ecdsa.PrivateKey, peer *Peer) bool {
-	if debug {
-		fmt.Println("Connection à un peer :" + peer.Name)
-	}
+func (n *Node) HelloToPeer(conn Transport, priv *ecdsa.PrivateKey, peer *Peer) bool {
+	transportLog.Debug("Connection à un peer", "peer", peer.Name)
 
-	if debug {
-		fmt.Printf("addrIndex : %d , len Addresses : %d \n", peer.AddrIndex, len(peer.Addresses))
-	}
-	if peer.AddrIndex == -1 {
-		peer.AddrIndex = 0
-		fmt.Println("pas de changement d'addresse")
+	if !peer.resetAddrIndex() {
+		transportLog.Info("pas de changement d'addresse")
 		return false
 	}
 
@@ -366,65 +323,62 @@ func HelloToPeer(conn *net.UDPConn, priv *ecdsa.PrivateKey, peer *Peer) bool {
 		return false
 	}
 
-	return SendHello(conn, priv, peer)
+	return n.SendHello(conn, priv, peer)
 }
 
-func SendHello(conn *net.UDPConn, priv *ecdsa.PrivateKey, peer *Peer) bool {
+func (n *Node) SendHello(conn Transport, priv *ecdsa.PrivateKey, peer *Peer) bool {
 
-	fmt.Println("SendHello à un peer :" + peer.Name)
-	if peer.PublicKey == nil {
+	transportLog.Info("SendHello à un peer", "peer", peer.Name)
+	peer.Mupeer.RLock()
+	hasKey := peer.PublicKey != nil
+	peer.Mupeer.RUnlock()
+	if !hasKey {
 		pub, err := GetPeerKey(peer.Name)
 		if err != nil {
-			if debug {
-				fmt.Println("GetPeerKey failed:", err)
-			}
+			transportLog.Debug("GetPeerKey failed", "err", err)
 			return false
 		}
+		peer.Mupeer.Lock()
 		peer.PublicKey = pub
+		peer.Mupeer.Unlock()
 	}
 
-	// envoi du hello
-	id := GenerateId()
+	// envoi du hello : bloc TLV seulement si le peer a déjà annoncé ExtensionVersion
+	id := n.GenerateId()
+	peer.Mupeer.RLock()
+	peerExt := peer.Extensions
+	addr := peer.ActiveAddr
+	peer.Mupeer.RUnlock()
+	ext, tlvs := n.helloExtensions(peer.Name, peerExt)
+	hello := &HelloMsg{Extensions: ext, Name: n.Name, TLVs: tlvs}
 	var msg []byte
-	if chiffre == false || peer.Name == NameofServeurUDP {
+	if !hello.Encrypted() || peer.Name == NameofServeurUDP {
 		var err error
-		ext := BuildExtension()
-		if debugExtension {
-			fmt.Printf("Voici l'extension Construite : 0x%08X\n", ext)
-		}
-		msg, err = BuildHello(id, ext, NameofOurPeer, priv, Hello)
+		transportLog.Debug(fmt.Sprintf("Voici l'extension Construite : 0x%08X", ext))
+		msg, err = BuildHelloMsg(id, hello, priv)
 		if err != nil {
-			if debug {
-				fmt.Println("erreur lors de la construction du helloRequest")
-			}
+			transportLog.Debug("erreur lors de la construction du helloRequest")
 			return false
 		}
 
-		CreateTransaction(id, peer, peer.ActiveAddr, Hello, msg, Retries-1)
+		n.CreateTransaction(id, peer, addr, Hello, msg, Retries-1)
 	} else {
 		dh_priv, dh_pub, err := GenerateKeyPair()
 		if err != nil {
 			return false
 		}
-		dh_pubByte := SerializePublicKey(dh_pub)
-		if debug {
-			fmt.Println("key pub genere(sendhello): ", hex.EncodeToString(dh_pubByte))
-		}
-		ext := BuildExtension()
-		if debugExtension {
-			fmt.Printf("Voici l'extension Construite (chiffré): 0x%08X\n", ext)
-		}
-		msg, err = BuildHelloDH(id, ext, NameofOurPeer, dh_pubByte, priv, Hello)
+		hello.DHPub = SerializePublicKey(dh_pub)
+		transportLog.Debug("key pub genere(sendhello)", "dh_pubByte", hex.EncodeToString(hello.DHPub))
+		transportLog.Debug(fmt.Sprintf("Voici l'extension Construite (chiffré): 0x%08X", ext))
+		msg, err = BuildHelloMsg(id, hello, priv)
 		if err != nil {
-			if debug {
-				fmt.Println("erreur lors de la construction du helloRequest")
-			}
+			transportLog.Debug("erreur lors de la construction du helloRequest")
 			return false
 		}
 		tx := &Transaction{
 			Id:      id,
 			Peer:    peer,
-			Addr:    peer.ActiveAddr,
+			Addr:    addr,
 			MsgType: Hello,
 			SentAt:  time.Now(),
 			Timeout: 1 * time.Second,
@@ -433,12 +387,10 @@ func SendHello(conn *net.UDPConn, priv *ecdsa.PrivateKey, peer *Peer) bool {
 			State:   TxPending,
 			DhPriv:  dh_priv,
 		}
-		addTransaction(tx)
-	}
-	if debug {
-		fmt.Println("peer activeaddr : ", peer.ActiveAddr)
+		n.addTransaction(tx)
 	}
-	SendMessage(conn, peer.ActiveAddr, msg)
+	transportLog.Debug("peer activeaddr", "activeAddr", addr)
+	SendMessage(conn, addr, msg)
 
 	return true
 }
@@ -2,9 +2,11 @@ package client
 
 import (
 	"bytes"
+	"context"
 	"crypto/ecdsa"
 	"fmt"
 	"io"
+	"log/slog"
 	"net"
 	"net/http"
 	"strings"
@@ -24,8 +26,6 @@ var (
 	ServerURL        = "https://jch.irif.fr:8443" // URL du serveur central HTTPS
 	AddrServeurUDP   = "jch.irif.fr:8443"         // Adresse serveur UDP
 	NameofServeurUDP = "jch.irif.fr"              // Nom serveur
-	debugServer      = false                      // Affiche les logs de debug si true
-	peersETag        = ""                         // ETag pour cache HTTP
 )
 
 // ============================
@@ -35,24 +35,18 @@ var (
 // ReadHTTP effectue un GET HTTP sur le serveur et retourne le corps.
 func ReadHTTP(path string) ([]byte, error) {
 	url := ServerURL + path
-	if debugServer {
-		fmt.Println("→ GET", url)
-	}
+	serverLog.Debug("GET", "url", url)
 
 	resp, err := http.Get(url)
 	if err != nil {
-		if debugServer {
-			fmt.Println("❌ Erreur GET ReadHTTP:", err)
-		}
+		serverLog.Debug("Erreur GET ReadHTTP", "err", err)
 		return nil, err
 	}
 	defer resp.Body.Close()
 
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		if debugServer {
-			fmt.Println("❌ Erreur lecture body ReadHTTP:", err)
-		}
+		serverLog.Debug("Erreur lecture body ReadHTTP", "err", err)
 		return nil, err
 	}
 	return body, nil
@@ -60,44 +54,38 @@ func ReadHTTP(path string) ([]byte, error) {
 
 // GetPeerListIfChanged récupère la liste des peers seulement si elle a changé
 // grâce à l'en-tête HTTP ETag.
-func GetPeerListIfChanged() ([]string, bool, error) {
+func (n *Node) GetPeerListIfChanged() ([]string, bool, error) {
 	req, err := http.NewRequest("GET", ServerURL+"/peers/", nil)
 	if err != nil {
 		return nil, false, fmt.Errorf("création requête GET /peers/ échouée : %w", err)
 	}
 
-	if peersETag != "" {
-		req.Header.Set("If-None-Match", peersETag)
+	if n.peersETag != "" {
+		req.Header.Set("If-None-Match", n.peersETag)
 	}
 
 	clientHTTP := &http.Client{Timeout: 10 * time.Second}
 	resp, err := clientHTTP.Do(req)
 	if err != nil {
-		if debugServer {
-			fmt.Println("Erreur Do GetPeerListIfChanged :", err)
-		}
+		serverLog.Debug("Erreur Do GetPeerListIfChanged", "err", err)
 		return nil, false, fmt.Errorf("GET /peers/ échoué : %w", err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode == http.StatusNotModified {
-		if debugServer {
-			fmt.Println("✔ Peers inchangés (HTTP 304)")
-		}
+		serverLog.Debug("Peers inchangés (HTTP 304)")
 		return nil, false, nil
 	}
 
 	// Mettre à jour ETag si présent
 	if etag := resp.Header.Get("ETag"); etag != "" {
-		peersETag = etag
+		n.peersETag = etag
 	}
 
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
 
-		if debugServer {
-			fmt.Println("❌ Erreur lecture body ReadHTTP:", err)
-		}
+		serverLog.Debug("Erreur lecture body ReadHTTP", "err", err)
 		return nil, false, fmt.Errorf("lecture body GET /peers/ échouée : %w", err)
 	}
 
@@ -112,9 +100,7 @@ func GetPeerList() ([]string, error) {
 		return nil, fmt.Errorf("GET /peers/ échoué : %w", err)
 	}
 
-	if debugServer {
-		fmt.Println("Body GET /peers/ :", string(body))
-	}
+	serverLog.Debug("Body GET /peers/", "body", string(body))
 
 	peers := strings.Split(strings.TrimSpace(string(body)), "\n")
 	return peers, nil
@@ -124,17 +110,13 @@ func GetPeerList() ([]string, error) {
 func GetPeerKey(name string) (*ecdsa.PublicKey, error) {
 	body, err := ReadHTTP("/peers/" + name + "/key")
 	if err != nil {
-		if debugServer {
-			fmt.Println("❌ Erreur GET clé peer:", err)
-		}
+		serverLog.Debug("Erreur GET clé peer", "err", err)
 		return nil, err
 	}
 
 	pub, err := ParsePublicKey(body)
 	if err != nil {
-		if debugServer {
-			fmt.Printf("❌ Erreur parsing clé publique du peer %s: %v\n", name, err)
-		}
+		serverLog.Debug(fmt.Sprintf("Erreur parsing clé publique du peer %s: %v", name, err))
 		return nil, err
 	}
 	return pub, nil
@@ -144,9 +126,7 @@ func GetPeerKey(name string) (*ecdsa.PublicKey, error) {
 func GetPeerAddresses(name string) ([]string, error) {
 	body, err := ReadHTTP("/peers/" + name + "/addresses")
 	if err != nil {
-		if debugServer {
-			fmt.Println("❌ Erreur GET adresses peer:", err)
-		}
+		serverLog.Debug("Erreur GET adresses peer", "err", err)
 		return nil, err
 	}
 
@@ -186,37 +166,34 @@ func RegisterKey(name string, pubKey []byte) error {
 
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		if debugServer {
-			fmt.Println("❌ Erreur lecture body ReadHTTP:", err)
-		}
+		serverLog.Debug("Erreur lecture body ReadHTTP", "err", err)
 		return err
 	}
-	fmt.Printf("PUT /peers/%s/key → HTTP %d %s\n", name, resp.StatusCode, resp.Status)
-	if len(body) > 0 && debugServer {
-		fmt.Println("Body serveur :", string(body))
+	serverLog.Info(fmt.Sprintf("PUT /peers/%s/key → HTTP %d %s", name, resp.StatusCode, resp.Status))
+	if len(body) > 0 {
+		serverLog.Debug("Body serveur", "body", string(body))
 	}
 
 	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
 		return fmt.Errorf("enregistrement échoué : HTTP %d (%s)", resp.StatusCode, string(body))
 	}
 
-	if debugServer {
-		fmt.Println("✅ Clé publique enregistrée avec succès sur le serveur")
+	if serverLog.Enabled(context.Background(), slog.LevelDebug) {
+		serverLog.Debug("Clé publique enregistrée avec succès sur le serveur")
 
 		// Vérification de la clé côté serveur
-		peerKey, err := GetPeerKey(NameofOurPeer)
+		peerKey, err := GetPeerKey(name)
 		if err != nil {
-			fmt.Println("⚠️ Impossible de récupérer la clé depuis le serveur :", err)
+			serverLog.Warn("Impossible de récupérer la clé depuis le serveur", "err", err)
 			return err
 		}
 		keyBytes := SerializePublicKey(peerKey)
 
-		fmt.Printf("🔑 Clé publique du peer serveur : %x\n", keyBytes)
+		serverLog.Debug(fmt.Sprintf("Clé publique du peer serveur : %x", keyBytes))
 		if bytes.Equal(pubKey, keyBytes) {
-			fmt.Println("✅ Clé locale et serveur identiques")
+			serverLog.Debug("Clé locale et serveur identiques")
 		} else {
-			fmt.Println("❌ Clé locale et serveur diffèrent !")
-			fmt.Printf("Local  : %x\nServeur: %x\n", pubKey, keyBytes)
+			serverLog.Warn("Clé locale et serveur diffèrent", "local", fmt.Sprintf("%x", pubKey), "serveur", fmt.Sprintf("%x", keyBytes))
 		}
 	}
 	return nil
@@ -227,12 +204,12 @@ func RegisterKey(name string, pubKey []byte) error {
 // ============================
 
 // HandShakeWithServer effectue un handshake UDP avec le serveur
-func HandShakeWithServer(conn *net.UDPConn, priv *ecdsa.PrivateKey, addrServeur *net.UDPAddr) error {
-	peer, ok := FindPeer(NameofServeurUDP)
+func (n *Node) HandShakeWithServer(conn Transport, priv *ecdsa.PrivateKey, addrServeur *net.UDPAddr) error {
+	peer, ok := n.FindPeer(NameofServeurUDP)
 	if !ok {
 		return fmt.Errorf("aucun peer avec ce nom")
 	}
 	NoChangeAddr(peer, addrServeur)
-	SendHello(conn, priv, peer)
+	n.SendHello(conn, priv, peer)
 	return nil
 }
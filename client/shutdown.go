@@ -0,0 +1,271 @@
+package client
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+//
+// ======================= ARRÊT PROPRE =======================
+//
+
+// L’arrêt d’un nœud se fait en quatre temps :
+//  1. plus de nouveaux téléchargements (DatumScheduler s’arrête) ;
+//  2. on laisse aux transactions en vol au plus ShutdownFlushTimeout pour aboutir,
+//     le socket et les handlers continuent de tourner pendant ce temps ;
+//  3. si ShutdownNotifyPeers est activé, les peers associés reçoivent un Error
+//     « arrêt du peer » ;
+//  4. toutes les boucles sont arrêtées (annulation du contexte du nœud et
+//     fermeture du transport), puis l’état est sauvegardé : bans, et si StateDir
+//     est défini, peers connus, historique de nos roots et Store.
+
+// Paramètres de l’arrêt
+var (
+	ShutdownFlushTimeout = 2 * time.Second // attente maximale des transactions en vol
+	ShutdownNotifyPeers  = false           // prévenir les peers associés de l’arrêt
+)
+
+// Fichiers de sauvegarde dans StateDir
+const (
+	stateFile = "state.json"
+	storeFile = "store.gob"
+)
+
+// -----------------------------------------------------------------------------------------
+// Shutdown arrête proprement le nœud (cf. plus haut). ctx borne l’attente des
+// transactions en vol en plus de ShutdownFlushTimeout, ainsi que l’attente de
+// la fin des boucles ; l’état est sauvegardé dans tous les cas. Les appels
+// suivants renvoient le résultat du premier.
+// Retour :
+//   - première erreur rencontrée lors de la fermeture ou de la sauvegarde
+func (n *Node) Shutdown(ctx context.Context) error {
+	n.shutdownOnce.Do(func() {
+		n.runMu.Lock()
+		running := n.running
+		n.runMu.Unlock()
+
+		close(n.drain)
+		if running {
+			n.flushTransactions(ctx)
+			if ShutdownNotifyPeers {
+				n.notifyPeers()
+			}
+		}
+
+		n.cancel()
+		var errs []error
+		if n.conn != nil {
+			if err := n.conn.Close(); err != nil && !errors.Is(err, net.ErrClosed) {
+				errs = append(errs, err)
+			}
+		}
+		if err := n.waitLoops(ctx); err != nil {
+			errs = append(errs, err)
+		}
+
+		n.saveBans()
+		if err := n.SaveState(); err != nil {
+			errs = append(errs, err)
+		}
+		n.shutdownErr = errors.Join(errs...)
+		nodeLog.Info("nœud arrêté", "name", n.Name)
+	})
+	return n.shutdownErr
+}
+
+// waitLoops attend la fin des boucles du nœud, au plus jusqu’à la fin de ctx.
+func (n *Node) waitLoops(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		n.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		nodeLog.Warn("Arrêt : des boucles ne se sont pas arrêtées à temps")
+		return fmt.Errorf("attente des boucles : %w", ctx.Err())
+	}
+}
+
+// pendingTransactions renvoie le nombre de transactions non terminées.
+func (n *Node) pendingTransactions() int {
+	n.txMu.Lock()
+	defer n.txMu.Unlock()
+	count := 0
+	for _, tx := range n.transactions {
+		if tx.State != TxDone {
+			count++
+		}
+	}
+	return count
+}
+
+// flushTransactions attend que les transactions en vol aboutissent
+// (au plus ShutdownFlushTimeout, ou jusqu’à la fin de ctx).
+func (n *Node) flushTransactions(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, ShutdownFlushTimeout)
+	defer cancel()
+	ticker := time.NewTicker(50 * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		pending := n.pendingTransactions()
+		if pending == 0 {
+			return
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			nodeLog.Debug(fmt.Sprintf("Arrêt : %d transaction(s) abandonnée(s)", pending))
+			return
+		}
+	}
+}
+
+// notifyPeers envoie un Error « arrêt du peer » à tous les peers associés.
+func (n *Node) notifyPeers() {
+	for _, peer := range n.ListPeers() {
+		peer.Mupeer.RLock()
+		addr, state := peer.ActiveAddr, peer.State
+		peer.Mupeer.RUnlock()
+		if state != PeerAssociated || addr == nil || peer.Name == NameofServeurUDP {
+			continue
+		}
+		SendErrorCode(n.conn, n.GenerateId(), n.priv, addr, ErrCodeShutdown, "Arrêt du peer "+n.Name)
+	}
+}
+
+//
+// ======================= SAUVEGARDE DE L’ÉTAT =======================
+//
+
+// savedPeer : ce qu’on conserve d’un peer entre deux lancements
+type savedPeer struct {
+	Name      string   `json:"name"`
+	Addresses []string `json:"addresses,omitempty"`
+	Roots     []string `json:"roots,omitempty"` // derniers roots connus (hex), le plus ancien en premier
+}
+
+// savedState : contenu de state.json
+type savedState struct {
+	Roots []string    `json:"roots,omitempty"` // nos derniers roots (hex)
+	Peers []savedPeer `json:"peers,omitempty"`
+}
+
+func encodeRoots(roots [][]byte) []string {
+	list := make([]string, 0, len(roots))
+	for _, r := range roots {
+		if r != nil {
+			list = append(list, hex.EncodeToString(r))
+		}
+	}
+	return list
+}
+
+func decodeRoots(list []string) [][]byte {
+	roots := make([][]byte, 0, len(list))
+	for _, s := range list {
+		if r, err := hex.DecodeString(s); err == nil {
+			roots = append(roots, r)
+		}
+	}
+	return roots
+}
+
+// -----------------------------------------------------------------------------------------
+// SaveState enregistre dans StateDir les peers connus, nos roots et le Store.
+// Ne fait rien si StateDir est vide.
+func (n *Node) SaveState() error {
+	if n.StateDir == "" {
+		return nil
+	}
+	state := savedState{Roots: encodeRoots(n.MyRoots())}
+	for _, p := range n.ListPeers() {
+		p.Mupeer.RLock()
+		sp := savedPeer{
+			Name:      p.Name,
+			Addresses: append([]string(nil), p.Addresses...),
+			Roots:     encodeRoots(p.Listroots),
+		}
+		p.Mupeer.RUnlock()
+		state.Peers = append(state.Peers, sp)
+	}
+
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(n.StateDir, 0755); err != nil {
+		return err
+	}
+	path := filepath.Join(n.StateDir, stateFile)
+	if err := os.WriteFile(path+".tmp", data, 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		return err
+	}
+	return n.store.Save(filepath.Join(n.StateDir, storeFile))
+}
+
+// -----------------------------------------------------------------------------------------
+// LoadState recharge l’état sauvegardé par SaveState (à appeler avant Run).
+// Les peers restaurés sont à l’état PeerDiscovered ; InitPeersMap met ensuite
+// leurs adresses à jour. Un peer déjà connu reçoit les roots sauvegardés s’il
+// n’en a pas, et les adresses sauvegardées s’il n’en a aucune. Un StateDir vide ou absent n’est pas une erreur.
+func (n *Node) LoadState() error {
+	if n.StateDir == "" {
+		return nil
+	}
+	if err := n.store.Load(filepath.Join(n.StateDir, storeFile)); err != nil {
+		return err
+	}
+	data, err := os.ReadFile(filepath.Join(n.StateDir, stateFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var state savedState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return fmt.Errorf("%s : état invalide : %w", stateFile, err)
+	}
+
+	n.rootsMu.Lock()
+	n.myRoots = decodeRoots(state.Roots)
+	n.rootsMu.Unlock()
+
+	for _, sp := range state.Peers {
+		if sp.Name == "" || sp.Name == n.Name {
+			continue
+		}
+		// un peer déjà connu (InitPeersMap appelé avant) garde son état et ses
+		// adresses à jour ; l'état sauvegardé complète ce qui lui manque
+		peer, exists := n.FindPeer(sp.Name)
+		if !exists {
+			peer, _ = n.AddPeer(sp.Name, nil, nil, PeerDiscovered)
+		}
+		roots := decodeRoots(sp.Roots)
+		peer.Mupeer.Lock()
+		if len(peer.Addresses) == 0 {
+			peer.Addresses = sp.Addresses
+		}
+		if len(peer.Listroots) == 0 && len(roots) > 0 {
+			peer.Listroots = roots
+			peer.Root = roots[len(roots)-1]
+		}
+		peer.Mupeer.Unlock()
+	}
+	nodeLog.Debug(fmt.Sprintf("État restauré : %d root(s), %d peer(s)", len(state.Roots), len(state.Peers)))
+	return nil
+}
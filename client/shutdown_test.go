@@ -0,0 +1,57 @@
+package client_test
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"myp2p/client"
+	"myp2p/internal/testkeyserver"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// TestLoadStateKnownPeer : l’état sauvegardé est appliqué à un peer que
+// InitPeersMap a déjà créé avant LoadState.
+func TestLoadStateKnownPeer(t *testing.T) {
+	ks := testkeyserver.New(t)
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ks.Register("bob", &priv.PublicKey, "10.0.0.2:9000")
+	dir := t.TempDir()
+	roots := [][]byte{bytes.Repeat([]byte{1}, 32), bytes.Repeat([]byte{2}, 32)}
+
+	saved := client.NewNode(client.NodeConfig{Name: "alice", StateDir: dir, BanFile: filepath.Join(dir, "bans.json")})
+	saved.InitPeersMap([]string{"bob"})
+	peer, ok := saved.FindPeer("bob")
+	if !ok {
+		t.Fatal("bob inconnu")
+	}
+	for _, r := range roots {
+		saved.AddRootToPeer(peer, r)
+	}
+	if err := saved.SaveState(); err != nil {
+		t.Fatal(err)
+	}
+
+	restored := client.NewNode(client.NodeConfig{Name: "alice", StateDir: dir, BanFile: filepath.Join(dir, "bans.json")})
+	restored.InitPeersMap([]string{"bob"})
+	if err := restored.LoadState(); err != nil {
+		t.Fatal(err)
+	}
+	peer, ok = restored.FindPeer("bob")
+	if !ok {
+		t.Fatal("bob inconnu après LoadState")
+	}
+	peer.Mupeer.RLock()
+	defer peer.Mupeer.RUnlock()
+	if !bytes.Equal(peer.Root, roots[1]) || len(peer.Listroots) != len(roots) {
+		t.Fatalf("roots restaurés : %x / %x", peer.Root, peer.Listroots)
+	}
+	if !slices.Equal(peer.Addresses, []string{"10.0.0.2:9000"}) {
+		t.Fatalf("adresses : %v", peer.Addresses)
+	}
+}
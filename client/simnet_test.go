@@ -0,0 +1,309 @@
+package client_test
+
+import (
+	"bytes"
+	"context"
+	"myp2p/client"
+	"myp2p/internal/testkeyserver"
+	"myp2p/simnet"
+	"net"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+//
+// ======================= HELLO =======================
+//
+
+// TestHelloAssociation : Hello entre deux peers publics, clés récupérées sur
+// le serveur de clés.
+func TestHelloAssociation(t *testing.T) {
+	ks := testkeyserver.New(t)
+	sim := simnet.New(1)
+	ca, err := sim.Listen("10.0.0.1:9000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cb, err := sim.Listen("10.0.0.2:9000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := startNode(t, ks, "alice", ca, "10.0.0.1:9000", nil)
+	b := startNode(t, ks, "bob", cb, "10.0.0.2:9000", nil)
+
+	associate(t, a, b)
+
+	if got := a.peerState(b.Name); got != client.PeerAssociated {
+		t.Fatalf("%s voit %s dans l’état %v", a.Name, b.Name, got)
+	}
+	peer, _ := a.FindPeer(b.Name)
+	peer.Mupeer.RLock()
+	active := peer.ActiveAddr
+	peer.Mupeer.RUnlock()
+	if active == nil || active.String() != "10.0.0.2:9000" {
+		t.Fatalf("adresse active de %s : %v", b.Name, active)
+	}
+}
+
+//
+// ======================= TRAVERSÉE DE NAT =======================
+//
+
+// TestNATTraversal : bob est derrière un NAT port restricted cone, le Hello
+// direct d’alice est filtré. Après son expiration, alice passe par le relais
+// (à l’adresse AddrServeurUDP) qui transmet un NatTraversalRequest2 à bob ;
+// bob envoie un Ping à alice, ce qui ouvre son NAT, et le Hello suivant
+// d’alice aboutit.
+func TestNATTraversal(t *testing.T) {
+	const relayAddr = "10.0.0.254:8443"
+	setVar(t, &client.AddrServeurUDP, relayAddr)
+	setVar(t, &client.Retries, 1) // le Hello direct expire après son premier délai
+
+	ks := testkeyserver.New(t)
+	sim := simnet.New(1)
+	nat, err := sim.AddNAT("198.51.100.1", simnet.PortRestrictedCone)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cr, err := sim.Listen(relayAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, err := sim.Listen("10.0.0.1:9000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cb, err := nat.Listen("192.168.1.2:9000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// bob a déjà joint le relais : son NAT laisse passer ce qui en vient
+	if _, err := cb.WriteToUDP([]byte{0}, udpAddr(t, relayAddr)); err != nil {
+		t.Fatal(err)
+	}
+	bobPublic := nat.PublicAddr(cb, relayAddr)
+	if !bobPublic.IsValid() {
+		t.Fatal("pas de correspondance NAT pour bob")
+	}
+
+	relay := startNode(t, ks, "relay", cr, relayAddr, nil)
+	a := startNode(t, ks, "alice", ca, "10.0.0.1:9000", nil)
+	b := startNode(t, ks, "bob", cb, bobPublic.String(), nil)
+
+	relay.InitPeersMap([]string{a.Name, b.Name})
+	a.InitPeersMap([]string{b.Name})
+	peer, ok := a.FindPeer(b.Name)
+	if !ok {
+		t.Fatalf("%s ne connaît pas %s", a.Name, b.Name)
+	}
+	if !a.HelloToPeer(a.conn, a.priv, peer) {
+		t.Fatal("Hello non envoyé")
+	}
+
+	a.waitEvent(t, b.Name, client.EventConnectionFailed, 5*time.Second)
+	b.waitEvent(t, a.Name, client.EventNatTraversal2Received, 5*time.Second)
+	a.waitEvent(t, b.Name, client.EventConnected, 5*time.Second)
+	waitFor(t, 5*time.Second, func() bool { return b.peerState(a.Name) == client.PeerAssociated })
+
+	if sim.Stats().Filtered == 0 {
+		t.Fatal("le Hello direct aurait dû être filtré par le NAT")
+	}
+}
+
+//
+// ======================= TÉLÉCHARGEMENT =======================
+//
+
+// TestMerkleDownload : téléchargement complet d’un arbre sur un lien avec
+// latence, pertes, duplications et réordonnancement, puis comparaison octet
+// par octet des fichiers reconstruits.
+func TestMerkleDownload(t *testing.T) {
+	ks := testkeyserver.New(t)
+	sim := simnet.New(1)
+	sim.SetDefaultLink(simnet.LinkParams{
+		Latency:      2 * time.Millisecond,
+		Jitter:       3 * time.Millisecond,
+		Loss:         0.02,
+		Duplicate:    0.01,
+		Reorder:      0.02,
+		ReorderDelay: 10 * time.Millisecond,
+	})
+
+	ca, err := sim.Listen("10.0.0.1:9000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cb, err := sim.Listen("10.0.0.2:9000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	src := t.TempDir()
+	store, root := makeTree(t, src, 3, 5, 20000)
+	a := startNode(t, ks, "alice", ca, "10.0.0.1:9000", nil)
+	b := startNode(t, ks, "bob", cb, "10.0.0.2:9000", store)
+
+	associate(t, a, b)
+	if got := askRoot(t, a, b); !bytes.Equal(got, root) {
+		t.Fatalf("root %x, attendu %x", got, root)
+	}
+	startDownload(t, a, b, root)
+	a.waitEvent(t, b.Name, client.EventMerkleDownloadComplete, 60*time.Second)
+
+	peer, _ := a.FindPeer(b.Name)
+	out := filepath.Join(t.TempDir(), "out")
+	if err := a.RebuildFromPeer(peer, root, out); err != nil {
+		t.Fatal(err)
+	}
+	sameFiles(t, src, out)
+}
+
+// TestSubtreeCorruptedNode : un nœud poussé en réponse à un SubtreeRequest
+// est altéré en route. Il est rejeté, redemandé à la fin de la poussée, et le
+// téléchargement se termine avec des fichiers identiques.
+func TestSubtreeCorruptedNode(t *testing.T) {
+	ks := testkeyserver.New(t)
+	sim := simnet.New(1)
+	ca, err := sim.Listen("10.0.0.1:9000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cb, err := sim.Listen("10.0.0.2:9000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	src := t.TempDir()
+	store, root := makeTree(t, src, 2, 3, 20000)
+	corrupt := &corruptPush{Transport: cb, pushes: map[uint32]bool{}}
+	a := startNode(t, ks, "alice", ca, "10.0.0.1:9000", nil)
+	b := startNode(t, ks, "bob", corrupt, "10.0.0.2:9000", store)
+
+	associate(t, a, b)
+	askRoot(t, a, b)
+	startDownload(t, a, b, root)
+	a.waitEvent(t, b.Name, client.EventMerkleDownloadComplete, 30*time.Second)
+
+	corrupt.mu.Lock()
+	corrupted := corrupt.corrupted
+	corrupt.mu.Unlock()
+	if corrupted != 1 {
+		t.Fatalf("%d nœuds poussés altérés, attendu 1", corrupted)
+	}
+	peer, _ := a.FindPeer(b.Name)
+	out := filepath.Join(t.TempDir(), "out")
+	if err := a.RebuildFromPeer(peer, root, out); err != nil {
+		t.Fatal(err)
+	}
+	sameFiles(t, src, out)
+}
+
+// corruptPush altère la valeur du deuxième Datum envoyé en réponse au
+// premier SubtreeRequest reçu (le hash annoncé ne correspond plus).
+type corruptPush struct {
+	client.Transport
+
+	mu        sync.Mutex
+	pushes    map[uint32]bool // SubtreeRequest reçus
+	sent      int             // Datum poussés
+	corrupted int
+}
+
+func (c *corruptPush) ReadFromUDP(b []byte) (int, *net.UDPAddr, error) {
+	n, addr, err := c.Transport.ReadFromUDP(b)
+	if err == nil {
+		if p, perr := client.ParsePacket(b[:n]); perr == nil && p.Type == client.SubtreeRequest {
+			c.mu.Lock()
+			if len(c.pushes) == 0 {
+				c.pushes[p.ID] = true
+			}
+			c.mu.Unlock()
+		}
+	}
+	return n, addr, err
+}
+
+func (c *corruptPush) WriteToUDP(b []byte, addr *net.UDPAddr) (int, error) {
+	p, err := client.ParsePacket(b)
+	if err != nil || p.Type != client.Datum {
+		return c.Transport.WriteToUDP(b, addr)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.pushes[p.ID] {
+		return c.Transport.WriteToUDP(b, addr)
+	}
+	if c.sent++; c.sent != 2 {
+		return c.Transport.WriteToUDP(b, addr)
+	}
+	m, err := p.Message()
+	if err != nil {
+		return 0, err
+	}
+	datum := m.(*client.DatumMsg)
+	datum.Value = append([]byte(nil), datum.Value...)
+	datum.Value[len(datum.Value)-1] ^= 0xFF
+	pkt, err := client.EncodeMessage(p.ID, datum, nil, false)
+	if err != nil {
+		return 0, err
+	}
+	c.corrupted++
+	if _, err := c.Transport.WriteToUDP(pkt, addr); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+//
+// ======================= ARRÊT =======================
+//
+
+// TestShutdownMidTransfer : l’arrêt du nœud qui télécharge, en plein
+// transfert, se termine sans erreur avant l’échéance de son contexte.
+func TestShutdownMidTransfer(t *testing.T) {
+	ks := testkeyserver.New(t)
+	sim := simnet.New(1)
+	sim.SetDefaultLink(simnet.LinkParams{Latency: 50 * time.Millisecond})
+
+	ca, err := sim.Listen("10.0.0.1:9000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cb, err := sim.Listen("10.0.0.2:9000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, root := makeTree(t, t.TempDir(), 4, 8, 40000)
+	a := startNode(t, ks, "alice", ca, "10.0.0.1:9000", nil)
+	b := startNode(t, ks, "bob", cb, "10.0.0.2:9000", store)
+
+	associate(t, a, b)
+	askRoot(t, a, b)
+	startDownload(t, a, b, root)
+	time.Sleep(500 * time.Millisecond)
+	for len(a.events) > 0 {
+		if e := <-a.events; e.event == client.EventMerkleDownloadComplete {
+			t.Fatal("téléchargement terminé avant l’arrêt : arbre trop petit")
+		}
+	}
+
+	const limit = 5 * time.Second
+	ctx, cancel := context.WithTimeout(context.Background(), limit)
+	defer cancel()
+	start := time.Now()
+	if err := a.Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown : %v", err)
+	}
+	if d := time.Since(start); d >= limit {
+		t.Fatalf("Shutdown a pris %s", d)
+	}
+	select {
+	case err := <-a.done:
+		if err != nil {
+			t.Fatalf("Run : %v", err)
+		}
+	case <-ctx.Done():
+		t.Fatal("Run ne s’est pas terminé après Shutdown")
+	}
+}
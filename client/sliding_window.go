@@ -1,13 +1,10 @@
 package client
 
 import (
-	"fmt"
 	"sync"
 	"time"
 )
 
-var debugSlidingWindow = true
-
 type SlidingWindow struct {
 	mu       sync.RWMutex
 	Size     int //combien de DatumRequest je peux avoir en vol
@@ -32,11 +29,7 @@ func (w *SlidingWindow) CanSend() bool {
 	w.mu.Lock()
 	defer w.mu.Unlock()
 	ok := w.InFlight < w.Size
-	if ok {
-		fmt.Println("oui on peut envoyer")
-	} else {
-		fmt.Println("non on peut pas")
-	}
+	txLog.Debug("fenêtre glissante", "canSend", ok, "inFlight", w.InFlight, "size", w.Size)
 	return ok
 }
 
@@ -45,12 +38,37 @@ func (w *SlidingWindow) OnSend() {
 	w.mu.Lock()
 	w.InFlight++
 	if w.InFlight > w.Size {
-		fmt.Println("[BUG] InFlight > Size, correction")
+		txLog.Warn("InFlight > Size, correction", "inFlight", w.InFlight, "size", w.Size)
 		w.InFlight = w.Size
 	}
 	w.mu.Unlock()
 }
 
+// nombre de places libres dans la fenêtre
+func (w *SlidingWindow) Free() int {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	return max(w.Size-w.InFlight, 0)
+}
+
+// appelé avant une requête qui occupe plusieurs places (cf. sendSubtree) :
+// réserve au plus count places libres et renvoie le nombre réservé
+func (w *SlidingWindow) Reserve(count int) int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	count = min(count, max(w.Size-w.InFlight, 0))
+	w.InFlight += count
+	return count
+}
+
+// appelé quand des places réservées ne serviront pas (réponse plus courte
+// que prévu) : elles sont libérées sans changer la taille de la fenêtre
+func (w *SlidingWindow) OnRelease(count int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.InFlight = max(w.InFlight-count, 0)
+}
+
 // appelé quand un Datum arrive correctement
 
 func (w *SlidingWindow) OnSuccess(rtt time.Duration) {
@@ -58,34 +76,32 @@ func (w *SlidingWindow) OnSuccess(rtt time.Duration) {
 	defer w.mu.Unlock()
 
 	if w.InFlight > 0 {
-		if debugSlidingWindow {
-			fmt.Println("décrémenter")
-		}
+		txLog.Debug("décrémenter")
 		w.InFlight--
 	}
 
 	if w.Size < w.Max {
-		if debugSlidingWindow {
-			fmt.Println("Augmentation de la taille de la fenêtre")
-		}
+		txLog.Debug("Augmentation de la taille de la fenêtre")
 		w.Size++
 	} else {
-		if debugSlidingWindow {
-			fmt.Println("Max atteint", w.Max)
-		}
+		txLog.Debug("Max atteint", "max", w.Max)
 	}
 }
 
 // appelé sur timeout / retry épuisé
 func (w *SlidingWindow) OnTimeout() {
+	w.OnTimeoutN(1)
+}
+
+// appelé quand une requête groupée expire avec count hashes sans réponse :
+// leurs places sont libérées et la fenêtre n'est réduite qu'une fois
+func (w *SlidingWindow) OnTimeoutN(count int) {
 	w.mu.Lock()
 	defer w.mu.Unlock()
 
 	if w.InFlight > 0 {
-		if debugSlidingWindow {
-			fmt.Println("timeout décrémenter")
-		}
-		w.InFlight--
+		txLog.Debug("timeout décrémenter", "count", count)
+		w.InFlight = max(w.InFlight-count, 0)
 	}
 
 	if w.Size > w.Min {
@@ -98,10 +114,5 @@ func (w *SlidingWindow) OnTimeout() {
 
 // Pour debug
 func (w *SlidingWindow) dump(reason string) {
-	fmt.Printf(
-		"[WIN][%s] Size=%d InFlight=%d\n",
-		reason,
-		w.Size,
-		w.InFlight,
-	)
+	txLog.Debug("fenêtre glissante", "reason", reason, "size", w.Size, "inFlight", w.InFlight)
 }
@@ -0,0 +1,483 @@
+package client
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"myp2p/clientStorage"
+	"net"
+	"slices"
+	"time"
+)
+
+//-----------------------------------------------------------------------------------------
+// Ce fichier regroupe la demande de sous-arbres, négociée par l’extension
+// ExtensionSubtree. Un SubtreeRequest demande au peer un ou plusieurs nœuds
+// (les racines) et tous leurs descendants qu’il possède, en largeur d’abord,
+// dans la limite d’une profondeur, d’un nombre de nœuds et d’un nombre
+// d’octets ; le peer répond par un Datum par nœud, un NoDatum par racine
+// absente, puis par un SubtreeEnd qui annonce combien de réponses il a
+// envoyées, toutes avec l’id de la requête.
+//
+// Le nombre de nœuds est le crédit du demandeur : des places réservées dans
+// sa fenêtre glissante, partagées entre les jobs en attente. Chaque nœud reçu
+// libère sa place et agrandit la fenêtre comme un Datum ordinaire : la taille
+// des poussées double à chaque aller-retour, et un arbre complet ne coûte que
+// quelques allers-retours au lieu d’un par niveau.
+//
+// Le demandeur vérifie chaque nœud contre son parent : un nœud n’est accepté
+// que si son hash est celui d’une racine ou celui d’un enfant d’un nœud déjà
+// accepté, et si sa valeur a bien ce hash. Les enfants qui ne sont pas
+// arrivés (limites atteintes, nœuds absents chez le peer, paquets perdus ou
+// réordonnés) sont redemandés comme racines à la fin de la poussée.
+
+// SubtreeEnabled : annonce de l'extension ExtensionSubtree
+var SubtreeEnabled = true
+
+// Limites demandées aux peers (0 = sans limite)
+var (
+	SubtreeMaxDepth uint8  = 0 // niveaux de nœuds sous le nœud demandé
+	SubtreeMaxBytes uint32 = 0 // octets de nœuds par réponse
+)
+
+// MaxSubtreeNodes : nombre maximal de nœuds d’une réponse (crédit demandé et servi)
+const MaxSubtreeNodes = 1024
+
+// Rythme d’une poussée : les Datums partent par rafales de SubtreePaceBurst,
+// séparées de SubtreePaceDelay, pour ne pas vider d’un coup le seau par
+// adresse du demandeur (RateAddrBurst) ni déborder les tampons du socket
+var (
+	SubtreePaceBurst = 128
+	SubtreePaceDelay = 10 * time.Millisecond
+)
+
+// MaxSubtreeRoots : nombre maximal de racines d’un SubtreeRequest, qui tient
+// dans un seul datagramme comme un BatchDatumRequest
+const MaxSubtreeRoots = (BatchDatagramSize - HeaderSize - subtreeHeaderSize) / clientStorage.HashSize
+
+// SubtreeState : progression d’un SubtreeRequest (protégée par txMu)
+type SubtreeState struct {
+	Roots     [][]byte
+	Credit    int                  // places réservées dans la fenêtre
+	Expected  map[string]bool      // hash attendu → déjà reçu
+	Early     map[string]*DatumMsg // nœuds arrivés avant leur parent
+	Received  int                  // nœuds acceptés
+	Done      int                  // réponses traitées : nœuds (enfants ajoutés à Expected) et NoDatum
+	Announced int                  // réponses annoncées par SubtreeEnd (-1 avant sa réception)
+	LastSeen  time.Time            // réception de la dernière réponse
+}
+
+//
+// ======================= ENVOI =======================
+//
+
+// -----------------------------------------------------------------------------------------
+// sendSubtree envoie à peer un SubtreeRequest qui regroupe job et les autres
+// hashes en attente pour ce peer (cf. gatherJobs). Le crédit est la part des
+// places libres de la fenêtre qui revient à cette requête, les jobs en
+// attente se répartissant en requêtes d’au plus MaxSubtreeRoots racines (au
+// moins une place, déjà disponible) ; il borne aussi le nombre de racines.
+// Paramètres :
+//   - conn : connexion UDP
+//   - peer : peer interrogé
+//   - job  : première racine de la requête
+//   - held : jobs mis de côté lors des regroupements précédents
+//
+// Retour :
+//   - jobs toujours en attente (ceux d’autres peers, ou au-delà de la requête)
+func (n *Node) sendSubtree(conn Transport, peer *Peer, job DatumJob, held []DatumJob) []DatumJob {
+	pending := 1 + len(held) + len(n.datumQueue)
+	requests := (pending + MaxSubtreeRoots - 1) / MaxSubtreeRoots
+	credit := peer.Window.Reserve(min(max(1, peer.Window.Free()/requests), MaxSubtreeNodes))
+	roots, held := n.gatherJobs(job, held, min(credit, MaxSubtreeRoots), nil)
+
+	id := n.GenerateId()
+	m := &SubtreeRequestMsg{MaxDepth: SubtreeMaxDepth, MaxNodes: uint16(credit), MaxBytes: SubtreeMaxBytes, Hashes: roots}
+	msg, err := EncodeMessage(id, m, nil, false)
+	if err != nil {
+		datumLog.Warn("Erreur SubtreeRequest", "err", err)
+		peer.Window.OnRelease(credit)
+		return held
+	}
+
+	expected := make(map[string]bool, len(roots))
+	for _, h := range roots {
+		expected[string(h)] = false
+	}
+	tx := n.CreateTransaction(id, peer, job.Addr, SubtreeRequest, msg, Retries+2)
+	n.txMu.Lock()
+	tx.Subtree = &SubtreeState{Roots: roots, Credit: credit, Expected: expected, Announced: -1}
+	n.txMu.Unlock()
+
+	datumLog.Debug("demande de sous-arbres", "peer", peer.Name, "racines", len(roots), "crédit", credit)
+	SendMessage(conn, job.Addr, msg)
+	return held
+}
+
+//
+// ======================= RÉPONSE =======================
+//
+
+// HandleSubtreeRequestWrapper : SubtreeRequest reçu, servi seulement si
+// l’extension a été négociée avec le peer (sinon le type est inconnu, comme
+// pour un peer qui ne l’implémente pas).
+func (n *Node) HandleSubtreeRequestWrapper(conn Transport, priv *ecdsa.PrivateKey, id uint32, addr *net.UDPAddr, m *SubtreeRequestMsg) {
+	transportLog.Debug("SubtreeRequest reçu", "racines", len(m.Hashes), "nœuds", m.MaxNodes, "profondeur", m.MaxDepth, "octets", m.MaxBytes)
+	peer, ok := n.FindPeerByAddr(addr)
+	if !ok || !peer.Has(ExtensionSubtree) {
+		SendErrorCode(conn, id, priv, addr, ErrCodeUnknownType, "extension "+ExtensionName(ExtensionSubtree)+" non négociée")
+		return
+	}
+	n.HandleSubtreeRequest(conn, priv, addr, id, m)
+}
+
+// -----------------------------------------------------------------------------------------
+// HandleSubtreeRequest répond à un SubtreeRequest : un Datum par nœud des
+// sous-arbres (cf. collectSubtree), un NoDatum signé par racine absente (ou
+// hors ACL), comme pour un DatumRequest, puis un SubtreeEnd qui compte ces
+// réponses. La requête compte pour une unité du quota de DatumRequest : sa
+// taille est déjà bornée par le crédit du demandeur. Les Datums sont envoyés
+// au rythme de SubtreePaceBurst / SubtreePaceDelay (cf. paceSubtree).
+func (n *Node) HandleSubtreeRequest(conn Transport, priv *ecdsa.PrivateKey, addr *net.UDPAddr, id uint32, m *SubtreeRequestMsg) {
+	if !n.admitDatumRequest(conn, priv, id, addr, 1, m.Hashes) {
+		return
+	}
+	nodes, missing := n.collectSubtree(addr, m)
+
+	peer, exist := n.FindPeerByAddr(addr)
+	if !exist {
+		SendErrorCode(conn, id, priv, addr, ErrCodeNotAssociated, "")
+		return
+	}
+	for i, data := range nodes {
+		if !n.paceSubtree(i) {
+			return
+		}
+		body, code := n.datumBody(peer, data)
+		if code != ErrCodeNone {
+			SendErrorCode(conn, id, priv, addr, code, "")
+			return
+		}
+		sendGenericMessage(conn, priv, addr, id, Datum, body, false)
+	}
+	for _, hash := range missing {
+		sendGenericMessage(conn, priv, addr, id, NoDatum, hash, true)
+	}
+	end, _ := (&SubtreeEndMsg{Count: uint16(len(nodes) + len(missing))}).MarshalBody()
+	sendGenericMessage(conn, priv, addr, id, SubtreeEnd, end, false)
+}
+
+// paceSubtree marque une pause avant le Datum d’indice sent d’une poussée
+// s’il commence une nouvelle rafale.
+// Retour : false si le nœud s’arrête (la poussée est abandonnée)
+func (n *Node) paceSubtree(sent int) bool {
+	if sent == 0 || SubtreePaceBurst <= 0 || sent%SubtreePaceBurst != 0 {
+		return true
+	}
+	t := time.NewTimer(SubtreePaceDelay)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-n.ctx.Done():
+		return false
+	}
+}
+
+// collectSubtree parcourt en largeur d’abord les sous-arbres des racines de m
+// visibles du peer situé à addr (cf. CanServeHash). Un nœud partagé n’est
+// envoyé qu’une fois ; le parcours s’arrête à m.MaxNodes nœuds (au plus
+// MaxSubtreeNodes) ou avant de dépasser m.MaxBytes octets, et ne descend pas
+// au-delà de m.MaxDepth niveaux sous les racines.
+// Retour :
+//   - nœuds collectés, parents avant enfants
+//   - racines absentes ou hors ACL
+func (n *Node) collectSubtree(addr *net.UDPAddr, m *SubtreeRequestMsg) ([][]byte, [][]byte) {
+	type item struct {
+		hash  []byte
+		depth int
+	}
+	limit := min(int(m.MaxNodes), MaxSubtreeNodes)
+	queue := make([]item, 0, len(m.Hashes))
+	seen := map[string]bool{}
+	for _, h := range m.Hashes {
+		if !seen[string(h)] {
+			seen[string(h)] = true
+			queue = append(queue, item{h, 0})
+		}
+	}
+	var nodes, missing [][]byte
+	size := 0
+	for len(queue) > 0 && len(nodes) < limit {
+		it := queue[0]
+		queue = queue[1:]
+		data, ok := n.store.FindHash(it.hash)
+		if !ok || !n.CanServeHash(addr, it.hash) {
+			if it.depth == 0 {
+				missing = append(missing, it.hash)
+			}
+			continue
+		}
+		if m.MaxBytes > 0 && len(nodes) > 0 && size+len(data) > int(m.MaxBytes) {
+			break
+		}
+		nodes = append(nodes, data)
+		size += len(data)
+
+		if m.MaxDepth > 0 && it.depth >= int(m.MaxDepth) {
+			continue
+		}
+		node, err := clientStorage.ParseNode(data)
+		if err != nil {
+			continue
+		}
+		for _, h := range node.ChildHashes() {
+			if !seen[string(h)] {
+				seen[string(h)] = true
+				queue = append(queue, item{h, it.depth + 1})
+			}
+		}
+	}
+	return nodes, missing
+}
+
+//
+// ======================= SUIVI DES RÉPONSES =======================
+//
+
+// handleSubtreeDatum : Datum reçu en réponse à un SubtreeRequest. Après
+// déchiffrement, le nœud doit avoir le hash annoncé et être décodable, puis
+// être attendu (cf. claimSubtreeNode) ; il est alors stocké et ses enfants
+// deviennent attendus. Un nœud invalide n’est pas réclamé : il reste dans la
+// frontière et sera redemandé à la fin de la poussée.
+func (n *Node) handleSubtreeDatum(id uint32, addr *net.UDPAddr, datum *DatumMsg) {
+	peer, exist := n.FindPeerByAddr(addr)
+	if !exist {
+		transportLog.Debug("Peer non trouvé pour Datum")
+		return
+	}
+	datum, ok := n.openDatum(peer, datum)
+	if !ok {
+		return
+	}
+	if !n.checkSubtreeDatum(peer, datum) {
+		n.rejectSubtreeNode(id, addr, datum.Hash)
+		return
+	}
+	tr, first := n.claimSubtreeNode(id, addr, datum)
+	if tr == nil {
+		return
+	}
+	if first {
+		n.observeRTT(time.Since(tr.SentAt))
+	}
+	n.acceptSubtreeNode(peer, tr, datum)
+	n.checkMerkleDone(peer)
+}
+
+// checkSubtreeDatum vérifie un nœud poussé avant qu’il soit réclamé : hash de
+// sa valeur et décodage. Un échec est compté contre le peer.
+func (n *Node) checkSubtreeDatum(peer *Peer, datum *DatumMsg) bool {
+	if !n.verifyDatum(peer, datum, datum.Hash) {
+		return false
+	}
+	if _, err := datum.Node(); err != nil {
+		datumLog.Warn("Datum rejeté", "err", err)
+		n.reportPeerMisbehaviour(peer, MisMalformed)
+		return false
+	}
+	return true
+}
+
+// rejectSubtreeNode compte comme traitée la réponse invalide à un nœud
+// attendu du SubtreeRequest id, sans le marquer reçu : il reste à redemander
+// (cf. closeSubtree).
+func (n *Node) rejectSubtreeNode(id uint32, addr *net.UDPAddr, hash []byte) {
+	n.txMu.Lock()
+	tx, ok := n.transactions[id]
+	if !ok || tx.MsgType != SubtreeRequest || tx.Subtree == nil || tx.State == TxDone || !sameUDPAddr(tx.Addr, addr) {
+		n.txMu.Unlock()
+		return
+	}
+	if received, expected := tx.Subtree.Expected[string(hash)]; !expected || received {
+		n.txMu.Unlock()
+		return
+	}
+	tx.Subtree.LastSeen = time.Now()
+	n.subtreeReplyDone(tx)
+}
+
+// acceptSubtreeNode stocke un nœud vérifié et réclamé du SubtreeRequest tx,
+// puis ceux de ses enfants arrivés avant lui.
+func (n *Node) acceptSubtreeNode(peer *Peer, tx *Transaction, datum *DatumMsg) {
+	peer.Window.OnSuccess(time.Since(tx.SentAt))
+
+	var children [][]byte
+	if node, err := datum.Node(); err == nil {
+		n.store.FillMap(datum.Value)
+		children = node.ChildHashes()
+	}
+	for _, early := range n.expandSubtree(tx, children) {
+		n.acceptSubtreeNode(peer, tx, early)
+	}
+}
+
+// claimSubtreeNode marque le nœud de datum comme reçu s’il est attendu par le
+// SubtreeRequest id et si la réponse vient de son destinataire, dans la
+// limite du crédit de la requête. Un nœud pas encore attendu (arrivé avant
+// son parent) est mis de côté jusqu’à l’arrivée de celui-ci.
+// Retour :
+//   - transaction correspondante, nil si le nœud est rejeté ou mis de côté
+//   - true si c’est le premier nœud reçu (mesure du RTT)
+func (n *Node) claimSubtreeNode(id uint32, addr *net.UDPAddr, datum *DatumMsg) (*Transaction, bool) {
+	n.txMu.Lock()
+	defer n.txMu.Unlock()
+
+	tx, ok := n.transactions[id]
+	if !ok || tx.MsgType != SubtreeRequest || tx.Subtree == nil || tx.State == TxDone || !sameUDPAddr(tx.Addr, addr) {
+		transportLog.Debug("Datum ignoré : pas de sous-arbre correspondant", "id", id)
+		return nil, false
+	}
+	st := tx.Subtree
+	key := string(datum.Hash)
+	received, expected := st.Expected[key]
+	switch {
+	case received || st.Early[key] != nil || st.Received+len(st.Early) >= st.Credit:
+		transportLog.Debug("nœud en double ou hors crédit dans le sous-arbre", "id", id)
+		return nil, false
+	case !expected:
+		if st.Early == nil {
+			st.Early = map[string]*DatumMsg{}
+		}
+		st.Early[key] = datum
+		st.LastSeen = time.Now()
+		return nil, false
+	}
+	st.Expected[key] = true
+	st.Received++
+	st.LastSeen = time.Now()
+	return tx, st.Received == 1
+}
+
+// expandSubtree ajoute les enfants d’un nœud accepté aux nœuds attendus et
+// termine la poussée si toutes les réponses annoncées ont été traitées. Si la
+// transaction est déjà terminée (expirée entre-temps), les enfants sont
+// demandés comme ceux d’un Datum ordinaire.
+// Retour : enfants arrivés avant le nœud, réclamés et à accepter à leur tour
+func (n *Node) expandSubtree(tx *Transaction, children [][]byte) []*DatumMsg {
+	n.txMu.Lock()
+	if tx.State == TxDone {
+		n.txMu.Unlock()
+		n.scheduleFrontier(tx, children, 0)
+		return nil
+	}
+	st := tx.Subtree
+	var ready []*DatumMsg
+	for _, h := range children {
+		key := string(h)
+		if _, ok := st.Expected[key]; ok {
+			continue
+		}
+		st.Expected[key] = false
+		if early, ok := st.Early[key]; ok {
+			delete(st.Early, key)
+			st.Expected[key] = true
+			st.Received++
+			ready = append(ready, early)
+		}
+	}
+	n.subtreeReplyDone(tx)
+	return ready
+}
+
+// HandleSubtreeEnd : fin d’une réponse à un SubtreeRequest, count réponses
+// envoyées. La poussée est terminée dès que ces réponses ont été traitées
+// (réponses perdues : à l’expiration de la transaction, cf. CleanupTransactions).
+func (n *Node) HandleSubtreeEnd(id uint32, addr *net.UDPAddr, count uint16) {
+	n.txMu.Lock()
+	tx, ok := n.transactions[id]
+	if !ok || tx.MsgType != SubtreeRequest || tx.Subtree == nil || tx.State == TxDone || !sameUDPAddr(tx.Addr, addr) {
+		n.txMu.Unlock()
+		transportLog.Debug("SubtreeEnd ignoré : pas de transaction correspondante")
+		return
+	}
+	st := tx.Subtree
+	st.Announced = int(count)
+	if st.Done < st.Announced {
+		n.txMu.Unlock()
+		transportLog.Debug("SubtreeEnd reçu avant les réponses", "annoncées", count, "traitées", st.Done)
+		return
+	}
+	frontier, unused := tx.closeSubtree()
+	n.txMu.Unlock()
+	n.scheduleFrontier(tx, frontier, unused)
+}
+
+// handleSubtreeNoDatum : le peer n’a pas la racine hash du SubtreeRequest id ;
+// elle n’est pas redemandée. Un NoDatum n’occupe pas de place du crédit.
+func (n *Node) handleSubtreeNoDatum(id uint32, addr *net.UDPAddr, hash []byte, signed []byte, sig []byte) {
+	n.txMu.Lock()
+	tx, ok := n.transactions[id]
+	if !ok || tx.MsgType != SubtreeRequest || tx.Subtree == nil || tx.State == TxDone || !sameUDPAddr(tx.Addr, addr) {
+		n.txMu.Unlock()
+		return
+	}
+	st := tx.Subtree
+	if received, expected := st.Expected[string(hash)]; !expected || received || !slices.ContainsFunc(st.Roots, func(h []byte) bool { return bytes.Equal(h, hash) }) {
+		n.txMu.Unlock()
+		return
+	}
+	st.Expected[string(hash)] = true
+	st.LastSeen = time.Now()
+	n.subtreeReplyDone(tx)
+
+	if !n.VerifSign(addr, signed, sig) {
+		transportLog.Warn("Erreur de signature dans NoDatum")
+	}
+	n.EmitPeerEvent(tx.Peer, EventNoDatum, " :(")
+}
+
+// subtreeReplyDone compte une réponse traitée et termine la poussée si toutes
+// les réponses annoncées l’ont été (à appeler sous txMu, qui est libéré).
+func (n *Node) subtreeReplyDone(tx *Transaction) {
+	st := tx.Subtree
+	st.Done++
+	if st.Announced < 0 || st.Done < st.Announced {
+		n.txMu.Unlock()
+		return
+	}
+	frontier, unused := tx.closeSubtree()
+	n.txMu.Unlock()
+	n.scheduleFrontier(tx, frontier, unused)
+}
+
+// closeSubtree termine un SubtreeRequest (à appeler sous txMu).
+// Retour :
+//   - hashes attendus qui ne sont pas arrivés, à redemander
+//   - places réservées dans la fenêtre qui n’ont pas servi
+func (tx *Transaction) closeSubtree() ([][]byte, int) {
+	tx.State = TxDone
+	st := tx.Subtree
+	var frontier [][]byte
+	for h, received := range st.Expected {
+		if !received {
+			frontier = append(frontier, []byte(h))
+		}
+	}
+	return frontier, max(st.Credit-st.Received, 0)
+}
+
+// scheduleFrontier libère les places inutilisées d’un SubtreeRequest terminé
+// et programme la demande des hashes restants auprès du même peer.
+func (n *Node) scheduleFrontier(tx *Transaction, frontier [][]byte, unused int) {
+	if unused > 0 {
+		tx.Peer.Window.OnRelease(unused)
+	}
+	if len(frontier) > 0 {
+		datumLog.Debug("fin de la poussée, nœuds à redemander", "peer", tx.Peer.Name, "nœuds", len(frontier))
+	}
+	for _, h := range frontier {
+		if !n.queueDatum(DatumJob{Hash: h, Addr: tx.Addr}) {
+			return
+		}
+	}
+}
@@ -2,9 +2,7 @@ package client
 
 import (
 	"crypto/ecdsa"
-	"fmt"
 	"net"
-	"sync"
 	"time"
 )
 
@@ -12,8 +10,6 @@ import (
 // ======================= GESTION DES TRANSACTIONS =======================
 //
 
-var debugTransaction = false
-
 // Nombre maximum de tentatives d’envoi avant abandon
 var Retries = 4
 
@@ -51,13 +47,34 @@ type Transaction struct {
 	Msg     []byte
 	State   TxState
 	DhPriv  *ecdsa.PrivateKey
+	Pending [][]byte      // BatchDatumRequest : hashes encore sans réponse (cf. batch.go)
+	Subtree *SubtreeState // SubtreeRequest : progression de la poussée (cf. subtree.go)
 }
 
-// Mutex protégeant l’accès concurrent aux transactions
-var (
-	txMu         sync.Mutex
-	Transactions = map[uint32]*Transaction{}
-)
+// datumSlots renvoie le nombre de places de la fenêtre glissante occupées
+// par une transaction : 1 pour un DatumRequest, un par hash sans réponse pour
+// un BatchDatumRequest, le crédit restant pour un SubtreeRequest (à appeler
+// sous txMu ou sur une transaction terminée).
+func (tx *Transaction) datumSlots() int {
+	switch {
+	case tx.MsgType == DatumRequest:
+		return 1
+	case tx.MsgType == BatchDatumRequest:
+		return len(tx.Pending)
+	case tx.MsgType == SubtreeRequest && tx.Subtree != nil:
+		return max(tx.Subtree.Credit-tx.Subtree.Received, 0)
+	}
+	return 0
+}
+
+// lastActivity renvoie le dernier signe de vie d’une transaction : son envoi,
+// ou la dernière réponse reçue pour un SubtreeRequest (à appeler sous txMu).
+func (tx *Transaction) lastActivity() time.Time {
+	if tx.Subtree != nil && tx.Subtree.LastSeen.After(tx.SentAt) {
+		return tx.Subtree.LastSeen
+	}
+	return tx.SentAt
+}
 
 //
 // ======================= GESTION DE LA MAP DE TRANSACTIONS =======================
@@ -66,10 +83,10 @@ var (
 // Ajoute une transaction à la map globale
 // Paramètre :
 //   - tx : transaction à enregistrer
-func addTransaction(tx *Transaction) {
-	txMu.Lock()
-	Transactions[tx.Id] = tx
-	txMu.Unlock()
+func (n *Node) addTransaction(tx *Transaction) {
+	n.txMu.Lock()
+	n.transactions[tx.Id] = tx
+	n.txMu.Unlock()
 }
 
 //
@@ -81,12 +98,17 @@ func addTransaction(tx *Transaction) {
 // Paramètres :
 //   - conn : connexion UDP utilisée pour les renvois
 //   - priv : clé privée locale
-func CleanupTransactionsLoop(conn *net.UDPConn, priv *ecdsa.PrivateKey) {
+func (n *Node) CleanupTransactionsLoop(conn Transport, priv *ecdsa.PrivateKey) {
 	ticker := time.NewTicker(200 * time.Millisecond)
 	defer ticker.Stop()
 
-	for range ticker.C {
-		CleanupTransactions(conn, priv)
+	for {
+		select {
+		case <-ticker.C:
+			n.CleanupTransactions(conn, priv)
+		case <-n.ctx.Done():
+			return
+		}
 	}
 }
 
@@ -95,25 +117,33 @@ func CleanupTransactionsLoop(conn *net.UDPConn, priv *ecdsa.PrivateKey) {
 // Paramètres :
 //   - conn : connexion UDP utilisée pour les renvois
 //   - priv : clé privée locale
-func CleanupTransactions(conn *net.UDPConn, priv *ecdsa.PrivateKey) {
+func (n *Node) CleanupTransactions(conn Transport, priv *ecdsa.PrivateKey) {
 	now := time.Now()
 
-	txMu.Lock()
-	for id, tx := range Transactions {
+	n.txMu.Lock()
+	for id, tx := range n.transactions {
 
 		if tx.State == TxDone {
 			// si le message est résolu alors on le supprime
-			delete(Transactions, id)
+			delete(n.transactions, id)
 			continue
 		}
-		if now.Sub(tx.SentAt) <= tx.Timeout {
+		if now.Sub(tx.lastActivity()) <= tx.Timeout {
 			// si le timing est encore bon pour cette transaction on passe à la suivante
 			continue
 		}
 
+		if tx.Subtree != nil && !tx.Subtree.LastSeen.IsZero() {
+			// poussée d'un sous-arbre interrompue : les nœuds manquants sont redemandés
+			frontier, unused := tx.closeSubtree()
+			go n.scheduleFrontier(tx, frontier, unused)
+			continue
+		}
+
 		if tx.Retries <= 0 {
-			if tx.MsgType == DatumRequest && tx.Peer != nil {
-				tx.Peer.Window.OnTimeout()
+			n.countExpired(tx.MsgType)
+			if slots := tx.datumSlots(); slots > 0 && tx.Peer != nil {
+				tx.Peer.Window.OnTimeoutN(slots)
 			}
 			switch tx.MsgType {
 			case Hello:
@@ -121,28 +151,24 @@ func CleanupTransactions(conn *net.UDPConn, priv *ecdsa.PrivateKey) {
 				// si entre temps le peer est connecté
 				tx.Peer.Mupeer.RLock()
 				if tx.Peer.State == PeerAssociated {
-					delete(Transactions, id)
+					delete(n.transactions, id)
 				}
 				tx.Peer.Mupeer.RUnlock()
 				// si c'était un hello on retente avec une nouvelle adresse ou on démarre la traversée de nat
-				if debugTransaction {
-					fmt.Println("expired Hello")
-				}
+				txLog.Debug("expired Hello")
 			case NatTraversalRequest:
 				// si le message qui a été envoyé était un NatTraversal on le retente si y'a une autre adresse sinon on abandonne
 				tx.State = TxChangeAddrNat
 				// si entre temps le peer est connecté
 				tx.Peer.Mupeer.RLock()
 				if tx.Peer.State == PeerAssociated {
-					delete(Transactions, id)
+					delete(n.transactions, id)
 				}
 				tx.Peer.Mupeer.RUnlock()
-				if debugTransaction {
-					fmt.Println("expired Nat")
-				}
+				txLog.Debug("expired Nat")
 			default:
 				// dans tous les autres cas le message avec le délai dépassé est supprimé
-				delete(Transactions, id)
+				delete(n.transactions, id)
 			}
 
 			continue
@@ -155,45 +181,58 @@ func CleanupTransactions(conn *net.UDPConn, priv *ecdsa.PrivateKey) {
 
 		if tx.Timeout > 64*time.Second {
 			// timeout alors on supprime la transaction
-			delete(Transactions, id)
+			n.countExpired(tx.MsgType)
+			delete(n.transactions, id)
 			continue
 		}
 
 		tx.State = TxResend
+		tx.refreshBatch() // requête groupée : seulement les hashes sans réponse
+		n.countRetransmission()
 	}
 	// on récupère toutes les transactions qui ne sont pas en vol (celle qui doivent etre renvoyé etc...)
-	var list []*Transaction
-	for _, tx := range Transactions {
-		if tx.State != TxPending {
-			list = append(list, tx)
+	// et on leur donne leur nouvel état tant que txMu est tenu
+	type txAction struct {
+		tx    *Transaction
+		state TxState
+	}
+	var list []txAction
+	for _, tx := range n.transactions {
+		switch tx.State {
+		case TxResend:
+			// les renvoie pour le hello et Natraversal passe également par là
+			list = append(list, txAction{tx, tx.State})
+			tx.State = TxPending // on la remet en vol
+		case TxChangeAddrHello, TxChangeAddrNat:
+			// on la termine car une nouvelle transaction est créée pour la nouvelle adresse ou le nat
+			list = append(list, txAction{tx, tx.State})
+			tx.State = TxDone
 		}
 	}
 
-	txMu.Unlock()
+	n.txMu.Unlock()
 
 	// pour toutes les transactions on effectue les actions spécifiques
 	// exécution
-	for _, tx := range list {
-		//addTransaction(tx)
-		switch tx.State {
+	for _, a := range list {
+		tx := a.tx
+		switch a.state {
 		case TxResend:
-			// les renvoie pour le hello et Natraversal passe également par là
-			tx.State = TxPending // on la remet en vol
 			SendMessage(conn, tx.Addr, tx.Msg)
 		case TxChangeAddrHello:
-			tx.State = TxDone // on la termine car une nouvelle transaction est créée pour la nouvelle adresse ou le nat
-			if tx.Peer.State == PeerDiscovered {
-				if !HelloToPeer(conn, priv, tx.Peer) {
-					OnPeerEvent(tx.Peer, EventConnectionFailed, "Hello non abouti, On teste la traversée de NAT")
+			tx.Peer.Mupeer.RLock()
+			state := tx.Peer.State
+			tx.Peer.Mupeer.RUnlock()
+			if state == PeerDiscovered {
+				if !n.HelloToPeer(conn, priv, tx.Peer) {
+					n.EmitPeerEvent(tx.Peer, EventConnectionFailed, "Hello non abouti, On teste la traversée de NAT")
 					tx.Peer.Mupeer.Lock()
 					tx.Peer.State = PeerWaitHelloNat
 					tx.Peer.Mupeer.Unlock()
-					TryNatTraversal(conn, priv, tx.Peer)
+					n.TryNatTraversal(conn, priv, tx.Peer)
 				}
 			}
 		case TxChangeAddrNat:
-			tx.State = TxDone // on la termine car une nouvelle transaction est créée à chaque nouvelle adresse
-
 			// on récupère l'état du peer
 			tx.Peer.Mupeer.RLock()
 			state := tx.Peer.State
@@ -202,12 +241,10 @@ func CleanupTransactions(conn *net.UDPConn, priv *ecdsa.PrivateKey) {
 			// et que la transaction indique un état de changement d'adresse pour le nat
 			if state == PeerWaitHelloNat {
 				// alors on teste de renvoyer un nouveau nat avec une nouvelle addresse
-				if !TryNatTraversal(conn, priv, tx.Peer) {
+				if !n.TryNatTraversal(conn, priv, tx.Peer) {
 					// si y'a plus d'adresse à tester on marque l'échec
-					OnPeerEvent(tx.Peer, EventConnectionFailed, "NatTraversalRequest2 non abouti")
-					if debugTransaction {
-						fmt.Println("NatTraversal: Fin d'envoie de l'essai")
-					}
+					n.EmitPeerEvent(tx.Peer, EventConnectionFailed, "NatTraversalRequest2 non abouti")
+					txLog.Debug("NatTraversal: Fin d'envoie de l'essai")
 				}
 			}
 
@@ -227,17 +264,60 @@ func CleanupTransactions(conn *net.UDPConn, priv *ecdsa.PrivateKey) {
 // Retour :
 //   - transaction correspondante
 //   - booléen indiquant si elle existait
-func resolveTransaction(id uint32) (*Transaction, bool) {
-	txMu.Lock()
-	defer txMu.Unlock()
+func (n *Node) resolveTransaction(id uint32) (*Transaction, bool) {
+	n.txMu.Lock()
+	defer n.txMu.Unlock()
 
-	tx, ok := Transactions[id]
+	tx, ok := n.transactions[id]
 	if ok {
 		tx.State = TxDone
 	}
 	return tx, ok
 }
 
+// Type du message d’une transaction, sans la résoudre.
+// Retour :
+//   - type du message
+//   - booléen indiquant si la transaction existe
+func (n *Node) transactionType(id uint32) (uint8, bool) {
+	n.txMu.Lock()
+	defer n.txMu.Unlock()
+
+	tx, ok := n.transactions[id]
+	if !ok {
+		return 0, false
+	}
+	return tx.MsgType, true
+}
+
+// Indique si une réponse d’id reçue de addr est attendue : la transaction
+// existe, est encore en cours et a été envoyée à cette adresse.
+func (n *Node) expectsReply(id uint32, addr *net.UDPAddr) bool {
+	n.txMu.Lock()
+	defer n.txMu.Unlock()
+
+	tx, ok := n.transactions[id]
+	return ok && tx.State != TxDone && sameUDPAddr(tx.Addr, addr)
+}
+
+// Comme resolveTransaction, mais seulement si la transaction est encore en
+// cours et que la réponse vient de son destinataire (une réponse en double ou
+// usurpée ne doit pas agir deux fois).
+// Retour :
+//   - transaction correspondante
+//   - booléen indiquant si elle vient d’être résolue
+func (n *Node) resolveTransactionFrom(id uint32, addr *net.UDPAddr) (*Transaction, bool) {
+	n.txMu.Lock()
+	defer n.txMu.Unlock()
+
+	tx, ok := n.transactions[id]
+	if !ok || tx.State == TxDone || !sameUDPAddr(tx.Addr, addr) {
+		return nil, false
+	}
+	tx.State = TxDone
+	return tx, true
+}
+
 // Crée et enregistre une nouvelle transaction réseau.
 // Paramètres :
 //   - id       : identifiant unique
@@ -250,7 +330,7 @@ func resolveTransaction(id uint32) (*Transaction, bool) {
 //
 // Retour :
 //   - pointeur vers la transaction créée
-func CreateTransaction(
+func (n *Node) CreateTransaction(
 	id uint32,
 	p *Peer,
 	addr *net.UDPAddr,
@@ -271,6 +351,6 @@ func CreateTransaction(
 		State:   TxPending,
 	}
 
-	addTransaction(tx)
+	n.addTransaction(tx)
 	return tx
 }
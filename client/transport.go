@@ -0,0 +1,32 @@
+package client
+
+import (
+	"net"
+)
+
+//
+// ======================= TRANSPORT =======================
+//
+
+// Transport est l’interface par laquelle passe tout le trafic P2P.
+// Elle reprend le sous-ensemble de *net.UDPConn utilisé par le client, si bien
+// qu’un *net.UDPConn est directement un Transport ; le paquet simnet fournit
+// une implémentation en mémoire (latence, pertes, réordonnancement, NAT…)
+// pour faire tourner plusieurs peers dans un même processus.
+type Transport interface {
+	// ReadFromUDP lit un paquet ; renvoie une erreur net.ErrClosed après Close
+	ReadFromUDP(b []byte) (int, *net.UDPAddr, error)
+	// WriteToUDP envoie un paquet à addr
+	WriteToUDP(b []byte, addr *net.UDPAddr) (int, error)
+	// LocalAddr renvoie l’adresse locale du transport
+	LocalAddr() net.Addr
+	// Close ferme le transport et débloque les lectures en cours
+	Close() error
+}
+
+// ListenUDP ouvre un socket UDP local et le renvoie sous forme de Transport.
+// Paramètre :
+//   - addr : adresse d’écoute
+func ListenUDP(addr *net.UDPAddr) (Transport, error) {
+	return net.ListenUDP("udp", addr)
+}
@@ -0,0 +1,123 @@
+package client
+
+import (
+	"hash/fnv"
+	"net"
+	"runtime"
+	"sync"
+	"time"
+)
+
+//
+// ======================= POOL DE WORKERS =======================
+//
+
+// Les requêtes et les réponses sont traitées par un pool de workers plutôt que
+// par une seule goroutine : un HandleDatumRequest lent (lecture disque, AES,
+// signature ECDSA) ou un appel HTTPS bloquant dans VerifSign ne fige plus tout
+// le trafic.
+//
+// Ordre : chaque adresse source est toujours affectée au même worker, les
+// paquets d’un même peer sont donc traités dans leur ordre d’arrivée.
+//
+// Contre-pression : chaque worker a une file bornée. Quand elle est pleine,
+// le répartiteur se bloque, requestChan/responseChan se remplissent et le
+// lecteur du socket attend au plus BackpressureTimeout avant de jeter le paquet
+// (le noyau tamponne entre-temps).
+
+// Paramètres du pool (à régler avant le lancement des handlers)
+var (
+	RequestWorkers      = runtime.NumCPU() // nombre de workers pour les requêtes
+	ResponseWorkers     = runtime.NumCPU() // nombre de workers pour les réponses
+	WorkerQueueSize     = 64               // taille de la file de chaque worker
+	BackpressureTimeout = 50 * time.Millisecond
+)
+
+// workerPool : un ensemble de workers, chacun avec sa propre file
+type workerPool struct {
+	queues []chan IncomingPacket
+	wg     sync.WaitGroup
+}
+
+// newWorkerPool démarre n workers qui appellent handle pour chaque paquet reçu.
+func newWorkerPool(n int, handle func(IncomingPacket)) *workerPool {
+	if n < 1 {
+		n = 1
+	}
+	p := &workerPool{queues: make([]chan IncomingPacket, n)}
+	for i := range p.queues {
+		q := make(chan IncomingPacket, WorkerQueueSize)
+		p.queues[i] = q
+		p.wg.Add(1)
+		go func() {
+			defer p.wg.Done()
+			for msg := range q {
+				handle(msg)
+			}
+		}()
+	}
+	return p
+}
+
+// shard renvoie l’indice du worker affecté à une adresse.
+func (p *workerPool) shard(addr *net.UDPAddr) int {
+	if addr == nil {
+		return 0
+	}
+	h := fnv.New32a()
+	h.Write(addr.IP)
+	h.Write([]byte{byte(addr.Port >> 8), byte(addr.Port)})
+	return int(h.Sum32() % uint32(len(p.queues)))
+}
+
+// dispatch place le paquet dans la file de son worker (bloque si elle est pleine).
+// Retour :
+//   - false si done a été fermé pendant l’attente
+func (p *workerPool) dispatch(msg IncomingPacket, done <-chan struct{}) bool {
+	select {
+	case p.queues[p.shard(msg.addr)] <- msg:
+		return true
+	case <-done:
+		return false
+	}
+}
+
+// run répartit les paquets de in jusqu’à sa fermeture ou celle de done,
+// puis attend la fin des workers.
+func (p *workerPool) run(in <-chan IncomingPacket, done <-chan struct{}) {
+loop:
+	for {
+		select {
+		case msg, ok := <-in:
+			if !ok || !p.dispatch(msg, done) {
+				break loop
+			}
+		case <-done:
+			break loop
+		}
+	}
+	for _, q := range p.queues {
+		close(q)
+	}
+	p.wg.Wait()
+}
+
+// enqueue met un paquet dans une file d’entrée en appliquant la contre-pression :
+// on attend au plus BackpressureTimeout qu’une place se libère.
+// Retour :
+//   - false si le paquet a dû être jeté
+func enqueue(ch chan<- IncomingPacket, msg IncomingPacket) bool {
+	select {
+	case ch <- msg:
+		return true
+	default:
+	}
+	t := time.NewTimer(BackpressureTimeout)
+	defer t.Stop()
+	select {
+	case ch <- msg:
+		return true
+	case <-t.C:
+		return false
+	}
+}
@@ -0,0 +1,180 @@
+package client_test
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"myp2p/client"
+	"myp2p/clientStorage"
+	"myp2p/internal/testkeyserver"
+	"myp2p/simnet"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestWorkerPoolConcurrentDownloads fait télécharger le même arbre par
+// plusieurs peers à la fois : les requêtes sont réparties sur plusieurs
+// workers du côté serveur et les réponses sur plusieurs workers du côté de
+// chaque client. À lancer avec -race.
+func TestWorkerPoolConcurrentDownloads(t *testing.T) {
+	setVar(t, &client.RequestWorkers, 4)
+	setVar(t, &client.ResponseWorkers, 4)
+
+	ks := testkeyserver.New(t)
+	sim := simnet.New(1)
+	src := t.TempDir()
+	store, root := makeTree(t, src, 3, 4, 20000)
+
+	conn, err := sim.Listen("10.0.0.1:9000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := startNode(t, ks, "server", conn, "10.0.0.1:9000", store)
+
+	var clients []*testNode
+	for i := range 4 {
+		addr := fmt.Sprintf("10.0.1.%d:9000", i+1)
+		conn, err := sim.Listen(addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c := startNode(t, ks, fmt.Sprintf("client%d", i), conn, addr, nil)
+		associate(t, c, server)
+		if got := askRoot(t, c, server); !bytes.Equal(got, root) {
+			t.Fatalf("%s : root %x, attendu %x", c.Name, got, root)
+		}
+		clients = append(clients, c)
+	}
+
+	for _, c := range clients {
+		startDownload(t, c, server, root)
+	}
+	for _, c := range clients {
+		c.waitEvent(t, server.Name, client.EventMerkleDownloadComplete, 60*time.Second)
+		peer, _ := c.FindPeer(server.Name)
+		out := filepath.Join(t.TempDir(), "out")
+		if err := c.RebuildFromPeer(peer, root, out); err != nil {
+			t.Fatal(err)
+		}
+		sameFiles(t, src, out)
+	}
+}
+
+// TestRootReplyDuringRefresh traite des RootReply (nouveau root, liste des
+// versions) et un téléchargement pendant que le peer est rafraîchi et refait
+// son Hello en boucle : adresses, état, root et fenêtre sont lus et écrits
+// par plusieurs workers à la fois. À lancer avec -race.
+func TestRootReplyDuringRefresh(t *testing.T) {
+	setVar(t, &client.RequestWorkers, 4)
+	setVar(t, &client.ResponseWorkers, 4)
+
+	ks := testkeyserver.New(t)
+	sim := simnet.New(1)
+	ca, err := sim.Listen("10.0.0.1:9000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cb, err := sim.Listen("10.0.0.2:9000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// cinq versions dans le même Store : au-delà de trois, les anciens roots
+	// sortent de la liste du peer
+	src := t.TempDir()
+	store, root := makeTree(t, src, 2, 3, 5000)
+	roots := [][]byte{root}
+	for v := range 4 {
+		dir := filepath.Join(t.TempDir(), "v")
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, "version.txt"), []byte(fmt.Sprint(v)), 0644); err != nil {
+			t.Fatal(err)
+		}
+		node, err := store.BuildMerkleNode(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		roots = append(roots, clientStorage.Sha(node))
+	}
+	a := startNode(t, ks, "alice", ca, "10.0.0.1:9000", nil)
+	b := startNode(t, ks, "bob", cb, "10.0.0.2:9000", store)
+	associate(t, a, b)
+
+	// carol n'a pas de nœud : elle entre et sort de la liste des peers d'alice
+	carol, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ks.Register("carol", &carol.PublicKey, "10.0.0.3:9000")
+
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	var once sync.Once
+	stopChurn := func() {
+		once.Do(func() { close(stop) })
+		wg.Wait()
+	}
+	defer stopChurn()
+	churn := func(f func(i int)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; ; i++ {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				f(i)
+				time.Sleep(time.Millisecond)
+			}
+		}()
+	}
+	bobSeen, _ := a.FindPeer(b.Name)
+	aliceSeen, _ := b.FindPeer(a.Name)
+	churn(func(i int) {
+		if i%2 == 0 {
+			a.RefreshPeers([]string{b.Name, "carol"})
+		} else {
+			a.RefreshPeers([]string{b.Name})
+		}
+		a.InitPeersMap([]string{b.Name})
+		a.SendHello(a.conn, a.priv, bobSeen)
+		a.WriteMetrics(io.Discard)
+	})
+	churn(func(int) { b.SendHello(b.conn, b.priv, aliceSeen) })
+
+	// deux RootRequest à la fois : les RootReply sont traités par deux workers
+	for i := range 2 * len(roots) {
+		want := roots[len(roots)-1-i%len(roots)]
+		store.SetRoot(want)
+		sendRootRequest(t, a, b)
+		askRoot(t, a, b)
+		a.waitEvent(t, b.Name, client.EventNewRoot, 5*time.Second)
+		bobSeen.Mupeer.RLock()
+		got := bobSeen.Root
+		bobSeen.Mupeer.RUnlock()
+		if !bytes.Equal(got, want) {
+			t.Fatalf("root %x, attendu %x", got, want)
+		}
+	}
+	store.SetRoot(root)
+	askRoot(t, a, b)
+	startDownload(t, a, b, root)
+	a.waitEvent(t, b.Name, client.EventMerkleDownloadComplete, 30*time.Second)
+	stopChurn()
+
+	peer, _ := a.FindPeer(b.Name)
+	out := filepath.Join(t.TempDir(), "out")
+	if err := a.RebuildFromPeer(peer, root, out); err != nil {
+		t.Fatal(err)
+	}
+	sameFiles(t, src, out)
+}
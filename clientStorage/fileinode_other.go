@@ -0,0 +1,8 @@
+//go:build !unix
+
+package clientStorage
+
+import "os"
+
+// fileInode : pas d’inode sur ce système, le cache se fie à la taille et à la date.
+func fileInode(os.FileInfo) uint64 { return 0 }
@@ -0,0 +1,16 @@
+//go:build unix
+
+package clientStorage
+
+import (
+	"os"
+	"syscall"
+)
+
+// fileInode renvoie l’inode d’un fichier (clé du cache de hashes).
+func fileInode(fi os.FileInfo) uint64 {
+	if st, ok := fi.Sys().(*syscall.Stat_t); ok {
+		return uint64(st.Ino)
+	}
+	return 0
+}
@@ -9,33 +9,32 @@ import (
 	"strings"
 )
 
-// ---------------------
-// Configuration debug
-// ---------------------
-
-// Active l’affichage des informations de débogage
-var debug = true
-
 // ---------------------
 // Reconstruction Merkle
 // ---------------------
 
 // RebuildNode reconstruit récursivement un fichier ou un répertoire
-// à partir de son hash Merkle.
+// à partir de son hash Merkle. L’arbre est d’abord vérifié (CheckTree) puis
+// écrit dans un répertoire temporaire déplacé en path une fois complet
+// (cf. rebuild_sandbox.go).
 //
 // Paramètres :
 //   - hash : hash du nœud Merkle à reconstruire
 //   - path : chemin local où reconstruire le nœud
 //
 // Retour :
-//   - erreur éventuelle lors de la reconstruction
-func RebuildNode(hash []byte, path string) error {
-	node, ok := MerkleMap[hex.EncodeToString(hash)]
+//   - erreur éventuelle lors de la reconstruction (ErrUnsafeTree si l’arbre est refusé)
+func (s *Store) RebuildNode(hash []byte, path string) error {
+	return s.rebuildSandboxed(hash, path)
+}
 
-	if debug {
-		fmt.Println("\n\n=== Reconstruction du noeud :", hex.EncodeToString(hash), "===")
-		fmt.Println("Noeud Merkle :", node)
-	}
+// rebuildNode : RebuildNode d’un nœud situé sous base, le chemin où la
+// reconstruction a commencé (un lien symbolique ne doit pas en sortir).
+func (s *Store) rebuildNode(hash []byte, path, base string) error {
+	node, ok := s.FindHash(hash)
+
+	merkleLog.Debug("Reconstruction du noeud", "hash", hex.EncodeToString(hash))
+	merkleLog.Debug("Noeud Merkle", "node", node)
 
 	if !ok {
 		return fmt.Errorf("node not found: %x", hash)
@@ -43,7 +42,7 @@ func RebuildNode(hash []byte, path string) error {
 
 	// Nœud vide : rien à reconstruire
 	if len(node) == 0 {
-		fmt.Printf("%s <empty node>\n", path)
+		merkleLog.Debug("nœud vide", "path", path)
 		return nil
 	}
 
@@ -72,19 +71,57 @@ func RebuildNode(hash []byte, path string) error {
 			Thename := UniqueName(path, name)
 			childPath := filepath.Join(path, Thename)
 
-			if err := RebuildNode(childHash, childPath); err != nil {
+			if err := s.rebuildNode(childHash, childPath, base); err != nil {
 				return err
 			}
 		}
 		return nil
 
+	// ---------------------
+	// LongDirectory : comme Directory, noms de longueur variable
+	// ---------------------
+	case LongDirectory:
+		n, err := ParseNode(node)
+		if err != nil {
+			return err
+		}
+		if err := os.MkdirAll(path, DirPerm); err != nil {
+			return err
+		}
+		for _, e := range n.Entries {
+			childPath := filepath.Join(path, UniqueName(path, e.Name))
+			if err := s.rebuildNode(e.Hash, childPath, base); err != nil {
+				return err
+			}
+		}
+		return nil
+
+	// ---------------------
+	// Metadata : reconstruction du contenu puis application du mode et de la date
+	// ---------------------
+	case Metadata:
+		n, err := ParseNode(node)
+		if err != nil {
+			return err
+		}
+		if err := s.rebuildNode(n.Children[0], path, base); err != nil {
+			return err
+		}
+		return applyMetadata(path, n.Meta)
+
+	// ---------------------
+	// Symlink : lien symbolique (cf. RebuildSymlinks)
+	// ---------------------
+	case Symlink:
+		return rebuildSymlink(node, path, base)
+
 	// ---------------------
 	// Big : fichier composé de chunks ou de Big imbriqués
 	// ---------------------
 	case Big:
 		f, err := os.Create(path)
 		if err != nil {
-			fmt.Println("Erreur création fichier Big :", err)
+			merkleLog.Warn("Erreur création fichier Big", "err", err)
 			return err
 		}
 		defer f.Close()
@@ -92,17 +129,17 @@ func RebuildNode(hash []byte, path string) error {
 		count := (len(node) - IdSize) / HashSize
 		for i := 0; i < count; i++ {
 			childHash := node[IdSize+i*HashSize : IdSize+i*HashSize+HashSize]
-			childNode := MerkleMap[hex.EncodeToString(childHash)]
+			childNode, _ := s.FindHash(childHash)
 
 			if childNode[0] != Chunk {
-				if err := WriteBigToFile(f, childNode); err != nil {
+				if err := s.WriteBigToFile(f, childNode); err != nil {
 					return err
 				}
 				continue
 			}
 
 			if _, err := f.Write(childNode[IdSize:]); err != nil {
-				fmt.Println("Erreur écriture chunk :", err)
+				merkleLog.Warn("Erreur écriture chunk", "err", err)
 				return err
 			}
 		}
@@ -115,7 +152,7 @@ func RebuildNode(hash []byte, path string) error {
 		count := (len(node) - IdSize) / HashSize
 		for i := 0; i < count; i++ {
 			childHash := node[IdSize+i*HashSize : IdSize+i*HashSize+HashSize]
-			if err := RebuildNode(childHash, path); err != nil {
+			if err := s.rebuildNode(childHash, path, base); err != nil {
 				return err
 			}
 		}
@@ -141,12 +178,12 @@ func RebuildNode(hash []byte, path string) error {
 //
 // Retour :
 //   - erreur éventuelle
-func WriteBigToFile(f *os.File, node []byte) error {
+func (s *Store) WriteBigToFile(f *os.File, node []byte) error {
 	count := (len(node) - IdSize) / HashSize
 
 	for i := 0; i < count; i++ {
 		childHash := node[IdSize+i*HashSize : IdSize+i*HashSize+HashSize]
-		childNode := MerkleMap[hex.EncodeToString(childHash)]
+		childNode, _ := s.FindHash(childHash)
 
 		switch childNode[0] {
 
@@ -157,7 +194,7 @@ func WriteBigToFile(f *os.File, node []byte) error {
 
 		case Big:
 			// Récursion sur Big imbriqué
-			if err := WriteBigToFile(f, childNode); err != nil {
+			if err := s.WriteBigToFile(f, childNode); err != nil {
 				return err
 			}
 
@@ -178,16 +215,16 @@ func WriteBigToFile(f *os.File, node []byte) error {
 // Paramètres :
 //   - hash : hash racine du fichier à télécharger
 //   - path : chemin de destination
-func DownloadFile(hash []byte, path string) {
+func (s *Store) DownloadFile(hash []byte, path string) {
 	// Suppression complète pour éviter les incohérences
 	_ = os.RemoveAll(path)
 
-	if err := RebuildNode(hash, path); err != nil {
-		fmt.Println("Erreur lors de la reconstruction :", err)
+	if err := s.RebuildNode(hash, path); err != nil {
+		merkleLog.Warn("Erreur lors de la reconstruction", "err", err)
 		return
 	}
 
-	fmt.Println("Fichier téléchargé avec succès")
+	merkleLog.Info("Fichier téléchargé avec succès")
 }
 
 // UniqueName génère un nom de fichier unique dans le répertoire donné.
@@ -208,7 +245,8 @@ func UniqueName(dir string, name string) string {
 	for {
 
 		currentPath := filepath.Join(dir, name)
-		_, err := os.Stat(currentPath)
+		// Lstat : un lien cassé occupe aussi le nom (on n’écrit jamais à travers)
+		_, err := os.Lstat(currentPath)
 
 		if os.IsNotExist(err) {
 			return name
@@ -0,0 +1,100 @@
+package clientStorage
+
+import (
+	"bytes"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+//
+// ======================= CIBLES DE FUZZING =======================
+//
+
+// FuzzNode : décodage d’un nœud Merkle reçu (cf. README, « Fuzzing ») :
+//
+//	go test ./clientStorage -run '^$' -fuzz '^FuzzNode$'
+//
+// Le corpus initial contient chaque sorte de nœud d’un vrai arbre. Un nœud
+// accepté doit se réencoder octet pour octet, et les fonctions qui parcourent
+// les nœuds du Store ne doivent pas paniquer.
+func FuzzNode(f *testing.F) {
+	for _, node := range seedNodes(f) {
+		f.Add(node)
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		Typedata(data)
+		children := ListChildrenHashes(data)
+
+		n, err := ParseNode(data)
+		if err != nil {
+			if len(children) != 0 {
+				t.Fatal("enfants listés pour un nœud invalide")
+			}
+			return
+		}
+		if !bytes.Equal(n.Marshal(), data) {
+			t.Fatal("aller-retour ParseNode/Marshal instable")
+		}
+		if len(children) != len(n.ChildHashes()) {
+			t.Fatal("ListChildrenHashes et ChildHashes divergent")
+		}
+	})
+}
+
+// seedNodes construit un arbre avec un fichier d’un chunk, un gros fichier,
+// un nom long, un lien symbolique stocké et les métadonnées, et renvoie tous
+// ses nœuds.
+func seedNodes(f *testing.F) [][]byte {
+	f.Helper()
+	oldPolicy, oldMeta := SymlinkPolicy, FileMetadata
+	SymlinkPolicy, FileMetadata = SymlinkStore, true
+	defer func() { SymlinkPolicy, FileMetadata = oldPolicy, oldMeta }()
+
+	dir := f.TempDir()
+	sub := filepath.Join(dir, "sous-répertoire")
+	if err := os.Mkdir(sub, 0755); err != nil {
+		f.Fatal(err)
+	}
+	big := make([]byte, 3*ChunkSize+17)
+	for i := range big {
+		big[i] = byte(i * 7)
+	}
+	files := map[string][]byte{
+		"petit.txt":                     []byte("bonjour\n"),
+		"gros.bin":                      big,
+		strings.Repeat("nom-long-", 6):  []byte("nom de plus de 32 octets"),
+		filepath.Join(sub, "vide.txt"):  {},
+		filepath.Join(sub, "autre.txt"): []byte("au revoir\n"),
+	}
+	for name, data := range files {
+		if !filepath.IsAbs(name) {
+			name = filepath.Join(dir, name)
+		}
+		if err := os.WriteFile(name, data, 0640); err != nil {
+			f.Fatal(err)
+		}
+	}
+	if err := os.Symlink("petit.txt", filepath.Join(dir, "lien")); err != nil {
+		f.Fatal(err)
+	}
+
+	s := NewStore()
+	root, err := s.BuildMerkleNode(dir)
+	if err != nil {
+		f.Fatal(err)
+	}
+	var nodes [][]byte
+	for h := range s.ReachableHashes(Sha(root)) {
+		hash, _ := hex.DecodeString(h)
+		if node, ok := s.FindHash(hash); ok {
+			nodes = append(nodes, node)
+		}
+	}
+	if len(nodes) == 0 {
+		f.Fatal("arbre vide")
+	}
+	return nodes
+}
@@ -0,0 +1,282 @@
+package clientStorage
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+//-----------------------------------------------------------------------------------------
+// Ce fichier définit le cache de hashes utilisé pour reconstruire l’arbre de
+// nos données sans relire les fichiers inchangés : pour chaque fichier, sa
+// taille, sa date de modification et son inode au moment du hachage, et le
+// hash de son nœud de plus haut niveau.
+//
+// Une entrée n’est reprise que si :
+//   - taille, date de modification et inode sont inchangés ;
+//   - le fichier n’a pas été modifié juste avant d’être haché (une
+//     modification dans la même unité de temps serait invisible) ;
+//   - tout le sous-arbre du fichier est encore présent dans le Store.
+//
+// Sinon le fichier est relu. Le cache n’est sauvegardé qu’après une
+// construction réussie et ne garde que les fichiers vus lors de celle-ci.
+
+// HashCacheFile : fichier de persistance du cache ("" = pas de cache, tout est relu)
+var HashCacheFile = ""
+
+// Marge en deçà de laquelle une date de modification proche du hachage rend
+// l’entrée douteuse
+var hashCacheRacyWindow = 2 * time.Second
+
+//
+// ======================= CACHE =======================
+//
+
+// CacheEntry : état d’un fichier lors de son dernier hachage
+type CacheEntry struct {
+	Size     int64  `json:"size"`
+	ModTime  int64  `json:"mtime"` // nanosecondes depuis l’époque Unix
+	Inode    uint64 `json:"inode"` // 0 si le système ne le fournit pas
+	Hash     string `json:"hash"`  // hash hexadécimal du nœud de plus haut niveau
+	HashedAt int64  `json:"hashed_at"`
+
+	Chunking ChunkingMode `json:"chunking,omitempty"` // découpage utilisé (cf. ShareChunking)
+}
+
+// HashCache : chemin relatif → entrée, pour un répertoire racine donné
+type HashCache struct {
+	Root    string                `json:"root"`
+	Entries map[string]CacheEntry `json:"entries"`
+
+	mu   sync.Mutex
+	seen map[string]CacheEntry // entrées de la construction en cours
+}
+
+// LoadHashCache lit le cache de path. Un fichier absent ou illisible donne un
+// cache vide : au pire, tous les fichiers sont relus.
+func LoadHashCache(path string) *HashCache {
+	c := &HashCache{Entries: map[string]CacheEntry{}}
+	if path == "" {
+		return c
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			merkleLog.Warn("cache de hashes illisible, ignoré", "file", path, "err", err)
+		}
+		return c
+	}
+	if err := json.Unmarshal(data, c); err != nil || c.Entries == nil {
+		merkleLog.Warn("cache de hashes invalide, ignoré", "file", path, "err", err)
+		return &HashCache{Entries: map[string]CacheEntry{}}
+	}
+	return c
+}
+
+// Save écrit le cache dans path (fichier temporaire puis renommage).
+func (c *HashCache) Save(path string) error {
+	if path == "" {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	data, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// newCacheEntry décrit fi haché au moment hashedAt avec le découpage mode.
+func newCacheEntry(fi os.FileInfo, hash []byte, hashedAt time.Time, mode ChunkingMode) CacheEntry {
+	return CacheEntry{
+		Size:     fi.Size(),
+		ModTime:  fi.ModTime().UnixNano(),
+		Inode:    fileInode(fi),
+		Hash:     hex.EncodeToString(hash),
+		HashedAt: hashedAt.UnixNano(),
+		Chunking: mode,
+	}
+}
+
+// lookup renvoie le hash en cache de rel si fi n’a pas changé depuis son
+// hachage avec le découpage mode.
+func (c *HashCache) lookup(rel string, fi os.FileInfo, mode ChunkingMode) ([]byte, bool) {
+	c.mu.Lock()
+	e, ok := c.Entries[rel]
+	c.mu.Unlock()
+	if !ok || e.Chunking != mode || e.Size != fi.Size() || e.ModTime != fi.ModTime().UnixNano() || e.Inode != fileInode(fi) {
+		return nil, false
+	}
+	// modifié juste avant le hachage : une modification ultérieure pourrait
+	// avoir gardé la même date
+	if e.HashedAt-e.ModTime < hashCacheRacyWindow.Nanoseconds() {
+		return nil, false
+	}
+	hash, err := hex.DecodeString(e.Hash)
+	if err != nil || len(hash) != HashSize {
+		return nil, false
+	}
+	return hash, true
+}
+
+// record note l’entrée de rel pour la construction en cours.
+func (c *HashCache) record(rel string, e CacheEntry) {
+	c.mu.Lock()
+	c.seen[rel] = e
+	c.mu.Unlock()
+}
+
+// begin prépare une construction de root ; un cache d’un autre répertoire est vidé.
+func (c *HashCache) begin(root string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.Root != root {
+		c.Root = root
+		c.Entries = map[string]CacheEntry{}
+	}
+	c.seen = map[string]CacheEntry{}
+}
+
+// commit remplace les entrées par celles de la construction terminée et
+// renvoie les chemins ajoutés, supprimés et modifiés depuis la précédente.
+func (c *HashCache) commit(r *MerkleReport) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for rel, e := range c.seen {
+		old, ok := c.Entries[rel]
+		switch {
+		case !ok:
+			r.Added = append(r.Added, rel)
+		case old.Hash != e.Hash:
+			r.Modified = append(r.Modified, rel)
+		}
+	}
+	for rel := range c.Entries {
+		if _, ok := c.seen[rel]; !ok {
+			r.Removed = append(r.Removed, rel)
+		}
+	}
+	sort.Strings(r.Added)
+	sort.Strings(r.Removed)
+	sort.Strings(r.Modified)
+	c.Entries, c.seen = c.seen, nil
+}
+
+//
+// ======================= CONSTRUCTION INCRÉMENTALE =======================
+//
+
+// MerkleReport : bilan d’une construction incrémentale
+type MerkleReport struct {
+	Added    []string // fichiers apparus depuis la construction précédente
+	Removed  []string // fichiers disparus
+	Modified []string // fichiers dont le contenu a changé
+	Reused   int      // fichiers repris du cache sans être relus
+	Hashed   int      // fichiers relus
+
+	// Inconsistent (mode vérification) : fichiers dont l’entrée du cache était
+	// jugée valide mais ne correspond pas au contenu
+	Inconsistent []string
+}
+
+// Changed indique si des fichiers ont été ajoutés, supprimés ou modifiés.
+func (r *MerkleReport) Changed() bool {
+	return len(r.Added)+len(r.Removed)+len(r.Modified) > 0
+}
+
+func (r *MerkleReport) String() string {
+	s := fmt.Sprintf("%d ajouté(s), %d supprimé(s), %d modifié(s) ; %d relu(s), %d repris du cache",
+		len(r.Added), len(r.Removed), len(r.Modified), r.Hashed, r.Reused)
+	if len(r.Inconsistent) > 0 {
+		s += fmt.Sprintf(" ; %d entrée(s) du cache incohérente(s)", len(r.Inconsistent))
+	}
+	return s
+}
+
+// -----------------------------------------------------------------------------------------
+// UpdateMerkle construit l’arbre de dir en reprenant du cache (HashCacheFile)
+// les fichiers inchangés, puis sauvegarde le cache.
+// Paramètres :
+//   - dir    : répertoire (ou fichier) à construire
+//   - verify : relire tous les fichiers et signaler les entrées du cache qui
+//     ne correspondent pas à leur contenu (MerkleReport.Inconsistent)
+//
+// Retour :
+//   - le nœud racine, le bilan de la construction, et l’erreur éventuelle
+//     (le cache n’est alors pas modifié)
+func (s *Store) UpdateMerkle(dir string, verify bool) ([]byte, *MerkleReport, error) {
+	cache := LoadHashCache(HashCacheFile)
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, nil, err
+	}
+	cache.begin(abs)
+
+	b := NewMerkleBuilder(s, MerkleWorkers)
+	b.cache, b.verify = cache, verify
+	b.report = &MerkleReport{}
+	root, err := b.Build(dir)
+	if err != nil {
+		return nil, nil, err
+	}
+	cache.commit(b.report)
+	sort.Strings(b.report.Inconsistent)
+	if err := cache.Save(HashCacheFile); err != nil {
+		merkleLog.Warn("sauvegarde du cache de hashes impossible", "file", HashCacheFile, "err", err)
+	}
+	merkleLog.Info("arbre reconstruit", "dir", dir, "bilan", b.report.String())
+	for _, rel := range b.report.Inconsistent {
+		merkleLog.Warn("entrée du cache incohérente", "path", rel)
+	}
+	return root, b.report, nil
+}
+
+// -----------------------------------------------------------------------------------------
+// retainTree compte une référence de plus sur chaque nœud du sous-arbre de
+// hash, comme l’aurait fait sa reconstruction (la racine deux fois, cf.
+// buildFile), si tout le sous-arbre est présent.
+// Retour :
+//   - le nœud racine, et false s’il manque un nœud (rien n’est alors compté)
+func (s *Store) retainTree(hash []byte) ([]byte, bool) {
+	key := hex.EncodeToString(hash)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.walkLocked(key, func(string) {}) {
+		return nil, false
+	}
+	s.walkLocked(key, func(k string) { s.counts[k]++ })
+	s.counts[key]++
+	return s.nodes[key], true
+}
+
+// walkLocked appelle f sur chaque nœud (et chaque occurrence) du sous-arbre
+// de key, key compris. Retour : false si un nœud manque.
+func (s *Store) walkLocked(key string, f func(key string)) bool {
+	node, ok := s.nodes[key]
+	if !ok {
+		return false
+	}
+	f(key)
+	n, err := ParseNode(node)
+	if err != nil {
+		return false
+	}
+	for _, h := range n.ChildHashes() {
+		if !s.walkLocked(hex.EncodeToString(h), f) {
+			return false
+		}
+	}
+	return true
+}
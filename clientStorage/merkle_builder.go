@@ -3,9 +3,7 @@ package clientStorage
 import (
 	"crypto/sha256"
 	"encoding/hex"
-	"fmt"
 	"os"
-	"sync"
 )
 
 //
@@ -16,14 +14,19 @@ import (
 // Types de noeuds
 const (
 	// Types de noeuds
-	Chunk        = 0
-	Directory    = 1
-	Big          = 2
-	BigDirectory = 3
+	Chunk         = 0
+	Directory     = 1
+	Big           = 2
+	BigDirectory  = 3
+	LongDirectory = 4 // Directory à noms de longueur variable (cf. merkle_names.go)
+	Metadata      = 5 // métadonnées d’une entrée (cf. merkle_meta.go)
+	Symlink       = 6 // lien symbolique (cf. merkle_links.go)
 
 	// Tailles fixes
 	HashSize      = 32
 	NameSize      = 32
+	LongNameSize  = 255                 // taille maximale d’un nom dans un LongDirectory
+	MetadataSize  = 4 + 8 + 8           // mode, date de modification, taille
 	DirEntrySize  = NameSize + HashSize // 64
 	MaxDirEntries = 16
 	MaxBigEntries = 32
@@ -41,25 +44,12 @@ const (
 // du Merkle Tree à partir de fichiers et de répertoires locaux, en générant
 // récursivement les nœuds et les structures intermédiaires nécessaires.
 
-//
-// ======================= VARIABLES GLOBALES =======================
-//
-
-// Mutex protégeant l’accès concurrent au Merkle Tree
-var mu sync.RWMutex
-
-// Map principale contenant tous les nœuds du Merkle Tree (hash → contenu)
-var MerkleMap = map[string][]byte{}
-
 // Structure représentant une entrée de répertoire
 type DirectoryEntry struct {
 	Name string
 	Hash []byte
 }
 
-// Hash racine du Merkle Tree local
-var RootHash []byte
-
 //
 // ======================= OUTILS UTILITAIRES =======================
 //
@@ -96,34 +86,35 @@ func SplitIntoChunks(data []byte) [][]byte {
 	return chunks
 }
 
-// Ajoute un nœud dans la MerkleMap avec comptage de références
+// Ajoute un nœud dans le Store avec comptage de références
 // Paramètre : node → nœud à enregistrer
-func FillMap(node []byte) {
-	if debugMerkle {
-		fmt.Println("FillMap")
-
-		fmt.Printf("[DEBUG] Node created: type=%d hash=%x\n", node[0], Sha(node))
+func (s *Store) FillMap(node []byte) {
+	s.fillNode(node, Sha(node))
+}
 
-	}
-	hash := Sha(node)
+// fillNode : FillMap d’un nœud dont le hash est déjà calculé
+func (s *Store) fillNode(node []byte, hash []byte) {
 	key := hex.EncodeToString(hash)
+	merkleLog.Debug("nœud créé", "type", node[0], "hash", key)
 
-	mu.Lock()
-	_, exists := MerkleMap[key]
+	s.mu.Lock()
+	_, exists := s.nodes[key]
 	if exists {
-		CountMap[key]++
+		s.counts[key]++
 	} else {
-		MerkleMap[key] = node
-		CountMap[key] = 1
+		s.nodes[key] = node
+		s.counts[key] = 1
 	}
-	mu.Unlock()
+	s.mu.Unlock()
 }
 
 // Retourne le type d’un nœud Merkle
 // Paramètre : node → nœud à analyser
-// Retour : type du nœud
+// Retour : type du nœud (255 si vide ou inconnu)
 func Typedata(node []byte) byte {
-	if node[0] == Chunk {
+	if len(node) == 0 {
+		return 255
+	} else if node[0] == Chunk {
 		return Chunk
 	} else if node[0] == Directory {
 		return Directory
@@ -131,6 +122,12 @@ func Typedata(node []byte) byte {
 		return Big
 	} else if node[0] == BigDirectory {
 		return BigDirectory
+	} else if node[0] == LongDirectory {
+		return LongDirectory
+	} else if node[0] == Metadata {
+		return Metadata
+	} else if node[0] == Symlink {
+		return Symlink
 	} else {
 		return 255
 	}
@@ -185,71 +182,18 @@ func HashBigDirectory(hashes [][]byte) []byte {
 // ======================= CONSTRUCTION DU MERKLE TREE =======================
 //
 
-// Construit récursivement le Merkle Tree à partir d’un chemin
-// Paramètre : path → fichier ou répertoire
-// Retour : nœud racine et erreur éventuelle
-func BuildMerkleNode(path string) ([]byte, error) {
-	fi, err := os.Stat(path)
-	if err != nil {
-		if debugMerkle {
-			fmt.Println("Erreur Stat BuildMerkleNode")
-		}
-		return nil, err
-	}
-
-	if fi.IsDir() {
-		entries, err := os.ReadDir(path)
-		if err != nil {
-			if debugMerkle {
-				fmt.Println("Erreur ReadDir BuildMerkleNode")
-			}
-			return nil, err
-		}
-		var children [][]byte
-		for _, e := range entries {
-			childPath := path + "/" + e.Name()
-			childNode, err := BuildMerkleNode(childPath)
-			if err != nil {
-				return nil, err
-			}
-			children = append(children, childNode)
-		}
-		node := buildDirectoryNode(entries, children)
-		return node, nil
-	} else {
-		data, err := os.ReadFile(path)
-		if err != nil {
-			if debugMerkle {
-				fmt.Println("Erreur ReadFile BuildMerkleNode")
-			}
-			return nil, err
-		}
-		chunks := SplitIntoChunks(data)
-		var children [][]byte
-		for _, c := range chunks {
-			chunkNode := HashChunk(c)
-			FillMap(chunkNode)
-			children = append(children, chunkNode)
-		}
-		for len(children) > 1 {
-			children = buildBigNodes(children)
-		}
-		FillMap(children[0])
-		return children[0], nil
-	}
-}
-
 // -----------------------------------------------------------------------------------------
 // Construit un nœud de type Directory ou BigDirectory selon le nombre d’entrées.
 // Si le nombre d’entrées dépasse MaxDirEntries, plusieurs sous-nœuds sont créés
-// puis regroupés dans un BigDirectory.
+// puis regroupés dans un BigDirectory. Un sous-nœud dont un nom dépasse
+// NameSize est un LongDirectory.
 // Paramètres :
 //   - entries : liste des entrées du répertoire (fichiers / sous-répertoires)
 //   - children : liste des nœuds enfants déjà construits
 //
 // Retour :
 //   - le nœud Merkle représentant le répertoire
-func buildDirectoryNode(entries []os.DirEntry, children [][]byte) []byte {
+func (s *Store) buildDirectoryNode(entries []os.DirEntry, children [][]byte) []byte {
 	dirEntries := make([]DirectoryEntry, len(entries))
 	for i, e := range entries {
 		dirEntries[i] = DirectoryEntry{
@@ -259,8 +203,8 @@ func buildDirectoryNode(entries []os.DirEntry, children [][]byte) []byte {
 	}
 	var node []byte
 	if len(dirEntries) <= MaxDirEntries {
-		node = HashDirectory(dirEntries)
-		FillMap(node)
+		node = hashDirectoryPart(dirEntries)
+		s.FillMap(node)
 	} else {
 		var chunkHashes [][]byte
 		for i := 0; i < len(dirEntries); i += MaxDirEntries {
@@ -268,12 +212,12 @@ func buildDirectoryNode(entries []os.DirEntry, children [][]byte) []byte {
 			if end > len(dirEntries) {
 				end = len(dirEntries)
 			}
-			subNode := HashDirectory(dirEntries[i:end])
-			FillMap(subNode)
+			subNode := hashDirectoryPart(dirEntries[i:end])
+			s.FillMap(subNode)
 			chunkHashes = append(chunkHashes, subNode)
 		}
 		for len(chunkHashes) > 1 {
-			chunkHashes = buildBigDirectoryNodes(chunkHashes)
+			chunkHashes = s.buildBigDirectoryNodes(chunkHashes)
 		}
 		node = chunkHashes[0]
 	}
@@ -288,8 +232,8 @@ func buildDirectoryNode(entries []os.DirEntry, children [][]byte) []byte {
 //
 // Retour :
 //   - liste de nœuds Big
-func buildBigNodes(children [][]byte) [][]byte {
-	return mergeNodes(children, Big)
+func (s *Store) buildBigNodes(children [][]byte) [][]byte {
+	return s.mergeNodes(children, Big)
 }
 
 // -----------------------------------------------------------------------------------------
@@ -300,8 +244,8 @@ func buildBigNodes(children [][]byte) [][]byte {
 //
 // Retour :
 //   - liste de nœuds BigDirectory
-func buildBigDirectoryNodes(children [][]byte) [][]byte {
-	return mergeNodes(children, BigDirectory)
+func (s *Store) buildBigDirectoryNodes(children [][]byte) [][]byte {
+	return s.mergeNodes(children, BigDirectory)
 }
 
 // -----------------------------------------------------------------------------------------
@@ -313,7 +257,7 @@ func buildBigDirectoryNodes(children [][]byte) [][]byte {
 //
 // Retour :
 //   - liste des nouveaux nœuds intermédiaires
-func mergeNodes(children [][]byte, nodeType byte) [][]byte {
+func (s *Store) mergeNodes(children [][]byte, nodeType byte) [][]byte {
 
 	var next [][]byte
 	for i := 0; i < len(children); i += MaxBigEntries {
@@ -325,7 +269,7 @@ func mergeNodes(children [][]byte, nodeType byte) [][]byte {
 		for _, h := range children[i:end] {
 			node = append(node, Sha(h)...)
 		}
-		FillMap(node)
+		s.FillMap(node)
 		next = append(next, node)
 	}
 	return next
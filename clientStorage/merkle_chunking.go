@@ -0,0 +1,280 @@
+package clientStorage
+
+import (
+	"encoding/hex"
+	"fmt"
+	"math/bits"
+	"sort"
+	"strings"
+)
+
+//-----------------------------------------------------------------------------------------
+// Ce fichier regroupe le découpage des fichiers en chunks. Le découpage fixe
+// (ChunkSize octets) est celui du protocole d’origine : insérer un octet en
+// tête d’un fichier décale toutes les frontières et change tous les chunks de
+// la nouvelle version. Le découpage selon le contenu (FastCDC) place les
+// frontières d’après un hash glissant des derniers octets lus : après une
+// insertion ou une suppression, seuls les chunks voisins changent et les
+// versions successives d’un fichier partagent la plupart de leurs chunks.
+//
+// Les chunks produits restent des nœuds Chunk d’au plus ChunkSize octets :
+// les deux découpages sont lisibles par tous les peers. Le mode est choisi par
+// répertoire de nos données (cf. ShareChunking).
+
+// ChunkingMode : découpage des fichiers en chunks
+type ChunkingMode uint8
+
+const (
+	ChunkFixed ChunkingMode = iota // chunks de ChunkSize octets (protocole d’origine)
+	ChunkCDC                       // frontières selon le contenu (FastCDC)
+)
+
+var chunkingModeNames = [...]string{
+	ChunkFixed: "fixed",
+	ChunkCDC:   "cdc",
+}
+
+// Tailles des chunks du découpage CDC : CDCMinSize ≤ CDCAvgSize ≤ CDCMaxSize ≤ ChunkSize,
+// CDCAvgSize étant une puissance de 2 (à régler avant la construction de l’arbre)
+var (
+	CDCMinSize = 256
+	CDCAvgSize = 512
+	CDCMaxSize = ChunkSize
+)
+
+// DefaultChunking : découpage des fichiers hors des répertoires de ShareChunking
+var DefaultChunking = ChunkFixed
+
+// ShareChunking : découpage propre à un répertoire de nos données (chemin
+// relatif, séparé par des "/") ; le répertoire le plus profond l’emporte
+var ShareChunking = map[string]ChunkingMode{}
+
+func (m ChunkingMode) String() string {
+	if int(m) < len(chunkingModeNames) {
+		return chunkingModeNames[m]
+	}
+	return "unknown"
+}
+
+// ParseChunkingMode lit un mode : fixed ou cdc.
+func ParseChunkingMode(s string) (ChunkingMode, error) {
+	for m, name := range chunkingModeNames {
+		if name == s {
+			return ChunkingMode(m), nil
+		}
+	}
+	return ChunkFixed, fmt.Errorf("découpage %q inconnu (fixed ou cdc)", s)
+}
+
+// ParseChunking applique une liste de réglages séparés par des virgules :
+// "cdc" (tous les fichiers), "photos=cdc", "fixed,docs=cdc,docs/archives=fixed".
+func ParseChunking(spec string) error {
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		dir, mode, found := strings.Cut(item, "=")
+		if !found {
+			dir, mode = "", item
+		}
+		m, err := ParseChunkingMode(strings.TrimSpace(mode))
+		if err != nil {
+			return err
+		}
+		dir = strings.Trim(strings.TrimSpace(dir), "/")
+		if dir == "" {
+			DefaultChunking = m
+		} else {
+			ShareChunking[dir] = m
+		}
+	}
+	return checkCDCSizes()
+}
+
+// checkCDCSizes vérifie les tailles du découpage CDC.
+func checkCDCSizes() error {
+	if CDCMinSize < 1 || CDCMinSize > CDCAvgSize || CDCAvgSize > CDCMaxSize || CDCMaxSize > ChunkSize ||
+		bits.OnesCount(uint(CDCAvgSize)) != 1 {
+		return fmt.Errorf("tailles CDC %d/%d/%d invalides (min ≤ moyenne ≤ max ≤ %d, moyenne puissance de 2)",
+			CDCMinSize, CDCAvgSize, CDCMaxSize, ChunkSize)
+	}
+	return nil
+}
+
+// UsesCDC indique si une partie de nos données est découpée selon le contenu.
+func UsesCDC() bool {
+	if DefaultChunking == ChunkCDC {
+		return true
+	}
+	for _, m := range ShareChunking {
+		if m == ChunkCDC {
+			return true
+		}
+	}
+	return false
+}
+
+// ChunkingSummary décrit les réglages de découpage ("fixed", "fixed,photos=cdc"…).
+func ChunkingSummary() string {
+	items := []string{DefaultChunking.String()}
+	dirs := make([]string, 0, len(ShareChunking))
+	for dir := range ShareChunking {
+		dirs = append(dirs, dir)
+	}
+	sort.Strings(dirs)
+	for _, dir := range dirs {
+		items = append(items, dir+"="+ShareChunking[dir].String())
+	}
+	return strings.Join(items, ",")
+}
+
+// chunkingFor renvoie le découpage du fichier rel (chemin relatif à la racine partagée).
+func chunkingFor(rel string) ChunkingMode {
+	mode, depth := DefaultChunking, -1
+	for dir, m := range ShareChunking {
+		if (rel == dir || strings.HasPrefix(rel, dir+"/")) && len(dir) > depth {
+			mode, depth = m, len(dir)
+		}
+	}
+	return mode
+}
+
+//
+// ======================= DÉCOUPAGE =======================
+//
+
+// gear : valeurs pseudo-aléatoires (fixes) associées à chaque octet ; la même
+// table doit servir à toutes les versions pour que leurs frontières coïncident
+var gear = func() (t [256]uint64) {
+	x := uint64(0x6d7970327021cdc1)
+	for i := range t {
+		// splitmix64
+		x += 0x9e3779b97f4a7c15
+		z := x
+		z = (z ^ z>>30) * 0xbf58476d1ce4e5b9
+		z = (z ^ z>>27) * 0x94d049bb133111eb
+		t[i] = z ^ z>>31
+	}
+	return
+}()
+
+// cutChunk renvoie la longueur du prochain chunk de data, qui contient les
+// octets suivants du fichier : ChunkSize octets, ou le reste du fichier s’il
+// est plus court.
+func cutChunk(mode ChunkingMode, data []byte) int {
+	if mode == ChunkCDC {
+		return cdcCut(data)
+	}
+	return min(len(data), ChunkSize)
+}
+
+// cdcCut cherche une frontière FastCDC dans data : au plus tôt après
+// CDCMinSize octets, au plus tard après CDCMaxSize. Avant CDCAvgSize le masque
+// compte deux bits de plus (frontière moins probable), après deux bits de
+// moins : la taille des chunks se resserre autour de CDCAvgSize.
+func cdcCut(data []byte) int {
+	n := min(len(data), CDCMaxSize)
+	if n <= CDCMinSize {
+		return n
+	}
+	avgBits := bits.TrailingZeros(uint(CDCAvgSize))
+	maskS := ^uint64(0) << (64 - avgBits - 2)
+	maskL := ^uint64(0) << (64 - max(avgBits-2, 1))
+	normal := min(CDCAvgSize, n)
+
+	var fp uint64
+	i := CDCMinSize
+	for ; i < normal; i++ {
+		fp = fp<<1 + gear[data[i]]
+		if fp&maskS == 0 {
+			return i + 1
+		}
+	}
+	for ; i < n; i++ {
+		fp = fp<<1 + gear[data[i]]
+		if fp&maskL == 0 {
+			return i + 1
+		}
+	}
+	return n
+}
+
+// Découpe des données selon leur contenu (cf. cdcCut)
+// Paramètre : data → données du fichier
+// Retour : liste de chunks d’au plus CDCMaxSize octets
+func SplitContentDefined(data []byte) [][]byte {
+	var chunks [][]byte
+	for len(data) > 0 {
+		cut := cdcCut(data)
+		chunks = append(chunks, data[:cut])
+		data = data[cut:]
+	}
+	return chunks
+}
+
+//
+// ======================= DÉDUPLICATION =======================
+//
+
+// VersionDedup : chunks d’une version d’un arbre
+type VersionDedup struct {
+	Chunks   int   // chunks distincts de la version
+	Bytes    int64 // octets de ces chunks
+	NewBytes int64 // octets des chunks absents des versions précédentes
+	Missing  int   // nœuds absents du Store (arbre incomplet)
+}
+
+// -----------------------------------------------------------------------------------------
+// DedupStats mesure le partage des chunks entre plusieurs versions d’un arbre.
+// Paramètre :
+//   - roots : racines des versions, de la plus ancienne à la plus récente
+//
+// Retour :
+//   - pour chaque version, ses chunks et la part qui n’existait dans aucune
+//     version précédente (ce qu’un peer qui les possède doit télécharger)
+func (s *Store) DedupStats(roots [][]byte) []VersionDedup {
+	seen := map[string]bool{}
+	stats := make([]VersionDedup, len(roots))
+	for i, root := range roots {
+		if root == nil {
+			continue
+		}
+		st := &stats[i]
+		s.mu.RLock()
+		s.dedupWalk(root, map[string]bool{}, seen, st)
+		s.mu.RUnlock()
+	}
+	return stats
+}
+
+// dedupWalk compte les chunks du sous-arbre de hash ; visited évite de
+// parcourir deux fois un nœud de la version, seen mémorise les chunks des
+// versions déjà comptées.
+func (s *Store) dedupWalk(hash []byte, visited, seen map[string]bool, st *VersionDedup) {
+	key := hex.EncodeToString(hash)
+	if visited[key] {
+		return
+	}
+	visited[key] = true
+	node, ok := s.nodes[key]
+	if !ok || len(node) == 0 {
+		st.Missing++
+		return
+	}
+	if node[0] == Chunk {
+		size := int64(len(node) - IdSize)
+		st.Chunks++
+		st.Bytes += size
+		if !seen[key] {
+			seen[key] = true
+			st.NewBytes += size
+		}
+		return
+	}
+	for _, child := range ListChildrenHashes(node) {
+		if h, err := hex.DecodeString(child); err == nil {
+			s.dedupWalk(h, visited, seen, st)
+		}
+	}
+}
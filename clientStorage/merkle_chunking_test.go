@@ -0,0 +1,168 @@
+package clientStorage
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+//
+// ======================= DÉDUPLICATION ENTRE VERSIONS =======================
+//
+
+// Construction des trois versions d’un répertoire (cf. README, « Déduplication
+// entre versions ») avec chaque découpage :
+//
+//	go test ./clientStorage -run '^$' -bench '^BenchmarkDedup'
+//
+// dedup-ratio : octets des versions 2 et 3 / octets à télécharger pour un peer
+// qui possède les versions précédentes ; new-bytes-% : part de ces octets.
+
+// BenchmarkDedupFixed : chunks de ChunkSize octets
+func BenchmarkDedupFixed(b *testing.B) { benchmarkDedup(b, ChunkFixed) }
+
+// BenchmarkDedupCDC : frontières selon le contenu
+func BenchmarkDedupCDC(b *testing.B) { benchmarkDedup(b, ChunkCDC) }
+
+// benchmarkDedup construit à chaque itération l’arbre des trois versions avec
+// le découpage mode, puis rapporte le partage de leurs chunks.
+func benchmarkDedup(b *testing.B, mode ChunkingMode) {
+	versions := writeVersions(b)
+	oldMode, oldMeta := DefaultChunking, FileMetadata
+	DefaultChunking, FileMetadata = mode, false
+	defer func() { DefaultChunking, FileMetadata = oldMode, oldMeta }()
+
+	var size int64
+	for _, dir := range versions {
+		size += dirSize(b, dir)
+	}
+	b.SetBytes(size)
+	b.ResetTimer()
+
+	var stats []VersionDedup
+	for i := 0; i < b.N; i++ {
+		store := NewStore()
+		roots := make([][]byte, len(versions))
+		for v, dir := range versions {
+			root, err := store.BuildMerkleNode(dir)
+			if err != nil {
+				b.Fatal(err)
+			}
+			roots[v] = Sha(root)
+		}
+		stats = store.DedupStats(roots)
+	}
+	b.StopTimer()
+
+	var total, fetched int64
+	for _, st := range stats[1:] {
+		if st.Missing != 0 {
+			b.Fatalf("%d nœuds absents du Store", st.Missing)
+		}
+		total += st.Bytes
+		fetched += st.NewBytes
+	}
+	if fetched == 0 {
+		b.Fatal("aucune modification entre les versions")
+	}
+	b.ReportMetric(float64(total)/float64(fetched), "dedup-ratio")
+	b.ReportMetric(100*float64(fetched)/float64(total), "new-bytes-%")
+}
+
+// writeVersions écrit trois versions d’un répertoire (deux textes, un binaire,
+// un source) :
+//   - v2 : un octet ajouté en tête d’un texte, 50 octets insérés dans le
+//     binaire, une ligne ajoutée au source
+//   - v3 : un titre en tête de l’autre texte, 2 Ko supprimés du binaire et
+//     500 octets du premier texte
+func writeVersions(b *testing.B) []string {
+	b.Helper()
+	rng := rand.New(rand.NewPCG(1, 2))
+	files := map[string][]byte{
+		"livre.txt":   text(rng, 200_000),
+		"notes.txt":   text(rng, 120_000),
+		"image.bin":   random(rng, 300_000),
+		"main.go.txt": text(rng, 80_000),
+	}
+
+	root := b.TempDir()
+	var versions []string
+	write := func() {
+		dir := filepath.Join(root, fmt.Sprintf("v%d", len(versions)+1))
+		if err := os.Mkdir(dir, 0755); err != nil {
+			b.Fatal(err)
+		}
+		for name, data := range files {
+			if err := os.WriteFile(filepath.Join(dir, name), data, 0644); err != nil {
+				b.Fatal(err)
+			}
+		}
+		versions = append(versions, dir)
+	}
+
+	write()
+	files["livre.txt"] = insert(files["livre.txt"], 0, []byte("#"))
+	files["image.bin"] = insert(files["image.bin"], 150_000, random(rng, 50))
+	files["main.go.txt"] = insert(files["main.go.txt"], 40_000, []byte("\tlog.Println(\"version 2\")\n"))
+	write()
+	files["notes.txt"] = insert(files["notes.txt"], 0, []byte("Notes de version\n================\n\n"))
+	files["image.bin"] = remove(files["image.bin"], 60_000, 2048)
+	files["livre.txt"] = remove(files["livre.txt"], 100_000, 500)
+	write()
+	return versions
+}
+
+// text renvoie size octets de mots pris au hasard, en lignes.
+func text(rng *rand.Rand, size int) []byte {
+	words := []string{"le", "pair", "arbre", "chunk", "nœud", "version", "fichier", "réseau", "hash", "merkle", "donnée", "paquet"}
+	var buf bytes.Buffer
+	for buf.Len() < size {
+		buf.WriteString(words[rng.IntN(len(words))])
+		if rng.IntN(12) == 0 {
+			buf.WriteByte('\n')
+		} else {
+			buf.WriteByte(' ')
+		}
+	}
+	return buf.Bytes()[:size]
+}
+
+// random renvoie size octets aléatoires.
+func random(rng *rand.Rand, size int) []byte {
+	data := make([]byte, size)
+	for i := range data {
+		data[i] = byte(rng.Uint32())
+	}
+	return data
+}
+
+// insert renvoie data avec extra inséré à la position at.
+func insert(data []byte, at int, extra []byte) []byte {
+	return append(append(append([]byte(nil), data[:at]...), extra...), data[at:]...)
+}
+
+// remove renvoie data sans les n octets à partir de at.
+func remove(data []byte, at, n int) []byte {
+	return append(append([]byte(nil), data[:at]...), data[at+n:]...)
+}
+
+// dirSize renvoie la taille totale des fichiers de dir.
+func dirSize(b *testing.B, dir string) int64 {
+	b.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		b.Fatal(err)
+	}
+	var size int64
+	for _, e := range entries {
+		info, err := e.Info()
+		if err != nil {
+			b.Fatal(err)
+		}
+		size += info.Size()
+	}
+	return size
+}
@@ -0,0 +1,121 @@
+package clientStorage
+
+import (
+	"bytes"
+	"fmt"
+)
+
+//-----------------------------------------------------------------------------------------
+// Ce fichier construit, à partir de notre arbre complet, la représentation
+// lisible par un peer qui ne décode qu’une partie des types de nœuds : les
+// nœuds Metadata et les entrées Symlink sont retirés, les LongDirectory sont
+// remplacés par des Directory aux noms raccourcis (cf. legacyNames). Comme pour les arbres
+// filtrés, les sous-arbres inchangés sont partagés avec l’arbre complet.
+
+// TreeFormat : types de nœuds optionnels qu’un peer sait décoder
+type TreeFormat uint8
+
+const (
+	FormatLongNames TreeFormat = 1 << iota // nœuds LongDirectory
+	FormatMetadata                         // nœuds Metadata
+	FormatSymlinks                         // nœuds Symlink
+
+	FormatLegacy TreeFormat = 0                                                 // protocole d’origine
+	FormatFull              = FormatLongNames | FormatMetadata | FormatSymlinks // tous les types de nœuds
+)
+
+// -----------------------------------------------------------------------------------------
+// DowngradeTree construit la représentation d’un arbre au format format.
+// Paramètres :
+//   - rootHash : racine de l’arbre complet
+//   - format   : types de nœuds optionnels conservés
+//
+// Retour :
+//   - le hash de la racine convertie (rootHash si rien n’a changé)
+//   - les clés (hex) des nœuds créés pour l’occasion, à libérer avec ReleaseNodes
+//   - erreur éventuelle (nœud manquant)
+func (s *Store) DowngradeTree(rootHash []byte, format TreeFormat) ([]byte, []string, error) {
+	if format&FormatFull == FormatFull {
+		return rootHash, nil, nil
+	}
+	var created []string
+	h, err := s.downgradeNode(rootHash, format, map[string][]byte{}, &created)
+	if err != nil {
+		s.ReleaseNodes(created)
+		return nil, nil, err
+	}
+	return h, created, nil
+}
+
+// downgradeNode renvoie le hash converti du sous-arbre de hash ; done
+// mémorise les sous-arbres déjà convertis.
+func (s *Store) downgradeNode(hash []byte, format TreeFormat, done map[string][]byte, created *[]string) ([]byte, error) {
+	key := string(hash)
+	if h, ok := done[key]; ok {
+		return h, nil
+	}
+
+	if inner, meta, ok := s.unwrapMetadata(hash); ok {
+		h, err := s.downgradeNode(inner, format, done, created)
+		if err != nil {
+			return nil, err
+		}
+		if format&FormatMetadata != 0 {
+			h = s.rewrapMetadata(hash, inner, h, meta, created)
+		}
+		done[key] = h
+		return h, nil
+	}
+
+	entries, ok := s.ListDirectory(hash)
+	if !ok {
+		if _, exists := s.FindHash(hash); !exists {
+			return nil, fmt.Errorf("node not found: %x", hash)
+		}
+		// fichier : identique pour tous les peers
+		return hash, nil
+	}
+
+	changed := false
+	if format&FormatSymlinks == 0 {
+		visible := entries[:0:0]
+		for _, e := range entries {
+			if s.isSymlink(e.Hash) {
+				changed = true
+				continue
+			}
+			visible = append(visible, e)
+		}
+		entries = visible
+	}
+	names := make([]string, len(entries))
+	for i, e := range entries {
+		names[i] = e.Name
+	}
+	if format&FormatLongNames == 0 {
+		names = legacyNames(names)
+	}
+	kept := make([]DirectoryEntry, len(entries))
+	for i, e := range entries {
+		h, err := s.downgradeNode(e.Hash, format, done, created)
+		if err != nil {
+			return nil, err
+		}
+		kept[i] = DirectoryEntry{Name: names[i], Hash: h}
+		if names[i] != e.Name || !bytes.Equal(h, e.Hash) {
+			changed = true
+		}
+	}
+	h := hash
+	if changed {
+		h = s.buildFilteredDirectory(kept, created)
+	}
+	done[key] = h
+	return h, nil
+}
+
+// isSymlink indique si hash désigne un nœud Symlink.
+func (s *Store) isSymlink(hash []byte) bool {
+	node, ok := s.FindHash(hash)
+	return ok && Typedata(node) == Symlink
+}
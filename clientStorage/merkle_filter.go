@@ -0,0 +1,271 @@
+package clientStorage
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"path"
+	"strings"
+)
+
+//-----------------------------------------------------------------------------------------
+// Ce fichier contient la construction d’arbres de Merkle filtrés : à partir de notre
+// arbre complet et d’une liste de chemins autorisés, on produit une nouvelle racine
+// qui ne référence que les sous-arbres visibles. Les nœuds non modifiés sont partagés
+// avec l’arbre complet, seuls les répertoires "élagués" sont recréés.
+
+// -----------------------------------------------------------------------------------------
+// ListDirectory aplatit un nœud Directory, LongDirectory ou BigDirectory en
+// liste d’entrées.
+// Contrairement à HashDirectory, le champ Hash des entrées retournées contient
+// directement le hash de l’enfant (et non le nœud).
+// Paramètre :
+//   - hash : hash du répertoire
+//
+// Retour :
+//   - les entrées du répertoire
+//   - false si le nœud est absent ou n’est pas un répertoire
+func (s *Store) ListDirectory(hash []byte) ([]DirectoryEntry, bool) {
+	node, ok := s.FindHash(hash)
+	if !ok || len(node) == 0 {
+		return nil, false
+	}
+
+	switch node[0] {
+	case Directory:
+		var entries []DirectoryEntry
+		count := (len(node) - IdSize) / DirEntrySize
+		for i := 0; i < count; i++ {
+			off := IdSize + i*DirEntrySize
+			entries = append(entries, DirectoryEntry{
+				Name: string(bytes.TrimRight(node[off:off+NameSize], "\x00")),
+				Hash: node[off+NameSize : off+DirEntrySize],
+			})
+		}
+		return entries, true
+
+	case LongDirectory:
+		n, err := ParseNode(node)
+		if err != nil {
+			return nil, false
+		}
+		return n.Entries, true
+
+	case BigDirectory:
+		var entries []DirectoryEntry
+		count := (len(node) - IdSize) / HashSize
+		for i := 0; i < count; i++ {
+			sub, ok := s.ListDirectory(node[IdSize+i*HashSize : IdSize+(i+1)*HashSize])
+			if !ok {
+				return nil, false
+			}
+			entries = append(entries, sub...)
+		}
+		return entries, true
+	}
+	return nil, false
+}
+
+// -----------------------------------------------------------------------------------------
+// grantTree représente un ensemble de chemins autorisés sous forme d’arbre :
+// full indique que tout le sous-arbre est visible.
+type grantTree struct {
+	full     bool
+	children map[string]*grantTree
+}
+
+// newGrantTree construit l’arbre des chemins autorisés.
+// "", "/" et "." désignent la racine entière.
+func newGrantTree(paths []string) *grantTree {
+	root := &grantTree{children: map[string]*grantTree{}}
+	for _, p := range paths {
+		p = strings.Trim(path.Clean("/"+p), "/")
+		if p == "" {
+			root.full = true
+			continue
+		}
+		cur := root
+		for _, part := range strings.Split(p, "/") {
+			next, ok := cur.children[part]
+			if !ok {
+				next = &grantTree{children: map[string]*grantTree{}}
+				cur.children[part] = next
+			}
+			cur = next
+		}
+		cur.full = true
+	}
+	return root
+}
+
+// -----------------------------------------------------------------------------------------
+// FilterTree construit un arbre filtré ne contenant que les chemins autorisés.
+// Paramètres :
+//   - rootHash : racine de l’arbre complet
+//   - paths    : chemins visibles, relatifs à la racine (séparateur "/")
+//
+// Retour :
+//   - le hash de la racine filtrée (répertoire vide si rien n’est visible)
+//   - les clés (hex) des nœuds créés pour l’occasion, à libérer avec ReleaseNodes
+//   - erreur éventuelle (nœud manquant)
+func (s *Store) FilterTree(rootHash []byte, paths []string) ([]byte, []string, error) {
+	var created []string
+	h, err := s.filterNode(rootHash, newGrantTree(paths), &created)
+	if err != nil {
+		s.ReleaseNodes(created)
+		return nil, nil, err
+	}
+	if h == nil {
+		// rien de visible : racine = répertoire vide
+		empty := []byte{Directory}
+		s.fillCreated(empty, &created)
+		h = Sha(empty)
+	}
+	return h, created, nil
+}
+
+// filterNode renvoie le hash du sous-arbre filtré, ou nil s’il ne reste rien.
+func (s *Store) filterNode(hash []byte, g *grantTree, created *[]string) ([]byte, error) {
+	if g.full {
+		return hash, nil
+	}
+	if len(g.children) == 0 {
+		return nil, nil
+	}
+	if inner, meta, ok := s.unwrapMetadata(hash); ok {
+		// entrée avec métadonnées : on filtre le contenu et on les conserve
+		h, err := s.filterNode(inner, g, created)
+		if err != nil || h == nil {
+			return nil, err
+		}
+		return s.rewrapMetadata(hash, inner, h, meta, created), nil
+	}
+	entries, ok := s.ListDirectory(hash)
+	if !ok {
+		// un fichier ne peut pas être partiellement visible
+		if _, exists := s.FindHash(hash); !exists {
+			return nil, fmt.Errorf("node not found: %x", hash)
+		}
+		return nil, nil
+	}
+
+	var kept []DirectoryEntry
+	for _, e := range entries {
+		sub, ok := g.children[e.Name]
+		if !ok {
+			continue
+		}
+		h, err := s.filterNode(e.Hash, sub, created)
+		if err != nil {
+			return nil, err
+		}
+		if h != nil {
+			kept = append(kept, DirectoryEntry{Name: e.Name, Hash: h})
+		}
+	}
+	if len(kept) == 0 {
+		return nil, nil
+	}
+	return s.buildFilteredDirectory(kept, created), nil
+}
+
+// buildFilteredDirectory reproduit buildDirectoryNode à partir d’entrées
+// dont le champ Hash contient déjà le hash de l’enfant.
+func (s *Store) buildFilteredDirectory(entries []DirectoryEntry, created *[]string) []byte {
+	dirNode := func(part []DirectoryEntry) []byte {
+		return MerkleNode{Type: directoryType(part), Entries: part}.Marshal()
+	}
+
+	if len(entries) <= MaxDirEntries {
+		node := dirNode(entries)
+		s.fillCreated(node, created)
+		return Sha(node)
+	}
+
+	var subNodes [][]byte
+	for i := 0; i < len(entries); i += MaxDirEntries {
+		end := i + MaxDirEntries
+		if end > len(entries) {
+			end = len(entries)
+		}
+		sub := dirNode(entries[i:end])
+		s.fillCreated(sub, created)
+		subNodes = append(subNodes, sub)
+	}
+	for len(subNodes) > 1 {
+		var next [][]byte
+		for i := 0; i < len(subNodes); i += MaxBigEntries {
+			end := i + MaxBigEntries
+			if end > len(subNodes) {
+				end = len(subNodes)
+			}
+			node := []byte{BigDirectory}
+			for _, n := range subNodes[i:end] {
+				node = append(node, Sha(n)...)
+			}
+			s.fillCreated(node, created)
+			next = append(next, node)
+		}
+		subNodes = next
+	}
+	return Sha(subNodes[0])
+}
+
+// fillCreated enregistre un nœud et mémorise sa clé pour une libération ultérieure.
+func (s *Store) fillCreated(node []byte, created *[]string) {
+	s.FillMap(node)
+	*created = append(*created, hex.EncodeToString(Sha(node)))
+}
+
+// -----------------------------------------------------------------------------------------
+// ReleaseNodes décrémente le compteur de références des nœuds donnés
+// (sans récursion sur leurs enfants) et les supprime lorsqu’il atteint zéro.
+// Paramètre :
+//   - keys : clés hexadécimales retournées par FilterTree
+func (s *Store) ReleaseNodes(keys []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, key := range keys {
+		if _, ok := s.nodes[key]; !ok {
+			continue
+		}
+		s.counts[key]--
+		if s.counts[key] == 0 {
+			delete(s.nodes, key)
+			delete(s.counts, key)
+		}
+	}
+}
+
+// -----------------------------------------------------------------------------------------
+// ReachableHashes renvoie l’ensemble des hashes (hex) atteignables depuis une racine.
+// Paramètre :
+//   - rootHash : racine de l’arbre
+//
+// Retour :
+//   - ensemble des clés hexadécimales des nœuds présents dans l’arbre
+func (s *Store) ReachableHashes(rootHash []byte) map[string]bool {
+	set := make(map[string]bool)
+	s.mu.RLock()
+	s.collectReachable(rootHash, set)
+	s.mu.RUnlock()
+	return set
+}
+
+func (s *Store) collectReachable(hash []byte, set map[string]bool) {
+	key := hex.EncodeToString(hash)
+	if set[key] {
+		return
+	}
+	node, ok := s.nodes[key]
+	if !ok || len(node) == 0 {
+		return
+	}
+	set[key] = true
+	for _, child := range ListChildrenHashes(node) {
+		h, err := hex.DecodeString(child)
+		if err == nil {
+			s.collectReachable(h, set)
+		}
+	}
+}
@@ -0,0 +1,177 @@
+package clientStorage
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+//-----------------------------------------------------------------------------------------
+// Ce fichier regroupe le traitement des liens symboliques et des fichiers
+// spéciaux lors de la construction de l’arbre. Selon SymlinkPolicy, un lien
+// est ignoré, suivi (si sa cible reste dans le répertoire partagé), ou stocké
+// tel quel dans un nœud Symlink :
+//
+//	type (1) | cible du lien (1 à ChunkSize octets)
+//
+// Les sockets, périphériques et tubes nommés ne sont jamais partagés. Les
+// peers qui n’ont pas négocié l’extension correspondante reçoivent l’arbre
+// sans les entrées Symlink (cf. DowngradeTree).
+
+// SymlinkMode : traitement des liens symboliques par le builder
+type SymlinkMode uint8
+
+const (
+	SymlinkSkip   SymlinkMode = iota // les liens sont ignorés
+	SymlinkFollow                    // suivis si leur cible reste dans la racine partagée
+	SymlinkStore                     // stockés comme nœuds Symlink (cible relative, dans la racine)
+)
+
+var symlinkModeNames = [...]string{
+	SymlinkSkip:   "skip",
+	SymlinkFollow: "follow",
+	SymlinkStore:  "store",
+}
+
+// SymlinkPolicy : traitement des liens symboliques de nos données
+var SymlinkPolicy = SymlinkFollow
+
+// RebuildSymlinks : recréer les nœuds Symlink lors d’une reconstruction
+// (sinon ils sont ignorés)
+var RebuildSymlinks = true
+
+func (m SymlinkMode) String() string {
+	if int(m) < len(symlinkModeNames) {
+		return symlinkModeNames[m]
+	}
+	return "unknown"
+}
+
+// ParseSymlinkMode lit une politique : skip, follow ou store.
+func ParseSymlinkMode(s string) (SymlinkMode, error) {
+	for m, name := range symlinkModeNames {
+		if name == s {
+			return SymlinkMode(m), nil
+		}
+	}
+	return SymlinkSkip, fmt.Errorf("politique de liens %q inconnue (skip, follow ou store)", s)
+}
+
+//
+// ======================= CONSTRUCTION =======================
+//
+
+// Construit un nœud Symlink
+// Paramètre : target → cible du lien
+// Retour : nœud Symlink
+func HashSymlink(target string) []byte {
+	return append([]byte{Symlink}, target...)
+}
+
+// within indique si path (absolu ou relatif à la racine) reste dans root.
+func within(root, path string) bool {
+	rel, err := filepath.Rel(root, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// safeTarget indique si une cible de lien peut être vérifiée lexicalement :
+// relative et sous forme canonique, les ".." ne peuvent donc figurer qu’en
+// tête ("a/../../b" pourrait sortir de la racine en passant par un autre lien).
+func safeTarget(target string) bool {
+	return target != "" && !filepath.IsAbs(target) && filepath.Clean(target) == target
+}
+
+// -----------------------------------------------------------------------------------------
+// resolveEntry décide du sort d’une entrée de répertoire selon son type et
+// SymlinkPolicy.
+// Paramètres :
+//   - path      : chemin de l’entrée
+//   - lfi       : résultat de os.Lstat sur path
+//   - ancestors : répertoires en cours de parcours, du plus haut au parent de path
+//
+// Retour :
+//   - fi     : informations sur le fichier ou répertoire à construire (cible d’un lien suivi)
+//   - link   : nœud Symlink à stocker à la place
+//   - les deux sont nil si l’entrée est ignorée
+func (b *MerkleBuilder) resolveEntry(path string, lfi os.FileInfo, ancestors []os.FileInfo) (fi os.FileInfo, link []byte) {
+	mode := lfi.Mode()
+	switch {
+	case mode.IsRegular() || mode.IsDir():
+		return lfi, nil
+	case mode&os.ModeSymlink == 0:
+		merkleLog.Warn("fichier spécial ignoré", "path", path, "mode", mode.String())
+		return nil, nil
+	}
+
+	switch SymlinkPolicy {
+	case SymlinkFollow:
+		target, err := filepath.EvalSymlinks(path)
+		if err != nil {
+			merkleLog.Warn("lien symbolique cassé ignoré", "path", path, "err", err)
+			return nil, nil
+		}
+		if !within(b.realRoot, target) {
+			merkleLog.Warn("lien symbolique hors de la racine partagée ignoré", "path", path, "target", target)
+			return nil, nil
+		}
+		fi, err := os.Stat(path)
+		if err != nil {
+			merkleLog.Warn("lien symbolique illisible ignoré", "path", path, "err", err)
+			return nil, nil
+		}
+		if fi.IsDir() {
+			for _, a := range ancestors {
+				if os.SameFile(a, fi) {
+					merkleLog.Warn("boucle de liens symboliques ignorée", "path", path, "target", target)
+					return nil, nil
+				}
+			}
+		} else if !fi.Mode().IsRegular() {
+			merkleLog.Warn("fichier spécial ignoré", "path", path, "mode", fi.Mode().String())
+			return nil, nil
+		}
+		return fi, nil
+
+	case SymlinkStore:
+		target, err := os.Readlink(path)
+		if err != nil {
+			merkleLog.Warn("lien symbolique illisible ignoré", "path", path, "err", err)
+			return nil, nil
+		}
+		rel, err := filepath.Rel(b.base, filepath.Dir(path))
+		if err != nil || !safeTarget(target) || len(target) > ChunkSize || !within(".", filepath.Join(rel, target)) {
+			merkleLog.Warn("lien symbolique hors de la racine partagée ignoré", "path", path, "target", target)
+			return nil, nil
+		}
+		node := HashSymlink(filepath.ToSlash(target))
+		b.store.FillMap(node)
+		return nil, node
+	}
+
+	merkleLog.Debug("lien symbolique ignoré", "path", path)
+	return nil, nil
+}
+
+//
+// ======================= RECONSTRUCTION =======================
+//
+
+// rebuildSymlink recrée un nœud Symlink en path, si RebuildSymlinks est actif
+// et si la cible reste sous base.
+func rebuildSymlink(node []byte, path, base string) error {
+	n, err := ParseNode(node)
+	if err != nil {
+		return err
+	}
+	target := filepath.FromSlash(string(n.Data))
+	if !RebuildSymlinks {
+		merkleLog.Debug("lien symbolique non recréé", "path", path, "target", target)
+		return nil
+	}
+	if !safeTarget(target) || !within(base, filepath.Join(filepath.Dir(path), target)) {
+		merkleLog.Warn("lien symbolique hors de la reconstruction refusé", "path", path, "target", target)
+		return nil
+	}
+	return os.Symlink(target, path)
+}
@@ -0,0 +1,116 @@
+package clientStorage
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"time"
+)
+
+//-----------------------------------------------------------------------------------------
+// Ce fichier regroupe les métadonnées des fichiers partagés. Une entrée de
+// répertoire peut pointer vers un nœud Metadata au lieu de son contenu :
+//
+//	type (1) | mode (4) | date de modification en ns (8) | taille (8) | hash du contenu (32)
+//
+// Les métadonnées font donc partie de l’arbre (elles changent sa racine) et
+// RebuildNode les applique au fichier ou au répertoire reconstruit. Seuls les
+// bits de permission sont transmis : jamais de setuid, setgid ni sticky bit.
+// Les peers qui n’ont pas négocié l’extension correspondante reçoivent l’arbre
+// sans nœuds Metadata (cf. DowngradeTree).
+
+// FileMetadata : produire un nœud Metadata pour chaque entrée de nos répertoires
+var FileMetadata = true
+
+// MetaModeMask : bits de mode transmis dans un nœud Metadata
+const MetaModeMask = uint32(os.ModePerm)
+
+// FileMeta : métadonnées d’un fichier ou d’un répertoire
+type FileMeta struct {
+	Mode    uint32 // permissions (MetaModeMask)
+	ModTime int64  // date de modification, en nanosecondes depuis l’époque Unix
+	Size    uint64 // taille du fichier (0 pour un répertoire)
+}
+
+// metaOf lit les métadonnées d’un fichier ou d’un répertoire local.
+func metaOf(fi os.FileInfo) FileMeta {
+	m := FileMeta{
+		Mode:    uint32(fi.Mode().Perm()),
+		ModTime: fi.ModTime().UnixNano(),
+	}
+	if !fi.IsDir() {
+		m.Size = uint64(fi.Size())
+	}
+	return m
+}
+
+func (m FileMeta) String() string {
+	return fmt.Sprintf("%04o %s %d octets", m.Mode, time.Unix(0, m.ModTime).Format(time.DateTime), m.Size)
+}
+
+//
+// ======================= CONSTRUCTION =======================
+//
+
+// Construit un nœud Metadata
+// Paramètres : meta → métadonnées, child → nœud du contenu
+// Retour : nœud Metadata
+func HashMetadata(meta FileMeta, child []byte) []byte {
+	return MerkleNode{Type: Metadata, Meta: meta, Children: [][]byte{Sha(child)}}.Marshal()
+}
+
+// wrapMetadata enregistre le nœud Metadata de child et le renvoie.
+func (s *Store) wrapMetadata(fi os.FileInfo, child []byte) []byte {
+	node := HashMetadata(metaOf(fi), child)
+	s.FillMap(node)
+	return node
+}
+
+// unwrapMetadata renvoie le hash du contenu et les métadonnées si hash
+// désigne un nœud Metadata.
+func (s *Store) unwrapMetadata(hash []byte) ([]byte, FileMeta, bool) {
+	node, ok := s.FindHash(hash)
+	if !ok || Typedata(node) != Metadata {
+		return nil, FileMeta{}, false
+	}
+	n, err := ParseNode(node)
+	if err != nil {
+		return nil, FileMeta{}, false
+	}
+	return n.Children[0], n.Meta, true
+}
+
+// rewrapMetadata recrée le nœud Metadata de hash autour d’un nouveau contenu.
+// Retour : hash du nouveau nœud (hash lui-même si le contenu n’a pas changé)
+func (s *Store) rewrapMetadata(hash, inner, newInner []byte, meta FileMeta, created *[]string) []byte {
+	if bytes.Equal(inner, newInner) {
+		return hash
+	}
+	node := MerkleNode{Type: Metadata, Meta: meta, Children: [][]byte{newInner}}.Marshal()
+	s.fillCreated(node, created)
+	return Sha(node)
+}
+
+//
+// ======================= RECONSTRUCTION =======================
+//
+
+// applyMetadata applique des métadonnées au chemin reconstruit et vérifie
+// la taille d’un fichier.
+// Retour :
+//   - erreur si la taille ne correspond pas, ou si le mode ou la date ne
+//     peuvent pas être appliqués
+func applyMetadata(path string, meta FileMeta) error {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if !fi.IsDir() && uint64(fi.Size()) != meta.Size {
+		return fmt.Errorf("%s : %d octets reconstruits, %d annoncés", path, fi.Size(), meta.Size)
+	}
+	if err := os.Chmod(path, os.FileMode(meta.Mode&MetaModeMask)); err != nil {
+		return err
+	}
+	mtime := time.Unix(0, meta.ModTime)
+	return os.Chtimes(path, mtime, mtime)
+}
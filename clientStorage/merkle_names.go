@@ -0,0 +1,154 @@
+package clientStorage
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"unicode/utf8"
+)
+
+//-----------------------------------------------------------------------------------------
+// Ce fichier regroupe la gestion des noms d’entrées de répertoire. Un nœud
+// Directory réserve NameSize (32) octets par nom : un nom plus long est
+// stocké dans un nœud LongDirectory, où chaque entrée porte sa longueur.
+//
+// Les peers qui n’ont pas négocié l’extension correspondante ne savent pas
+// décoder un LongDirectory : ils reçoivent un arbre "legacy" (cf. DowngradeTree)
+// où les noms trop longs sont raccourcis, sans couper de caractère UTF-8 et
+// sans créer de doublon, par exemple "un nom très long….txt" → "un nom t~1.txt".
+
+// StrictNames : refuser de construire un répertoire dont un nom serait
+// raccourci pour les anciens peers (sinon un avertissement est journalisé)
+var StrictNames = false
+
+// ErrEntryName : nom d’entrée impossible à partager (à tester avec errors.Is)
+var ErrEntryName = errors.New("nom d’entrée invalide")
+
+//
+// ======================= CONSTRUCTION =======================
+//
+
+// Construit un nœud LongDirectory à partir d’entrées
+// Paramètre : entries → liste des fichiers/répertoires (Hash = nœud enfant)
+// Retour : nœud LongDirectory
+func HashLongDirectory(entries []DirectoryEntry) []byte {
+	node := []byte{LongDirectory}
+	for _, e := range entries {
+		node = append(node, byte(len(e.Name)))
+		node = append(node, e.Name...)
+		node = append(node, Sha(e.Hash)...)
+	}
+	return node
+}
+
+// directoryType renvoie le type de nœud capable de porter ces noms : Directory
+// s’ils tiennent tous dans NameSize octets, LongDirectory sinon.
+func directoryType(entries []DirectoryEntry) byte {
+	for _, e := range entries {
+		if len(e.Name) > NameSize {
+			return LongDirectory
+		}
+	}
+	return Directory
+}
+
+// hashDirectoryPart construit le nœud d’une partie (au plus MaxDirEntries
+// entrées) d’un répertoire, dans le format le plus ancien possible.
+func hashDirectoryPart(entries []DirectoryEntry) []byte {
+	if directoryType(entries) == Directory {
+		return HashDirectory(entries)
+	}
+	return HashLongDirectory(entries)
+}
+
+// -----------------------------------------------------------------------------------------
+// checkEntryNames vérifie les noms d’un répertoire avant sa construction.
+// Un nom de plus de LongNameSize octets est toujours refusé ; un nom qui
+// serait raccourci pour les anciens peers, ou dont les NameSize premiers
+// octets sont ceux d’un autre nom, est signalé (refusé si StrictNames).
+// Paramètres :
+//   - dir     : chemin du répertoire (pour les messages)
+//   - entries : entrées du répertoire
+//
+// Retour :
+//   - erreur ErrEntryName si un nom ne peut pas être partagé
+func checkEntryNames(dir string, entries []os.DirEntry) error {
+	prefixes := make(map[string]string, len(entries))
+	for _, e := range entries {
+		name := e.Name()
+		if len(name) > LongNameSize {
+			return fmt.Errorf("%w : %q dépasse %d octets", ErrEntryName, path.Join(dir, name), LongNameSize)
+		}
+		if len(name) <= NameSize {
+			continue
+		}
+		if StrictNames {
+			return fmt.Errorf("%w : %q dépasse %d octets (StrictNames)", ErrEntryName, path.Join(dir, name), NameSize)
+		}
+		merkleLog.Warn("nom raccourci pour les anciens peers", "path", path.Join(dir, name), "octets", len(name))
+	}
+	for _, e := range entries {
+		name := e.Name()
+		prefix := string(padTo32([]byte(name)))
+		if other, ok := prefixes[prefix]; ok {
+			merkleLog.Warn("noms identiques une fois tronqués à 32 octets, renommés pour les anciens peers",
+				"dir", dir, "a", other, "b", name)
+		}
+		prefixes[prefix] = name
+	}
+	return nil
+}
+
+//
+// ======================= NOMS LEGACY =======================
+//
+
+// legacyNames renvoie les noms d’un répertoire tels que les voit un ancien
+// peer : les noms d’au plus NameSize octets sont gardés, les autres sont
+// raccourcis avec un suffixe ~N qui les rend uniques dans le répertoire.
+func legacyNames(names []string) []string {
+	out := make([]string, len(names))
+	used := make(map[string]bool, len(names))
+	for i, name := range names {
+		if len(name) <= NameSize {
+			out[i] = name
+			used[name] = true
+		}
+	}
+	for i, name := range names {
+		if len(name) <= NameSize {
+			continue
+		}
+		for n := 1; ; n++ {
+			short := shortenName(name, n)
+			if !used[short] {
+				out[i] = short
+				used[short] = true
+				break
+			}
+		}
+	}
+	return out
+}
+
+// shortenName raccourcit name à NameSize octets au plus, en gardant son
+// extension (si elle est courte) et en terminant la base par ~n. La coupe
+// tombe toujours entre deux caractères UTF-8.
+func shortenName(name string, n int) string {
+	ext := path.Ext(name)
+	if len(ext) > 8 || ext == name {
+		ext = ""
+	}
+	suffix := fmt.Sprintf("~%d", n)
+	base := strings.TrimSuffix(name, ext)
+	cut := NameSize - len(suffix) - len(ext)
+	if cut < len(base) {
+		for cut > 0 && !utf8.RuneStart(base[cut]) {
+			cut--
+		}
+		base = base[:cut]
+	}
+	return base + suffix + ext
+}
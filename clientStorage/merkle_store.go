@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"encoding/hex"
 	"fmt"
+	"myp2p/logging"
 	"strings"
 )
 
@@ -12,14 +13,8 @@ import (
 // à la gestion, à la vérification d’intégrité et à la suppression des nœuds
 // du Merkle Tree une fois celui-ci construit.
 
-//
-// ======================= VARIABLES GLOBALES =======================
-//
-
-// Compteur de références pour chaque nœud (permet la suppression sécurisée)
-var CountMap = map[string]uint{}
-
-var debugMerkle = false
+// Logger du sous-système merkle (construction, stockage et reconstruction)
+var merkleLog = logging.For("merkle")
 
 // -----------------------------------------------------------------------------------------
 // Recherche un nœud Merkle à partir de son hash.
@@ -29,10 +24,10 @@ var debugMerkle = false
 // Retour :
 //   - le nœud correspondant
 //   - un booléen indiquant si le nœud existe
-func FindHash(hash []byte) ([]byte, bool) {
-	mu.Lock()
-	node, exiting := MerkleMap[hex.EncodeToString(hash)]
-	mu.Unlock()
+func (s *Store) FindHash(hash []byte) ([]byte, bool) {
+	s.mu.RLock()
+	node, exiting := s.nodes[hex.EncodeToString(hash)]
+	s.mu.RUnlock()
 	return node, exiting
 }
 
@@ -44,11 +39,11 @@ func FindHash(hash []byte) ([]byte, bool) {
 // Retour :
 //   - le hash du nœud correspondant
 //   - un booléen indiquant si le nom a été trouvé
-func FindName(name []byte) ([]byte, bool) {
-	if debugMerkle {
-		fmt.Println("FindName")
-	}
-	for _, node := range MerkleMap {
+func (s *Store) FindName(name []byte) ([]byte, bool) {
+	merkleLog.Debug("FindName")
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, node := range s.nodes {
 		if Typedata(node) == Directory {
 			offset := 1
 			for offset+DirEntrySize <= len(node) {
@@ -60,10 +55,16 @@ func FindName(name []byte) ([]byte, bool) {
 				}
 			}
 		}
+		if Typedata(node) == LongDirectory {
+			n, _ := ParseNode(node)
+			for _, e := range n.Entries {
+				if e.Name == string(name) {
+					return e.Hash, true
+				}
+			}
+		}
 	}
-	if debugMerkle {
-		fmt.Println("Non trouvé")
-	}
+	merkleLog.Debug("Non trouvé")
 	return nil, false
 }
 
@@ -73,7 +74,7 @@ func FindName(name []byte) ([]byte, bool) {
 // Paramètres :
 //   - node : nœud courant
 //   - depth : profondeur dans l’arbre (indentation)
-func PrintTree(node []byte, depth int) {
+func (s *Store) PrintTree(node []byte, depth int) {
 	prefix := strings.Repeat("  ", depth)
 	if len(node) == 0 {
 		fmt.Printf("%s<empty node>\n", prefix)
@@ -89,9 +90,28 @@ func PrintTree(node []byte, depth int) {
 			hashBytes := node[offset+NameSize : offset+DirEntrySize]
 			offset += DirEntrySize
 			fmt.Printf("%s  Name: %s\n", prefix, strings.TrimRight(string(nameBytes), "\x00"))
-			child, ok := MerkleMap[hex.EncodeToString(hashBytes)]
+			child, ok := s.FindHash(hashBytes)
 			if ok {
-				PrintTree(child, depth+1)
+				s.PrintTree(child, depth+1)
+			}
+		}
+	case Symlink:
+		fmt.Printf("%s-> %s\n", prefix, node[IdSize:])
+	case Metadata:
+		n, _ := ParseNode(node)
+		fmt.Printf("%s(%s)\n", prefix, n.Meta)
+		child, ok := s.FindHash(n.Children[0])
+		if ok {
+			s.PrintTree(child, depth)
+		}
+	case LongDirectory:
+		fmt.Printf("%sDirectory:\n", prefix)
+		n, _ := ParseNode(node)
+		for _, e := range n.Entries {
+			fmt.Printf("%s  Name: %s\n", prefix, e.Name)
+			child, ok := s.FindHash(e.Hash)
+			if ok {
+				s.PrintTree(child, depth+1)
 			}
 		}
 	case Big, BigDirectory:
@@ -99,9 +119,9 @@ func PrintTree(node []byte, depth int) {
 		for offset+HashSize <= len(node) {
 			childHash := node[offset : offset+HashSize]
 			offset += HashSize
-			child, ok := MerkleMap[hex.EncodeToString(childHash)]
+			child, ok := s.FindHash(childHash)
 			if ok {
-				PrintTree(child, depth+1)
+				s.PrintTree(child, depth+1)
 			}
 		}
 	default:
@@ -116,14 +136,12 @@ func PrintTree(node []byte, depth int) {
 //
 // Retour :
 //   - true si l’arbre est valide, false sinon
-func VerifyMerkle(rootHash []byte) bool {
-	if debugMerkle {
-		fmt.Println("VerifyMerkle")
-	}
+func (s *Store) VerifyMerkle(rootHash []byte) bool {
+	merkleLog.Debug("VerifyMerkle")
 	visited := make(map[string]bool)
-	mu.RLock()
-	ok := verifyNode(rootHash, visited)
-	mu.RUnlock()
+	s.mu.RLock()
+	ok := s.verifyNode(rootHash, visited)
+	s.mu.RUnlock()
 	return ok
 }
 
@@ -135,7 +153,7 @@ func VerifyMerkle(rootHash []byte) bool {
 //
 // Retour :
 //   - true si le sous-arbre est valide
-func verifyNode(hash []byte, visited map[string]bool) bool {
+func (s *Store) verifyNode(hash []byte, visited map[string]bool) bool {
 	key := hex.EncodeToString(hash)
 
 	if visited[key] {
@@ -143,7 +161,7 @@ func verifyNode(hash []byte, visited map[string]bool) bool {
 	}
 	visited[key] = true
 
-	node, exists := MerkleMap[key]
+	node, exists := s.nodes[key]
 	if !exists {
 		return false
 	}
@@ -153,13 +171,24 @@ func verifyNode(hash []byte, visited map[string]bool) bool {
 	}
 
 	switch node[0] {
-	case Chunk:
+	case Chunk, Symlink:
 		return true
 	case Directory:
 		count := (len(node) - IdSize) / DirEntrySize
 		for i := 0; i < count; i++ {
 			childHash := node[IdSize+i*DirEntrySize+NameSize : IdSize+i*DirEntrySize+DirEntrySize]
-			if !verifyNode(childHash, visited) {
+			if !s.verifyNode(childHash, visited) {
+				return false
+			}
+		}
+		return true
+	case LongDirectory, Metadata:
+		n, err := ParseNode(node)
+		if err != nil {
+			return false
+		}
+		for _, h := range n.ChildHashes() {
+			if !s.verifyNode(h, visited) {
 				return false
 			}
 		}
@@ -168,7 +197,7 @@ func verifyNode(hash []byte, visited map[string]bool) bool {
 		count := (len(node) - IdSize) / HashSize
 		for i := 0; i < count; i++ {
 			childHash := node[IdSize+i*HashSize : IdSize+i*HashSize+HashSize]
-			if !verifyNode(childHash, visited) {
+			if !s.verifyNode(childHash, visited) {
 				return false
 			}
 		}
@@ -182,9 +211,9 @@ func verifyNode(hash []byte, visited map[string]bool) bool {
 // Supprime un Merkle Tree à partir de la racine en tenant compte des références.
 // Paramètre :
 //   - rootHash : hash de la racine à supprimer
-func DeleteMerkleTree(rootHash []byte) {
+func (s *Store) DeleteMerkleTree(rootHash []byte) {
 	visited := make(map[string]bool)
-	deleteNode(rootHash, visited)
+	s.deleteNode(rootHash, visited)
 }
 
 // -----------------------------------------------------------------------------------------
@@ -192,7 +221,7 @@ func DeleteMerkleTree(rootHash []byte) {
 // Paramètres :
 //   - hash : hash du nœud à supprimer
 //   - visited : map pour éviter les suppressions multiples
-func deleteNode(hash []byte, visited map[string]bool) {
+func (s *Store) deleteNode(hash []byte, visited map[string]bool) {
 	key := hex.EncodeToString(hash)
 
 	if visited[key] {
@@ -200,39 +229,44 @@ func deleteNode(hash []byte, visited map[string]bool) {
 	}
 	visited[key] = true
 
-	mu.Lock()
-	node, exists := MerkleMap[key]
+	s.mu.Lock()
+	node, exists := s.nodes[key]
 	if !exists {
-		mu.Unlock()
+		s.mu.Unlock()
 		return
 	}
 
-	CountMap[key]--
-	if CountMap[key] > 0 {
-		mu.Unlock()
+	s.counts[key]--
+	if s.counts[key] > 0 {
+		s.mu.Unlock()
 		return
 	}
-	mu.Unlock()
+	s.mu.Unlock()
 
 	switch node[0] {
 	case Directory:
 		count := (len(node) - IdSize) / DirEntrySize
 		for i := 0; i < count; i++ {
 			childHash := node[IdSize+i*DirEntrySize+NameSize : IdSize+i*DirEntrySize+DirEntrySize]
-			deleteNode(childHash, visited)
+			s.deleteNode(childHash, visited)
+		}
+	case LongDirectory, Metadata:
+		n, _ := ParseNode(node)
+		for _, h := range n.ChildHashes() {
+			s.deleteNode(h, visited)
 		}
 	case Big, BigDirectory:
 		count := (len(node) - IdSize) / HashSize
 		for i := 0; i < count; i++ {
 			childHash := node[IdSize+i*HashSize : IdSize+i*HashSize+HashSize]
-			deleteNode(childHash, visited)
+			s.deleteNode(childHash, visited)
 		}
 	}
 
-	mu.Lock()
-	delete(MerkleMap, key)
-	delete(CountMap, key)
-	mu.Unlock()
+	s.mu.Lock()
+	delete(s.nodes, key)
+	delete(s.counts, key)
+	s.mu.Unlock()
 }
 
 // -----------------------------------------------------------------------------------------
@@ -244,19 +278,12 @@ func deleteNode(hash []byte, visited map[string]bool) {
 //   - liste de hashes hexadécimaux
 func ListChildrenHashes(node []byte) []string {
 	children := []string{}
-	switch node[0] {
-	case Directory:
-		count := (len(node) - 1) / 64
-		for i := 0; i < count; i++ {
-			childHash := node[1+i*64+32 : 1+i*64+64]
-			children = append(children, hex.EncodeToString(childHash))
-		}
-	case BigDirectory, Big:
-		count := (len(node) - 1) / 32
-		for i := 0; i < count; i++ {
-			childHash := node[1+i*32 : 1+i*32+32]
-			children = append(children, hex.EncodeToString(childHash))
-		}
+	n, err := ParseNode(node)
+	if err != nil {
+		return children
+	}
+	for _, h := range n.ChildHashes() {
+		children = append(children, hex.EncodeToString(h))
 	}
 	return children
 }
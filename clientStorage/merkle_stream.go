@@ -0,0 +1,296 @@
+package clientStorage
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"time"
+)
+
+//-----------------------------------------------------------------------------------------
+// Ce fichier regroupe la construction en flux du Merkle Tree : un fichier est
+// lu chunk par chunk et ses nœuds Chunk et Big sont transmis au Store au fur et
+// à mesure, sans jamais charger le fichier entier. En découpage fixe, les
+// hashes produits sont identiques à ceux de la construction par niveaux
+// (cf. mergeNodes) ; le découpage des fichiers est décrit dans merkle_chunking.go.
+
+// MerkleWorkers : nombre de fichiers hachés en parallèle par BuildMerkleNode
+var MerkleWorkers = runtime.NumCPU()
+
+//
+// ======================= CONSTRUCTION D’UN ARBRE =======================
+//
+
+// MerkleBuilder construit le Merkle Tree d’un fichier ou d’un répertoire en
+// hachant plusieurs fichiers en parallèle.
+type MerkleBuilder struct {
+	store   *Store
+	workers chan struct{} // jetons : un par fichier en cours de hachage
+
+	// construction incrémentale (cf. UpdateMerkle)
+	cache    *HashCache // nil = tous les fichiers sont relus
+	verify   bool       // relire les fichiers même si le cache est valide
+	base     string     // répertoire construit (clé du cache : chemin relatif)
+	realRoot string     // base, liens résolus (limite des liens suivis, cf. SymlinkPolicy)
+	mu       sync.Mutex // protège report
+	report   *MerkleReport
+}
+
+// NewMerkleBuilder crée un builder qui ajoute ses nœuds au Store s.
+// Paramètres :
+//   - s       : Store qui reçoit les nœuds, au fil de l’eau
+//   - workers : nombre maximal de fichiers hachés en parallèle (au moins 1)
+func NewMerkleBuilder(s *Store, workers int) *MerkleBuilder {
+	if workers < 1 {
+		workers = 1
+	}
+	return &MerkleBuilder{store: s, workers: make(chan struct{}, workers)}
+}
+
+// Construit récursivement le Merkle Tree à partir d’un chemin
+// Paramètre : path → fichier ou répertoire
+// Retour : nœud racine et erreur éventuelle
+func (s *Store) BuildMerkleNode(path string) ([]byte, error) {
+	return NewMerkleBuilder(s, MerkleWorkers).Build(path)
+}
+
+// Build construit l’arbre de path et renvoie son nœud racine.
+func (b *MerkleBuilder) Build(path string) ([]byte, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		merkleLog.Debug("Erreur Stat BuildMerkleNode")
+		return nil, err
+	}
+	b.base = path
+	if b.realRoot, err = filepath.EvalSymlinks(path); err != nil {
+		return nil, err
+	}
+	if !fi.IsDir() {
+		return b.buildFile(path, fi)
+	}
+	return b.buildDir(path, []os.FileInfo{fi})
+}
+
+// -----------------------------------------------------------------------------------------
+// buildDir construit un répertoire. Les sous-répertoires sont parcourus dans
+// la routine appelante ; chaque fichier est haché par une routine dès qu’un
+// jeton est libre. Les routines de hachage n’attendent jamais rien, le
+// parcours ne peut donc pas bloquer le pool. Les liens symboliques et les
+// fichiers spéciaux sont traités par resolveEntry ; ancestors contient les
+// répertoires en cours de parcours, path compris (détection des boucles).
+func (b *MerkleBuilder) buildDir(path string, ancestors []os.FileInfo) ([]byte, error) {
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		merkleLog.Debug("Erreur ReadDir BuildMerkleNode")
+		return nil, err
+	}
+	if err := checkEntryNames(path, entries); err != nil {
+		return nil, err
+	}
+
+	children := make([][]byte, len(entries))
+	infos := make([]os.FileInfo, len(entries))
+	errs := make([]error, len(entries))
+	var wg sync.WaitGroup
+	for i, e := range entries {
+		childPath := path + "/" + e.Name()
+		lfi, err := os.Lstat(childPath)
+		if err != nil {
+			errs[i] = err
+			break
+		}
+		fi, link := b.resolveEntry(childPath, lfi, ancestors)
+		if fi == nil {
+			children[i] = link // nil : entrée ignorée
+			continue
+		}
+		infos[i] = fi
+		if fi.IsDir() {
+			if children[i], errs[i] = b.buildDir(childPath, append(ancestors[:len(ancestors):len(ancestors)], fi)); errs[i] != nil {
+				break
+			}
+			continue
+		}
+		b.workers <- struct{}{}
+		wg.Add(1)
+		go func(i int) {
+			defer func() { <-b.workers; wg.Done() }()
+			children[i], errs[i] = b.buildFile(childPath, fi)
+		}(i)
+	}
+	wg.Wait()
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+
+	kept := entries[:0]
+	keptChildren := children[:0]
+	for i, child := range children {
+		if child == nil {
+			continue
+		}
+		if FileMetadata && infos[i] != nil {
+			child = b.store.wrapMetadata(infos[i], child)
+		}
+		kept = append(kept, entries[i])
+		keptChildren = append(keptChildren, child)
+	}
+	return b.store.buildDirectoryNode(kept, keptChildren), nil
+}
+
+// -----------------------------------------------------------------------------------------
+// buildFile renvoie le nœud racine d’un fichier, repris du cache s’il n’a pas
+// changé (cf. HashCache), sinon relu par hashFile avec le découpage de son
+// répertoire (cf. ShareChunking).
+func (b *MerkleBuilder) buildFile(path string, fi os.FileInfo) ([]byte, error) {
+	rel, err := filepath.Rel(b.base, path)
+	if err != nil || rel == "." {
+		rel = filepath.Base(path)
+	}
+	rel = filepath.ToSlash(rel)
+	mode := chunkingFor(rel)
+	if b.cache == nil {
+		return b.hashFile(path, mode)
+	}
+
+	cached, valid := b.cache.lookup(rel, fi, mode)
+	if valid && !b.verify {
+		if root, ok := b.store.retainTree(cached); ok {
+			b.cache.record(rel, newCacheEntry(fi, cached, time.Unix(0, b.cache.Entries[rel].HashedAt), mode))
+			b.count(func(r *MerkleReport) { r.Reused++ })
+			return root, nil
+		}
+		merkleLog.Debug("sous-arbre absent du Store, fichier relu", "path", rel)
+	}
+
+	hashedAt := time.Now()
+	root, err := b.hashFile(path, mode)
+	if err != nil {
+		return nil, err
+	}
+	hash := Sha(root)
+	if valid && !bytes.Equal(hash, cached) {
+		b.count(func(r *MerkleReport) { r.Inconsistent = append(r.Inconsistent, rel) })
+	}
+	b.cache.record(rel, newCacheEntry(fi, hash, hashedAt, mode))
+	b.count(func(r *MerkleReport) { r.Hashed++ })
+	return root, nil
+}
+
+// count met à jour le bilan de la construction.
+func (b *MerkleBuilder) count(f func(r *MerkleReport)) {
+	if b.report == nil {
+		return
+	}
+	b.mu.Lock()
+	f(b.report)
+	b.mu.Unlock()
+}
+
+// -----------------------------------------------------------------------------------------
+// hashFile lit un fichier chunk par chunk, découpé selon mode (cf. cutChunk),
+// et renvoie son nœud racine : le Chunk unique d’un petit fichier, sinon le
+// Big de plus haut niveau. Un fichier vide donne un Chunk vide.
+func (b *MerkleBuilder) hashFile(path string, mode ChunkingMode) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		merkleLog.Debug("Erreur Open BuildMerkleNode")
+		return nil, err
+	}
+	defer f.Close()
+
+	r := bufio.NewReaderSize(f, 64*ChunkSize)
+	tree := bigTree{store: b.store}
+	buf := make([]byte, ChunkSize)
+	fill := 0
+	for {
+		n, err := io.ReadFull(r, buf[fill:])
+		fill += n
+		eof := err == io.EOF || err == io.ErrUnexpectedEOF
+		if err != nil && !eof {
+			merkleLog.Debug("Erreur lecture BuildMerkleNode", "path", path, "err", err)
+			return nil, err
+		}
+		// un chunk n’est coupé que sur un tampon plein, ou à la fin du fichier
+		for fill > 0 && (fill == len(buf) || eof) {
+			cut := cutChunk(mode, buf[:fill])
+			tree.add(HashChunk(buf[:cut]))
+			fill = copy(buf, buf[cut:fill])
+		}
+		if eof {
+			break
+		}
+	}
+	if tree.empty() {
+		tree.add(HashChunk(nil))
+	}
+	root := tree.finish()
+	// comme la construction par niveaux : la racine du fichier est comptée une fois de plus
+	b.store.FillMap(root)
+	return root, nil
+}
+
+//
+// ======================= NŒUDS BIG EN FLUX =======================
+//
+
+// bigTree regroupe des nœuds en Big au fil de l’eau, avec au plus
+// MaxBigEntries hashes en attente par niveau. Le résultat est celui de
+// mergeNodes appliqué niveau par niveau : à chaque niveau les nœuds sont
+// regroupés par MaxBigEntries, jusqu’au premier niveau qui n’en compte qu’un.
+type bigTree struct {
+	store   *Store
+	pending [][][]byte // pending[i] : hashes du niveau i pas encore regroupés
+	total   []int      // total[i] : nombre de nœuds produits au niveau i
+	last    []byte     // dernier nœud produit
+}
+
+func (t *bigTree) empty() bool { return len(t.total) == 0 }
+
+// add ajoute un nœud Chunk (niveau 0) au Store et l’ajoute à l’arbre.
+func (t *bigTree) add(node []byte) {
+	t.push(0, node)
+}
+
+// push ajoute node au niveau level ; un groupe complet devient un Big du niveau suivant.
+func (t *bigTree) push(level int, node []byte) {
+	hash := Sha(node)
+	t.store.fillNode(node, hash)
+	t.last = node
+	if level == len(t.total) {
+		t.pending = append(t.pending, nil)
+		t.total = append(t.total, 0)
+	}
+	t.pending[level] = append(t.pending[level], hash)
+	t.total[level]++
+	if len(t.pending[level]) == MaxBigEntries {
+		t.flush(level)
+	}
+}
+
+// flush regroupe les hashes en attente du niveau level en un Big du niveau suivant.
+func (t *bigTree) flush(level int) {
+	node := make([]byte, 0, IdSize+len(t.pending[level])*HashSize)
+	node = append(node, Big)
+	for _, h := range t.pending[level] {
+		node = append(node, h...)
+	}
+	t.pending[level] = t.pending[level][:0]
+	t.push(level+1, node)
+}
+
+// finish regroupe les restes de chaque niveau et renvoie la racine, qui est
+// toujours le dernier nœud produit.
+func (t *bigTree) finish() []byte {
+	for level := 0; t.total[level] > 1; level++ {
+		if len(t.pending[level]) > 0 {
+			t.flush(level)
+		}
+	}
+	return t.last
+}
@@ -0,0 +1,116 @@
+package clientStorage
+
+import (
+	"bytes"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+//
+// ======================= COMPATIBILITÉ DU FORMAT =======================
+//
+
+// TestStreamingMatchesLevels : la construction en flux donne les mêmes nœuds
+// (hashes et comptages du Store) que la construction d’origine, qui lisait
+// le fichier entier, le découpait avec SplitIntoChunks puis regroupait les
+// chunks niveau par niveau avec buildBigNodes.
+func TestStreamingMatchesLevels(t *testing.T) {
+	oldMode, oldMeta := DefaultChunking, FileMetadata
+	DefaultChunking, FileMetadata = ChunkFixed, false
+	defer func() { DefaultChunking, FileMetadata = oldMode, oldMeta }()
+
+	for _, tc := range []struct {
+		name  string
+		files []int // taille de chaque fichier du répertoire
+	}{
+		{"0 chunk", []int{0}},
+		{"1 chunk", []int{ChunkSize}},
+		{"1 chunk partiel", []int{17}},
+		{"32 chunks", []int{32 * ChunkSize}},
+		{"33 chunks", []int{32*ChunkSize + 1}},
+		{"1024 chunks", []int{1024 * ChunkSize}},
+		{"1025 chunks", []int{1024*ChunkSize + 100}},
+		{"répertoire large", sizes(2*MaxDirEntries+3, 100)},
+		{"répertoire très large", sizes(MaxDirEntries*MaxBigEntries+1, 10)},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for i, size := range tc.files {
+				data := make([]byte, size)
+				for j := range data {
+					data[j] = byte(j*31 + i)
+				}
+				if err := os.WriteFile(filepath.Join(dir, fmt.Sprintf("f%04d", i)), data, 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			stream := NewStore()
+			got, err := stream.BuildMerkleNode(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			levels := NewStore()
+			want := levels.levelsDir(t, dir)
+
+			if !bytes.Equal(Sha(got), Sha(want)) {
+				t.Fatalf("racine %x, attendu %x", Sha(got), Sha(want))
+			}
+			if !maps.Equal(stream.counts, levels.counts) {
+				t.Fatalf("Store : %d nœuds, attendu %d (ou comptages différents)", len(stream.counts), len(levels.counts))
+			}
+		})
+	}
+}
+
+// sizes renvoie count tailles de fichier égales à size.
+func sizes(count, size int) []int {
+	s := make([]int, count)
+	for i := range s {
+		s[i] = size
+	}
+	return s
+}
+
+// levelsDir construit un répertoire de fichiers comme la version d’origine.
+func (s *Store) levelsDir(t *testing.T, dir string) []byte {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	children := make([][]byte, len(entries))
+	for i, e := range entries {
+		data, err := os.ReadFile(filepath.Join(dir, e.Name()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		children[i] = s.levelsFile(data)
+	}
+	return s.buildDirectoryNode(entries, children)
+}
+
+// levelsFile : fichier entier découpé puis regroupé niveau par niveau. La
+// version d’origine n’acceptait pas de fichier vide ; il donne ici un Chunk
+// vide, comme la construction en flux.
+func (s *Store) levelsFile(data []byte) []byte {
+	var children [][]byte
+	for _, c := range SplitIntoChunks(data) {
+		node := HashChunk(c)
+		s.FillMap(node)
+		children = append(children, node)
+	}
+	if len(children) == 0 {
+		node := HashChunk(nil)
+		s.FillMap(node)
+		children = append(children, node)
+	}
+	for len(children) > 1 {
+		children = s.buildBigNodes(children)
+	}
+	s.FillMap(children[0])
+	return children[0]
+}
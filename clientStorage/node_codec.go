@@ -0,0 +1,179 @@
+package clientStorage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+//
+// ======================= DÉCODAGE DES NŒUDS =======================
+//
+
+// Les nœuds reçus d’un peer sont des octets non fiables : ParseNode les
+// décode en vérifiant strictement leur forme avant toute utilisation.
+
+// ErrMalformedNode : nœud Merkle mal formé (à tester avec errors.Is)
+var ErrMalformedNode = errors.New("nœud Merkle malformé")
+
+// MerkleNode : nœud Merkle décodé
+type MerkleNode struct {
+	Type     byte
+	Data     []byte           // Chunk : données ; Symlink : cible du lien
+	Entries  []DirectoryEntry // Directory, LongDirectory : entrées (Hash = hash de l’enfant)
+	Children [][]byte         // Big, BigDirectory, Metadata : hashes des enfants
+	Meta     FileMeta         // Metadata : métadonnées de l’enfant
+}
+
+// -----------------------------------------------------------------------------------------
+// ParseNode décode un nœud Merkle et vérifie sa forme :
+//   - Chunk : au plus ChunkSize octets de données
+//   - Directory : au plus MaxDirEntries entrées de DirEntrySize octets, noms
+//     non vides, complétés uniquement par des octets nuls
+//   - LongDirectory : au plus MaxDirEntries entrées longueur (1 octet) + nom
+//     (1 à LongNameSize octets, sans octet nul) + hash
+//   - Big, BigDirectory : de 1 à MaxBigEntries hashes
+//   - Metadata : mode (permissions uniquement), date, taille puis un hash
+//   - Symlink : cible de 1 à ChunkSize octets, sans octet nul
+//
+// Paramètre :
+//   - b : nœud brut (type + contenu)
+//
+// Retour :
+//   - le nœud décodé (les tranches pointent dans b)
+//   - erreur ErrMalformedNode si le nœud est invalide
+func ParseNode(b []byte) (MerkleNode, error) {
+	if len(b) < IdSize {
+		return MerkleNode{}, fmt.Errorf("%w : nœud vide", ErrMalformedNode)
+	}
+	n := MerkleNode{Type: b[0]}
+	content := b[IdSize:]
+
+	switch n.Type {
+	case Chunk:
+		if len(content) > ChunkSize {
+			return MerkleNode{}, fmt.Errorf("%w : chunk de %d octets", ErrMalformedNode, len(content))
+		}
+		n.Data = content
+
+	case Directory:
+		if len(content)%DirEntrySize != 0 || len(content)/DirEntrySize > MaxDirEntries {
+			return MerkleNode{}, fmt.Errorf("%w : répertoire de %d octets", ErrMalformedNode, len(content))
+		}
+		for off := 0; off < len(content); off += DirEntrySize {
+			name, err := parseEntryName(content[off : off+NameSize])
+			if err != nil {
+				return MerkleNode{}, err
+			}
+			n.Entries = append(n.Entries, DirectoryEntry{Name: name, Hash: content[off+NameSize : off+DirEntrySize]})
+		}
+
+	case LongDirectory:
+		for off := 0; off < len(content); {
+			size := int(content[off])
+			end := off + 1 + size + HashSize
+			if size == 0 || end > len(content) || len(n.Entries) == MaxDirEntries {
+				return MerkleNode{}, fmt.Errorf("%w : répertoire long de %d octets", ErrMalformedNode, len(content))
+			}
+			name := content[off+1 : off+1+size]
+			if bytes.IndexByte(name, 0) >= 0 {
+				return MerkleNode{}, fmt.Errorf("%w : octet nul dans un nom d’entrée", ErrMalformedNode)
+			}
+			n.Entries = append(n.Entries, DirectoryEntry{Name: string(name), Hash: content[off+1+size : end]})
+			off = end
+		}
+
+	case Big, BigDirectory:
+		count := len(content) / HashSize
+		if len(content)%HashSize != 0 || count == 0 || count > MaxBigEntries {
+			return MerkleNode{}, fmt.Errorf("%w : nœud %d de %d octets", ErrMalformedNode, n.Type, len(content))
+		}
+		for off := 0; off < len(content); off += HashSize {
+			n.Children = append(n.Children, content[off:off+HashSize])
+		}
+
+	case Symlink:
+		if len(content) == 0 || len(content) > ChunkSize || bytes.IndexByte(content, 0) >= 0 {
+			return MerkleNode{}, fmt.Errorf("%w : lien symbolique de %d octets", ErrMalformedNode, len(content))
+		}
+		n.Data = content
+
+	case Metadata:
+		if len(content) != MetadataSize+HashSize {
+			return MerkleNode{}, fmt.Errorf("%w : métadonnées de %d octets", ErrMalformedNode, len(content))
+		}
+		n.Meta = FileMeta{
+			Mode:    binary.BigEndian.Uint32(content[0:4]),
+			ModTime: int64(binary.BigEndian.Uint64(content[4:12])),
+			Size:    binary.BigEndian.Uint64(content[12:20]),
+		}
+		if n.Meta.Mode&^MetaModeMask != 0 {
+			return MerkleNode{}, fmt.Errorf("%w : mode %o", ErrMalformedNode, n.Meta.Mode)
+		}
+		n.Children = [][]byte{content[MetadataSize:]}
+
+	default:
+		return MerkleNode{}, fmt.Errorf("%w : type %d inconnu", ErrMalformedNode, n.Type)
+	}
+	return n, nil
+}
+
+// parseEntryName décode un nom d’entrée de répertoire (NameSize octets).
+func parseEntryName(raw []byte) (string, error) {
+	name := raw
+	if i := bytes.IndexByte(raw, 0); i >= 0 {
+		if len(bytes.Trim(raw[i:], "\x00")) != 0 {
+			return "", fmt.Errorf("%w : octet nul dans un nom d’entrée", ErrMalformedNode)
+		}
+		name = raw[:i]
+	}
+	if len(name) == 0 {
+		return "", fmt.Errorf("%w : nom d’entrée invalide %q", ErrMalformedNode, raw)
+	}
+	return string(name), nil
+}
+
+// Marshal réencode le nœud (inverse de ParseNode).
+func (n MerkleNode) Marshal() []byte {
+	out := []byte{n.Type}
+	switch n.Type {
+	case Chunk, Symlink:
+		out = append(out, n.Data...)
+	case Directory:
+		for _, e := range n.Entries {
+			out = append(out, padTo32([]byte(e.Name))...)
+			out = append(out, e.Hash...)
+		}
+	case LongDirectory:
+		for _, e := range n.Entries {
+			out = append(out, byte(len(e.Name)))
+			out = append(out, e.Name...)
+			out = append(out, e.Hash...)
+		}
+	case Big, BigDirectory:
+		for _, h := range n.Children {
+			out = append(out, h...)
+		}
+	case Metadata:
+		out = binary.BigEndian.AppendUint32(out, n.Meta.Mode)
+		out = binary.BigEndian.AppendUint64(out, uint64(n.Meta.ModTime))
+		out = binary.BigEndian.AppendUint64(out, n.Meta.Size)
+		if len(n.Children) == 1 {
+			out = append(out, n.Children[0]...)
+		}
+	}
+	return out
+}
+
+// ChildHashes renvoie les hashes des enfants du nœud (aucun pour un Chunk).
+func (n MerkleNode) ChildHashes() [][]byte {
+	if n.Type != Directory && n.Type != LongDirectory {
+		return n.Children
+	}
+	hashes := make([][]byte, len(n.Entries))
+	for i, e := range n.Entries {
+		hashes[i] = e.Hash
+	}
+	return hashes
+}
@@ -0,0 +1,281 @@
+package clientStorage
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+//-----------------------------------------------------------------------------------------
+// Ce fichier protège la reconstruction contre les arbres malveillants. Un
+// arbre reçu d’un peer est d’abord entièrement vérifié en mémoire (noms,
+// profondeur, nombre d’entrées, taille totale, forme des nœuds), puis écrit
+// dans un répertoire temporaire voisin de la destination et déplacé d’un seul
+// renommage une fois complet : une reconstruction refusée ou interrompue ne
+// laisse rien dans OUTPUT/.
+
+// Limites d’une reconstruction
+var (
+	RebuildMaxDepth   = 64              // profondeur maximale de répertoires
+	RebuildMaxEntries = 100_000         // nombre maximal d’entrées (fichiers, répertoires, liens)
+	RebuildMaxBytes   = int64(16) << 30 // taille totale maximale des fichiers
+	rebuildMaxNesting = 16              // imbrication maximale de Big ou de BigDirectory
+)
+
+// ErrUnsafeTree : arbre refusé par la reconstruction (à tester avec errors.Is)
+var ErrUnsafeTree = errors.New("arbre refusé")
+
+// unsafeTree construit une erreur ErrUnsafeTree.
+func unsafeTree(format string, args ...any) error {
+	return fmt.Errorf("%w : %s", ErrUnsafeTree, fmt.Sprintf(format, args...))
+}
+
+// validEntryName vérifie qu’un nom d’entrée désigne un seul élément du
+// répertoire courant : ni vide, ni "." ou "..", sans séparateur ni octet nul.
+func validEntryName(name string) error {
+	switch {
+	case name == "", name == ".", name == "..":
+		return unsafeTree("nom d’entrée %q", name)
+	case strings.ContainsAny(name, "/\\\x00"):
+		return unsafeTree("nom d’entrée %q (séparateur ou octet nul)", name)
+	}
+	return nil
+}
+
+//
+// ======================= VÉRIFICATION =======================
+//
+
+// treeCheck : état de la vérification d’un arbre
+type treeCheck struct {
+	store   *Store
+	entries int
+	bytes   int64
+	sizes   map[string]int64 // taille des fichiers déjà vérifiés (nœuds partagés)
+}
+
+// -----------------------------------------------------------------------------------------
+// CheckTree vérifie qu’un arbre peut être reconstruit sans risque.
+// Paramètre :
+//   - hash : racine de l’arbre
+//
+// Retour :
+//   - erreur ErrUnsafeTree si l’arbre dépasse une limite ou contient un nom
+//     invalide ou en double, un lien ou un nœud invalide ; erreur simple si
+//     un nœud manque
+func (s *Store) CheckTree(hash []byte) error {
+	c := &treeCheck{store: s, sizes: map[string]int64{}}
+	return c.entry(hash, ".", 0)
+}
+
+// entry vérifie le nœud d’une entrée située en rel (chemin relatif à la racine).
+func (c *treeCheck) entry(hash []byte, rel string, depth int) error {
+	n, err := c.parse(hash)
+	if err != nil {
+		return err
+	}
+	switch n.Type {
+	case Metadata:
+		child, err := c.parse(n.Children[0])
+		if err != nil {
+			return err
+		}
+		if child.Type == Metadata {
+			return unsafeTree("%s : métadonnées imbriquées", rel)
+		}
+		if child.Type == Chunk || child.Type == Big {
+			size, err := c.file(n.Children[0], 0)
+			if err != nil {
+				return err
+			}
+			if uint64(size) != n.Meta.Size {
+				return unsafeTree("%s : %d octets annoncés pour %d", rel, n.Meta.Size, size)
+			}
+			return nil
+		}
+		return c.entry(n.Children[0], rel, depth)
+
+	case Chunk, Big:
+		_, err := c.file(hash, 0)
+		return err
+
+	case Symlink:
+		target := filepath.FromSlash(string(n.Data))
+		if !safeTarget(target) || !within(".", filepath.Join(filepath.Dir(rel), target)) {
+			return unsafeTree("%s : lien vers %q hors de l’arbre", rel, target)
+		}
+		return nil
+	}
+
+	if depth >= RebuildMaxDepth {
+		return unsafeTree("%s : plus de %d niveaux de répertoires", rel, RebuildMaxDepth)
+	}
+	return c.directory(hash, rel, depth, 0, map[string]bool{})
+}
+
+// directory vérifie un répertoire (Directory, LongDirectory ou BigDirectory).
+// names regroupe les noms déjà vus dans le répertoire, partagés entre les
+// nœuds d’un même BigDirectory.
+func (c *treeCheck) directory(hash []byte, rel string, depth, nesting int, names map[string]bool) error {
+	n, err := c.parse(hash)
+	if err != nil {
+		return err
+	}
+	switch n.Type {
+	case Directory, LongDirectory:
+		for _, e := range n.Entries {
+			if err := validEntryName(e.Name); err != nil {
+				return fmt.Errorf("%s : %w", rel, err)
+			}
+			if names[e.Name] {
+				// la seconde entrée écraserait la première dans OUTPUT/
+				return unsafeTree("%s : entrée %q en double", rel, e.Name)
+			}
+			names[e.Name] = true
+			if c.entries++; c.entries > RebuildMaxEntries {
+				return unsafeTree("plus de %d entrées", RebuildMaxEntries)
+			}
+			if err := c.entry(e.Hash, filepath.Join(rel, e.Name), depth+1); err != nil {
+				return err
+			}
+		}
+		return nil
+	case BigDirectory:
+		if nesting >= rebuildMaxNesting {
+			return unsafeTree("%s : BigDirectory imbriqués sur plus de %d niveaux", rel, rebuildMaxNesting)
+		}
+		for _, h := range n.Children {
+			if err := c.directory(h, rel, depth, nesting+1, names); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return unsafeTree("%s : nœud de type %d dans un répertoire", rel, n.Type)
+}
+
+// file vérifie un contenu de fichier (Chunk ou Big) et renvoie sa taille.
+func (c *treeCheck) file(hash []byte, nesting int) (int64, error) {
+	key := string(hash)
+	size, ok := c.sizes[key]
+	if !ok {
+		n, err := c.parse(hash)
+		if err != nil {
+			return 0, err
+		}
+		switch {
+		case n.Type == Chunk:
+			size = int64(len(n.Data))
+		case n.Type == Big && nesting < rebuildMaxNesting:
+			for _, h := range n.Children {
+				sub, err := c.file(h, nesting+1)
+				if err != nil {
+					return 0, err
+				}
+				size += sub
+				if size > RebuildMaxBytes {
+					return 0, unsafeTree("plus de %d octets", RebuildMaxBytes)
+				}
+			}
+		case n.Type == Big:
+			return 0, unsafeTree("Big imbriqués sur plus de %d niveaux", rebuildMaxNesting)
+		default:
+			return 0, unsafeTree("nœud de type %d dans un fichier", n.Type)
+		}
+		c.sizes[key] = size
+	}
+	if c.bytes += size; c.bytes > RebuildMaxBytes {
+		return 0, unsafeTree("plus de %d octets", RebuildMaxBytes)
+	}
+	return size, nil
+}
+
+// parse renvoie le nœud décodé de hash.
+func (c *treeCheck) parse(hash []byte) (MerkleNode, error) {
+	node, ok := c.store.FindHash(hash)
+	if !ok {
+		return MerkleNode{}, fmt.Errorf("node not found: %x", hash)
+	}
+	n, err := ParseNode(node)
+	if err != nil {
+		return MerkleNode{}, fmt.Errorf("%w : %w", ErrUnsafeTree, err)
+	}
+	return n, nil
+}
+
+//
+// ======================= ÉCRITURE =======================
+//
+
+// -----------------------------------------------------------------------------------------
+// rebuildSandboxed vérifie l’arbre (CheckTree), le reconstruit dans un
+// répertoire temporaire voisin de path puis le déplace en path. Si path est
+// un répertoire existant, les entrées reconstruites y sont déplacées une à
+// une (renommées si le nom est déjà pris) ; si c’est un fichier, le résultat
+// prend un nom libre à côté.
+func (s *Store) rebuildSandboxed(hash []byte, path string) error {
+	if err := s.CheckTree(hash); err != nil {
+		return err
+	}
+	parent := filepath.Dir(path)
+	if err := os.MkdirAll(parent, DirPerm); err != nil {
+		return err
+	}
+	tmp, err := os.MkdirTemp(parent, "."+filepath.Base(path)+".partial-")
+	if err != nil {
+		return err
+	}
+	defer removePartial(tmp)
+
+	staged := filepath.Join(tmp, filepath.Base(path))
+	if err := s.rebuildNode(hash, staged, staged); err != nil {
+		return err
+	}
+	return commitRebuild(staged, path)
+}
+
+// commitRebuild déplace la reconstruction terminée staged vers path.
+func commitRebuild(staged, path string) error {
+	dst, err := os.Lstat(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return os.Rename(staged, path)
+	}
+	if err != nil {
+		return err
+	}
+	src, err := os.Lstat(staged)
+	if err != nil {
+		return err
+	}
+	if !dst.IsDir() || !src.IsDir() {
+		dir := filepath.Dir(path)
+		return os.Rename(staged, filepath.Join(dir, UniqueName(dir, filepath.Base(path))))
+	}
+	entries, err := os.ReadDir(staged)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if err := os.Rename(filepath.Join(staged, e.Name()), filepath.Join(path, UniqueName(path, e.Name()))); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// removePartial supprime un répertoire temporaire, y compris les
+// sous-répertoires dont les métadonnées ont retiré le droit d’écriture.
+func removePartial(tmp string) {
+	_ = filepath.WalkDir(tmp, func(p string, d fs.DirEntry, err error) error {
+		if err == nil && d.IsDir() {
+			_ = os.Chmod(p, 0700)
+		}
+		return nil
+	})
+	if err := os.RemoveAll(tmp); err != nil {
+		merkleLog.Warn("répertoire temporaire non supprimé", "path", tmp, "err", err)
+	}
+}
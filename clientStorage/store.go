@@ -0,0 +1,165 @@
+package clientStorage
+
+import (
+	"encoding/gob"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+//-----------------------------------------------------------------------------------------
+// Ce fichier définit le Store : l’ensemble des nœuds Merkle connus d’un peer
+// (les siens et ceux téléchargés), leur compteur de références et la racine
+// locale. Chaque client.Node possède son Store ; les fonctions du paquet
+// opèrent sur le Store par défaut (Default) pour rester compatibles.
+
+//
+// ======================= STORE =======================
+//
+
+// Store regroupe un Merkle Tree en mémoire.
+type Store struct {
+	mu       sync.RWMutex      // protège nodes, counts et rootHash
+	nodes    map[string][]byte // tous les nœuds (hash hex → contenu)
+	counts   map[string]uint   // compteur de références de chaque nœud
+	rootHash []byte            // hash racine de nos données
+}
+
+// NewStore crée un Store vide.
+func NewStore() *Store {
+	return &Store{
+		nodes:  map[string][]byte{},
+		counts: map[string]uint{},
+	}
+}
+
+// Default est le Store utilisé par les fonctions du paquet.
+var Default = NewStore()
+
+// Root renvoie le hash racine de nos données.
+func (s *Store) Root() []byte {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.rootHash
+}
+
+// SetRoot remplace le hash racine de nos données.
+func (s *Store) SetRoot(hash []byte) {
+	s.mu.Lock()
+	s.rootHash = hash
+	s.mu.Unlock()
+}
+
+// Len renvoie le nombre de nœuds présents dans le Store.
+func (s *Store) Len() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.nodes)
+}
+
+//
+// ======================= SAUVEGARDE =======================
+//
+
+// storeSnapshot : forme sérialisée d’un Store
+type storeSnapshot struct {
+	Nodes  map[string][]byte
+	Counts map[string]uint
+	Root   []byte
+}
+
+// Save écrit le Store dans path (fichier temporaire puis renommage).
+func (s *Store) Save(path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	s.mu.RLock()
+	err = gob.NewEncoder(f).Encode(storeSnapshot{Nodes: s.nodes, Counts: s.counts, Root: s.rootHash})
+	s.mu.RUnlock()
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// Load remplace le contenu du Store par celui de path (absent = rien à faire).
+// Chaque nœud est vérifié : un nœud malformé ou dont le hash ne correspond pas
+// est ignoré.
+func (s *Store) Load(path string) error {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var snap storeSnapshot
+	if err := gob.NewDecoder(f).Decode(&snap); err != nil {
+		return fmt.Errorf("%s : store invalide : %w", path, err)
+	}
+	nodes := make(map[string][]byte, len(snap.Nodes))
+	counts := make(map[string]uint, len(snap.Nodes))
+	for key, node := range snap.Nodes {
+		if hex.EncodeToString(Sha(node)) != key {
+			continue
+		}
+		if _, err := ParseNode(node); err != nil {
+			continue
+		}
+		nodes[key] = node
+		counts[key] = snap.Counts[key]
+	}
+
+	s.mu.Lock()
+	s.nodes, s.counts, s.rootHash = nodes, counts, snap.Root
+	s.mu.Unlock()
+	return nil
+}
+
+//
+// ======================= FONCTIONS DU STORE PAR DÉFAUT =======================
+//
+
+// Root renvoie le hash racine du Store par défaut.
+func Root() []byte { return Default.Root() }
+
+// SetRoot remplace le hash racine du Store par défaut.
+func SetRoot(hash []byte) { Default.SetRoot(hash) }
+
+func FillMap(node []byte)                                { Default.FillMap(node) }
+func BuildMerkleNode(path string) ([]byte, error)        { return Default.BuildMerkleNode(path) }
+func FindHash(hash []byte) ([]byte, bool)                { return Default.FindHash(hash) }
+func FindName(name []byte) ([]byte, bool)                { return Default.FindName(name) }
+func PrintTree(node []byte, depth int)                   { Default.PrintTree(node, depth) }
+func VerifyMerkle(rootHash []byte) bool                  { return Default.VerifyMerkle(rootHash) }
+func DeleteMerkleTree(rootHash []byte)                   { Default.DeleteMerkleTree(rootHash) }
+func RebuildNode(hash []byte, path string) error         { return Default.RebuildNode(hash, path) }
+func WriteBigToFile(f *os.File, node []byte) error       { return Default.WriteBigToFile(f, node) }
+func DownloadFile(hash []byte, path string)              { Default.DownloadFile(hash, path) }
+func ListDirectory(hash []byte) ([]DirectoryEntry, bool) { return Default.ListDirectory(hash) }
+func ReleaseNodes(keys []string)                         { Default.ReleaseNodes(keys) }
+func ReachableHashes(rootHash []byte) map[string]bool    { return Default.ReachableHashes(rootHash) }
+
+func FilterTree(rootHash []byte, paths []string) ([]byte, []string, error) {
+	return Default.FilterTree(rootHash, paths)
+}
+
+func DedupStats(roots [][]byte) []VersionDedup { return Default.DedupStats(roots) }
+
+func UpdateMerkle(dir string, verify bool) ([]byte, *MerkleReport, error) {
+	return Default.UpdateMerkle(dir, verify)
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"flag"
+	"fmt"
+	"myp2p/capture"
+	"myp2p/client"
+	"os"
+)
+
+// runDecode implémente la sous-commande decode : affichage lisible d’une capture
+// enregistrée avec -capture.
+//
+//	myp2p decode [-fetch-keys] capture.pcapng
+func runDecode(args []string) int {
+	fs := flag.NewFlagSet("decode", flag.ExitOnError)
+	fetchKeys := fs.Bool("fetch-keys", false, "récupérer les clés publiques auprès du serveur pour vérifier les signatures")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage : myp2p decode [-fetch-keys] capture.pcapng")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 2
+	}
+
+	recs, err := capture.ReadFile(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		if len(recs) == 0 {
+			return 1
+		}
+	}
+
+	var keys capture.KeyFunc
+	if *fetchKeys {
+		keys = func(name string) *ecdsa.PublicKey {
+			key, err := client.GetPeerKey(name)
+			if err != nil {
+				mainLog.Warn("clé publique introuvable", "peer", name, "err", err)
+				return nil
+			}
+			return key
+		}
+	}
+	if err := capture.Decode(os.Stdout, recs, keys); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
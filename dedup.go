@@ -0,0 +1,57 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"myp2p/clientStorage"
+	"os"
+)
+
+// runDedup implémente la sous-commande dedup : construit l’arbre de chaque
+// version d’un répertoire avec les deux découpages et affiche, pour chaque
+// version, la part de ses chunks absente des versions précédentes (ce qu’un
+// peer qui les possède doit télécharger).
+//
+//	myp2p dedup v1/ v2/ v3/
+func runDedup(args []string) int {
+	fs := flag.NewFlagSet("dedup", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage : myp2p dedup VERSION... (de la plus ancienne à la plus récente)")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return 2
+	}
+
+	for _, mode := range []clientStorage.ChunkingMode{clientStorage.ChunkFixed, clientStorage.ChunkCDC} {
+		clientStorage.DefaultChunking = mode
+		store := clientStorage.NewStore()
+		roots := make([][]byte, fs.NArg())
+		for i, dir := range fs.Args() {
+			root, err := store.BuildMerkleNode(dir)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				return 1
+			}
+			roots[i] = clientStorage.Sha(root)
+		}
+
+		var total, fetched int64
+		fmt.Printf("découpage %s\n", mode)
+		for i, st := range store.DedupStats(roots) {
+			fmt.Printf("  %-30s %6d chunks %12d octets %12d nouveaux", fs.Arg(i), st.Chunks, st.Bytes, st.NewBytes)
+			if i > 0 && st.Bytes > 0 {
+				fmt.Printf("  (%.1f %%)", 100*float64(st.NewBytes)/float64(st.Bytes))
+				total += st.Bytes
+				fetched += st.NewBytes
+			}
+			fmt.Println()
+		}
+		if fetched > 0 {
+			fmt.Printf("  ratio de déduplication des versions suivantes : %.2f\n", float64(total)/float64(fetched))
+		}
+	}
+	return 0
+}
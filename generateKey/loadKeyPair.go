@@ -15,61 +15,100 @@ import (
 // les décode et les reconstruit sous forme d’objets ECDSA utilisables.
 // Si la clé privée n’existe pas, une erreur os.ErrNotExist est retournée
 // afin d’indiquer à l’appelant qu’une génération de clés est nécessaire.
+// Si la clé privée est chiffrée, la passphrase est demandée à passphrase ;
+// sans source de passphrase, ErrEncryptedKey est retournée.
 //
 // Paramètres :
-//   - privPath : chemin vers le fichier contenant la clé privée PEM
-//   - pubPath  : chemin vers le fichier contenant la clé publique PEM
+//   - privPath   : chemin vers le fichier contenant la clé privée PEM
+//   - pubPath    : chemin vers le fichier contenant la clé publique PEM
+//   - passphrase : source de la passphrase (peut être nil)
 //
 // Retour :
 //   - *ecdsa.PrivateKey : clé privée chargée
 //   - *ecdsa.PublicKey  : clé publique chargée
 //   - error             : erreur éventuelle (lecture, parsing, absence de clé)
-func LoadKeyPair(privPath, pubPath string) (*ecdsa.PrivateKey, *ecdsa.PublicKey, error) {
+func LoadKeyPair(privPath, pubPath string, passphrase PassphraseFunc) (*ecdsa.PrivateKey, *ecdsa.PublicKey, error) {
 
 	// Vérifie l’existence de la clé privée
 	// Si elle n’existe pas, l’appelant devra générer une nouvelle paire de clés
 	if _, err := os.Stat(privPath); errors.Is(err, os.ErrNotExist) {
-		if debugKey {
-			fmt.Println("Erreur de Stat dans loadKeyPair")
-		}
+		keyLog.Debug("Erreur de Stat dans loadKeyPair")
 		return nil, nil, os.ErrNotExist
 	}
 
 	// Lecture et décodage de la clé privée (PEM → ECDSA)
 	privPem, err := os.ReadFile(privPath)
 	if err != nil {
-		if debugKey {
-			fmt.Println("Erreur de ReadFile dans loadKeyPair")
-		}
+		keyLog.Debug("Erreur de ReadFile dans loadKeyPair")
 		return nil, nil, err
 	}
 	privBlock, _ := pem.Decode(privPem)
-	privKey, err := x509.ParseECPrivateKey(privBlock.Bytes)
-	if err != nil {
-		if debugKey {
-			fmt.Println("Erreur de ParseECPPrivateKey dans loadKeyPair")
+	if privBlock == nil {
+		keyLog.Debug("Erreur de pem.Decode (clé privée) dans loadKeyPair")
+		return nil, nil, fmt.Errorf("%s : aucun bloc PEM trouvé", privPath)
+	}
+	privDer := privBlock.Bytes
+	switch privBlock.Type {
+	case "EC PRIVATE KEY":
+	case EncryptedPrivateKeyType:
+		if passphrase == nil {
+			return nil, nil, ErrEncryptedKey
+		}
+		pass, err := passphrase()
+		if err != nil {
+			return nil, nil, err
 		}
+		privDer, err = openPrivateKey(privBlock, pass)
+		if err != nil {
+			keyLog.Debug("Erreur de déchiffrement dans loadKeyPair")
+			return nil, nil, err
+		}
+	default:
+		return nil, nil, fmt.Errorf("%s : type de bloc PEM inattendu %q", privPath, privBlock.Type)
+	}
+	privKey, err := x509.ParseECPrivateKey(privDer)
+	if err != nil {
+		keyLog.Debug("Erreur de ParseECPPrivateKey dans loadKeyPair")
 		return nil, nil, err
 	}
 
 	// Lecture et décodage de la clé publique (PEM → ECDSA)
 	pubPem, err := os.ReadFile(pubPath)
 	if err != nil {
-		if debugKey {
-			fmt.Println("Erreur de ReadFile dans loadKeyPair")
-		}
+		keyLog.Debug("Erreur de ReadFile dans loadKeyPair")
 		return nil, nil, err
 	}
 	pubBlock, _ := pem.Decode(pubPem)
+	if pubBlock == nil {
+		keyLog.Debug("Erreur de pem.Decode (clé publique) dans loadKeyPair")
+		return nil, nil, fmt.Errorf("%s : aucun bloc PEM trouvé", pubPath)
+	}
 	pubIfc, err := x509.ParsePKIXPublicKey(pubBlock.Bytes)
 	if err != nil {
-		if debugKey {
-			fmt.Println("Erreur de ParsePKIXPublicKey dans loadKeyPair")
-		}
+		keyLog.Debug("Erreur de ParsePKIXPublicKey dans loadKeyPair")
 		return nil, nil, err
 	}
-	pubKey := pubIfc.(*ecdsa.PublicKey)
+	pubKey, ok := pubIfc.(*ecdsa.PublicKey)
+	if !ok {
+		return nil, nil, fmt.Errorf("%s : la clé publique n’est pas une clé ECDSA", pubPath)
+	}
+	if !pubKey.Equal(&privKey.PublicKey) {
+		return nil, nil, fmt.Errorf("%s ne correspond pas à la clé privée %s", pubPath, privPath)
+	}
 
 	// Retourne la paire de clés chargée
 	return privKey, pubKey, nil
 }
+
+// IsKeyEncrypted indique si la clé privée stockée dans privPath est chiffrée.
+func IsKeyEncrypted(privPath string) (bool, error) {
+	privPem, err := os.ReadFile(privPath)
+	if err != nil {
+		return false, err
+	}
+	block, _ := pem.Decode(privPem)
+	if block == nil {
+		return false, fmt.Errorf("%s : aucun bloc PEM trouvé", privPath)
+	}
+	return block.Type == EncryptedPrivateKeyType, nil
+}
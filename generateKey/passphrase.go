@@ -0,0 +1,227 @@
+package generateKey
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/scrypt"
+	"golang.org/x/term"
+)
+
+// -----------------------------------------------------------------------------------------
+// Ce fichier regroupe le chiffrement au repos de la clé privée :
+// dérivation d’une clé symétrique à partir d’une passphrase (scrypt),
+// chiffrement AES-GCM du DER de la clé, et sources possibles de la passphrase
+// (variable d’environnement, descripteur de fichier, saisie au terminal).
+
+// Type du bloc PEM d’une clé privée chiffrée
+const EncryptedPrivateKeyType = "ENCRYPTED EC PRIVATE KEY"
+
+// Variable d’environnement contenant la passphrase (mode headless)
+const PassphraseEnv = "P2P_KEY_PASSPHRASE"
+
+// Paramètres scrypt par défaut (recommandations 2017+ pour un usage interactif)
+const (
+	scryptN      = 1 << 15
+	scryptR      = 8
+	scryptP      = 1
+	scryptKeyLen = 32
+	saltSize     = 16
+)
+
+// Bornes des paramètres scrypt lus dans un fichier de clé : un fichier forgé
+// ne doit pas pouvoir faire allouer des gigaoctets ni tourner indéfiniment
+// (scrypt utilise 128·N·r octets et p passes)
+const (
+	scryptMinN   = 1 << 10
+	scryptMaxN   = 1 << 20
+	scryptMaxR   = 32
+	scryptMaxP   = 16
+	scryptMaxMem = 1 << 30
+)
+
+var (
+	// ErrEncryptedKey est renvoyée lorsque la clé privée est chiffrée
+	// mais qu’aucune passphrase n’a été fournie.
+	ErrEncryptedKey = errors.New("la clé privée est chiffrée : passphrase requise")
+
+	// ErrNoPassphrase est renvoyée lorsqu’aucune source de passphrase n’est disponible.
+	ErrNoPassphrase = errors.New("aucune passphrase disponible (terminal, " + PassphraseEnv + " ou descripteur)")
+
+	// ErrBadPassphrase est renvoyée lorsque le déchiffrement échoue.
+	ErrBadPassphrase = errors.New("passphrase incorrecte ou clé privée corrompue")
+)
+
+// PassphraseFunc fournit la passphrase à la demande.
+// Elle n’est appelée que si la clé privée est effectivement chiffrée.
+type PassphraseFunc func() ([]byte, error)
+
+// -----------------------------------------------------------------------------------------
+// PassphraseSource construit une PassphraseFunc selon l’ordre de priorité suivant :
+//  1. descripteur de fichier fd (si fd >= 0), première ligne lue
+//  2. variable d’environnement PassphraseEnv
+//  3. saisie au terminal (sans écho) si stdin est un terminal
+//
+// Paramètres :
+//   - fd     : descripteur à lire (-1 pour ignorer)
+//   - prompt : message affiché lors de la saisie interactive
+//
+// Retour :
+//   - la fonction de récupération de la passphrase
+func PassphraseSource(fd int, prompt string) PassphraseFunc {
+	return func() ([]byte, error) {
+		if fd >= 0 {
+			f := os.NewFile(uintptr(fd), "passphrase-fd")
+			if f == nil {
+				return nil, fmt.Errorf("descripteur de passphrase invalide : %d", fd)
+			}
+			defer f.Close()
+			line, err := bufio.NewReader(f).ReadString('\n')
+			if err != nil && line == "" {
+				return nil, fmt.Errorf("lecture de la passphrase sur le fd %d : %w", fd, err)
+			}
+			return []byte(strings.TrimRight(line, "\r\n")), nil
+		}
+
+		if env, ok := os.LookupEnv(PassphraseEnv); ok {
+			return []byte(env), nil
+		}
+
+		stdin := int(os.Stdin.Fd())
+		if !term.IsTerminal(stdin) {
+			return nil, ErrNoPassphrase
+		}
+		fmt.Fprint(os.Stderr, prompt)
+		pass, err := term.ReadPassword(stdin)
+		fmt.Fprintln(os.Stderr)
+		if err != nil {
+			return nil, fmt.Errorf("saisie de la passphrase : %w", err)
+		}
+		return pass, nil
+	}
+}
+
+// -----------------------------------------------------------------------------------------
+// deriveKey dérive une clé AES-256 d’une passphrase avec scrypt.
+func deriveKey(passphrase, salt []byte, n, r, p int) ([]byte, error) {
+	return scrypt.Key(passphrase, salt, n, r, p, scryptKeyLen)
+}
+
+// checkScryptParams vérifie les paramètres scrypt d’un fichier de clé :
+// N puissance de deux entre scryptMinN et scryptMaxN, r et p bornés,
+// r·p < 2^30 (exigence de scrypt) et mémoire nécessaire bornée.
+func checkScryptParams(n, r, p int) error {
+	if n < scryptMinN || n > scryptMaxN || n&(n-1) != 0 {
+		return fmt.Errorf("paramètre scrypt N invalide : %d", n)
+	}
+	if r < 1 || r > scryptMaxR || p < 1 || p > scryptMaxP || r*p >= 1<<30 {
+		return fmt.Errorf("paramètres scrypt r=%d p=%d invalides", r, p)
+	}
+	if 128*n*r > scryptMaxMem {
+		return fmt.Errorf("paramètres scrypt trop coûteux : N=%d r=%d", n, r)
+	}
+	return nil
+}
+
+// -----------------------------------------------------------------------------------------
+// sealPrivateKey chiffre le DER d’une clé privée et renvoie le bloc PEM correspondant.
+// Les paramètres de dérivation sont stockés dans les en-têtes PEM afin de pouvoir
+// les faire évoluer sans casser les fichiers existants.
+//
+// Paramètres :
+//   - der        : clé privée sérialisée (ASN.1)
+//   - passphrase : passphrase de l’utilisateur
+//
+// Retour :
+//   - le bloc PEM chiffré
+//   - error : erreur éventuelle (aléa, dérivation, chiffrement)
+func sealPrivateKey(der, passphrase []byte) (*pem.Block, error) {
+	salt := make([]byte, saltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	key, err := deriveKey(passphrase, salt, scryptN, scryptR, scryptP)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	return &pem.Block{
+		Type: EncryptedPrivateKeyType,
+		Headers: map[string]string{
+			"KDF":  "scrypt",
+			"Salt": hex.EncodeToString(salt),
+			"N":    strconv.Itoa(scryptN),
+			"R":    strconv.Itoa(scryptR),
+			"P":    strconv.Itoa(scryptP),
+		},
+		Bytes: gcm.Seal(nonce, nonce, der, []byte(EncryptedPrivateKeyType)),
+	}, nil
+}
+
+// -----------------------------------------------------------------------------------------
+// openPrivateKey déchiffre un bloc PEM produit par sealPrivateKey.
+//
+// Retour :
+//   - le DER de la clé privée
+//   - error : ErrBadPassphrase si l’authentification GCM échoue
+func openPrivateKey(b *pem.Block, passphrase []byte) ([]byte, error) {
+	if kdf := b.Headers["KDF"]; kdf != "scrypt" {
+		return nil, fmt.Errorf("KDF non supportée : %q", kdf)
+	}
+	salt, err := hex.DecodeString(b.Headers["Salt"])
+	if err != nil || len(salt) == 0 {
+		return nil, errors.New("en-tête Salt invalide")
+	}
+	n, errN := strconv.Atoi(b.Headers["N"])
+	r, errR := strconv.Atoi(b.Headers["R"])
+	p, errP := strconv.Atoi(b.Headers["P"])
+	if errN != nil || errR != nil || errP != nil {
+		return nil, errors.New("paramètres scrypt invalides")
+	}
+	if err := checkScryptParams(n, r, p); err != nil {
+		return nil, err
+	}
+
+	key, err := deriveKey(passphrase, salt, n, r, p)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	if len(b.Bytes) < gcm.NonceSize() {
+		return nil, errors.New("clé privée chiffrée trop courte")
+	}
+	nonce, ciphertext := b.Bytes[:gcm.NonceSize()], b.Bytes[gcm.NonceSize():]
+	der, err := gcm.Open(nil, nonce, ciphertext, []byte(EncryptedPrivateKeyType))
+	if err != nil {
+		return nil, ErrBadPassphrase
+	}
+	return der, nil
+}
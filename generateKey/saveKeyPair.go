@@ -4,11 +4,15 @@ import (
 	"crypto/ecdsa"
 	"crypto/x509"
 	"encoding/pem"
+	"errors"
 	"fmt"
+	"myp2p/logging"
 	"os"
+	"path/filepath"
 )
 
-var debugKey = false
+// Logger du sous-système key
+var keyLog = logging.For("key")
 
 // SaveKeyPair enregistre une paire de clés ECDSA sur le disque.
 //
@@ -16,38 +20,45 @@ var debugKey = false
 // La clé privée est enregistrée avec des permissions restrictives
 // afin d’éviter tout accès non autorisé, tandis que la clé publique
 // peut être librement lisible.
+// Si une passphrase est fournie, la clé privée est chiffrée au repos
+// (scrypt + AES-GCM, voir passphrase.go).
 //
 // Paramètres :
-//   - priv     : clé privée ECDSA à sauvegarder
-//   - pub      : clé publique ECDSA associée
-//   - privPath : chemin du fichier de sortie pour la clé privée
-//   - pubPath  : chemin du fichier de sortie pour la clé publique
+//   - priv       : clé privée ECDSA à sauvegarder
+//   - pub        : clé publique ECDSA associée
+//   - privPath   : chemin du fichier de sortie pour la clé privée
+//   - pubPath    : chemin du fichier de sortie pour la clé publique
+//   - passphrase : passphrase de chiffrement (nil = clé en clair)
 //
 // Retour :
 //   - error : erreur éventuelle lors de la sérialisation ou de l’écriture des fichiers
-func SaveKeyPair(priv *ecdsa.PrivateKey, pub *ecdsa.PublicKey, privPath, pubPath string) error {
+func SaveKeyPair(priv *ecdsa.PrivateKey, pub *ecdsa.PublicKey, privPath, pubPath string, passphrase []byte) error {
 
 	// ----- Sauvegarde de la clé privée -----
 	// Sérialisation de la clé privée au format ASN.1
 	privBytes, err := x509.MarshalECPrivateKey(priv)
 	if err != nil {
-		if debugKey {
-			fmt.Println("erreur MarshalECPrivateKey")
-		}
+		keyLog.Debug("erreur MarshalECPrivateKey")
 		return err
 	}
 
-	// Encodage PEM de la clé privée
-	privPem := pem.EncodeToMemory(&pem.Block{
+	// Encodage PEM de la clé privée (chiffrée si une passphrase est fournie)
+	privBlock := &pem.Block{
 		Type:  "EC PRIVATE KEY",
 		Bytes: privBytes,
-	})
+	}
+	if passphrase != nil {
+		privBlock, err = sealPrivateKey(privBytes, passphrase)
+		if err != nil {
+			keyLog.Debug("erreur chiffrement de la clé privée")
+			return err
+		}
+	}
+	privPem := pem.EncodeToMemory(privBlock)
 
 	// Écriture de la clé privée sur le disque avec des permissions strictes
-	if err := os.WriteFile(privPath, privPem, 0600); err != nil {
-		if debugKey {
-			fmt.Println("erreur d'écriture sur le disque ")
-		}
+	if err := writeFileAtomic(privPath, privPem, 0600); err != nil {
+		keyLog.Debug("erreur d'écriture sur le disque")
 		return err
 	}
 
@@ -55,9 +66,7 @@ func SaveKeyPair(priv *ecdsa.PrivateKey, pub *ecdsa.PublicKey, privPath, pubPath
 	// Sérialisation de la clé publique au format PKIX
 	pubBytes, err := x509.MarshalPKIXPublicKey(pub)
 	if err != nil {
-		if debugKey {
-			fmt.Println("erreur MarshalPKIXPublicKey ")
-		}
+		keyLog.Debug("erreur MarshalPKIXPublicKey")
 		return err
 	}
 
@@ -70,3 +79,69 @@ func SaveKeyPair(priv *ecdsa.PrivateKey, pub *ecdsa.PublicKey, privPath, pubPath
 	// Écriture de la clé publique sur le disque
 	return os.WriteFile(pubPath, pubPem, 0644)
 }
+
+// MigrateKey chiffre une clé privée existante stockée en clair.
+//
+// La clé est relue, vérifiée puis réécrite chiffrée de manière atomique
+// (fichier temporaire + renommage) afin de ne jamais laisser de fichier
+// partiellement écrit en cas d’interruption.
+//
+// Paramètres :
+//   - privPath   : chemin de la clé privée à migrer
+//   - passphrase : passphrase de chiffrement (non vide)
+//
+// Retour :
+//   - error : erreur éventuelle (clé déjà chiffrée, lecture, écriture)
+func MigrateKey(privPath string, passphrase []byte) error {
+	if len(passphrase) == 0 {
+		return errors.New("passphrase vide : migration refusée")
+	}
+	privPem, err := os.ReadFile(privPath)
+	if err != nil {
+		return err
+	}
+	block, _ := pem.Decode(privPem)
+	if block == nil {
+		return fmt.Errorf("%s : aucun bloc PEM trouvé", privPath)
+	}
+	if block.Type == EncryptedPrivateKeyType {
+		return fmt.Errorf("%s : la clé privée est déjà chiffrée", privPath)
+	}
+	if _, err := x509.ParseECPrivateKey(block.Bytes); err != nil {
+		return fmt.Errorf("%s : clé privée invalide : %w", privPath, err)
+	}
+
+	sealed, err := sealPrivateKey(block.Bytes, passphrase)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(privPath, pem.EncodeToMemory(sealed), 0600)
+}
+
+// writeFileAtomic écrit data dans un fichier temporaire du même répertoire
+// puis le renomme vers path.
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	tmpName := tmp.Name()
+	defer os.Remove(tmpName) // sans effet après un renommage réussi
+
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmpName, path)
+}
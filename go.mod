@@ -2,7 +2,11 @@ module myp2p
 
 go 1.22.2
 
-require fyne.io/fyne/v2 v2.7.1
+require (
+	fyne.io/fyne/v2 v2.7.1
+	golang.org/x/crypto v0.33.0
+	golang.org/x/term v0.29.0
+)
 
 require (
 	fyne.io/systray v1.11.1-0.20250603113521-ca66a66d8b58 // indirect
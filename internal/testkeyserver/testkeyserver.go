@@ -0,0 +1,78 @@
+// Package testkeyserver remplace, dans les tests, le serveur central HTTPS
+// (cf. client.ServerURL) : liste des peers, clés publiques et adresses. Il est
+// partagé par les tests de client et de capture.
+package testkeyserver
+
+import (
+	"crypto/ecdsa"
+	"fmt"
+	"io"
+	"myp2p/client"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+// Server : serveur de clés d’un test
+type Server struct {
+	mu    sync.Mutex
+	keys  map[string][]byte
+	addrs map[string][]string
+	srv   *httptest.Server
+}
+
+// New démarre un serveur de clés et y redirige client.ServerURL le temps du
+// test.
+func New(t testing.TB) *Server {
+	ks := &Server{keys: map[string][]byte{}, addrs: map[string][]string{}}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /peers/", func(w http.ResponseWriter, r *http.Request) {
+		ks.mu.Lock()
+		defer ks.mu.Unlock()
+		for name := range ks.keys {
+			fmt.Fprintln(w, name)
+		}
+	})
+	mux.HandleFunc("GET /peers/{name}/key", func(w http.ResponseWriter, r *http.Request) {
+		ks.mu.Lock()
+		key, ok := ks.keys[r.PathValue("name")]
+		ks.mu.Unlock()
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(key)
+	})
+	mux.HandleFunc("PUT /peers/{name}/key", func(w http.ResponseWriter, r *http.Request) {
+		key, _ := io.ReadAll(r.Body)
+		ks.mu.Lock()
+		ks.keys[r.PathValue("name")] = key
+		ks.mu.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+	})
+	mux.HandleFunc("GET /peers/{name}/addresses", func(w http.ResponseWriter, r *http.Request) {
+		ks.mu.Lock()
+		defer ks.mu.Unlock()
+		for _, a := range ks.addrs[r.PathValue("name")] {
+			fmt.Fprintln(w, a)
+		}
+	})
+	ks.srv = httptest.NewServer(mux)
+
+	oldURL := client.ServerURL
+	client.ServerURL = ks.srv.URL
+	t.Cleanup(func() {
+		client.ServerURL = oldURL
+		ks.srv.Close()
+	})
+	return ks
+}
+
+// Register publie la clé et les adresses d’un peer.
+func (ks *Server) Register(name string, pub *ecdsa.PublicKey, addrs ...string) {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	ks.keys[name] = client.SerializePublicKey(pub)
+	ks.addrs[name] = addrs
+}
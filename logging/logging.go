@@ -0,0 +1,237 @@
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+//-----------------------------------------------------------------------------------------
+// Ce paquet fournit la journalisation de tous les autres paquets, au-dessus de log/slog :
+//   - un logger par sous-système (transport, crypto, merkle, peer, transaction…),
+//     obtenu par For ; chaque message porte l’attribut sub=<sous-système> ;
+//   - un niveau par sous-système, modifiable à chaud (SetLevel, ParseLevels) ;
+//   - une sortie commune, texte (par défaut, sur stderr) ou JSON (UseJSON) ;
+//   - des sinks supplémentaires (AddSink), par exemple la vue de log de la GUI.
+
+//
+// ======================= NIVEAUX =======================
+//
+
+// Variable d’environnement lue au démarrage (même syntaxe que ParseLevels)
+const LevelsEnv = "MYP2P_LOG"
+
+var (
+	mu           sync.RWMutex
+	out          slog.Handler = slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelDebug})
+	sinks        []sink
+	levels       = map[string]*slog.LevelVar{} // niveau de chaque sous-système
+	defaultLevel = slog.LevelInfo              // niveau des sous-systèmes non réglés
+)
+
+func init() {
+	if spec := os.Getenv(LevelsEnv); spec != "" {
+		if err := ParseLevels(spec); err != nil {
+			fmt.Fprintln(os.Stderr, LevelsEnv, ":", err)
+		}
+	}
+}
+
+// levelVar renvoie le niveau d’un sous-système (créé au niveau par défaut).
+func levelVar(sub string) *slog.LevelVar {
+	mu.Lock()
+	defer mu.Unlock()
+	lv, ok := levels[sub]
+	if !ok {
+		lv = new(slog.LevelVar)
+		lv.Set(defaultLevel)
+		levels[sub] = lv
+	}
+	return lv
+}
+
+// SetLevel règle le niveau d’un sous-système ; sub vide ou "all" règle tous
+// les sous-systèmes ainsi que le niveau par défaut.
+func SetLevel(sub string, level slog.Level) {
+	if sub == "" || sub == "all" {
+		mu.Lock()
+		defaultLevel = level
+		for _, lv := range levels {
+			lv.Set(level)
+		}
+		mu.Unlock()
+		return
+	}
+	levelVar(sub).Set(level)
+}
+
+// ParseLevel convertit "debug", "info", "warn" ou "error".
+func ParseLevel(s string) (slog.Level, error) {
+	var l slog.Level
+	err := l.UnmarshalText([]byte(s))
+	return l, err
+}
+
+// ParseLevels applique une liste de réglages séparés par des virgules :
+// "info" (tous), "peer=debug", "info,crypto=debug,transport=warn".
+func ParseLevels(spec string) error {
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		sub, lvl, found := strings.Cut(item, "=")
+		if !found {
+			sub, lvl = "", item
+		}
+		level, err := ParseLevel(lvl)
+		if err != nil {
+			return fmt.Errorf("niveau invalide %q : %w", item, err)
+		}
+		SetLevel(strings.TrimSpace(sub), level)
+	}
+	return nil
+}
+
+// Levels renvoie le niveau de chaque sous-système connu, trié par nom.
+func Levels() []string {
+	mu.RLock()
+	list := make([]string, 0, len(levels))
+	for sub, lv := range levels {
+		list = append(list, sub+"="+strings.ToLower(lv.Level().String()))
+	}
+	mu.RUnlock()
+	sort.Strings(list)
+	return list
+}
+
+//
+// ======================= SORTIES =======================
+//
+
+// UseText écrit les logs au format texte (clé=valeur) sur w.
+func UseText(w io.Writer) {
+	setOutput(slog.NewTextHandler(w, &slog.HandlerOptions{Level: slog.LevelDebug}))
+}
+
+// UseJSON écrit les logs au format JSON (un objet par ligne) sur w.
+func UseJSON(w io.Writer) {
+	setOutput(slog.NewJSONHandler(w, &slog.HandlerOptions{Level: slog.LevelDebug}))
+}
+
+// Discard supprime la sortie principale (seuls les sinks reçoivent les logs).
+func Discard() {
+	setOutput(nil)
+}
+
+func setOutput(h slog.Handler) {
+	mu.Lock()
+	out = h
+	mu.Unlock()
+}
+
+// Entry : un message transmis à un sink
+type Entry struct {
+	Time      time.Time
+	Level     slog.Level
+	Subsystem string
+	Message   string
+	Attrs     []slog.Attr
+}
+
+// String met en forme l’entrée sur une ligne : "[sub] message clé=valeur…".
+func (e Entry) String() string {
+	var b strings.Builder
+	b.WriteString("[" + e.Subsystem + "] " + e.Message)
+	for _, a := range e.Attrs {
+		b.WriteString(" " + a.String())
+	}
+	return b.String()
+}
+
+type sink struct {
+	min slog.Leveler
+	fn  func(Entry)
+}
+
+// AddSink ajoute une sortie recevant les messages de niveau au moins min
+// (et autorisés par le niveau de leur sous-système) ; min peut être un
+// *slog.LevelVar pour être réglé à chaud.
+func AddSink(min slog.Leveler, fn func(Entry)) {
+	mu.Lock()
+	sinks = append(sinks, sink{min: min, fn: fn})
+	mu.Unlock()
+}
+
+//
+// ======================= HANDLER =======================
+//
+
+// For renvoie le logger d’un sous-système.
+func For(sub string) *slog.Logger {
+	return slog.New(&handler{sub: sub, level: levelVar(sub)})
+}
+
+// handler filtre selon le niveau du sous-système puis transmet à la sortie
+// courante et aux sinks ; les attributs et groupes ajoutés par With / WithGroup
+// sont rejoués sur la sortie au moment de l’écriture (elle peut changer).
+type handler struct {
+	sub   string
+	level *slog.LevelVar
+	attrs []slog.Attr
+	ops   []func(slog.Handler) slog.Handler
+}
+
+func (h *handler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level.Level()
+}
+
+func (h *handler) Handle(ctx context.Context, r slog.Record) error {
+	mu.RLock()
+	base, targets := out, sinks
+	mu.RUnlock()
+
+	var err error
+	if base != nil {
+		base = base.WithAttrs([]slog.Attr{slog.String("sub", h.sub)})
+		for _, op := range h.ops {
+			base = op(base)
+		}
+		err = base.Handle(ctx, r)
+	}
+	if len(targets) > 0 {
+		e := Entry{Time: r.Time, Level: r.Level, Subsystem: h.sub, Message: r.Message}
+		e.Attrs = append(e.Attrs, h.attrs...)
+		r.Attrs(func(a slog.Attr) bool {
+			e.Attrs = append(e.Attrs, a)
+			return true
+		})
+		for _, s := range targets {
+			if r.Level >= s.min.Level() {
+				s.fn(e)
+			}
+		}
+	}
+	return err
+}
+
+func (h *handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	c := *h
+	c.attrs = append(append([]slog.Attr(nil), h.attrs...), attrs...)
+	c.ops = append(append([]func(slog.Handler) slog.Handler(nil), h.ops...),
+		func(b slog.Handler) slog.Handler { return b.WithAttrs(attrs) })
+	return &c
+}
+
+func (h *handler) WithGroup(name string) slog.Handler {
+	c := *h
+	c.ops = append(append([]func(slog.Handler) slog.Handler(nil), h.ops...),
+		func(b slog.Handler) slog.Handler { return b.WithGroup(name) })
+	return &c
+}
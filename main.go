@@ -1,22 +1,100 @@
 package main
 
 import (
+	"context"
 	"crypto/ecdsa"
 	"encoding/hex"
+	"errors"
+	"flag"
 	"fmt"
 	"log"
 	"myp2p/UI"
+	"myp2p/capture"
 	"myp2p/client"
 	"myp2p/clientStorage"
 	"myp2p/generateKey"
+	"myp2p/logging"
 	"net"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
 )
 
-// Activer/désactiver les messages de debug
-const debugMain = true
+// Logger du programme principal
+var mainLog = logging.For("main")
+
+// Répertoire de sauvegarde de l'état du nœud (peers, roots, Store) entre deux lancements
+const stateDir = "state"
 
 func main() {
+	// Sous-commande decode : lecture d'une capture, sans démarrer de nœud
+	if len(os.Args) > 1 && os.Args[1] == "decode" {
+		os.Exit(runDecode(os.Args[2:]))
+	}
+	// Sous-commande dedup : mesure du partage des chunks entre versions
+	if len(os.Args) > 1 && os.Args[1] == "dedup" {
+		os.Exit(runDedup(os.Args[2:]))
+	}
+
+	// ============================
+	// Options de la ligne de commande
+	// ============================
+	headless := flag.Bool("headless", false, "lancer sans interface graphique (CLI sur l'entrée standard)")
+	encryptKey := flag.Bool("encrypt-key", false, "chiffrer la clé privée générée avec une passphrase")
+	migrateKey := flag.Bool("migrate-key", false, "chiffrer la clé privée existante puis quitter")
+	passFd := flag.Int("passphrase-fd", -1, "descripteur de fichier d'où lire la passphrase (sinon $"+generateKey.PassphraseEnv+" ou saisie)")
+	workers := flag.Int("workers", client.RequestWorkers, "nombre de workers pour traiter les requêtes et les réponses")
+	notifyShutdown := flag.Bool("notify-shutdown", false, "prévenir les peers connectés lors de l'arrêt")
+	logLevels := flag.String("log", "", "niveaux de log, ex. \"info,peer=debug,crypto=warn\" (sinon $"+logging.LevelsEnv+")")
+	metricsAddr := flag.String("metrics", "", "adresse locale d'exposition des métriques Prometheus, ex. 127.0.0.1:9464 (vide = désactivé)")
+	capturePath := flag.String("capture", "", "enregistrer tous les paquets émis et reçus dans ce fichier pcapng (relire avec : myp2p decode FICHIER)")
+	logFormat := flag.String("log-format", "", "format des logs sur stderr : text ou json (défaut : json en mode -headless, text sinon)")
+	verify := flag.Bool("verify", false, "relire tous les fichiers au démarrage et signaler les entrées incohérentes du cache de hashes")
+	symlinks := flag.String("symlinks", clientStorage.SymlinkPolicy.String(), "liens symboliques de DATA : skip (ignorés), follow (suivis dans DATA) ou store (partagés comme liens)")
+	chunking := flag.String("chunking", clientStorage.DefaultChunking.String(), "découpage des fichiers de DATA : fixed ou cdc (selon le contenu), par répertoire, ex. \"fixed,photos=cdc\"")
+	encrypt := flag.String("encrypt", client.DefaultEncryptionPolicy.String(), "politique de chiffrement des échanges avec les peers : never, opportunistic ou required")
+	flag.Parse()
+
+	// ============================
+	// Journalisation
+	// ============================
+	if err := logging.ParseLevels(*logLevels); err != nil {
+		log.Fatal("Option -log : ", err)
+	}
+	switch *logFormat {
+	case "json":
+		logging.UseJSON(os.Stderr)
+	case "text":
+	case "":
+		if *headless {
+			logging.UseJSON(os.Stderr)
+		}
+	default:
+		log.Fatal("Option -log-format : text ou json attendu")
+	}
+
+	client.ShutdownNotifyPeers = *notifyShutdown
+
+	if policy, err := client.ParseEncryptionPolicy(*encrypt); err != nil {
+		log.Fatal("Option -encrypt : ", err)
+	} else {
+		client.DefaultEncryptionPolicy = policy
+	}
+	if mode, err := clientStorage.ParseSymlinkMode(*symlinks); err != nil {
+		log.Fatal("Option -symlinks : ", err)
+	} else {
+		clientStorage.SymlinkPolicy = mode
+	}
+	if err := clientStorage.ParseChunking(*chunking); err != nil {
+		log.Fatal("Option -chunking : ", err)
+	}
+
+	client.RequestWorkers = *workers
+	client.ResponseWorkers = *workers
+
+	passphrase := generateKey.PassphraseSource(*passFd, "Passphrase de la clé privée : ")
+
 	// ============================
 	// 0. Préparation des dossiers & chemins
 	// ============================
@@ -35,25 +113,41 @@ func main() {
 	var pub *ecdsa.PublicKey
 	var err error
 
-	priv, pub, err = generateKey.LoadKeyPair(privPath, pubPath)
-	if err == nil {
-		if debugMain {
-			fmt.Println("🔑 Paire de clés chargée depuis le disque.")
+	// Migration d'une clé en clair vers une clé chiffrée
+	if *migrateKey {
+		pass, err := passphrase()
+		if err != nil {
+			log.Fatal("Erreur passphrase :", err)
 		}
-	} else {
-		if debugMain {
-			fmt.Println("⚠️ Pas de clé trouvée → génération d’une nouvelle paire...")
+		if err := generateKey.MigrateKey(privPath, pass); err != nil {
+			log.Fatal("Erreur migration de la clé :", err)
 		}
+		fmt.Println("🔐 Clé privée chiffrée :", privPath)
+		return
+	}
+
+	priv, pub, err = generateKey.LoadKeyPair(privPath, pubPath, passphrase)
+	if err == nil {
+		mainLog.Debug("Paire de clés chargée depuis le disque.")
+	} else if errors.Is(err, os.ErrNotExist) {
+		mainLog.Debug("Pas de clé trouvée → génération d’une nouvelle paire...")
 		priv, pub, err = client.GenerateKeyPair()
 		if err != nil {
 			log.Fatal("Erreur génération clé :", err)
 		}
-		if err := generateKey.SaveKeyPair(priv, pub, privPath, pubPath); err != nil {
-			log.Fatal("Erreur sauvegarde clé :", err)
+		var pass []byte
+		if *encryptKey {
+			if pass, err = passphrase(); err != nil {
+				log.Fatal("Erreur passphrase :", err)
+			}
 		}
-		if debugMain {
-			fmt.Println("🔐 Nouvelle paire générée et sauvegardée.")
+		if err := generateKey.SaveKeyPair(priv, pub, privPath, pubPath, pass); err != nil {
+			log.Fatal("Erreur sauvegarde clé :", err)
 		}
+		mainLog.Debug("Nouvelle paire générée et sauvegardée.")
+	} else {
+		// Clé présente mais illisible : on ne l'écrase surtout pas
+		log.Fatal("Erreur chargement clé :", err)
 	}
 
 	// ============================
@@ -63,9 +157,7 @@ func main() {
 	if err := client.RegisterKey(client.NameofOurPeer, pubBytes); err != nil {
 		log.Fatal("Erreur lors de l'enregistrement du peer :", err)
 	}
-	if debugMain {
-		fmt.Println("📡 Peer enregistré sur le serveur.")
-	}
+	mainLog.Debug("Peer enregistré sur le serveur.")
 	// ============================
 	// 3. Récupérer la liste des peers connus
 	// ============================
@@ -73,18 +165,14 @@ func main() {
 	if err != nil {
 		log.Fatal("Erreur récupération peer list :", err)
 	}
-	if debugMain {
-		fmt.Println("🌐 Peers connus :", peers)
-	}
+	mainLog.Debug("Peers connus", "peers", peers)
 	// ============================
 	// 4. Ouvrir un socket UDP local
 	// ============================
-	if debugMain {
-		fmt.Println("Ouvrir une socket UDP locale...")
-	}
+	mainLog.Debug("Ouvrir une socket UDP locale...")
 	raddr, err := net.ResolveUDPAddr("udp", client.AddrServeurUDP)
 	if err != nil {
-		fmt.Println("erreur resolution addr udp serveur main.go")
+		mainLog.Warn("erreur resolution addr udp serveur main.go")
 		return
 	}
 	addr := net.UDPAddr{
@@ -92,68 +180,115 @@ func main() {
 		Port: 7513, //59562 15546 1234
 	}
 
-	conn, err := net.ListenUDP("udp", &addr)
+	conn, err := client.ListenUDP(&addr)
 	if err != nil {
 		log.Fatal("Impossible d'écouter sur le port UDP :", err)
 	}
-	defer conn.Close()
 
-	if debugMain {
-		fmt.Println("🟢 Socket UDP ouverte :", addr.String())
+	mainLog.Debug("socket UDP ouverte", "addr", addr.String())
+
+	// Capture du trafic (le fichier est fermé avec le transport)
+	var transport client.Transport = conn
+	if *capturePath != "" {
+		w, err := capture.Create(*capturePath)
+		if err != nil {
+			log.Fatal("Impossible de créer la capture :", err)
+		}
+		transport = capture.Wrap(conn, w)
+		mainLog.Info("capture du trafic", "file", *capturePath)
+	}
+
+	// Le nœud porte tout l’état P2P ; il devient le nœud par défaut utilisé par l’interface
+	node := client.NewNode(client.NodeConfig{
+		Conn:       transport,
+		Priv:       priv,
+		ServerAddr: raddr,
+		Store:      clientStorage.Default,
+		StateDir:   stateDir,
+	})
+	client.SetDefault(node)
+	defer node.Close()
+
+	// Exposition des métriques (loopback uniquement)
+	if *metricsAddr != "" {
+		if err := node.ServeMetrics(*metricsAddr); err != nil {
+			log.Fatal("Impossible d'exposer les métriques :", err)
+		}
+	}
+
+	// Restauration de l'état du lancement précédent
+	if err := node.LoadState(); err != nil {
+		mainLog.Warn("Erreur restauration de l'état", "err", err)
 	}
+
 	// ============================
 	// 5. Initialiser la map de peers
 	// ============================
 	client.InitPeersMap(peers)
-	if debugMain {
-		fmt.Println("===== debugMain PEERS ADDRESSES =====")
-		for name, p := range client.Peers {
-			fmt.Printf("Peer: %s\n", name)
-			fmt.Printf("  len(Addresses) = %d\n", len(p.Addresses))
-			for i, addr := range p.Addresses {
-				fmt.Printf("  [%d] %q (len=%d)\n", i, addr, len(addr))
-			}
-			fmt.Println()
-		}
-		fmt.Println("================================")
+	for _, p := range client.ListPeers() {
+		mainLog.Debug("peer connu", "peer", p.Name, "addresses", p.Addresses)
+	}
+
+	// Chargement des bans persistés
+	if err := client.LoadBans(node.BanFile); err != nil {
+		log.Fatal("Erreur chargement des bans :", err)
+	}
+
+	// Chargement des ACL (absentes = tout est partagé)
+	if err := client.LoadACL(client.ACLFile); err != nil {
+		log.Fatal("Erreur chargement ACL :", err)
 	}
 
 	// ============================
 	// 6. Lancer les routines P2P en arrière-plan
 	// ============================
-	go client.ResponseHandler(conn, priv)
-	go client.RequestHandler(conn, priv)
-	go client.CaptureMessage(conn, priv)
-	go client.CleanupTransactionsLoop(conn, priv)
-	go client.CheckRoots(conn, priv)
-	go client.DatumScheduler(conn)
-
-	// Handshake initial avec le serveur
-	if debugMain {
-		fmt.Println("Handshake avec le serveur...")
+	// (dont le handshake périodique avec le serveur)
+	mainLog.Debug("Handshake avec le serveur...")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if *headless {
+		// En mode CLI, SIGINT / SIGTERM déclenchent un arrêt propre
+		ctx, cancel = signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+		defer cancel()
 	}
-	go client.KeepAlive(conn, priv, pub, raddr)
+	go node.Run(ctx)
 
 	// ============================
 	// 7. Construire le hashRoot du répertoire DATA
 	// ============================
-	fmt.Printf("\n\n=== Test du répertoire: %s ===\n", UI.DATA_DIRECTORY)
-	rootNode, err := clientStorage.BuildMerkleNode(UI.DATA_DIRECTORY)
+	mainLog.Info(fmt.Sprintf("Test du répertoire: %s", UI.DATA_DIRECTORY))
+	clientStorage.HashCacheFile = filepath.Join(stateDir, "merkle_cache.json")
+	rootNode, report, err := clientStorage.UpdateMerkle(UI.DATA_DIRECTORY, *verify)
 	if err != nil {
 
-		fmt.Println("Erreur lors de la construction du Merkle :", err)
+		mainLog.Warn("Erreur lors de la construction du Merkle", "err", err)
 
 		return
 	}
-	clientStorage.RootHash = clientStorage.Sha(rootNode)
-	client.MyListroots = client.AddListRoot(client.MyListroots, clientStorage.RootHash)
-	fmt.Println("Hash de la racine :", hex.EncodeToString(clientStorage.RootHash))
+	if *verify && len(report.Inconsistent) == 0 {
+		mainLog.Info("cache de hashes cohérent avec les fichiers")
+	}
+	clientStorage.SetRoot(clientStorage.Sha(rootNode))
+	client.PushMyRoot(clientStorage.Root())
+	mainLog.Info("hash de la racine", "root", hex.EncodeToString(clientStorage.Root()))
 
 	// ============================
-	// 8. Démarrage de l'interface graphique
+	// 8. Démarrage de l'interface (graphique ou CLI)
 	// ============================
-	if debugMain {
-		fmt.Println("Démarrage de la GUI...")
+	if *headless {
+		mainLog.Debug("Démarrage de la CLI...")
+		// la CLI s'arrête à la fin de l'entrée standard
+		go func() {
+			UI.StartCLI(node.Conn(), priv)
+			cancel()
+		}()
+		<-ctx.Done()
+		mainLog.Info("Arrêt en cours...")
+		if err := node.Shutdown(context.Background()); err != nil {
+			mainLog.Warn("Erreur lors de l'arrêt", "err", err)
+		}
+		return
 	}
-	UI.StartGUI(conn, priv)
+	mainLog.Debug("Démarrage de la GUI...")
+	UI.StartGUI(node.Conn(), priv)
 }
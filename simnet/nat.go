@@ -0,0 +1,183 @@
+package simnet
+
+import (
+	"fmt"
+	"net/netip"
+)
+
+//
+// ======================= NAT =======================
+//
+
+// NATKind : comportement d’un NAT (classification de la RFC 3489)
+type NATKind int
+
+const (
+	// FullCone : une fois la correspondance créée, tout le monde peut joindre l’hôte
+	FullCone NATKind = iota
+	// RestrictedCone : seules les IP déjà contactées peuvent répondre
+	RestrictedCone
+	// PortRestrictedCone : seuls les couples ip:port déjà contactés peuvent répondre
+	PortRestrictedCone
+	// Symmetric : une correspondance (port public) différente par destination
+	Symmetric
+)
+
+func (k NATKind) String() string {
+	switch k {
+	case FullCone:
+		return "full cone"
+	case RestrictedCone:
+		return "restricted cone"
+	case PortRestrictedCone:
+		return "port restricted cone"
+	case Symmetric:
+		return "symmetric"
+	}
+	return "inconnu"
+}
+
+// mapping : correspondance entre une extrémité privée et un port public
+type mapping struct {
+	conn    *Conn
+	port    uint16
+	allowed map[netip.AddrPort]bool // destinations contactées
+}
+
+// NAT place des extrémités privées derrière une adresse IP publique.
+// Ses champs sont protégés par le verrou du réseau.
+type NAT struct {
+	net      *Network
+	public   netip.Addr
+	kind     NATKind
+	byKey    map[string]*mapping // clé de correspondance → mapping
+	byPort   map[uint16]*mapping // port public → mapping
+	internal map[netip.AddrPort]*Conn
+}
+
+// AddNAT ajoute un NAT d’adresse publique publicIP.
+func (n *Network) AddNAT(publicIP string, kind NATKind) (*NAT, error) {
+	ip, err := netip.ParseAddr(publicIP)
+	if err != nil {
+		return nil, err
+	}
+	ip = ip.Unmap()
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if _, ok := n.nats[ip]; ok {
+		return nil, fmt.Errorf("simnet : NAT %s déjà présent", ip)
+	}
+	for ap := range n.hosts {
+		if ap.Addr() == ip {
+			return nil, fmt.Errorf("simnet : %s est déjà utilisée par un hôte", ip)
+		}
+	}
+	nat := &NAT{
+		net:      n,
+		public:   ip,
+		kind:     kind,
+		byKey:    map[string]*mapping{},
+		byPort:   map[uint16]*mapping{},
+		internal: map[netip.AddrPort]*Conn{},
+	}
+	n.nats[ip] = nat
+	return nat, nil
+}
+
+// Listen crée une extrémité privée derrière le NAT.
+// Paramètre :
+//   - addr : adresse privée "ip:port" (sert d’identifiant, elle n’est pas routable)
+func (nat *NAT) Listen(addr string) (*Conn, error) {
+	ap, err := netip.ParseAddrPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	ap = netip.AddrPortFrom(ap.Addr().Unmap(), ap.Port())
+
+	nat.net.mu.Lock()
+	defer nat.net.mu.Unlock()
+	if _, used := nat.internal[ap]; used {
+		return nil, fmt.Errorf("simnet : adresse privée %s déjà utilisée", ap)
+	}
+	c := newConn(nat.net, ap, nat)
+	nat.internal[ap] = c
+	return c, nil
+}
+
+// PublicAddr renvoie l’adresse publique actuellement associée à c pour joindre dst
+// (invalide si aucune correspondance n’existe encore).
+func (nat *NAT) PublicAddr(c *Conn, dst string) netip.AddrPort {
+	nat.net.mu.Lock()
+	defer nat.net.mu.Unlock()
+	d, err := netip.ParseAddrPort(dst)
+	if err != nil {
+		return netip.AddrPort{}
+	}
+	m, ok := nat.byKey[nat.key(c, netip.AddrPortFrom(d.Addr().Unmap(), d.Port()))]
+	if !ok {
+		return netip.AddrPort{}
+	}
+	return netip.AddrPortFrom(nat.public, m.port)
+}
+
+// key renvoie la clé de correspondance : par extrémité, ou par (extrémité, destination)
+// pour un NAT symétrique.
+func (nat *NAT) key(c *Conn, dst netip.AddrPort) string {
+	if nat.kind == Symmetric {
+		return c.local.String() + "|" + dst.String()
+	}
+	return c.local.String()
+}
+
+// outbound traduit l’adresse source d’un paquet sortant (n.mu verrouillé).
+func (nat *NAT) outbound(c *Conn, dst netip.AddrPort) netip.AddrPort {
+	k := nat.key(c, dst)
+	m, ok := nat.byKey[k]
+	if !ok {
+		port := nat.net.allocPortLocked(nat.public, func(p uint16) bool {
+			_, used := nat.byPort[p]
+			return used
+		})
+		m = &mapping{conn: c, port: port, allowed: map[netip.AddrPort]bool{}}
+		nat.byKey[k] = m
+		nat.byPort[port] = m
+	}
+	m.allowed[dst] = true
+	return netip.AddrPortFrom(nat.public, m.port)
+}
+
+// inbound décide si un paquet entrant sur le port public est accepté (n.mu verrouillé).
+// Retour :
+//   - l’extrémité privée destinataire (nil si aucune correspondance)
+//   - false si le paquet est filtré
+func (nat *NAT) inbound(src netip.AddrPort, port uint16) (*Conn, bool) {
+	m, ok := nat.byPort[port]
+	if !ok {
+		return nil, false
+	}
+	switch nat.kind {
+	case FullCone:
+		return m.conn, true
+	case RestrictedCone:
+		for d := range m.allowed {
+			if d.Addr() == src.Addr() {
+				return m.conn, true
+			}
+		}
+		return nil, false
+	default: // PortRestrictedCone, Symmetric
+		return m.conn, m.allowed[src]
+	}
+}
+
+// removeLocked supprime une extrémité et ses correspondances (n.mu verrouillé).
+func (nat *NAT) removeLocked(c *Conn) {
+	delete(nat.internal, c.local)
+	for k, m := range nat.byKey {
+		if m.conn == c {
+			delete(nat.byKey, k)
+			delete(nat.byPort, m.port)
+		}
+	}
+}
@@ -0,0 +1,343 @@
+// Package simnet fournit un réseau UDP simulé en mémoire.
+//
+// Chaque extrémité (*Conn) implémente client.Transport : on peut donc faire
+// tourner plusieurs peers dans un même processus, avec des liens configurables
+// (latence, gigue, pertes, duplication, réordonnancement) et des hôtes placés
+// derrière un NAT (full cone, restricted, port restricted, symétrique).
+//
+// Le tirage aléatoire est issu d’une graine fixe : à ordonnancement identique,
+// deux exécutions perdent et dupliquent les mêmes paquets.
+//
+// simnet importe client (pour client.Transport) : les tests qui l’utilisent ne
+// peuvent pas être dans le paquet client, ils sont dans client_test.
+package simnet
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"myp2p/client"
+	"myp2p/logging"
+	"net"
+	"net/netip"
+	"sync"
+	"time"
+)
+
+// Logger du sous-système simnet
+var simLog = logging.For("simnet")
+
+// Taille de la file de réception de chaque extrémité (au-delà, les paquets sont perdus)
+const InboxSize = 1024
+
+// Première valeur utilisée pour les ports éphémères
+const firstEphemeralPort = 40000
+
+// LinkParams décrit le comportement d’un lien entre deux adresses IP.
+type LinkParams struct {
+	Latency      time.Duration // délai de base
+	Jitter       time.Duration // délai supplémentaire aléatoire dans [0, Jitter)
+	Loss         float64       // probabilité de perte d’un paquet
+	Duplicate    float64       // probabilité qu’un paquet soit livré deux fois
+	Reorder      float64       // probabilité qu’un paquet soit retardé de ReorderDelay
+	ReorderDelay time.Duration // retard appliqué aux paquets réordonnés
+}
+
+// Stats : compteurs du réseau simulé
+type Stats struct {
+	Sent        uint64 // paquets émis
+	Delivered   uint64 // paquets remis à une extrémité
+	Lost        uint64 // paquets perdus sur le lien
+	Duplicated  uint64 // copies supplémentaires émises
+	Filtered    uint64 // paquets rejetés par un NAT
+	Unreachable uint64 // destination inconnue
+	Overflow    uint64 // file de réception pleine
+}
+
+// linkKey identifie un lien (orienté) entre deux adresses IP
+type linkKey struct {
+	from, to netip.Addr
+}
+
+// Network est un réseau simulé.
+type Network struct {
+	mu       sync.Mutex
+	rng      *rand.Rand
+	link     LinkParams               // paramètres par défaut
+	links    map[linkKey]LinkParams   // paramètres spécifiques
+	hosts    map[netip.AddrPort]*Conn // extrémités joignables directement
+	nats     map[netip.Addr]*NAT      // NAT, par adresse publique
+	nextPort map[netip.Addr]uint16    // prochain port éphémère par IP
+	stats    Stats
+}
+
+// New crée un réseau simulé sans latence ni perte.
+// Paramètre :
+//   - seed : graine du générateur aléatoire
+func New(seed int64) *Network {
+	return &Network{
+		rng:      rand.New(rand.NewSource(seed)),
+		links:    map[linkKey]LinkParams{},
+		hosts:    map[netip.AddrPort]*Conn{},
+		nats:     map[netip.Addr]*NAT{},
+		nextPort: map[netip.Addr]uint16{},
+	}
+}
+
+// SetDefaultLink définit les paramètres appliqués aux liens sans configuration propre.
+func (n *Network) SetDefaultLink(p LinkParams) {
+	n.mu.Lock()
+	n.link = p
+	n.mu.Unlock()
+}
+
+// SetLink définit les paramètres du lien entre deux adresses IP (dans les deux sens).
+func (n *Network) SetLink(a, b string, p LinkParams) error {
+	ipA, err := netip.ParseAddr(a)
+	if err != nil {
+		return err
+	}
+	ipB, err := netip.ParseAddr(b)
+	if err != nil {
+		return err
+	}
+	n.mu.Lock()
+	n.links[linkKey{ipA.Unmap(), ipB.Unmap()}] = p
+	n.links[linkKey{ipB.Unmap(), ipA.Unmap()}] = p
+	n.mu.Unlock()
+	return nil
+}
+
+// Stats renvoie une copie des compteurs du réseau.
+func (n *Network) Stats() Stats {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.stats
+}
+
+// -----------------------------------------------------------------------------------------
+// Listen crée une extrémité joignable directement (sans NAT).
+// Paramètre :
+//   - addr : adresse "ip:port" (port 0 = port éphémère)
+//
+// Retour :
+//   - l’extrémité, utilisable comme client.Transport
+//   - erreur si l’adresse est invalide ou déjà prise
+func (n *Network) Listen(addr string) (*Conn, error) {
+	ap, err := netip.ParseAddrPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	ip := ap.Addr().Unmap()
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if _, ok := n.nats[ip]; ok {
+		return nil, fmt.Errorf("simnet : %s est l’adresse publique d’un NAT", ip)
+	}
+	port := ap.Port()
+	if port == 0 {
+		port = n.allocPortLocked(ip, func(p uint16) bool {
+			_, used := n.hosts[netip.AddrPortFrom(ip, p)]
+			return used
+		})
+	}
+	ap = netip.AddrPortFrom(ip, port)
+	if _, used := n.hosts[ap]; used {
+		return nil, fmt.Errorf("simnet : adresse %s déjà utilisée", ap)
+	}
+	c := newConn(n, ap, nil)
+	n.hosts[ap] = c
+	return c, nil
+}
+
+// allocPortLocked renvoie le prochain port libre pour une IP (n.mu doit être verrouillé).
+func (n *Network) allocPortLocked(ip netip.Addr, used func(uint16) bool) uint16 {
+	p := n.nextPort[ip]
+	if p < firstEphemeralPort {
+		p = firstEphemeralPort
+	}
+	for used(p) {
+		p++
+	}
+	n.nextPort[ip] = p + 1
+	return p
+}
+
+// -----------------------------------------------------------------------------------------
+// send simule l’émission d’un paquet sur le réseau (adresses déjà traduites par le NAT).
+func (n *Network) send(src, dst netip.AddrPort, payload []byte) {
+	n.mu.Lock()
+	n.stats.Sent++
+	p, ok := n.links[linkKey{src.Addr(), dst.Addr()}]
+	if !ok {
+		p = n.link
+	}
+	if p.Loss > 0 && n.rng.Float64() < p.Loss {
+		n.stats.Lost++
+		n.mu.Unlock()
+		simLog.Debug("paquet perdu", "src", src, "dst", dst)
+		return
+	}
+	copies := 1
+	if p.Duplicate > 0 && n.rng.Float64() < p.Duplicate {
+		copies = 2
+		n.stats.Duplicated++
+	}
+	delays := make([]time.Duration, copies)
+	for i := range delays {
+		d := p.Latency
+		if p.Jitter > 0 {
+			d += time.Duration(n.rng.Int63n(int64(p.Jitter)))
+		}
+		if p.Reorder > 0 && n.rng.Float64() < p.Reorder {
+			d += p.ReorderDelay
+		}
+		delays[i] = d
+	}
+	n.mu.Unlock()
+
+	for _, d := range delays {
+		data := append([]byte(nil), payload...)
+		if d <= 0 {
+			n.deliver(src, dst, data)
+			continue
+		}
+		time.AfterFunc(d, func() { n.deliver(src, dst, data) })
+	}
+}
+
+// deliver remet un paquet à son destinataire (directement ou à travers un NAT).
+func (n *Network) deliver(src, dst netip.AddrPort, payload []byte) {
+	n.mu.Lock()
+	c, ok := n.hosts[dst]
+	if !ok {
+		if nat, isNat := n.nats[dst.Addr()]; isNat {
+			var allowed bool
+			c, allowed = nat.inbound(src, dst.Port())
+			if !allowed {
+				n.stats.Filtered++
+				n.mu.Unlock()
+				simLog.Debug("paquet filtré par le NAT", "src", src, "dst", dst)
+				return
+			}
+			ok = c != nil
+		}
+	}
+	if !ok {
+		n.stats.Unreachable++
+		n.mu.Unlock()
+		return
+	}
+	n.mu.Unlock()
+
+	if c.push(src, payload) {
+		n.mu.Lock()
+		n.stats.Delivered++
+		n.mu.Unlock()
+	} else {
+		n.mu.Lock()
+		n.stats.Overflow++
+		n.mu.Unlock()
+	}
+}
+
+//
+// ======================= EXTRÉMITÉ =======================
+//
+
+// datagram : paquet en attente de lecture
+type datagram struct {
+	from    netip.AddrPort
+	payload []byte
+}
+
+// Conn est une extrémité du réseau simulé ; elle implémente client.Transport.
+type Conn struct {
+	net    *Network
+	local  netip.AddrPort // adresse locale (privée si derrière un NAT)
+	nat    *NAT           // NAT devant l’extrémité (nil si joignable directement)
+	inbox  chan datagram
+	closed chan struct{}
+	once   sync.Once
+}
+
+var _ client.Transport = (*Conn)(nil)
+
+func newConn(n *Network, local netip.AddrPort, nat *NAT) *Conn {
+	return &Conn{
+		net:    n,
+		local:  local,
+		nat:    nat,
+		inbox:  make(chan datagram, InboxSize),
+		closed: make(chan struct{}),
+	}
+}
+
+// push dépose un paquet dans la file de réception (false si pleine ou fermée).
+func (c *Conn) push(from netip.AddrPort, payload []byte) bool {
+	select {
+	case <-c.closed:
+		return false
+	default:
+	}
+	select {
+	case c.inbox <- datagram{from: from, payload: payload}:
+		return true
+	default:
+		return false
+	}
+}
+
+// ReadFromUDP attend un paquet ; renvoie net.ErrClosed une fois l’extrémité fermée.
+func (c *Conn) ReadFromUDP(b []byte) (int, *net.UDPAddr, error) {
+	select {
+	case d := <-c.inbox:
+		return copy(b, d.payload), net.UDPAddrFromAddrPort(d.from), nil
+	case <-c.closed:
+		return 0, nil, net.ErrClosed
+	}
+}
+
+// WriteToUDP émet un paquet vers addr.
+func (c *Conn) WriteToUDP(b []byte, addr *net.UDPAddr) (int, error) {
+	select {
+	case <-c.closed:
+		return 0, net.ErrClosed
+	default:
+	}
+	if addr == nil {
+		return 0, errors.New("simnet : adresse de destination nil")
+	}
+	dst := addr.AddrPort()
+	dst = netip.AddrPortFrom(dst.Addr().Unmap(), dst.Port())
+
+	src := c.local
+	if c.nat != nil {
+		c.net.mu.Lock()
+		src = c.nat.outbound(c, dst)
+		c.net.mu.Unlock()
+	}
+	c.net.send(src, dst, b)
+	return len(b), nil
+}
+
+// LocalAddr renvoie l’adresse locale de l’extrémité.
+func (c *Conn) LocalAddr() net.Addr {
+	return net.UDPAddrFromAddrPort(c.local)
+}
+
+// Close ferme l’extrémité : les lectures en cours renvoient net.ErrClosed.
+func (c *Conn) Close() error {
+	c.once.Do(func() {
+		close(c.closed)
+		c.net.mu.Lock()
+		if c.nat != nil {
+			c.nat.removeLocked(c)
+		} else {
+			delete(c.net.hosts, c.local)
+		}
+		c.net.mu.Unlock()
+	})
+	return nil
+}